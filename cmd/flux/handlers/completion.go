@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+// commands that take an app name as their first argument
+var appCommands = []string{"redeploy", "start", "stop", "pause", "unpause", "delete", "rename", "stats", "logs", "ps", "open", "describe"}
+
+var bashCompletion = `_flux() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%[1]s" -- "$cur"))
+		return
+	fi
+
+	case "${COMP_WORDS[1]}" in
+		%[2]s)
+			if [ "$COMP_CWORD" -eq 2 ]; then
+				COMPREPLY=($(compgen -W "$(flux completion apps 2>/dev/null)" -- "$cur"))
+			fi
+			;;
+		maintenance)
+			if [ "$COMP_CWORD" -eq 2 ]; then
+				COMPREPLY=($(compgen -W "on off" -- "$cur"))
+			elif [ "$COMP_CWORD" -eq 3 ]; then
+				COMPREPLY=($(compgen -W "$(flux completion apps 2>/dev/null)" -- "$cur"))
+			fi
+			;;
+		completion)
+			COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+			;;
+	esac
+}
+
+complete -F _flux flux
+`
+
+var zshCompletion = `#compdef flux
+
+_flux() {
+	if (( CURRENT == 2 )); then
+		compadd -- %[1]s
+		return
+	fi
+
+	case "${words[2]}" in
+		%[2]s)
+			(( CURRENT == 3 )) && compadd -- ${(f)"$(flux completion apps 2>/dev/null)"}
+			;;
+		maintenance)
+			(( CURRENT == 3 )) && compadd -- on off
+			(( CURRENT == 4 )) && compadd -- ${(f)"$(flux completion apps 2>/dev/null)"}
+			;;
+		completion)
+			compadd -- bash zsh fish
+			;;
+	esac
+}
+
+compdef _flux flux
+`
+
+var fishCompletion = `complete -c flux -f
+complete -c flux -n "__fish_use_subcommand" -a "%[1]s"
+complete -c flux -n "__fish_seen_subcommand_from %[2]s" -a "(flux completion apps 2>/dev/null)"
+complete -c flux -n "__fish_seen_subcommand_from maintenance; and not __fish_seen_subcommand_from on off" -a "on off"
+complete -c flux -n "__fish_seen_subcommand_from maintenance; and __fish_seen_subcommand_from on off" -a "(flux completion apps 2>/dev/null)"
+complete -c flux -n "__fish_seen_subcommand_from completion" -a "bash zsh fish"
+`
+
+// CompletionCommand returns the completion command, commands is called to get the names of every registered command
+func CompletionCommand(commands func() []string) func(bool, models.Config, pkg.Info, *spinner.Spinner, *models.CustomSpinnerWriter, []string) error {
+	return func(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+		if seekingHelp {
+			fmt.Println(`Usage:
+			  flux completion <bash|zsh|fish>
+
+			Flux will print a completion script for the given shell, load it with:
+			  bash: source <(flux completion bash)
+			  zsh:  source <(flux completion zsh)
+			  fish: flux completion fish | source`)
+			return nil
+		}
+
+		if len(args) != 1 {
+			return fmt.Errorf("usage: flux completion <bash|zsh|fish>")
+		}
+
+		names := strings.Join(commands(), " ")
+
+		switch args[0] {
+		case "bash":
+			fmt.Printf(bashCompletion, names, strings.Join(appCommands, "|"))
+		case "zsh":
+			fmt.Printf(zshCompletion, names, strings.Join(appCommands, "|"))
+		case "fish":
+			fmt.Printf(fishCompletion, names, strings.Join(appCommands, " "))
+		case "apps":
+			// used by the completion scripts to complete app names
+			apps, err := getApps(config, nil)
+			if err != nil {
+				return nil
+			}
+
+			for _, app := range apps {
+				fmt.Println(app.Name)
+			}
+		default:
+			return fmt.Errorf("unsupported shell: %s", args[0])
+		}
+
+		return nil
+	}
+}
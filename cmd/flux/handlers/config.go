@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+	"github.com/juls0730/flux/pkg/client"
+)
+
+// newClient returns a client for the daemon in the config, which sends the auth token with every request
+func newClient(config models.Config) *client.Client {
+	c := client.New(config.DaemonURL, config.AuthToken)
+	if config.Verbose {
+		c.HTTPClient = &http.Client{Transport: verboseTransport{base: http.DefaultTransport}}
+	}
+
+	return c
+}
+
+// Verbosef prints a line to stderr when --verbose is set
+func Verbosef(config models.Config, format string, a ...any) {
+	if config.Verbose {
+		fmt.Fprintf(os.Stderr, "[verbose] "+format+"\n", a...)
+	}
+}
+
+// verboseTransport prints every request to the daemon and its response to stderr
+type verboseTransport struct {
+	base http.RoundTripper
+}
+
+func (t verboseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	fmt.Fprintf(os.Stderr, "[verbose] > %s %s\n", req.Method, req.URL.Redacted())
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		fmt.Fprintf(os.Stderr, "[verbose] > Content-Type: %s\n", contentType)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[verbose] < %s %s failed after %s: %v\n", req.Method, req.URL.Path, time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "[verbose] < %s %s %s in %s\n", req.Method, req.URL.Path, resp.Status, time.Since(start).Round(time.Millisecond))
+	return resp, nil
+}
+
+// getDaemonInfo checks that the daemon is reachable and returns what it reports about itself
+func getDaemonInfo(config models.Config) (pkg.Info, error) {
+	info, err := newClient(config).Info(context.Background())
+	if err != nil {
+		return info, fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+
+	return info, nil
+}
+
+func ConfigCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux config get [key]
+		  flux config set <key> <value>
+
+		Keys:
+		  daemon_url: The url of the flux daemon
+		  auth_token: A token that is sent to the daemon as a bearer token with every request
+
+		Flux will print or change the cli config in ~/.config/flux/config.json.`)
+		return nil
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: flux config get [key], or flux config set <key> <value>")
+	}
+
+	switch args[0] {
+	case "get":
+		if len(args) == 1 {
+			fmt.Printf("daemon_url: %s\n", config.DaemonURL)
+			fmt.Printf("auth_token: %s\n", MaskToken(config.AuthToken))
+			return nil
+		}
+
+		switch args[1] {
+		case "daemon_url", "deamon_url":
+			fmt.Println(config.DaemonURL)
+		case "auth_token":
+			fmt.Println(config.AuthToken)
+		default:
+			return fmt.Errorf("unknown config key: %s", args[1])
+		}
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: flux config set <key> <value>")
+		}
+
+		// start from the config file, so that a --daemon-url override is never saved
+		config, err := models.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		switch args[1] {
+		case "daemon_url", "deamon_url":
+			daemonURL, err := ParseDaemonURL(args[2])
+			if err != nil {
+				return err
+			}
+
+			config.DaemonURL = daemonURL
+
+			if _, err := getDaemonInfo(config); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		case "auth_token":
+			config.AuthToken = args[2]
+		default:
+			return fmt.Errorf("unknown config key: %s", args[1])
+		}
+
+		if err := models.SaveConfig(config); err != nil {
+			return fmt.Errorf("failed to save config: %v", err)
+		}
+
+		fmt.Printf("Set %s\n", args[1])
+	default:
+		return fmt.Errorf("unknown config subcommand: %s", args[0])
+	}
+
+	return nil
+}
+
+// ParseDaemonURL validates a daemon url given on the command line
+func ParseDaemonURL(raw string) (string, error) {
+	daemonURL, err := url.Parse(raw)
+	if err != nil || (daemonURL.Scheme != "http" && daemonURL.Scheme != "https") || daemonURL.Host == "" {
+		return "", fmt.Errorf("invalid daemon url %q, expected something like http://127.0.0.1:5647", raw)
+	}
+
+	return daemonURL.String(), nil
+}
+
+// MaskToken hides all but the last few characters of a token, so that it can be printed
+func MaskToken(token string) string {
+	if token == "" {
+		return ""
+	}
+
+	if len(token) <= 4 {
+		return "****"
+	}
+
+	return "****" + token[len(token)-4:]
+}
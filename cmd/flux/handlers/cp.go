@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func CpCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux cp <app>:<path> <local-path>
+		  flux cp <local-path> <app>:<path>
+
+		Flux will copy a file or directory out of or into the head container of the app. Like docker cp, a
+		destination that is an existing directory receives the copy inside of it, any other destination is what the
+		copy is named. The daemon needs auth_token to be set, and the cli has to be configured with the same token.`)
+		return nil
+	}
+
+	usage := fmt.Errorf("usage: flux cp <app>:<path> <local-path>, or flux cp <local-path> <app>:<path>")
+	if len(args) != 2 {
+		return usage
+	}
+
+	srcApp, srcPath, srcInApp := parseCopyTarget(args[0])
+	dstApp, dstPath, dstInApp := parseCopyTarget(args[1])
+
+	switch {
+	case srcInApp && !dstInApp:
+		return copyFromApp(config, srcApp, srcPath, args[1])
+	case !srcInApp && dstInApp:
+		return copyToApp(config, args[0], dstApp, dstPath)
+	default:
+		return usage
+	}
+}
+
+// parseCopyTarget splits <app>:<path>, a local path never has a colon before its first slash
+func parseCopyTarget(target string) (string, string, bool) {
+	appName, containerPath, found := strings.Cut(target, ":")
+	if !found || appName == "" || strings.ContainsAny(appName, `/\`) {
+		return "", "", false
+	}
+
+	return appName, containerPath, true
+}
+
+func copyFromApp(config models.Config, appName string, containerPath string, localPath string) error {
+	archive, err := newClient(config).CopyFrom(context.Background(), appName, containerPath)
+	if err != nil {
+		// not found can be about the path as well as the app, so the message of the daemon is kept
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	defer archive.Close()
+
+	size, err := extractArchive(archive, localPath)
+	if err != nil {
+		return fmt.Errorf("failed to extract files: %v", err)
+	}
+
+	fmt.Printf("Successfully copied %s to %s\n", formatBytes(uint64(size)), localPath)
+	return nil
+}
+
+// extractArchive extracts an archive with a single root, as the daemon sends it, to dest. The root is placed inside
+// dest if dest is an existing directory, otherwise the root becomes dest
+func extractArchive(r io.Reader, dest string) (int64, error) {
+	destInfo, err := os.Stat(dest)
+	intoDir := err == nil && destInfo.IsDir()
+
+	var size int64
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return size, fmt.Errorf("refusing to extract %s, it is outside of the destination", header.Name)
+		}
+
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		if !intoDir {
+			_, rest, _ := strings.Cut(name, "/")
+			target = filepath.Join(dest, filepath.FromSlash(rest))
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return size, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return size, err
+			}
+
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return size, err
+			}
+
+			n, err := io.Copy(file, tarReader)
+			file.Close()
+			size += n
+			if err != nil {
+				return size, err
+			}
+		default:
+			// links could point anywhere on this machine, so only regular files and directories are extracted
+			fmt.Fprintf(os.Stderr, "Skipping %s, only files and directories are copied\n", header.Name)
+		}
+	}
+}
+
+func copyToApp(config models.Config, localPath string, appName string, containerPath string) error {
+	if _, err := os.Stat(localPath); err != nil {
+		return fmt.Errorf("failed to copy %s: %v", localPath, err)
+	}
+
+	// the archive is streamed, so that large files don't have to fit in memory
+	pipeReader, pipeWriter := io.Pipe()
+	sizeChan := make(chan int64, 1)
+	go func() {
+		size, err := writeArchive(pipeWriter, localPath)
+		sizeChan <- size
+		pipeWriter.CloseWithError(err)
+	}()
+
+	err := newClient(config).CopyTo(context.Background(), appName, containerPath, pipeReader)
+	// unblocks the archive writer if the daemon stopped reading early
+	pipeReader.Close()
+	if err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+
+	fmt.Printf("Successfully copied %s to %s:%s\n", formatBytes(uint64(<-sizeChan)), appName, containerPath)
+	return nil
+}
+
+// writeArchive writes localPath to w as a tar archive whose root is named after the base of localPath
+func writeArchive(w io.Writer, localPath string) (int64, error) {
+	localPath = filepath.Clean(localPath)
+	root := filepath.Base(localPath)
+
+	var size int64
+	tarWriter := tar.NewWriter(w)
+	err := filepath.Walk(localPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(localPath, filePath)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(root, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		n, err := io.Copy(tarWriter, file)
+		size += n
+		return err
+	})
+	if err != nil {
+		return size, err
+	}
+
+	return size, tarWriter.Close()
+}
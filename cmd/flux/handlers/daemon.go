@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func DaemonCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux daemon config
+		  flux daemon vacuum
+		  flux daemon backup [file]
+
+		Commands:
+		  config: Print the config that the daemon is running with, after defaults were applied, with the auth token
+		    and the database password redacted
+		  vacuum: Compact the database of the daemon
+		  backup: Save a copy of the sqlite database of the daemon to file, fluxd-<time>.db by default
+
+		vacuum and backup need the auth_token of the daemon.`)
+		return nil
+	}
+
+	usage := fmt.Errorf("usage: flux daemon <config|vacuum|backup>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "config":
+		if len(args) != 1 {
+			return usage
+		}
+
+		return printDaemonConfig(config)
+	case "vacuum":
+		if len(args) != 1 {
+			return usage
+		}
+
+		if err := newClient(config).VacuumDatabase(context.Background()); err != nil {
+			return fmt.Errorf("failed to vacuum database: %w", err)
+		}
+
+		fmt.Println("Successfully vacuumed the database")
+		return nil
+	case "backup":
+		if len(args) > 2 {
+			return fmt.Errorf("usage: flux daemon backup [file]")
+		}
+
+		path := fmt.Sprintf("fluxd-%s.db", time.Now().Format("20060102-150405"))
+		if len(args) == 2 {
+			path = args[1]
+		}
+
+		return backupDaemonDatabase(config, path)
+	default:
+		return usage
+	}
+}
+
+func printDaemonConfig(config models.Config) error {
+	daemonConfig, err := newClient(config).DaemonConfig(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get daemon config: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, daemonConfig, "", "  "); err != nil {
+		return fmt.Errorf("failed to format daemon config: %v", err)
+	}
+
+	fmt.Println(out.String())
+	return nil
+}
+
+// backupDaemonDatabase downloads the database of the daemon to path, a failed download leaves nothing behind
+func backupDaemonDatabase(config models.Config, path string) error {
+	backup, err := newClient(config).BackupDatabase(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	defer backup.Close()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %v", err)
+	}
+
+	size, err := io.Copy(file, backup)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to save backup: %v", err)
+	}
+
+	fmt.Printf("Saved the database to %s (%d bytes)\n", path, size)
+	return nil
+}
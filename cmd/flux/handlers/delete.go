@@ -1,9 +1,9 @@
 package handlers
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"strings"
 
 	"github.com/briandowns/spinner"
@@ -14,18 +14,33 @@ import (
 func DeleteCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
 	if seekingHelp {
 		fmt.Println(`Usage:
-		  flux delete [project-name | all]
+		  flux delete [flags] [project-name | all]
 
 		Options:
 		  project-name: The name of the project to delete
 		  all: Delete all projects
+
+		Flags:
+		  --force: Remove the containers, generated volumes, and database rows of the project directly, even if the
+		  daemon no longer knows the project or its containers and database are out of sync. Named volumes are kept
 		  
 		Flux will delete the deployment of the app in the current directory or the specified project.`)
 		return nil
 	}
 
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	force := flags.Bool("force", false, "Remove everything that is left of the project, even if the daemon no longer knows it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
 	if len(args) == 1 {
 		if args[0] == "all" {
+			if *force {
+				return fmt.Errorf("--force can only delete a single project")
+			}
+
 			var response string
 			fmt.Print("Are you sure you want to delete all projects? this will delete all volumes and containers associated and cannot be undone. \n[y/N] ")
 			fmt.Scanln(&response)
@@ -45,25 +60,8 @@ func DeleteCommand(seekingHelp bool, config models.Config, info pkg.Info, loadin
 				return nil
 			}
 
-			req, err := http.NewRequest("DELETE", config.DeamonURL+"/deployments", nil)
-			if err != nil {
-				return fmt.Errorf("failed to delete deployments: %v", err)
-			}
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				return fmt.Errorf("failed to delete deployments: %v", err)
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				responseBody, err := io.ReadAll(resp.Body)
-				if err != nil {
-					return fmt.Errorf("error reading response body: %v", err)
-				}
-
-				responseBody = []byte(strings.TrimSuffix(string(responseBody), "\n"))
-
-				return fmt.Errorf("delete failed: %s", responseBody)
+			if err := newClient(config).DeleteAll(context.Background()); err != nil {
+				return fmt.Errorf("delete failed: %w", err)
 			}
 
 			fmt.Printf("Successfully deleted all projects\n")
@@ -86,25 +84,21 @@ func DeleteCommand(seekingHelp bool, config models.Config, info pkg.Info, loadin
 		return nil
 	}
 
-	req, err := http.NewRequest("DELETE", config.DeamonURL+"/deployments/"+projectName, nil)
-	if err != nil {
-		return fmt.Errorf("failed to delete app: %v", err)
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to delete app: %v", err)
+	if *force {
+		if err := newClient(config).ForceDelete(context.Background(), projectName); err != nil {
+			return fmt.Errorf("delete failed: %w", err)
+		}
+
+		fmt.Printf("Successfully removed everything that was left of %s\n", projectName)
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		responseBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("error reading response body: %v", err)
+	if err := newClient(config).Delete(context.Background(), projectName); err != nil {
+		if errorCode(err) == pkg.ErrorCodeNotFound {
+			return fmt.Errorf("delete failed: there is no app named %s, run flux delete --force %s to remove containers that were left behind", projectName, projectName)
 		}
 
-		responseBody = []byte(strings.TrimSuffix(string(responseBody), "\n"))
-
-		return fmt.Errorf("delete failed: %s", responseBody)
+		return appError("delete", projectName, err)
 	}
 
 	fmt.Printf("Successfully deleted %s\n", projectName)
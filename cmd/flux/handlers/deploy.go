@@ -5,19 +5,28 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/juls0730/flux/cmd/flux/models"
 	"github.com/juls0730/flux/pkg"
+	"github.com/juls0730/flux/pkg/client"
 )
 
 func matchesIgnorePattern(path string, info os.FileInfo, patterns []string) bool {
@@ -64,32 +73,47 @@ func convertGitignorePatternToRegex(pattern string) string {
 	return pattern
 }
 
-func compressDirectory(compression pkg.Compression) ([]byte, error) {
-	var buf bytes.Buffer
-	var err error
+type archivedFile struct {
+	Path string
+	Size int64
+}
 
-	var ignoredFiles []string
+// compressDirectory archives the current directory for upload, it returns the archive along with every file in it
+// readIgnorePatterns returns the patterns in the .fluxignore of the current directory, if it has one
+func readIgnorePatterns() ([]string, error) {
 	fluxIgnore, err := os.Open(".fluxignore")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err
-		}
+		return nil, err
 	}
+	defer fluxIgnore.Close()
 
-	if fluxIgnore != nil {
-		defer fluxIgnore.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(fluxIgnore)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
 
-		scanner := bufio.NewScanner(fluxIgnore)
-		for scanner.Scan() {
-			ignoredFiles = append(ignoredFiles, scanner.Text())
-		}
+	return patterns, scanner.Err()
+}
+
+func compressDirectory(compression pkg.Compression) ([]byte, []archivedFile, error) {
+	var buf bytes.Buffer
+	var err error
+	var files []archivedFile
+
+	ignoredFiles, err := readIgnorePatterns()
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var gzWriter *gzip.Writer
 	if compression.Enabled {
 		gzWriter, err = gzip.NewWriterLevel(&buf, compression.Level)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -105,7 +129,7 @@ func compressDirectory(compression pkg.Compression) ([]byte, error) {
 			return err
 		}
 
-		if path == "flux.json" || info.IsDir() || matchesIgnorePattern(path, info, ignoredFiles) {
+		if slices.Contains(pkg.ProjectConfigFiles, path) || info.IsDir() || matchesIgnorePattern(path, info, ignoredFiles) {
 			return nil
 		}
 
@@ -114,6 +138,7 @@ func compressDirectory(compression pkg.Compression) ([]byte, error) {
 			return err
 		}
 		header.Name = path
+		files = append(files, archivedFile{Path: path, Size: info.Size()})
 
 		if err = tarWriter.WriteHeader(header); err != nil {
 			return err
@@ -135,62 +160,263 @@ func compressDirectory(compression pkg.Compression) ([]byte, error) {
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err = tarWriter.Close(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if gzWriter != nil {
 		if err = gzWriter.Close(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	return buf.Bytes(), nil
+	return buf.Bytes(), files, nil
+}
+
+// uploadProgress shows how much of the deploy request has been sent in the spinner
+type uploadProgress struct {
+	reader     io.Reader
+	total      int64
+	sent       int64
+	start      time.Time
+	lastUpdate time.Time
+	spinner    *spinner.Spinner
+}
+
+func (p *uploadProgress) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.sent += int64(n)
+
+	// updating the suffix on every read would make the spinner flicker
+	if p.sent < p.total && time.Since(p.lastUpdate) < 100*time.Millisecond {
+		return n, err
+	}
+	p.lastUpdate = time.Now()
+
+	suffix := " Deploying"
+	if p.sent < p.total {
+		rate := float64(p.sent) / time.Since(p.start).Seconds()
+		suffix = fmt.Sprintf(" Uploading %s / %s (%d%%, %s/s)", formatBytes(uint64(p.sent)), formatBytes(uint64(p.total)), p.sent*100/p.total, formatBytes(uint64(rate)))
+	}
+
+	p.spinner.Lock()
+	p.spinner.Suffix = suffix
+	p.spinner.Unlock()
+
+	return n, err
 }
 
 func DeployCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
 	if seekingHelp {
 		fmt.Println(`Usage:
-		  flux deploy
+		  flux deploy [flags]
+
+		Flags:
+		  --notify <url>: Post the result of this deploy to the given url once it finishes
+		  --no-wait: Fail instead of waiting if the app is already being deployed
+		  --log-file <path>: Write the full deploy output to the given file, the terminal only shows the progress
+		  of the deploy
+		  --replicas <n>: Run this deploy with n containers, overriding the replicas in flux.json
+		  --force-build: Build the app even if the source has not changed since the last build
+		  --no-cache: Build the app from scratch, without the cache of earlier builds, which makes the build slower
+		  --dry-run: Print the files that would be uploaded, the archive size, and the config without deploying
+		  --watch: Keep running and redeploy whenever a file in the project changes, until interrupted with Ctrl-C
+		  --detach: Return once the daemon has accepted the deploy, instead of waiting for it to finish
+		  --all: Deploy every service in flux.workspace.json, each from its own directory, in the order of their
+		  dependencies
 		  
 		Flux will deploy the app in the current directory, and start routing traffic to it.`)
 		return nil
 	}
 
-	if _, err := os.Stat("flux.json"); err != nil {
-		return fmt.Errorf("no flux.json found, please run flux init first")
+	flags := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	notifyURL := flags.String("notify", "", "Post the result of this deploy to the given url once it finishes")
+	noWait := flags.Bool("no-wait", false, "Fail instead of waiting if the app is already being deployed")
+	logFilePath := flags.String("log-file", "", "Write the full deploy output to the given file")
+	replicas := flags.Int("replicas", 0, "Run this deploy with n containers, overriding the replicas in flux.json")
+	forceBuild := flags.Bool("force-build", false, "Build the app even if the source has not changed since the last build")
+	noCache := flags.Bool("no-cache", false, "Build the app from scratch, without the cache of earlier builds")
+	dryRun := flags.Bool("dry-run", false, "Print the files that would be uploaded, the archive size, and the config without deploying")
+	watch := flags.Bool("watch", false, "Redeploy whenever a file in the project changes")
+	detach := flags.Bool("detach", false, "Return once the daemon has accepted the deploy")
+	all := flags.Bool("all", false, "Deploy every service in flux.workspace.json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	replicasSet := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "replicas" {
+			replicasSet = true
+		}
+	})
+
+	if replicasSet && *replicas < 1 {
+		return fmt.Errorf("--replicas must be at least 1")
+	}
+
+	var output io.Writer = os.Stdout
+	if config.Quiet {
+		output = io.Discard
+	}
+	var logFile *os.File
+	if *logFilePath != "" {
+		var err error
+		logFile, err = os.Create(*logFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to create log file: %v", err)
+		}
+		defer logFile.Close()
+	}
+
+	opts := deployOptions{
+		notifyURL:  *notifyURL,
+		noWait:     *noWait,
+		forceBuild: *forceBuild,
+		noCache:    *noCache,
+		dryRun:     *dryRun,
+		detach:     *detach,
+		output:     output,
+		logFile:    logFile,
 	}
+	if replicasSet {
+		opts.replicas = *replicas
+	}
+
+	if *all {
+		switch {
+		case *watch:
+			return fmt.Errorf("--all and --watch can't be used together")
+		case *detach:
+			return fmt.Errorf("--all and --detach can't be used together")
+		case replicasSet:
+			return fmt.Errorf("--all and --replicas can't be used together, set replicas per service instead")
+		}
+
+		return deployWorkspace(opts, config, info, loadingSpinner, spinnerWriter)
+	}
+
+	configPath, err := findProjectConfig()
+	if err != nil {
+		return fmt.Errorf("%v, please run flux init first", err)
+	}
+	opts.configPath = configPath
+
+	if *watch {
+		if *dryRun {
+			return fmt.Errorf("--watch and --dry-run can't be used together")
+		}
 
-	loadingSpinner.Suffix = " Deploying"
-	loadingSpinner.Start()
+		if *detach {
+			return fmt.Errorf("--watch and --detach can't be used together")
+		}
+
+		return watchAndDeploy(opts, *logFilePath, config, info, loadingSpinner, spinnerWriter)
+	}
+
+	return deploy(context.Background(), opts, config, info, loadingSpinner, spinnerWriter)
+}
 
-	buf, err := compressDirectory(info.Compression)
+// deployOptions are the flags of a single deploy
+type deployOptions struct {
+	// the project config file that gets uploaded
+	configPath string
+	// uploaded instead of the contents of configPath when set, configPath then only names it
+	config     []byte
+	notifyURL  string
+	noWait     bool
+	forceBuild bool
+	noCache    bool
+	dryRun     bool
+	// stop following the deploy once the daemon has accepted it
+	detach bool
+	// overrides the replicas in flux.json when above 0
+	replicas int
+	output   io.Writer
+	// receives the full output of the deploy instead of output when set
+	logFile *os.File
+}
+
+// deploy uploads the app in the current directory and streams the output of the deploy until it finishes, cancelling
+// ctx cancels the deploy
+func deploy(ctx context.Context, opts deployOptions, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter) error {
+	if !opts.dryRun {
+		loadingSpinner.Suffix = " Deploying"
+		loadingSpinner.Start()
+	}
+
+	buf, files, err := compressDirectory(info.Compression)
 	if err != nil {
 		return fmt.Errorf("failed to compress directory: %v", err)
 	}
 
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
-	configPart, err := writer.CreateFormFile("config", "flux.json")
+	configName := opts.configPath
+	fluxConfigBytes := opts.config
+	if fluxConfigBytes == nil {
+		fluxConfigBytes, err = os.ReadFile(configName)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", configName, err)
+		}
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to create config part: %v", err)
+	Verbosef(config, "project config %s:\n%s", configName, strings.TrimRight(string(fluxConfigBytes), "\n"))
+
+	// the config file stays the persistent default, overrides only apply to the config that gets uploaded
+	if opts.replicas > 0 {
+		projectConfig, err := pkg.DecodeProjectConfig(fluxConfigBytes, pkg.IsYAMLConfig(configName))
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %v", configName, err)
+		}
+
+		projectConfig.Replicas = opts.replicas
+
+		fluxConfigBytes, err = json.Marshal(projectConfig)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", configName, err)
+		}
+		configName = "flux.json"
+	}
+
+	if opts.dryRun {
+		return printDryRun(files, buf, configName, fluxConfigBytes)
+	}
+
+	contentType := "application/json"
+	if pkg.IsYAMLConfig(configName) {
+		contentType = "application/yaml"
 	}
 
-	fluxConfigFile, err := os.Open("flux.json")
+	configHeader := make(textproto.MIMEHeader)
+	configHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="config"; filename="%s"`, configName))
+	configHeader.Set("Content-Type", contentType)
+	configPart, err := writer.CreatePart(configHeader)
 	if err != nil {
-		return fmt.Errorf("failed to open flux.json: %v", err)
+		return fmt.Errorf("failed to create config part: %v", err)
 	}
-	defer fluxConfigFile.Close()
 
-	if _, err := io.Copy(configPart, fluxConfigFile); err != nil {
+	if _, err := configPart.Write(fluxConfigBytes); err != nil {
 		return fmt.Errorf("failed to write config part: %v", err)
 	}
 
-	codePart, err := writer.CreateFormFile("code", "code.tar.gz")
+	// tell the daemon how the archive is encoded, so that it never has to assume that it matches its own settings
+	codeHeader := make(textproto.MIMEHeader)
+	codeHeader.Set("Content-Disposition", `form-data; name="code"; filename="code.tar"`)
+	codeHeader.Set("Content-Type", "application/x-tar")
+	codeHeader.Set("Content-Encoding", "identity")
+	if info.Compression.Enabled {
+		codeHeader.Set("Content-Disposition", `form-data; name="code"; filename="code.tar.gz"`)
+		codeHeader.Set("Content-Encoding", "gzip")
+	}
+	// lets the daemon catch an archive that was corrupted on the way
+	checksum := sha256.Sum256(buf)
+	codeHeader.Set(pkg.CodeChecksumHeader, hex.EncodeToString(checksum[:]))
+
+	codePart, err := writer.CreatePart(codeHeader)
 	if err != nil {
 		return fmt.Errorf("failed to create code part: %v", err)
 	}
@@ -199,56 +425,250 @@ func DeployCommand(seekingHelp bool, config models.Config, info pkg.Info, loadin
 		return fmt.Errorf("failed to write code part: %v", err)
 	}
 
+	if opts.notifyURL != "" {
+		if err := writer.WriteField("notify", opts.notifyURL); err != nil {
+			return fmt.Errorf("failed to write notify field: %v", err)
+		}
+	}
+
+	if opts.noWait {
+		if err := writer.WriteField("no_wait", "true"); err != nil {
+			return fmt.Errorf("failed to write no_wait field: %v", err)
+		}
+	}
+
+	if opts.forceBuild {
+		if err := writer.WriteField("force_build", "true"); err != nil {
+			return fmt.Errorf("failed to write force_build field: %v", err)
+		}
+	}
+
+	if opts.noCache {
+		if err := writer.WriteField("no_cache", "true"); err != nil {
+			return fmt.Errorf("failed to write no_cache field: %v", err)
+		}
+	}
+
+	// the daemon finishes the deploy whether or not anyone follows it
+	var detachApp string
+	if opts.detach {
+		projectConfig, err := pkg.DecodeProjectConfig(fluxConfigBytes, pkg.IsYAMLConfig(configName))
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %v", configName, err)
+		}
+		detachApp = projectConfig.Name
+	}
+
 	if err := writer.Close(); err != nil {
 		return fmt.Errorf("failed to close writer: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", config.DeamonURL+"/deploy", body)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	// fail before uploading anything the daemon is going to reject anyway
+	if info.MaxUploadSize > 0 && int64(body.Len()) > info.MaxUploadSize {
+		return uploadTooLargeError(int64(body.Len()), info.MaxUploadSize)
+	}
+
+	// only retry when the daemon couldn't be reached at all, otherwise the deploy may have already started
+	var stream *client.DeployStream
+	err = retryWithBackoff(config.Timeout, isDialError, func() error {
+		progress := &uploadProgress{
+			reader:  bytes.NewReader(body.Bytes()),
+			total:   int64(body.Len()),
+			start:   time.Now(),
+			spinner: loadingSpinner,
+		}
 
+		stream, err = newClient(config).Deploy(ctx, progress, progress.total, writer.FormDataContentType())
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) {
+			return fmt.Errorf("failed to send request: %v", err)
+		}
+
+		switch {
+		case apiErr.StatusCode == http.StatusRequestEntityTooLarge:
+			return uploadTooLargeError(int64(body.Len()), info.MaxUploadSize)
+		case apiErr.Code == pkg.ErrorCodeDeployInProgress:
+			return fmt.Errorf("the app is already being deployed, deploy without --no-wait to deploy once it has finished")
+		}
+
+		return fmt.Errorf("deploy failed: %w", apiErr)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+	return followDeploy(stream, config, opts.output, opts.logFile, detachApp, loadingSpinner, spinnerWriter)
+}
+
+// followDeploy prints the events of a deploy to output as they come in, until the deploy completes or fails. When
+// logFile is set, the output of commands only goes to it while every other event goes to both. When detachApp is set
+// it returns as soon as the deploy of that app is queued or past its upload instead
+func followDeploy(stream *client.DeployStream, config models.Config, output io.Writer, logFile *os.File, detachApp string, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter) error {
+	defer stream.Close()
+
+	stream.OnReconnect = func(err error) {
+		if !config.Quiet {
+			fmt.Fprintf(os.Stderr, "Lost the connection to the daemon (%v), reconnecting...\n", err)
+		}
 	}
-	defer resp.Body.Close()
 
-	customWriter := models.NewCustomStdout(spinnerWriter)
+	customWriter := models.NewCustomStdout(spinnerWriter, output)
+	printEvent := func(format string, a ...any) {
+		customWriter.Printf(format, a...)
+		if logFile != nil {
+			fmt.Fprintf(logFile, format, a...)
+		}
+	}
+	deployFailed := func(message any) error {
+		if logFile != nil {
+			fmt.Fprintf(logFile, "deployment failed: %s\n", message)
+			return fmt.Errorf("deployment failed: %s, the full output is in %s", message, logFile.Name())
+		}
 
-	scanner := bufio.NewScanner(resp.Body)
-	var event string
-	var data pkg.DeploymentEvent
-	var line string
-	for scanner.Scan() {
-		line = scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			if err := json.Unmarshal([]byte(line[6:]), &data); err != nil {
-				return fmt.Errorf("failed to parse deployment event: %v", err)
+		return fmt.Errorf("deployment failed: %s", message)
+	}
+
+	// command output is timestamped relative to the first event
+	var start time.Time
+	var buildSummary string
+	uploaded := false
+	for {
+		event, data, err := stream.Next()
+		if err == io.EOF {
+			// the stream closed, but we didnt get a "complete" event
+			return deployFailed(stream.LastLine())
+		}
+		if err != nil {
+			return err
+		}
+
+		if start.IsZero() {
+			start = data.Time
+		}
+
+		// the daemon has accepted the upload once another event follows the start event, or it is waiting its turn
+		if detachApp != "" && (event == "queued" || (uploaded && event != "error" && event != "complete")) {
+			loadingSpinner.Stop()
+			fmt.Printf("Deploy of %s continues in the background, run flux list or flux logs %s to check on it\n", detachApp, detachApp)
+			return nil
+		}
+		if event == "start" {
+			uploaded = true
+		}
+
+		switch event {
+		case "complete":
+			loadingSpinner.Stop()
+			appName := data.Message.(map[string]interface{})["name"]
+			message := fmt.Sprintf("App %s deployed successfully!\n", appName)
+			// daemons that predate the duration and image size don't send them
+			if data.DurationMs != 0 {
+				summary := fmt.Sprintf("in %s", formatDeployDuration(time.Duration(data.DurationMs)*time.Millisecond))
+				if data.ImageSize > 0 {
+					summary += fmt.Sprintf(" (image %s)", formatBytes(uint64(data.ImageSize)))
+				}
+
+				message = fmt.Sprintf("App %s deployed successfully %s!\n", appName, summary)
 			}
 
-			switch event {
-			case "complete":
-				loadingSpinner.Stop()
-				fmt.Printf("App %s deployed successfully!\n", data.Message.(map[string]interface{})["name"])
-				return nil
-			case "cmd_output":
-				customWriter.Printf("... %s\n", data.Message)
-			case "error":
-				loadingSpinner.Stop()
-				return fmt.Errorf("deployment failed: %s", data.Message)
-			default:
-				customWriter.Printf("%s\n", data.Message)
+			message += buildSummary
+
+			fmt.Print(message)
+			if logFile != nil {
+				logFile.WriteString(message)
 			}
-			event = ""
-		} else if strings.HasPrefix(line, "event: ") {
-			event = strings.TrimPrefix(line, "event: ")
+			return nil
+		case "queued":
+			loadingSpinner.Suffix = " Queued"
+			printEvent("%s\n", data.Message)
+		case "start":
+			loadingSpinner.Suffix = " Deploying"
+			printEvent("%s\n", data.Message)
+		case "cmd_output":
+			// daemons that predate stages on events don't say which stage printed the output
+			stage := data.Stage
+			if stage == "" || stage == "cmd_output" {
+				stage = "output"
+			}
+
+			line := fmt.Sprintf("[%s +%s] %s\n", stage, data.Time.Sub(start).Round(100*time.Millisecond), data.Message)
+			if logFile != nil {
+				logFile.WriteString(line)
+			} else {
+				customWriter.Write([]byte(line))
+			}
+		case "build_summary":
+			// printed with the result, so that it isn't buried in the output of the build
+			buildSummary = formatBuildSummary(data.Message)
+		case "error":
+			loadingSpinner.Stop()
+			return deployFailed(data.Message)
+		default:
+			// anything else means that the deploy is no longer queued
+			loadingSpinner.Suffix = " Deploying"
+			printEvent("%s\n", data.Message)
 		}
 	}
+}
+
+// formatBuildSummary describes which buildpacks were served from the build cache, message is the message of a
+// build_summary event
+func formatBuildSummary(message any) string {
+	var summary pkg.BuildSummary
+	summaryJSON, err := json.Marshal(message)
+	if err != nil || json.Unmarshal(summaryJSON, &summary) != nil {
+		return ""
+	}
+
+	var lines []string
+	if len(summary.Cached) > 0 {
+		lines = append(lines, fmt.Sprintf("Cached: %s\n", strings.Join(summary.Cached, ", ")))
+	}
+	if len(summary.Rebuilt) > 0 {
+		lines = append(lines, fmt.Sprintf("Rebuilt: %s\n", strings.Join(summary.Rebuilt, ", ")))
+	}
+
+	return strings.Join(lines, "")
+}
+
+// formatDeployDuration rounds the duration of a deploy to a precision that is still meaningful at its length
+func formatDeployDuration(d time.Duration) string {
+	if d < 10*time.Second {
+		return d.Round(100 * time.Millisecond).String()
+	}
+
+	return d.Round(time.Second).String()
+}
+
+func uploadTooLargeError(size int64, limit int64) error {
+	if limit <= 0 {
+		return fmt.Errorf("the upload (%s) is larger than the daemon accepts, add large files that the build doesn't need to .fluxignore or raise max_upload_size on the daemon", formatBytes(uint64(size)))
+	}
+
+	return fmt.Errorf("the upload (%s) is larger than the daemon accepts (%s), add large files that the build doesn't need to .fluxignore or raise max_upload_size on the daemon", formatBytes(uint64(size)), formatBytes(uint64(limit)))
+}
+
+func printDryRun(files []archivedFile, archive []byte, configName string, fluxConfigBytes []byte) error {
+	var totalSize uint64
+	fmt.Println("Files:")
+	for _, file := range files {
+		totalSize += uint64(file.Size)
+		fmt.Printf("  %10s  %s\n", formatBytes(uint64(file.Size)), file.Path)
+	}
+
+	fmt.Printf("\n%d files, %s total, %s archive\n", len(files), formatBytes(totalSize), formatBytes(uint64(len(archive))))
+
+	if pkg.IsYAMLConfig(configName) {
+		fmt.Printf("\n%s:\n%s\n", configName, strings.TrimRight(string(fluxConfigBytes), "\n"))
+		return nil
+	}
+
+	var config bytes.Buffer
+	if err := json.Indent(&config, fluxConfigBytes, "", "  "); err != nil {
+		return fmt.Errorf("failed to format %s: %v", configName, err)
+	}
+
+	fmt.Printf("\n%s:\n%s\n", configName, config.String())
 
-	// the stream closed, but we didnt get a "complete" event
-	line = strings.TrimSuffix(line, "\n")
-	return fmt.Errorf("deploy failed: %s", line)
+	return nil
 }
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func DescribeCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux describe [project-name]
+
+		Options:
+		  project-name: The name of the project to describe
+
+		Flux will print everything that the daemon knows about the app in the current directory or the specified
+		project as json, including its config, the live state of its containers, its volumes, and its proxy. Useful
+		for bug reports, secrets are only included as the references in flux.json.`)
+		return nil
+	}
+
+	projectName, err := GetProjectName("describe", args)
+	if err != nil {
+		return err
+	}
+
+	description, err := newClient(config).Describe(context.Background(), projectName)
+	if err != nil {
+		return appError("describe", projectName, err)
+	}
+
+	out, err := json.MarshalIndent(description, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to format description: %v", err)
+	}
+
+	fmt.Println(string(out))
+	return nil
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func DoctorCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux doctor
+
+		Flux will list the apps that are in an inconsistent state, such as apps that the daemon could not load when
+		it started or apps whose containers were removed outside of flux.`)
+		return nil
+	}
+
+	problems, err := newClient(config).Doctor(context.Background())
+	if err != nil {
+		return fmt.Errorf("doctor failed: %w", err)
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", problem.App, problem.Problem)
+	}
+
+	return fmt.Errorf("found %d problems", len(problems))
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/juls0730/flux/pkg"
+	"github.com/juls0730/flux/pkg/client"
+)
+
+// errorCode returns the error code of an error response from the daemon, or an empty string if err isn't one
+func errorCode(err error) string {
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+
+	return ""
+}
+
+// appError turns the error of a request about an app into an error for the user
+func appError(action string, projectName string, err error) error {
+	if errorCode(err) == pkg.ErrorCodeNotFound {
+		return fmt.Errorf("%s failed: there is no app named %s, run flux list to see the deployed apps", action, projectName)
+	}
+
+	return fmt.Errorf("%s failed: %w", action, err)
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
@@ -10,62 +12,205 @@ import (
 	"github.com/briandowns/spinner"
 	"github.com/juls0730/flux/cmd/flux/models"
 	"github.com/juls0730/flux/pkg"
+	"gopkg.in/yaml.v3"
 )
 
+func prompt(question string) string {
+	fmt.Println(question)
+
+	var response string
+	fmt.Scanln(&response)
+
+	return response
+}
+
+// normalizeURL turns whatever url the user gave into the bare domain that the proxy matches on
+func normalizeURL(url string) string {
+	url = strings.TrimPrefix(url, "http://")
+	url = strings.TrimPrefix(url, "https://")
+
+	return strings.Split(url, "/")[0]
+}
+
+func parsePort(response string) (uint16, error) {
+	port, err := strconv.ParseUint(response, 10, 16)
+	if err != nil || port < 1024 {
+		return 0, fmt.Errorf("that doesnt look like a valid port, try a number between 1024 and 65535")
+	}
+
+	return uint16(port), nil
+}
+
+// imageAppName derives an app name from an image reference, myrepo/app:tag becomes app
+func imageAppName(imageName string) string {
+	if i := strings.Index(imageName, "@"); i != -1 {
+		imageName = imageName[:i]
+	}
+
+	// a colon after the last slash separates the tag, a colon before it is the port of the registry
+	name := imageName[strings.LastIndex(imageName, "/")+1:]
+	if i := strings.Index(name, ":"); i != -1 {
+		name = name[:i]
+	}
+
+	return name
+}
+
 func InitCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
 	if seekingHelp {
 		fmt.Println(`Usage:
-		  flux init [project-name]
+		  flux init [project-name] [flags]
 		  
 		Options:
 		  project-name: The name of the project to initialize
+
+		Flags:
+		  --name <name>: The name of the project
+		  --url <url>: The url that the project is served on
+		  --port <port>: The port that the project listens on, the port exposed by the image is used if it is left out
+		  --from-image <image>: Deploy an existing image instead of building the project, the port and the name
+		                        when it isn't given are taken from it so that only the url is prompted for
+		  --non-interactive: Never prompt, fail if the name or url is missing instead
+		  --yaml: Write the config to flux.yaml instead of flux.json
+		  --force: Overwrite an existing flux.json or flux.yaml
 		  
-		Flux will initialize a new project in the current directory or the specified project.`)
+		Flux will initialize a new project in the current directory or the specified project, prompting for
+		everything that isn't passed as a flag. The image passed to --from-image is written to the config as its image,
+		so the app runs that image rather than being built from the project's code when it's deployed.`)
 		return nil
 	}
 
-	var projectConfig pkg.ProjectConfig
+	// the project name can come before the flags
+	var projectName string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		projectName = args[0]
+		args = args[1:]
+	}
 
-	var response string
-	if len(args) > 1 {
-		response = args[0]
-	} else {
-		fmt.Println("What is the name of your project?")
-		fmt.Scanln(&response)
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	name := flags.String("name", "", "The name of the project")
+	url := flags.String("url", "", "The url that the project is served on")
+	port := flags.String("port", "", "The port that the project listens on")
+	fromImage := flags.String("from-image", "", "Deploy an existing image, and take the port and name from it")
+	nonInteractive := flags.Bool("non-interactive", false, "Never prompt, fail if the name or url is missing instead")
+	useYAML := flags.Bool("yaml", false, "Write the config to flux.yaml instead of flux.json")
+	force := flags.Bool("force", false, "Overwrite an existing flux.json or flux.yaml")
+	if err := flags.Parse(args); err != nil {
+		return err
 	}
 
-	projectConfig.Name = response
+	var existing []string
+	for _, configFile := range pkg.ProjectConfigFiles {
+		if _, err := os.Stat(configFile); err == nil {
+			existing = append(existing, configFile)
+		}
+	}
 
-	fmt.Println("What URL should your project listen to?")
-	fmt.Scanln(&response)
-	if strings.HasPrefix(response, "http") {
-		response = strings.TrimPrefix(response, "http://")
-		response = strings.TrimPrefix(response, "https://")
+	if len(existing) > 0 && !*force {
+		return fmt.Errorf("%s already exists, pass --force to overwrite it", existing[0])
 	}
 
-	response = strings.Split(response, "/")[0]
+	if *name == "" {
+		*name = projectName
+	}
 
-	projectConfig.Url = response
+	if *name == "" && flags.NArg() > 0 {
+		*name = flags.Arg(0)
+	}
 
-	fmt.Println("What port does your project listen to?")
-	fmt.Scanln(&response)
-	port, err := strconv.ParseUint(response, 10, 16)
-	portErr := fmt.Errorf("that doesnt look like a valid port, try a number between 1024 and 65535")
-	if port > 65535 {
-		return portErr
+	if *fromImage != "" {
+		imageInfo, err := newClient(config).InspectImage(context.Background(), *fromImage)
+		if err != nil {
+			return fmt.Errorf("failed to inspect image: %v", err)
+		}
+
+		if *name == "" {
+			*name = imageAppName(*fromImage)
+		}
+
+		if *port == "" {
+			switch len(imageInfo.ExposedPorts) {
+			case 0:
+				fmt.Printf("%s doesn't expose any ports\n", *fromImage)
+			case 1:
+				*port = strconv.Itoa(int(imageInfo.ExposedPorts[0]))
+			default:
+				ports := make([]string, len(imageInfo.ExposedPorts))
+				for i, exposedPort := range imageInfo.ExposedPorts {
+					ports[i] = strconv.Itoa(int(exposedPort))
+				}
+
+				if *nonInteractive {
+					return fmt.Errorf("%s exposes multiple ports (%s), pass the one the project listens on with --port", *fromImage, strings.Join(ports, ", "))
+				}
+
+				*port = prompt(fmt.Sprintf("%s exposes multiple ports (%s), which one does your project listen to?", *fromImage, strings.Join(ports, ", ")))
+			}
+		}
+	}
+
+	// every flag that was passed skips its prompt
+	interactive := !*nonInteractive && (*name == "" || *url == "" || (*port == "" && *fromImage == ""))
+
+	var projectConfig pkg.ProjectConfig
+	projectConfig.Image = *fromImage
+
+	projectConfig.Name = *name
+	if projectConfig.Name == "" && interactive {
+		projectConfig.Name = prompt("What is the name of your project?")
 	}
 
-	projectConfig.Port = uint16(port)
-	if err != nil || projectConfig.Port < 1024 {
-		return portErr
+	if projectConfig.Name == "" {
+		return fmt.Errorf("a project name is required, pass it with --name")
 	}
 
-	configBytes, err := json.MarshalIndent(projectConfig, "", "    ")
+	projectConfig.Url = *url
+	if projectConfig.Url == "" && interactive {
+		projectConfig.Url = prompt("What URL should your project listen to?")
+	}
+
+	projectConfig.Url = normalizeURL(projectConfig.Url)
+	if projectConfig.Url == "" {
+		return fmt.Errorf("a url is required, pass it with --url")
+	}
+
+	if *port == "" && *fromImage == "" && interactive {
+		*port = prompt("What port does your project listen to? (leave empty to use the port that the image exposes)")
+	}
+
+	if *port != "" {
+		var err error
+		projectConfig.Port, err = parsePort(*port)
+		if err != nil {
+			return err
+		}
+	}
+
+	configFile := "flux.json"
+	var configBytes []byte
+	var err error
+	if *useYAML {
+		configFile = "flux.yaml"
+		configBytes, err = yaml.Marshal(projectConfig)
+	} else {
+		configBytes, err = json.MarshalIndent(projectConfig, "", "    ")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to parse project config: %v", err)
 	}
 
-	os.WriteFile("flux.json", configBytes, 0644)
+	if err := os.WriteFile(configFile, configBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", configFile, err)
+	}
+
+	// the config that was overwritten may have been in another format, which would otherwise win over the new one
+	for _, existingFile := range existing {
+		if existingFile != configFile {
+			if err := os.Remove(existingFile); err != nil {
+				return fmt.Errorf("failed to remove %s: %v", existingFile, err)
+			}
+		}
+	}
 
 	fmt.Printf("Successfully initialized project %s\n", projectConfig.Name)
 
@@ -1,11 +1,16 @@
 package handlers
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
-	"io"
-	"net/http"
+	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/juls0730/flux/cmd/flux/models"
@@ -15,41 +20,173 @@ import (
 func ListCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
 	if seekingHelp {
 		fmt.Println(`Usage:
-		  flux list
+		  flux list [flags]
+
+		Flags:
+		  --label <key>[=<value>]: Only list apps that have the label, or that have it set to value. Can be passed
+		  more than once, apps have to match all of them
+		  --watch: Redraw the list with the replicas and active requests of every app every 2 seconds until Ctrl-C is
+		  pressed
 
 		Flux will list all the apps in the daemon.`)
 		return nil
 	}
 
-	resp, err := http.Get(config.DeamonURL + "/apps")
+	var labels repeatedFlag
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.Var(&labels, "label", "Only list apps that have the label, or that have it set to value")
+	watch := flags.Bool("watch", false, "Redraw the list every 2 seconds until interrupted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *watch {
+		return watchApps(config, labels)
+	}
+
+	apps, err := getApps(config, labels)
 	if err != nil {
-		return fmt.Errorf("failed to get apps: %v", err)
+		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		responseBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("error reading response body: %v", err)
+	if len(apps) == 0 {
+		fmt.Println("No apps found")
+		return nil
+	}
+
+	for _, app := range apps {
+		status := app.DeploymentStatus
+		if app.Maintenance {
+			status += ", maintenance"
 		}
 
-		responseBody = []byte(strings.TrimSuffix(string(responseBody), "\n"))
+		if len(app.Labels) == 0 {
+			fmt.Printf("%s (%s)\n", app.Name, status)
+			continue
+		}
 
-		return fmt.Errorf("list failed: %s", responseBody)
+		fmt.Printf("%s (%s) %s\n", app.Name, status, formatLabels(app.Labels))
 	}
 
-	var apps []pkg.App
-	if err := json.NewDecoder(resp.Body).Decode(&apps); err != nil {
-		return fmt.Errorf("failed to decode apps: %v", err)
+	return nil
+}
+
+// how often flux list --watch redraws the list
+const listWatchInterval = 2 * time.Second
+
+// watchApps redraws a table of the apps until it is interrupted
+func watchApps(config models.Config, labels []string) error {
+	// the cli exits on the first interrupt by default, but the cursor should be left below the table
+	signal.Reset(os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(listWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		table, err := renderAppTable(ctx, config, labels)
+		if ctx.Err() != nil {
+			fmt.Println()
+			return nil
+		}
+
+		// clear the screen and move the cursor home, the whole frame is written at once so that it doesn't flicker
+		var frame bytes.Buffer
+		fmt.Fprintf(&frame, "\033[H\033[2J%s, press Ctrl-C to stop\n\n", time.Now().Format("15:04:05"))
+		if err != nil {
+			fmt.Fprintln(&frame, err)
+		} else {
+			frame.Write(table)
+		}
+		os.Stdout.Write(frame.Bytes())
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// renderAppTable returns a table of the apps with their status, replicas, and the requests that the proxy is
+// currently sending to them
+func renderAppTable(ctx context.Context, config models.Config, labels []string) ([]byte, error) {
+	c := newClient(config)
+	apps, err := c.List(ctx, labels...)
+	if err != nil {
+		return nil, fmt.Errorf("list failed: %w", err)
 	}
 
 	if len(apps) == 0 {
-		fmt.Println("No apps found")
-		return nil
+		return []byte("No apps found\n"), nil
 	}
 
+	// daemons that predate the routing table don't report active requests
+	activeRequests := make(map[string]int64)
+	routes, routesErr := c.Routes(ctx)
+	for _, route := range routes {
+		activeRequests[route.App] += route.ActiveRequests
+	}
+
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tREPLICAS\tACTIVE REQUESTS\tLABELS")
 	for _, app := range apps {
-		fmt.Printf("%s (%s)\n", app.Name, app.DeploymentStatus)
+		status := app.DeploymentStatus
+		if app.Maintenance {
+			status += ", maintenance"
+		}
+
+		requests := "-"
+		if routesErr == nil {
+			requests = fmt.Sprint(activeRequests[app.Name])
+		}
+
+		appLabels := "-"
+		if len(app.Labels) > 0 {
+			appLabels = formatLabels(app.Labels)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", app.Name, status, app.Replicas, requests, appLabels)
 	}
 
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return table.Bytes(), nil
+}
+
+// repeatedFlag collects every value of a flag that can be passed more than once
+type repeatedFlag []string
+
+func (l *repeatedFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *repeatedFlag) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return "[" + strings.Join(pairs, " ") + "]"
+}
+
+// getApps lists the apps in the daemon, only the ones that match every label selector when there are any
+func getApps(config models.Config, labels []string) ([]pkg.App, error) {
+	apps, err := newClient(config).List(context.Background(), labels...)
+	if err != nil {
+		return nil, fmt.Errorf("list failed: %w", err)
+	}
+
+	return apps, nil
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+	"github.com/juls0730/flux/pkg/client"
+)
+
+func LogsCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux logs [project-name] [flags]
+
+		Options:
+		  project-name: The name of the project to show the logs of
+
+		Flags:
+		  --tail <n>: Only show the last n lines
+		  --since <time>: Only show lines written after a time, either relative like 1h or 30m, or absolute like
+		    2024-05-01, 2024-05-01T15:04:05 in local time, or an RFC 3339 timestamp
+		  --until <time>: Only show lines written before a time, in the same formats as --since
+
+		Flux will show the logs of the app in the current directory or the specified project. Apps with logs enabled in
+		flux.json show their logs across deploys, other apps only show the logs of their current containers. The
+		output of replicas is merged in the order it was written in.`)
+		return nil
+	}
+
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	tail := flags.Int("tail", 0, "Only show the last n lines")
+	since := flags.String("since", "", "Only show lines written after a time")
+	until := flags.String("until", "", "Only show lines written before a time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *tail < 0 {
+		return fmt.Errorf("--tail must be a positive number")
+	}
+
+	opts := client.LogsOptions{Tail: *tail}
+	now := time.Now()
+	var err error
+	if *since != "" {
+		if opts.Since, err = parseLogTime(*since, now); err != nil {
+			return fmt.Errorf("invalid --since: %v", err)
+		}
+	}
+	if *until != "" {
+		if opts.Until, err = parseLogTime(*until, now); err != nil {
+			return fmt.Errorf("invalid --until: %v", err)
+		}
+	}
+
+	if !opts.Since.IsZero() && !opts.Until.IsZero() && opts.Until.Before(opts.Since) {
+		return fmt.Errorf("--until must not be before --since")
+	}
+
+	projectName, err := GetProjectName("logs", flags.Args())
+	if err != nil {
+		return err
+	}
+
+	logs, err := newClient(config).Logs(context.Background(), projectName, opts)
+	if err != nil {
+		return appError("logs", projectName, err)
+	}
+	defer logs.Close()
+
+	if _, err := io.Copy(os.Stdout, logs); err != nil {
+		return fmt.Errorf("failed to read logs: %v", err)
+	}
+
+	return nil
+}
+
+// parseLogTime parses a time that is either relative to now, like 1h, or absolute. Absolute times without a time zone
+// are in local time
+func parseLogTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("%s is negative", value)
+		}
+
+		return now.Add(-d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q is neither a duration like 1h nor a time like 2024-05-01T15:04:05", value)
+}
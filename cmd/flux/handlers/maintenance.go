@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func MaintenanceCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux maintenance <on|off> [flags] [project-name]
+
+		Options:
+		  project-name: The name of the project to put in or take out of maintenance
+
+		Flags:
+		  --page <file>: An html file that is served instead of the default maintenance page
+
+		Flux will have the proxy answer every request to the app in the current directory or the specified project
+		with a 503 and a maintenance page, without touching its containers. Maintenance mode is kept across daemon
+		restarts and redeploys until it is turned off.`)
+		return nil
+	}
+
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off") {
+		return fmt.Errorf("usage: flux maintenance <on|off> [project-name]")
+	}
+	enabled := args[0] == "on"
+
+	flags := flag.NewFlagSet("maintenance", flag.ContinueOnError)
+	pagePath := flags.String("page", "", "An html file that is served instead of the default maintenance page")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *pagePath != "" && !enabled {
+		return fmt.Errorf("--page can only be used with flux maintenance on")
+	}
+
+	var page string
+	if *pagePath != "" {
+		pageBytes, err := os.ReadFile(*pagePath)
+		if err != nil {
+			return fmt.Errorf("failed to read maintenance page: %v", err)
+		}
+
+		page = string(pageBytes)
+	}
+
+	projectName, err := GetProjectName("maintenance "+args[0], flags.Args())
+	if err != nil {
+		return err
+	}
+
+	if err := newClient(config).Maintenance(context.Background(), projectName, enabled, page); err != nil {
+		return appError("maintenance", projectName, err)
+	}
+
+	if enabled {
+		fmt.Printf("%s is now in maintenance\n", projectName)
+	} else {
+		fmt.Printf("%s is out of maintenance\n", projectName)
+	}
+
+	return nil
+}
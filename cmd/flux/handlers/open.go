@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func getApp(config models.Config, projectName string) (*pkg.App, error) {
+	app, err := newClient(config).App(context.Background(), projectName)
+	if err != nil {
+		return nil, appError("get app", projectName, err)
+	}
+
+	return &app, nil
+}
+
+// openBrowser opens url in the default browser
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		// there is no browser to open without a display
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return fmt.Errorf("no display found")
+		}
+
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	return cmd.Start()
+}
+
+func OpenCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux open [project-name]
+
+		Options:
+		  project-name: The name of the project to open
+
+		Flux will open the url of the app in your browser, or print it if no browser can be opened.`)
+		return nil
+	}
+
+	projectName, err := GetProjectName("open", args)
+	if err != nil {
+		return err
+	}
+
+	app, err := getApp(config, projectName)
+	if err != nil {
+		return err
+	}
+
+	if app.HostPort != 0 {
+		return fmt.Errorf("%s is a tcp app, it can't be opened in a browser but it is reachable on port %d of the daemon host", projectName, app.HostPort)
+	}
+
+	if app.URL == "" {
+		return fmt.Errorf("the daemon did not return a url for %s, it may need to be updated", projectName)
+	}
+
+	appURL := "http://" + app.URL
+	if err := openBrowser(appURL); err != nil {
+		fmt.Println(appURL)
+		return nil
+	}
+
+	fmt.Printf("Opening %s\n", appURL)
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func PauseCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux pause [project-name]
+
+		Options:
+		  project-name: The name of the project to pause
+
+		Flux will freeze the containers of the app in the current directory or the specified project. Unlike stop,
+		the processes keep their memory, they just don't get any cpu time until the app is unpaused. The proxy
+		responds with a 503 while the app is paused.`)
+		return nil
+	}
+
+	projectName, err := GetProjectName("pause", args)
+	if err != nil {
+		return err
+	}
+
+	if err := newClient(config).Pause(context.Background(), projectName); err != nil {
+		if errorCode(err) == pkg.ErrorCodeAlreadyPaused {
+			fmt.Printf("%s is already paused\n", projectName)
+			return nil
+		}
+
+		return appError("pause", projectName, err)
+	}
+
+	fmt.Printf("Successfully paused %s\n", projectName)
+	return nil
+}
+
+func UnpauseCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux unpause [project-name]
+
+		Options:
+		  project-name: The name of the project to unpause
+
+		Flux will resume the containers of the app in the current directory or the specified project after flux pause.`)
+		return nil
+	}
+
+	projectName, err := GetProjectName("unpause", args)
+	if err != nil {
+		return err
+	}
+
+	if err := newClient(config).Unpause(context.Background(), projectName); err != nil {
+		if errorCode(err) == pkg.ErrorCodeNotPaused {
+			fmt.Printf("%s is not paused\n", projectName)
+			return nil
+		}
+
+		return appError("unpause", projectName, err)
+	}
+
+	fmt.Printf("Successfully unpaused %s\n", projectName)
+	return nil
+}
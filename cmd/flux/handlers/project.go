@@ -1,30 +1,68 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/juls0730/flux/pkg"
 )
 
+var errNoProjectConfig = errors.New("no flux.json or flux.yaml found")
+
+// findProjectConfig returns the name of the project config file in the current directory, flux.json wins over the
+// yaml variants when there are several
+func findProjectConfig() (string, error) {
+	var found []string
+	for _, name := range pkg.ProjectConfigFiles {
+		if _, err := os.Stat(name); err == nil {
+			found = append(found, name)
+		}
+	}
+
+	if len(found) == 0 {
+		return "", errNoProjectConfig
+	}
+
+	if len(found) > 1 {
+		fmt.Fprintf(os.Stderr, "Warning: found %s, using %s\n", strings.Join(found, " and "), found[0])
+	}
+
+	return found[0], nil
+}
+
+// readProjectConfig reads the project config of the current directory, along with the name of the file it was read
+// from and its raw contents
+func readProjectConfig() (pkg.ProjectConfig, string, []byte, error) {
+	path, err := findProjectConfig()
+	if err != nil {
+		return pkg.ProjectConfig{}, "", nil, err
+	}
+
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return pkg.ProjectConfig{}, path, nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	config, err := pkg.DecodeProjectConfig(configBytes, pkg.IsYAMLConfig(path))
+	if err != nil {
+		return pkg.ProjectConfig{}, path, configBytes, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+
+	return config, path, configBytes, nil
+}
+
 func GetProjectName(command string, args []string) (string, error) {
 	var projectName string
 
 	if len(args) == 0 {
-		if _, err := os.Stat("flux.json"); err != nil {
+		config, _, _, err := readProjectConfig()
+		if errors.Is(err, errNoProjectConfig) {
 			return "", fmt.Errorf("usage: flux %[1]s <app name>, or run flux %[1]s in the project directory", command)
 		}
-
-		fluxConfigFile, err := os.Open("flux.json")
 		if err != nil {
-			return "", fmt.Errorf("failed to open flux.json: %v", err)
-		}
-		defer fluxConfigFile.Close()
-
-		var config pkg.ProjectConfig
-		if err := json.NewDecoder(fluxConfigFile).Decode(&config); err != nil {
-			return "", fmt.Errorf("failed to decode flux.json: %v", err)
+			return "", err
 		}
 
 		projectName = config.Name
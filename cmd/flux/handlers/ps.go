@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func PsCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux ps [project-name]
+
+		Options:
+		  project-name: Only show the containers of this project
+
+		Flux will list the containers of every app in the daemon.`)
+		return nil
+	}
+
+	containers, err := newClient(config).Containers(context.Background())
+	if err != nil {
+		return fmt.Errorf("ps failed: %w", err)
+	}
+
+	if len(args) > 0 {
+		var filtered []pkg.ContainerInfo
+		for _, container := range containers {
+			if container.App == args[0] {
+				filtered = append(filtered, container)
+			}
+		}
+		containers = filtered
+	}
+
+	if len(containers) == 0 {
+		fmt.Println("No containers found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tAPP\tROLE\tSTATUS\tIMAGE\tCREATED")
+	for _, container := range containers {
+		created := container.Created
+		if createdAt, err := time.Parse(time.RFC3339Nano, container.Created); err == nil {
+			created = fmt.Sprintf("%s ago", time.Since(createdAt).Round(time.Second))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", container.ContainerID, container.App, container.Role, container.Status, container.Image, created)
+	}
+
+	return w.Flush()
+}
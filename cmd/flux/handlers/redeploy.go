@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func RedeployCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux redeploy [project-name]
+		  
+		Flux will rebuild the app from the code that was uploaded with its last deploy, without uploading the code
+		in the current directory, and start routing traffic to it.`)
+		return nil
+	}
+
+	projectName, err := GetProjectName("redeploy", args)
+	if err != nil {
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if config.Quiet {
+		output = io.Discard
+	}
+
+	loadingSpinner.Suffix = " Deploying"
+	loadingSpinner.Start()
+
+	stream, err := newClient(config).Redeploy(context.Background(), projectName)
+	if err != nil {
+		switch errorCode(err) {
+		case pkg.ErrorCodeNoStoredCode:
+			return fmt.Errorf("redeploy failed: the daemon has no code stored for %s, run flux deploy instead", projectName)
+		case pkg.ErrorCodePaused:
+			return fmt.Errorf("redeploy failed: %s is paused, run flux unpause first", projectName)
+		}
+
+		return appError("redeploy", projectName, err)
+	}
+
+	return followDeploy(stream, config, output, nil, "", loadingSpinner, spinnerWriter)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+func RenameCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux rename <old-name> <new-name>
+
+		Flux will rename the app without redeploying it, its containers and volumes are kept. If the flux.json or flux.yaml
+		in the current directory belongs to the app, its name is updated too.`)
+		return nil
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("usage: flux rename <old-name> <new-name>")
+	}
+	oldName, newName := args[0], args[1]
+
+	if err := newClient(config).Rename(context.Background(), oldName, newName); err != nil {
+		return appError("rename", oldName, err)
+	}
+
+	fmt.Printf("Successfully renamed %s to %s\n", oldName, newName)
+
+	// deploying with the old name from here on would create a second app
+	if err := renameProjectConfig(oldName, newName); err != nil {
+		return fmt.Errorf("the app was renamed, but the project config could not be updated, set its name to %s: %v", newName, err)
+	}
+
+	return nil
+}
+
+// renameProjectConfig updates the name in the project config of the current directory, if it belongs to the app
+func renameProjectConfig(oldName string, newName string) error {
+	projectConfig, configFile, configBytes, err := readProjectConfig()
+	if errors.Is(err, errNoProjectConfig) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if projectConfig.Name != oldName {
+		return nil
+	}
+
+	if pkg.IsYAMLConfig(configFile) {
+		configBytes, err = renameYAMLConfig(configBytes, newName)
+	} else {
+		configBytes, err = renameJSONConfig(configBytes, newName)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(configFile, configBytes, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated the name in %s\n", configFile)
+	return nil
+}
+
+// renameJSONConfig edits flux.json as a generic object, so that fields that this version of the cli doesn't know are
+// kept
+func renameJSONConfig(configBytes []byte, newName string) ([]byte, error) {
+	var rawConfig map[string]any
+	if err := json.Unmarshal(configBytes, &rawConfig); err != nil {
+		return nil, err
+	}
+	rawConfig["name"] = newName
+
+	configBytes, err := json.MarshalIndent(rawConfig, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(configBytes, '\n'), nil
+}
+
+// renameYAMLConfig edits the yaml document in place, so that comments and unknown fields are kept
+func renameYAMLConfig(configBytes []byte, newName string) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(configBytes, &document); err != nil {
+		return nil, err
+	}
+
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("the config is not a yaml mapping")
+	}
+
+	mapping := document.Content[0]
+	renamed := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "name" {
+			mapping.Content[i+1].SetString(newName)
+			renamed = true
+			break
+		}
+	}
+
+	if !renamed {
+		return nil, fmt.Errorf("the config has no name")
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+const (
+	initialBackoff = 250 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+)
+
+// retryWithBackoff calls fn until it succeeds, it fails with an error that shouldRetry rejects, or timeout has passed,
+// waiting twice as long after every failed attempt
+func retryWithBackoff(timeout time.Duration, shouldRetry func(error) bool, fn func() error) error {
+	deadline := time.Now().Add(timeout)
+	backoff := initialBackoff
+
+	for {
+		err := fn()
+		if err == nil || time.Now().Add(backoff).After(deadline) || !shouldRetry(err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// isDialError reports whether err happened while connecting, in which case nothing was sent to the daemon yet
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// WaitForDaemon gets the daemon info, retrying for up to config.Timeout so that a daemon that is restarting doesn't
+// fail the command
+func WaitForDaemon(config models.Config) (pkg.Info, error) {
+	var info pkg.Info
+	warned := false
+
+	err := retryWithBackoff(config.Timeout, func(error) bool {
+		if !warned && !config.Quiet {
+			fmt.Fprintln(os.Stderr, "Failed to connect to daemon, retrying...")
+			warned = true
+		}
+
+		return true
+	}, func() (err error) {
+		info, err = getDaemonInfo(config)
+		return err
+	})
+
+	return info, err
+}
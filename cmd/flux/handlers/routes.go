@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func RoutesCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux routes
+
+		Flux will print the routing table of the reverse proxy, every host with the app and the containers that its
+		requests are sent to. Requests for a host that isn't listed are answered with a 404.`)
+		return nil
+	}
+
+	routes, err := newClient(config).Routes(context.Background())
+	if err != nil {
+		return fmt.Errorf("routes failed: %w", err)
+	}
+
+	if len(routes) == 0 {
+		fmt.Println("No routes found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "HOST\tAPP\tPROTOCOL\tSTATE\tACTIVE REQUESTS\tUPSTREAMS")
+	for _, route := range routes {
+		var upstreams []string
+		for _, upstream := range route.Upstreams {
+			address := upstream.URL
+			if u, err := url.Parse(upstream.URL); err == nil && u.Host != "" {
+				address = u.Host
+			}
+
+			if !upstream.Healthy {
+				address += " (unhealthy)"
+			}
+			upstreams = append(upstreams, address)
+		}
+
+		if len(upstreams) == 0 {
+			upstreams = []string{"-"}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", route.Host, route.App, route.Protocol, route.State, route.ActiveRequests, strings.Join(upstreams, ", "))
+	}
+
+	return w.Flush()
+}
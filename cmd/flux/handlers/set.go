@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func SetCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux set [flags] [project-name]
+
+		Flags:
+		  --env <KEY=value>: Set an environment variable, replacing the variable with the same name
+		  --unset-env <KEY>: Remove an environment variable
+		  --label <key=value>: Set a label, replacing the label with the same key
+		  --unset-label <key>: Remove a label
+		  Every flag can be passed more than once
+
+		Flux will change the environment and labels of the app in the current directory or the specified project and
+		replace its containers with ones that use the changes, from the image that is already built. Like a deploy,
+		the new containers only receive traffic once they are healthy. The next flux deploy replaces the changes with
+		the environment and labels in flux.json.`)
+		return nil
+	}
+
+	var setEnv, unsetEnv, setLabels, unsetLabels repeatedFlag
+	flags := flag.NewFlagSet("set", flag.ContinueOnError)
+	flags.Var(&setEnv, "env", "Set an environment variable")
+	flags.Var(&unsetEnv, "unset-env", "Remove an environment variable")
+	flags.Var(&setLabels, "label", "Set a label")
+	flags.Var(&unsetLabels, "unset-label", "Remove a label")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	update := pkg.ConfigUpdate{
+		SetEnv:      setEnv,
+		UnsetEnv:    unsetEnv,
+		UnsetLabels: unsetLabels,
+	}
+	for _, label := range setLabels {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok {
+			return fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+
+		if update.SetLabels == nil {
+			update.SetLabels = make(map[string]string)
+		}
+		update.SetLabels[key] = value
+	}
+
+	if len(setEnv) == 0 && len(unsetEnv) == 0 && len(setLabels) == 0 && len(unsetLabels) == 0 {
+		return fmt.Errorf("nothing to change, pass --env, --unset-env, --label, or --unset-label")
+	}
+
+	projectName, err := GetProjectName("set", flags.Args())
+	if err != nil {
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if config.Quiet {
+		output = io.Discard
+	}
+
+	loadingSpinner.Suffix = " Deploying"
+	loadingSpinner.Start()
+
+	stream, err := newClient(config).UpdateConfig(context.Background(), projectName, update)
+	if err != nil {
+		if errorCode(err) == pkg.ErrorCodePaused {
+			return fmt.Errorf("set failed: %s is paused, run flux unpause first", projectName)
+		}
+
+		return appError("set", projectName, err)
+	}
+
+	return followDeploy(stream, config, output, nil, "", loadingSpinner, spinnerWriter)
+}
@@ -1,10 +1,8 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
 
 	"github.com/briandowns/spinner"
 	"github.com/juls0730/flux/cmd/flux/models"
@@ -25,21 +23,13 @@ func StartCommand(seekingHelp bool, config models.Config, info pkg.Info, loading
 		return err
 	}
 
-	req, err := http.Post(config.DeamonURL+"/start/"+projectName, "application/json", nil)
-	if err != nil {
-		return fmt.Errorf("failed to start app: %v", err)
-	}
-	defer req.Body.Close()
-
-	if req.StatusCode != http.StatusOK {
-		responseBody, err := io.ReadAll(req.Body)
-		if err != nil {
-			return fmt.Errorf("error reading response body: %v", err)
+	if err := newClient(config).Start(context.Background(), projectName); err != nil {
+		if errorCode(err) == pkg.ErrorCodeAlreadyRunning {
+			fmt.Printf("%s is already running\n", projectName)
+			return nil
 		}
 
-		responseBody = []byte(strings.TrimSuffix(string(responseBody), "\n"))
-
-		return fmt.Errorf("start failed: %s", responseBody)
+		return appError("start", projectName, err)
 	}
 
 	fmt.Printf("Successfully started %s\n", projectName)
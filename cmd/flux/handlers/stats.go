@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func getAppStats(config models.Config, projectName string) (*pkg.AppStats, error) {
+	stats, err := newClient(config).Stats(context.Background(), projectName)
+	if err != nil {
+		return nil, appError("stats", projectName, err)
+	}
+
+	return &stats, nil
+}
+
+func printStats(stats *pkg.AppStats) {
+	printRow := func(name string, s pkg.ContainerStats) {
+		fmt.Printf("%-14s %7.2f%%  %10s / %-10s  %10s / %-10s\n", name, s.CPUPercent, formatBytes(s.MemoryUsage), formatBytes(s.MemoryLimit), formatBytes(s.NetworkRx), formatBytes(s.NetworkTx))
+	}
+
+	fmt.Printf("%-14s %8s  %23s  %23s\n", "CONTAINER", "CPU", "MEM USAGE / LIMIT", "NET RX / TX")
+	for _, container := range stats.Containers {
+		printRow(container.ContainerID, container)
+	}
+
+	if len(stats.Containers) > 1 {
+		printRow("total", stats.Total)
+	}
+}
+
+func StatsCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux stats [project-name] [flags]
+
+		Options:
+		  project-name: The name of the project to show the stats of
+
+		Flags:
+		  --watch: Keep refreshing the stats every couple of seconds
+		  
+		Flux will show the CPU, memory, and network usage of the app in the current directory or the specified project.`)
+		return nil
+	}
+
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	watch := flags.Bool("watch", false, "Keep refreshing the stats every couple of seconds")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	projectName, err := GetProjectName("stats", flags.Args())
+	if err != nil {
+		return err
+	}
+
+	for {
+		stats, err := getAppStats(config, projectName)
+		if err != nil {
+			return err
+		}
+
+		if *watch {
+			// move the cursor to the top left and clear the screen before redrawing
+			fmt.Print("\033[H\033[2J")
+		}
+
+		printStats(stats)
+
+		if !*watch {
+			return nil
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
@@ -1,10 +1,8 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
 
 	"github.com/briandowns/spinner"
 	"github.com/juls0730/flux/cmd/flux/models"
@@ -25,21 +23,13 @@ func StopCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingS
 		return err
 	}
 
-	req, err := http.Post(config.DeamonURL+"/stop/"+projectName, "application/json", nil)
-	if err != nil {
-		return fmt.Errorf("failed to stop app: %v", err)
-	}
-	defer req.Body.Close()
-
-	if req.StatusCode != http.StatusOK {
-		responseBody, err := io.ReadAll(req.Body)
-		if err != nil {
-			return fmt.Errorf("error reading response body: %v", err)
+	if err := newClient(config).Stop(context.Background(), projectName); err != nil {
+		if errorCode(err) == pkg.ErrorCodeAlreadyStopped {
+			fmt.Printf("%s is already stopped\n", projectName)
+			return nil
 		}
 
-		responseBody = []byte(strings.TrimSuffix(string(responseBody), "\n"))
-
-		return fmt.Errorf("stop failed: %s", responseBody)
+		return appError("stop", projectName, err)
 	}
 
 	fmt.Printf("Successfully stopped %s\n", projectName)
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+func formatVersion(version pkg.VersionInfo) string {
+	return fmt.Sprintf("%s (commit %s, built %s)", version.Version, version.Commit, version.Date)
+}
+
+func VersionCommand(seekingHelp bool, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter, args []string) error {
+	if seekingHelp {
+		fmt.Println(`Usage:
+		  flux version
+
+		Flux will print the version of the cli and of the daemon it is connected to.`)
+		return nil
+	}
+
+	client := pkg.CurrentVersion()
+	fmt.Printf("Client: %s\n", formatVersion(client))
+
+	info, err := getDaemonInfo(config)
+	if err != nil {
+		fmt.Printf("Daemon: unreachable (%v)\n", err)
+		return nil
+	}
+
+	if info.Version.Version == "" {
+		fmt.Println("Daemon: unknown, the daemon is older than the cli")
+		return nil
+	}
+
+	fmt.Printf("Daemon: %s\n", formatVersion(info.Version))
+
+	// patch releases are compatible with each other, a different major or minor version may not be
+	clientMajor, clientMinor, clientOk := pkg.MajorMinor(client.Version)
+	daemonMajor, daemonMinor, daemonOk := pkg.MajorMinor(info.Version.Version)
+	if clientOk && daemonOk && (clientMajor != daemonMajor || clientMinor != daemonMinor) {
+		fmt.Printf("Warning: the cli (%s) and daemon (%s) versions differ, some commands may not work\n", client.Version, info.Version.Version)
+	}
+
+	return nil
+}
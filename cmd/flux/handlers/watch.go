@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/fsnotify/fsnotify"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+// how long the project has to stay unchanged before it is redeployed, editors and formatters tend to write several
+// files in quick succession
+const watchDebounce = 500 * time.Millisecond
+
+// removedFileInfo stands in for the file info of a path that no longer exists, so that it can still be matched
+// against the ignore patterns
+type removedFileInfo struct {
+	name string
+}
+
+func (fi removedFileInfo) Name() string       { return fi.name }
+func (fi removedFileInfo) Size() int64        { return 0 }
+func (fi removedFileInfo) Mode() fs.FileMode  { return 0 }
+func (fi removedFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi removedFileInfo) IsDir() bool        { return false }
+func (fi removedFileInfo) Sys() any           { return nil }
+
+// projectWatcher reports changes to the files of the project in the current directory that would end up in a deploy
+type projectWatcher struct {
+	watcher *fsnotify.Watcher
+	ignored []string
+	// paths that change because of the deploy itself, such as the log file
+	skip map[string]bool
+}
+
+func newProjectWatcher(skip ...string) (*projectWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %v", err)
+	}
+
+	pw := &projectWatcher{
+		watcher: watcher,
+		skip:    map[string]bool{".git": true},
+	}
+	for _, path := range skip {
+		if path != "" {
+			pw.skip[filepath.Clean(path)] = true
+		}
+	}
+
+	if err := pw.loadIgnorePatterns(); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	if err := pw.add("."); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+func (pw *projectWatcher) loadIgnorePatterns() error {
+	ignored, err := readIgnorePatterns()
+	if err != nil {
+		return fmt.Errorf("failed to read .fluxignore: %v", err)
+	}
+
+	pw.ignored = ignored
+	return nil
+}
+
+// add watches root and every directory below it that isn't ignored, fsnotify doesn't watch recursively on its own
+func (pw *projectWatcher) add(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the directory may have been removed again before we got to it
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if path != "." && pw.ignoredPath(path, info) {
+			return filepath.SkipDir
+		}
+
+		return pw.watcher.Add(path)
+	})
+}
+
+func (pw *projectWatcher) ignoredPath(path string, info os.FileInfo) bool {
+	path = filepath.Clean(path)
+	for dir := path; dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if pw.skip[dir] {
+			return true
+		}
+	}
+
+	return matchesIgnorePattern(path, info, pw.ignored)
+}
+
+// changed reports whether an event is a change to the project, directories that are created are watched as well
+func (pw *projectWatcher) changed(event fsnotify.Event) bool {
+	if event.Has(fsnotify.Chmod) {
+		return false
+	}
+
+	var info os.FileInfo = removedFileInfo{name: filepath.Base(event.Name)}
+	if stat, err := os.Lstat(event.Name); err == nil {
+		info = stat
+	}
+
+	if pw.ignoredPath(event.Name, info) {
+		return false
+	}
+
+	if filepath.Clean(event.Name) == ".fluxignore" {
+		if err := pw.loadIgnorePatterns(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	if event.Has(fsnotify.Create) && info.IsDir() {
+		if err := pw.add(event.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to watch %s: %v\n", event.Name, err)
+		}
+	}
+
+	return true
+}
+
+func (pw *projectWatcher) Close() error {
+	return pw.watcher.Close()
+}
+
+// watchAndDeploy deploys the app, and deploys it again whenever its files change until it is interrupted. A deploy
+// that is still running when the files change again is no longer followed, the daemon finishes it and then deploys the
+// new changes
+func watchAndDeploy(opts deployOptions, logFilePath string, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter) error {
+	watcher, err := newProjectWatcher(logFilePath)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// the cli exits on the first interrupt by default, but it should stop following the running deploy first
+	signal.Reset(os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var cancelDeploy context.CancelFunc
+	var deployDone chan struct{}
+	startDeploy := func() {
+		deployCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		cancelDeploy, deployDone = cancel, done
+
+		go func() {
+			defer close(done)
+
+			err := deploy(deployCtx, opts, config, info, loadingSpinner, spinnerWriter)
+			if loadingSpinner.Active() {
+				loadingSpinner.Stop()
+			}
+
+			if err != nil && deployCtx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+
+			if deployCtx.Err() == nil {
+				fmt.Println("Watching for changes, press Ctrl-C to stop")
+			}
+		}()
+	}
+	cancelRunningDeploy := func() {
+		cancelDeploy()
+		<-deployDone
+	}
+
+	startDeploy()
+
+	// the timer only fires once the project stopped changing for the debounce duration
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	var changedPath string
+
+	for {
+		select {
+		case <-ctx.Done():
+			cancelRunningDeploy()
+			fmt.Println("Stopped watching")
+			return nil
+		case event, ok := <-watcher.watcher.Events:
+			if !ok {
+				cancelRunningDeploy()
+				return nil
+			}
+
+			if watcher.changed(event) {
+				changedPath = filepath.Clean(event.Name)
+				debounce.Reset(watchDebounce)
+			}
+		case err, ok := <-watcher.watcher.Errors:
+			if !ok {
+				cancelRunningDeploy()
+				return nil
+			}
+
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// some changes were missed, so redeploy to be safe
+				debounce.Reset(watchDebounce)
+				continue
+			}
+
+			fmt.Fprintf(os.Stderr, "File watcher error: %v\n", err)
+		case <-debounce.C:
+			cancelRunningDeploy()
+			if changedPath == "" {
+				fmt.Println("Files changed, rebuilding...")
+			} else {
+				fmt.Printf("%s changed, rebuilding...\n", changedPath)
+			}
+			changedPath = ""
+			startDeploy()
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/briandowns/spinner"
+	"github.com/juls0730/flux/cmd/flux/models"
+	"github.com/juls0730/flux/pkg"
+)
+
+var errNoWorkspaceConfig = errors.New("no flux.workspace.json or flux.workspace.yaml found")
+
+// readWorkspaceConfig reads the workspace config of the current directory, along with the name of the file it was
+// read from
+func readWorkspaceConfig() (pkg.WorkspaceConfig, string, error) {
+	for _, name := range pkg.WorkspaceConfigFiles {
+		configBytes, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return pkg.WorkspaceConfig{}, name, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		config, err := pkg.DecodeWorkspaceConfig(configBytes, pkg.IsYAMLConfig(name))
+		if err != nil {
+			return pkg.WorkspaceConfig{}, name, fmt.Errorf("failed to decode %s: %v", name, err)
+		}
+
+		return config, name, nil
+	}
+
+	return pkg.WorkspaceConfig{}, "", errNoWorkspaceConfig
+}
+
+// deploymentOrder sorts the services so that every service comes after the services that it depends on, services
+// without dependencies between them keep the order that they are listed in
+func deploymentOrder(services []pkg.WorkspaceService) ([]pkg.WorkspaceService, error) {
+	byName := make(map[string]pkg.WorkspaceService)
+	for _, service := range services {
+		if service.Name == "" {
+			return nil, fmt.Errorf("every service needs a name")
+		}
+
+		if service.Path == "" {
+			return nil, fmt.Errorf("service %s has no path", service.Name)
+		}
+
+		if _, ok := byName[service.Name]; ok {
+			return nil, fmt.Errorf("there are several services named %s", service.Name)
+		}
+		byName[service.Name] = service
+	}
+
+	var ordered []pkg.WorkspaceService
+	// services that are being visited are false, services that are ordered are true
+	visited := make(map[string]bool)
+	var visit func(service pkg.WorkspaceService, path []string) error
+	visit = func(service pkg.WorkspaceService, path []string) error {
+		done, seen := visited[service.Name]
+		if done {
+			return nil
+		}
+		if seen {
+			return fmt.Errorf("services depend on each other in a cycle: %s", strings.Join(append(path, service.Name), " -> "))
+		}
+
+		visited[service.Name] = false
+		for _, dependency := range service.DependsOn {
+			dependencyService, ok := byName[dependency]
+			if !ok {
+				return fmt.Errorf("service %s depends on %s, which isn't a service", service.Name, dependency)
+			}
+
+			if err := visit(dependencyService, append(path, service.Name)); err != nil {
+				return err
+			}
+		}
+		visited[service.Name] = true
+		ordered = append(ordered, service)
+
+		return nil
+	}
+
+	for _, service := range services {
+		if err := visit(service, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// deployWorkspace deploys every service of the workspace in the current directory one after the other. A service that
+// fails doesn't stop the others, only the services that depend on it
+func deployWorkspace(opts deployOptions, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter) error {
+	workspace, configName, err := readWorkspaceConfig()
+	if err != nil {
+		return err
+	}
+
+	services, err := deploymentOrder(workspace.Services)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", configName, err)
+	}
+
+	if len(services) == 0 {
+		return fmt.Errorf("%s has no services", configName)
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	results := make(map[string]string)
+	failed := make(map[string]bool)
+	for _, service := range services {
+		var failedDependency string
+		for _, dependency := range service.DependsOn {
+			if failed[dependency] {
+				failedDependency = dependency
+				break
+			}
+		}
+
+		if failedDependency != "" {
+			failed[service.Name] = true
+			results[service.Name] = fmt.Sprintf("skipped, %s failed", failedDependency)
+			continue
+		}
+
+		fmt.Printf("Deploying %s from %s\n", service.Name, service.Path)
+		if err := deployService(root, service, opts, config, info, loadingSpinner, spinnerWriter); err != nil {
+			if loadingSpinner.Active() {
+				loadingSpinner.Stop()
+			}
+
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			failed[service.Name] = true
+			results[service.Name] = fmt.Sprintf("failed: %v", err)
+			continue
+		}
+
+		results[service.Name] = "deployed"
+	}
+
+	if opts.dryRun {
+		return nil
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tRESULT")
+	for _, service := range services {
+		fmt.Fprintf(w, "%s\t%s\n", service.Name, results[service.Name])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d services were not deployed", len(failed), len(services))
+	}
+
+	return nil
+}
+
+// deployService deploys a service of the workspace from its directory, with the config from the workspace config
+func deployService(root string, service pkg.WorkspaceService, opts deployOptions, config models.Config, info pkg.Info, loadingSpinner *spinner.Spinner, spinnerWriter *models.CustomSpinnerWriter) error {
+	projectConfig, err := json.Marshal(service.ProjectConfig)
+	if err != nil {
+		return fmt.Errorf("failed to encode the config of %s: %v", service.Name, err)
+	}
+	opts.configPath = "flux.json"
+	opts.config = projectConfig
+
+	// the code of the service is archived from the current directory
+	if err := os.Chdir(filepath.Join(root, service.Path)); err != nil {
+		return fmt.Errorf("failed to enter the directory of %s: %v", service.Name, err)
+	}
+	defer os.Chdir(root)
+
+	return deploy(context.Background(), opts, config, info, loadingSpinner, spinnerWriter)
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	_ "embed"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -21,48 +22,96 @@ import (
 //go:embed config.json
 var config []byte
 
-var configPath = filepath.Join(os.Getenv("HOME"), "/.config/flux")
-
 var helpStr = `Usage:
   flux <command>
 
 Available Commands:
   init        Initialize a new project
   deploy      Deploy a new version of the app
+  redeploy    Rebuild an app from its last uploaded code
+  set         Change the environment and labels of an app without rebuilding it
   stop        Stop a container
   start       Start a container
+  pause       Freeze an app without stopping it
+  unpause     Resume a paused app
+  maintenance Serve a maintenance page instead of an app
   delete      Delete a container
+  rename      Rename an app without redeploying it
   list        List all containers
+  stats       Show the resource usage of an app
+  logs        Show the logs of an app
+  cp          Copy files into or out of an app
+  describe    Print everything the daemon knows about an app
+  ps          List the containers of every app
+  routes      Show the routing table of the reverse proxy
+  open        Open the app in the browser
+  doctor      List apps in an inconsistent state
+  daemon      Inspect and maintain the daemon
+  config      Get or set the cli config
+  version     Show the cli and daemon versions
+  completion  Generate a shell completion script
 
 Flags:
-  -h, --help   help for flux
+  -h, --help             help for flux
+  --timeout <duration>   how long to keep retrying when the daemon can't be reached (default 10s)
+  --daemon-url <url>     the daemon to connect to instead of the configured one
+  -q, --quiet            hide the spinner and progress output, only print results and errors
+  --verbose              print every request to the daemon and the config that is used to stderr
 
 Use "flux <command> --help" for more information about a command.`
 
 var maxDistance = 3
 
+// how long to keep retrying to reach the daemon when --timeout isn't passed
+var defaultTimeout = 10 * time.Second
+
 type CommandHandler struct {
 	commands map[string]func(bool, models.Config, pkg.Info, *spinner.Spinner, *models.CustomSpinnerWriter, []string) error
+	// commands that run without connecting to the daemon first
+	offline map[string]bool
 }
 
 func (h *CommandHandler) RegisterCmd(name string, handler func(bool, models.Config, pkg.Info, *spinner.Spinner, *models.CustomSpinnerWriter, []string) error) {
 	h.commands[name] = handler
 }
 
+// RegisterOfflineCmd registers a command that has to work even when the daemon is unreachable
+func (h *CommandHandler) RegisterOfflineCmd(name string, handler func(bool, models.Config, pkg.Info, *spinner.Spinner, *models.CustomSpinnerWriter, []string) error) {
+	h.commands[name] = handler
+	h.offline[name] = true
+}
+
+func (h *CommandHandler) Names() []string {
+	names := make([]string, 0, len(h.commands))
+	for name := range h.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func isSeekingHelp(args []string) bool {
+	return len(args) > 0 && (args[len(args)-1] == "--help" || args[len(args)-1] == "-h")
+}
+
 func runCommand(command string, args []string, config models.Config, info pkg.Info, cmdHandler CommandHandler, try int) error {
 	if try == 2 {
 		return fmt.Errorf("unknown command: %s", command)
 	}
 
-	seekingHelp := false
-	if len(args) > 0 && (args[len(args)-1] == "--help" || args[len(args)-1] == "-h") {
-		seekingHelp = true
+	seekingHelp := isSeekingHelp(args)
+	if seekingHelp {
 		args = args[:len(args)-1]
 	}
 
 	spinnerWriter := models.NewCustomSpinnerWriter()
 
-	loadingSpinner := spinner.New(spinner.CharSets[14], 100*time.Millisecond, spinner.WithWriter(spinnerWriter))
+	var spinnerOutput io.Writer = spinnerWriter
+	if config.Quiet {
+		spinnerOutput = io.Discard
+	}
+	loadingSpinner := spinner.New(spinner.CharSets[14], 100*time.Millisecond, spinner.WithWriter(spinnerOutput))
 	defer func() {
 		if loadingSpinner.Active() {
 			loadingSpinner.Stop()
@@ -118,76 +167,169 @@ func runCommand(command string, args []string, config models.Config, info pkg.In
 	return runCommand(command, args, config, info, cmdHandler, try+1)
 }
 
+// takeFlag takes every occurrence of a flag with a value, as either "--name value" or "--name=value", out of args.
+// The last occurrence wins
+func takeFlag(args []string, name string) (value string, found bool, rest []string, err error) {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == name:
+			if i+1 >= len(args) {
+				return "", false, nil, fmt.Errorf("%s requires a value", name)
+			}
+			i++
+			value = args[i]
+		case strings.HasPrefix(args[i], name+"="):
+			value = strings.TrimPrefix(args[i], name+"=")
+		default:
+			rest = append(rest, args[i])
+			continue
+		}
+
+		found = true
+	}
+
+	return value, found, rest, nil
+}
+
+// takeBoolFlag takes every occurrence of a flag without a value, under any of its names, out of args
+func takeBoolFlag(args []string, names ...string) (found bool, rest []string) {
+	for _, arg := range args {
+		if slices.Contains(names, arg) {
+			found = true
+			continue
+		}
+
+		rest = append(rest, arg)
+	}
+
+	return found, rest
+}
+
+// parseGlobalFlags takes the flags that every command accepts out of args and sets them on config, they can be passed
+// before or after the command. The daemon url is returned separately, since it only overrides the config file when it
+// is set
+func parseGlobalFlags(args []string, config *models.Config) (string, []string, error) {
+	config.Timeout = defaultTimeout
+	value, found, args, err := takeFlag(args, "--timeout")
+	if err != nil {
+		return "", nil, fmt.Errorf("--timeout requires a duration, e.g. --timeout 30s")
+	}
+
+	if found {
+		config.Timeout, err = time.ParseDuration(value)
+		if err != nil || config.Timeout < 0 {
+			return "", nil, fmt.Errorf("invalid --timeout %q, expected a duration like 30s", value)
+		}
+	}
+
+	value, found, args, err = takeFlag(args, "--daemon-url")
+	if err != nil {
+		return "", nil, fmt.Errorf("--daemon-url requires a url, e.g. --daemon-url http://127.0.0.1:5647")
+	}
+
+	var daemonURL string
+	if found {
+		daemonURL, err = handlers.ParseDaemonURL(value)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	config.Quiet, args = takeBoolFlag(args, "--quiet", "-q")
+	config.Verbose, args = takeBoolFlag(args, "--verbose")
+	if config.Quiet && config.Verbose {
+		return "", nil, fmt.Errorf("--quiet and --verbose can't be used together")
+	}
+
+	return daemonURL, args, nil
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Println(helpStr)
 		os.Exit(1)
 	}
 
-	if os.Args[1] == "--help" || os.Args[1] == "-h" {
-		fmt.Println(helpStr)
-		os.Exit(0)
-	}
-
-	if _, err := os.Stat(filepath.Join(configPath, "config.json")); err != nil {
-		if err := os.MkdirAll(configPath, 0755); err != nil {
+	if _, err := os.Stat(models.ConfigPath); err != nil {
+		if err := os.MkdirAll(filepath.Dir(models.ConfigPath), 0755); err != nil {
 			fmt.Printf("Failed to create config directory: %v\n", err)
 			os.Exit(1)
 		}
 
-		if err = os.WriteFile(filepath.Join(configPath, "config.json"), config, 0644); err != nil {
+		if err = os.WriteFile(models.ConfigPath, config, 0644); err != nil {
 			fmt.Printf("Failed to write config file: %v\n", err)
 			os.Exit(1)
 		}
 	}
 
-	var config models.Config
-	configBytes, err := os.ReadFile(filepath.Join(configPath, "config.json"))
+	config, err := models.LoadConfig()
 	if err != nil {
-		fmt.Printf("Failed to read config file: %v\n", err)
-		os.Exit(1)
-	}
-
-	if err := json.Unmarshal(configBytes, &config); err != nil {
-		fmt.Printf("Failed to parse config file: %v\n", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	command := os.Args[1]
-	args := os.Args[2:]
-
-	resp, err := http.Get(config.DeamonURL + "/heartbeat")
+	var daemonURL string
+	var args []string
+	daemonURL, args, err = parseGlobalFlags(os.Args[1:], &config)
 	if err != nil {
-		fmt.Println("Failed to connect to daemon")
-		os.Exit(1)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Failed to connect to daemon")
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	var info pkg.Info
-	err = json.NewDecoder(resp.Body).Decode(&info)
-	if err != nil {
-		fmt.Printf("Failed to decode info: %v\n", err)
-		os.Exit(1)
+	if daemonURL != "" {
+		config.DaemonURL = daemonURL
 	}
+	handlers.Verbosef(config, "config: daemon_url=%s auth_token=%s timeout=%s", config.DaemonURL, handlers.MaskToken(config.AuthToken), config.Timeout)
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Failed to connect to daemon")
-		os.Exit(1)
+	if len(args) == 0 || args[0] == "--help" || args[0] == "-h" {
+		fmt.Println(helpStr)
+		os.Exit(0)
 	}
 
+	command := args[0]
+	args = args[1:]
+
 	cmdHandler := CommandHandler{
 		commands: make(map[string]func(bool, models.Config, pkg.Info, *spinner.Spinner, *models.CustomSpinnerWriter, []string) error),
+		offline:  make(map[string]bool),
 	}
 
 	cmdHandler.RegisterCmd("deploy", handlers.DeployCommand)
+	cmdHandler.RegisterCmd("redeploy", handlers.RedeployCommand)
+	cmdHandler.RegisterCmd("set", handlers.SetCommand)
 	cmdHandler.RegisterCmd("stop", handlers.StopCommand)
 	cmdHandler.RegisterCmd("start", handlers.StartCommand)
+	cmdHandler.RegisterCmd("pause", handlers.PauseCommand)
+	cmdHandler.RegisterCmd("unpause", handlers.UnpauseCommand)
+	cmdHandler.RegisterCmd("maintenance", handlers.MaintenanceCommand)
 	cmdHandler.RegisterCmd("delete", handlers.DeleteCommand)
-	cmdHandler.RegisterCmd("init", handlers.InitCommand)
+	cmdHandler.RegisterCmd("list", handlers.ListCommand)
+	cmdHandler.RegisterCmd("rename", handlers.RenameCommand)
+	cmdHandler.RegisterCmd("stats", handlers.StatsCommand)
+	cmdHandler.RegisterCmd("logs", handlers.LogsCommand)
+	cmdHandler.RegisterCmd("cp", handlers.CpCommand)
+	cmdHandler.RegisterCmd("describe", handlers.DescribeCommand)
+	cmdHandler.RegisterCmd("daemon", handlers.DaemonCommand)
+	cmdHandler.RegisterCmd("ps", handlers.PsCommand)
+	cmdHandler.RegisterCmd("routes", handlers.RoutesCommand)
+	cmdHandler.RegisterCmd("open", handlers.OpenCommand)
+	cmdHandler.RegisterCmd("doctor", handlers.DoctorCommand)
+	// config is how a wrong daemon url gets fixed, version reports an unreachable daemon itself, completion only
+	// needs the daemon for completing app names, and init never talks to the daemon
+	cmdHandler.RegisterOfflineCmd("init", handlers.InitCommand)
+	cmdHandler.RegisterOfflineCmd("config", handlers.ConfigCommand)
+	cmdHandler.RegisterOfflineCmd("version", handlers.VersionCommand)
+	cmdHandler.RegisterOfflineCmd("completion", handlers.CompletionCommand(cmdHandler.Names))
+
+	var info pkg.Info
+	// printing the help of a command doesn't need the daemon
+	if !cmdHandler.offline[command] && !isSeekingHelp(args) {
+		info, err = handlers.WaitForDaemon(config)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	err = runCommand(command, args, config, info, cmdHandler, 0)
 	if err != nil {
@@ -1,5 +1,73 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ConfigPath = filepath.Join(os.Getenv("HOME"), ".config/flux/config.json")
+
 type Config struct {
-	DeamonURL string `json:"deamon_url"`
+	DaemonURL string `json:"daemon_url"`
+	// sent to the daemon as a bearer token with every request
+	AuthToken string `json:"auth_token,omitempty"`
+
+	// how long to keep retrying to reach the daemon, set with the --timeout flag
+	Timeout time.Duration `json:"-"`
+	// hide the spinner and progress output, set with the --quiet flag
+	Quiet bool `json:"-"`
+	// print every request to the daemon and the config that is used, set with the --verbose flag
+	Verbose bool `json:"-"`
+}
+
+// UnmarshalJSON also accepts the misspelled deamon_url key that older config files use
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type config Config
+	aux := struct {
+		*config
+		DeamonURL string `json:"deamon_url"`
+	}{
+		config: (*config)(c),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if c.DaemonURL == "" {
+		c.DaemonURL = aux.DeamonURL
+	}
+
+	return nil
+}
+
+func LoadConfig() (Config, error) {
+	var config Config
+	configBytes, err := os.ReadFile(ConfigPath)
+	if err != nil {
+		return config, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	return config, nil
+}
+
+func SaveConfig(config Config) error {
+	configBytes, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ConfigPath), 0755); err != nil {
+		return err
+	}
+
+	// the config can hold the auth token, so keep it private
+	return os.WriteFile(ConfigPath, append(configBytes, '\n'), 0600)
 }
@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"sync"
 )
@@ -34,12 +35,20 @@ func (w *CustomSpinnerWriter) Write(p []byte) (n int, err error) {
 
 type CustomStdout struct {
 	spinner *CustomSpinnerWriter
+	out     io.Writer
 	lock    sync.Mutex
 }
 
-func NewCustomStdout(spinner *CustomSpinnerWriter) *CustomStdout {
+// NewCustomStdout creates a writer that prints above the spinner. If out is anything other than os.Stdout, the
+// output is written to it as is, without the terminal control sequences used to redraw the spinner.
+func NewCustomStdout(spinner *CustomSpinnerWriter, out io.Writer) *CustomStdout {
+	if out == nil {
+		out = os.Stdout
+	}
+
 	return &CustomStdout{
 		spinner: spinner,
+		out:     out,
 		lock:    sync.Mutex{},
 	}
 }
@@ -48,6 +57,10 @@ func (w *CustomStdout) Write(p []byte) (n int, err error) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
+	if w.out != os.Stdout {
+		return w.out.Write(p)
+	}
+
 	n, err = os.Stdout.Write([]byte(fmt.Sprintf("\033[2K\r%s", p)))
 	if err != nil {
 		return n, err
@@ -13,15 +13,35 @@ func main() {
 	defer fluxServer.Stop()
 
 	http.HandleFunc("POST /deploy", fluxServer.DeployHandler)
+	http.HandleFunc("GET /deploy/events", fluxServer.DeployEventsHandler)
+	http.HandleFunc("POST /redeploy/{name}", fluxServer.RedeployHandler)
 	http.HandleFunc("DELETE /deployments", fluxServer.DeleteAllDeploymentsHandler)
 	http.HandleFunc("DELETE /deployments/{name}", fluxServer.DeleteDeployHandler)
 	http.HandleFunc("POST /start/{name}", fluxServer.StartDeployHandler)
 	http.HandleFunc("POST /stop/{name}", fluxServer.StopDeployHandler)
+	http.HandleFunc("POST /pause/{name}", fluxServer.PauseDeployHandler)
+	http.HandleFunc("POST /unpause/{name}", fluxServer.UnpauseDeployHandler)
 	http.HandleFunc("GET /apps", fluxServer.ListAppsHandler)
+	http.HandleFunc("GET /apps/{name}", fluxServer.GetAppHandler)
+	http.HandleFunc("POST /apps/{name}/rename", fluxServer.RenameAppHandler)
+	http.HandleFunc("POST /apps/{name}/maintenance", fluxServer.MaintenanceHandler)
+	http.HandleFunc("POST /apps/{name}/config", fluxServer.UpdateConfigHandler)
+	http.HandleFunc("GET /apps/{name}/describe", fluxServer.DescribeAppHandler)
+	http.HandleFunc("GET /apps/{name}/stats", fluxServer.AppStatsHandler)
+	http.HandleFunc("GET /apps/{name}/logs", fluxServer.AppLogsHandler)
+	http.HandleFunc("GET /apps/{name}/files", fluxServer.RequireAuth(fluxServer.CopyFromAppHandler))
+	http.HandleFunc("PUT /apps/{name}/files", fluxServer.RequireAuth(fluxServer.CopyToAppHandler))
+	http.HandleFunc("GET /containers", fluxServer.ListContainersHandler)
+	http.HandleFunc("GET /proxy/routes", fluxServer.ProxyRoutesHandler)
+	http.HandleFunc("GET /images/inspect", fluxServer.InspectImageHandler)
+	http.HandleFunc("GET /doctor", fluxServer.DoctorHandler)
 	http.HandleFunc("GET /heartbeat", fluxServer.DaemonInfoHandler)
+	http.HandleFunc("GET /config", fluxServer.DaemonConfigHandler)
+	http.HandleFunc("POST /maintenance/db/vacuum", fluxServer.RequireAuth(fluxServer.VacuumDatabaseHandler))
+	http.HandleFunc("GET /maintenance/db/backup", fluxServer.RequireAuth(fluxServer.BackupDatabaseHandler))
+	http.HandleFunc("GET /health", fluxServer.HealthHandler)
 
-	fluxServer.Logger.Info("Fluxd started on http://127.0.0.1:5647")
-	err := http.ListenAndServe(":5647", nil)
+	err := fluxServer.Serve(nil)
 	if err != nil {
 		fluxServer.Logger.Fatalf("Failed to start server: %v", zap.Error(err))
 	}
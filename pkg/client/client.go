@@ -0,0 +1,382 @@
+// Package client is a client for the api of the flux daemon
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// Client talks to a single flux daemon
+type Client struct {
+	// the url of the daemon, such as http://127.0.0.1:5647
+	DaemonURL string
+	// sent as a bearer token with every request when it is set
+	Token string
+	// used for every request, http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+func New(daemonURL string, token string) *Client {
+	return &Client{
+		DaemonURL: strings.TrimSuffix(daemonURL, "/"),
+		Token:     token,
+	}
+}
+
+// Error is an error response from the daemon
+type Error struct {
+	StatusCode int
+	// one of the pkg.ErrorCode constants, empty when the daemon predates error codes
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// readError reads the error response of a failed request, daemons that predate json errors respond with plain text
+func readError(resp *http.Response) *Error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		apiErr.Message = fmt.Sprintf("error reading response body: %v", err)
+		return apiErr
+	}
+
+	var errorResponse pkg.Error
+	if err := json.Unmarshal(body, &errorResponse); err != nil || errorResponse.Message == "" {
+		apiErr.Message = strings.TrimSuffix(string(body), "\n")
+		if apiErr.Message == "" {
+			apiErr.Message = resp.Status
+		}
+
+		return apiErr
+	}
+
+	apiErr.Code = errorResponse.Code
+	apiErr.Message = errorResponse.Message
+	return apiErr
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	if len(query) == 0 {
+		return c.DaemonURL + path
+	}
+
+	return c.DaemonURL + path + "?" + query.Encode()
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, path string, query url.Values, body io.Reader, contentType string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, query), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	return req, nil
+}
+
+// do sends a request and returns the response if it has the expected status, any other status is returned as an
+// *Error. The caller has to close the body of the response
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body io.Reader, contentType string, expected int) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != expected {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+
+	return resp, nil
+}
+
+// getJSON decodes the response to a get request into v
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v any) error {
+	resp, err := c.do(ctx, http.MethodGet, path, query, nil, "", http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return nil
+}
+
+// send sends a request whose response has no body that is of interest
+func (c *Client) send(ctx context.Context, method string, path string, body io.Reader, contentType string) error {
+	resp, err := c.do(ctx, method, path, nil, body, contentType, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func appPath(name string, rest string) string {
+	return "/apps/" + url.PathEscape(name) + rest
+}
+
+// Info returns what the daemon reports about itself, it doubles as a check that the daemon is reachable
+func (c *Client) Info(ctx context.Context) (pkg.Info, error) {
+	var info pkg.Info
+	err := c.getJSON(ctx, "/heartbeat", nil, &info)
+	return info, err
+}
+
+// DaemonConfig returns the config that the daemon is running with, after defaults were applied and with secrets
+// redacted. It is returned as json because the config belongs to the daemon and changes with it
+func (c *Client) DaemonConfig(ctx context.Context) (json.RawMessage, error) {
+	var config json.RawMessage
+	err := c.getJSON(ctx, "/config", nil, &config)
+	return config, err
+}
+
+// Health checks whether the daemon is able to deploy and serve apps, an unhealthy daemon is reported in the returned
+// health rather than as an error
+func (c *Client) Health(ctx context.Context) (pkg.DaemonHealth, error) {
+	var health pkg.DaemonHealth
+
+	req, err := c.newRequest(ctx, http.MethodGet, "/health", nil, nil, "")
+	if err != nil {
+		return health, err
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return health, err
+	}
+	defer resp.Body.Close()
+
+	// the daemon responds with 503 when it is unhealthy, the body still describes why
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return health, readError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return health, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return health, nil
+}
+
+// List returns the apps in the daemon. When labels are given, only the apps that match every one of them are
+// returned, a label is either key or key=value
+func (c *Client) List(ctx context.Context, labels ...string) ([]pkg.App, error) {
+	var query url.Values
+	if len(labels) > 0 {
+		query = url.Values{"label": labels}
+	}
+
+	var apps []pkg.App
+	err := c.getJSON(ctx, "/apps", query, &apps)
+	return apps, err
+}
+
+func (c *Client) App(ctx context.Context, name string) (pkg.App, error) {
+	var app pkg.App
+	err := c.getJSON(ctx, appPath(name, ""), nil, &app)
+	return app, err
+}
+
+// Describe returns everything that the daemon knows about an app
+func (c *Client) Describe(ctx context.Context, name string) (pkg.AppDescription, error) {
+	var description pkg.AppDescription
+	err := c.getJSON(ctx, appPath(name, "/describe"), nil, &description)
+	return description, err
+}
+
+func (c *Client) Stats(ctx context.Context, name string) (pkg.AppStats, error) {
+	var stats pkg.AppStats
+	err := c.getJSON(ctx, appPath(name, "/stats"), nil, &stats)
+	return stats, err
+}
+
+// LogsOptions narrows down the logs of an app, the zero value returns all of them
+type LogsOptions struct {
+	// only the last Tail lines when above 0
+	Tail int
+	// only lines from within Since and Until, a zero time leaves that end open
+	Since time.Time
+	Until time.Time
+}
+
+// Logs returns the logs of an app, ordered by time across its containers. The caller has to close the logs
+func (c *Client) Logs(ctx context.Context, name string, opts LogsOptions) (io.ReadCloser, error) {
+	query := url.Values{}
+	if opts.Tail > 0 {
+		query.Set("tail", strconv.Itoa(opts.Tail))
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+	if !opts.Until.IsZero() {
+		query.Set("until", opts.Until.Format(time.RFC3339Nano))
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, appPath(name, "/logs"), query, nil, "", http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Containers returns the containers of every app
+func (c *Client) Containers(ctx context.Context) ([]pkg.ContainerInfo, error) {
+	var containers []pkg.ContainerInfo
+	err := c.getJSON(ctx, "/containers", nil, &containers)
+	return containers, err
+}
+
+// InspectImage returns the ports that an image exposes, the daemon pulls the image if it doesn't have it yet
+func (c *Client) InspectImage(ctx context.Context, imageName string) (pkg.ImageInfo, error) {
+	var info pkg.ImageInfo
+	err := c.getJSON(ctx, "/images/inspect", url.Values{"image": {imageName}}, &info)
+	return info, err
+}
+
+// Routes returns the routing table of the daemon's reverse proxy
+func (c *Client) Routes(ctx context.Context) ([]pkg.Route, error) {
+	var routes []pkg.Route
+	err := c.getJSON(ctx, "/proxy/routes", nil, &routes)
+	return routes, err
+}
+
+// Doctor returns the apps that are in an inconsistent state
+func (c *Client) Doctor(ctx context.Context) ([]pkg.AppProblem, error) {
+	var problems []pkg.AppProblem
+	err := c.getJSON(ctx, "/doctor", nil, &problems)
+	return problems, err
+}
+
+func (c *Client) Start(ctx context.Context, name string) error {
+	return c.send(ctx, http.MethodPost, "/start/"+url.PathEscape(name), nil, "")
+}
+
+func (c *Client) Stop(ctx context.Context, name string) error {
+	return c.send(ctx, http.MethodPost, "/stop/"+url.PathEscape(name), nil, "")
+}
+
+// Pause freezes the containers of an app without stopping them
+func (c *Client) Pause(ctx context.Context, name string) error {
+	return c.send(ctx, http.MethodPost, "/pause/"+url.PathEscape(name), nil, "")
+}
+
+func (c *Client) Unpause(ctx context.Context, name string) error {
+	return c.send(ctx, http.MethodPost, "/unpause/"+url.PathEscape(name), nil, "")
+}
+
+// Maintenance puts an app in or out of maintenance, page is the html that the proxy serves in the meantime and can be
+// left empty for the default page
+func (c *Client) Maintenance(ctx context.Context, name string, enabled bool, page string) error {
+	body, err := json.Marshal(pkg.MaintenanceRequest{Enabled: enabled, Page: page})
+	if err != nil {
+		return err
+	}
+
+	return c.send(ctx, http.MethodPost, "/apps/"+url.PathEscape(name)+"/maintenance", bytes.NewReader(body), "application/json")
+}
+
+// VacuumDatabase compacts the database of the daemon
+func (c *Client) VacuumDatabase(ctx context.Context) error {
+	return c.send(ctx, http.MethodPost, "/maintenance/db/vacuum", nil, "")
+}
+
+// BackupDatabase returns a copy of the sqlite database of the daemon. The caller has to close the backup
+func (c *Client) BackupDatabase(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/maintenance/db/backup", nil, nil, "", http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Delete removes an app along with its containers and volumes
+func (c *Client) Delete(ctx context.Context, name string) error {
+	return c.send(ctx, http.MethodDelete, "/deployments/"+url.PathEscape(name), nil, "")
+}
+
+// ForceDelete removes the containers, generated volumes, and database rows of an app, even if the daemon doesn't know
+// the app anymore or its containers don't match the database
+func (c *Client) ForceDelete(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/deployments/"+url.PathEscape(name), url.Values{"force": {"true"}}, nil, "", http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// DeleteAll removes every app in the daemon
+func (c *Client) DeleteAll(ctx context.Context) error {
+	return c.send(ctx, http.MethodDelete, "/deployments", nil, "")
+}
+
+// Rename renames an app without redeploying it
+func (c *Client) Rename(ctx context.Context, name string, newName string) error {
+	body, err := json.Marshal(pkg.RenameRequest{Name: newName})
+	if err != nil {
+		return err
+	}
+
+	return c.send(ctx, http.MethodPost, appPath(name, "/rename"), bytes.NewReader(body), "application/json")
+}
+
+// CopyFrom returns a tar archive of a path in the head container of an app, the caller has to close it
+func (c *Client) CopyFrom(ctx context.Context, name string, containerPath string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, appPath(name, "/files"), url.Values{"path": {containerPath}}, nil, "", http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// CopyTo extracts a tar archive with a single root into the head container of an app. If containerPath is an
+// existing directory the root is placed inside of it, otherwise the root is renamed to containerPath
+func (c *Client) CopyTo(ctx context.Context, name string, containerPath string, archive io.Reader) error {
+	resp, err := c.do(ctx, http.MethodPut, appPath(name, "/files"), url.Values{"path": {containerPath}}, archive, "application/x-tar", http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
@@ -0,0 +1,392 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// request is what the fake daemon received
+type request struct {
+	method        string
+	path          string
+	query         url.Values
+	authorization string
+	contentType   string
+	body          string
+}
+
+// newDaemon serves handler as the daemon and returns a client for it, along with the last request that it received
+func newDaemon(t *testing.T, handler http.HandlerFunc) (*Client, *request) {
+	t.Helper()
+
+	received := new(request)
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*received = request{
+			method:        r.Method,
+			path:          r.URL.EscapedPath(),
+			query:         r.URL.Query(),
+			authorization: r.Header.Get("Authorization"),
+			contentType:   r.Header.Get("Content-Type"),
+			body:          string(body),
+		}
+
+		handler(w, r)
+	}))
+	t.Cleanup(daemon.Close)
+
+	return New(daemon.URL+"/", "secret"), received
+}
+
+func respondJSON(v any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		call        func(c *Client) error
+		method      string
+		path        string
+		query       url.Values
+		contentType string
+		body        string
+		// what the daemon responds with, an empty object when nil
+		response any
+	}{
+		{
+			name:     "list",
+			call:     func(c *Client) error { _, err := c.List(ctx); return err },
+			method:   http.MethodGet,
+			path:     "/apps",
+			response: []pkg.App{},
+		},
+		{
+			name:     "list by label",
+			call:     func(c *Client) error { _, err := c.List(ctx, "team", "env=prod"); return err },
+			method:   http.MethodGet,
+			path:     "/apps",
+			query:    url.Values{"label": {"team", "env=prod"}},
+			response: []pkg.App{},
+		},
+		{
+			name:   "app names are escaped",
+			call:   func(c *Client) error { _, err := c.Describe(ctx, "my app"); return err },
+			method: http.MethodGet,
+			path:   "/apps/my%20app/describe",
+		},
+		{
+			name:   "start",
+			call:   func(c *Client) error { return c.Start(ctx, "app") },
+			method: http.MethodPost,
+			path:   "/start/app",
+		},
+		{
+			name:   "stop",
+			call:   func(c *Client) error { return c.Stop(ctx, "app") },
+			method: http.MethodPost,
+			path:   "/stop/app",
+		},
+		{
+			name:   "delete",
+			call:   func(c *Client) error { return c.Delete(ctx, "app") },
+			method: http.MethodDelete,
+			path:   "/deployments/app",
+		},
+		{
+			name:   "force delete",
+			call:   func(c *Client) error { return c.ForceDelete(ctx, "app") },
+			method: http.MethodDelete,
+			path:   "/deployments/app",
+			query:  url.Values{"force": {"true"}},
+		},
+		{
+			name:        "rename",
+			call:        func(c *Client) error { return c.Rename(ctx, "app", "renamed") },
+			method:      http.MethodPost,
+			path:        "/apps/app/rename",
+			contentType: "application/json",
+			body:        `{"name":"renamed"}`,
+		},
+		{
+			name:        "maintenance",
+			call:        func(c *Client) error { return c.Maintenance(ctx, "app", true, "back soon") },
+			method:      http.MethodPost,
+			path:        "/apps/app/maintenance",
+			contentType: "application/json",
+			body:        `{"enabled":true,"page":"back soon"}`,
+		},
+		{
+			name: "logs",
+			call: func(c *Client) error {
+				logs, err := c.Logs(ctx, "app", LogsOptions{Tail: 10, Since: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)})
+				if err != nil {
+					return err
+				}
+				return logs.Close()
+			},
+			method: http.MethodGet,
+			path:   "/apps/app/logs",
+			query:  url.Values{"tail": {"10"}, "since": {"2024-01-02T03:04:05Z"}},
+		},
+		{
+			name:   "inspect image",
+			call:   func(c *Client) error { _, err := c.InspectImage(ctx, "nginx:1.27"); return err },
+			method: http.MethodGet,
+			path:   "/images/inspect",
+			query:  url.Values{"image": {"nginx:1.27"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := test.response
+			if response == nil {
+				response = struct{}{}
+			}
+			c, received := newDaemon(t, respondJSON(response))
+
+			if err := test.call(c); err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+
+			if received.method != test.method || received.path != test.path {
+				t.Errorf("expected %s %s, got %s %s", test.method, test.path, received.method, received.path)
+			}
+
+			query := test.query
+			if query == nil {
+				query = url.Values{}
+			}
+			if !reflect.DeepEqual(received.query, query) {
+				t.Errorf("expected query %v, got %v", query, received.query)
+			}
+
+			if received.authorization != "Bearer secret" {
+				t.Errorf("expected the token to be sent, got %q", received.authorization)
+			}
+
+			if received.contentType != test.contentType {
+				t.Errorf("expected content type %q, got %q", test.contentType, received.contentType)
+			}
+
+			if received.body != test.body {
+				t.Errorf("expected body %q, got %q", test.body, received.body)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	want := []pkg.App{
+		{ID: 1, Name: "app", URL: "app.example.com", Port: 8080, DeploymentStatus: "running"},
+		{ID: 2, Name: "db", HostPort: 5432, Labels: map[string]string{"team": "data"}},
+	}
+	c, _ := newDaemon(t, respondJSON(want))
+
+	apps, err := c.List(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list apps: %v", err)
+	}
+
+	if !reflect.DeepEqual(apps, want) {
+		t.Errorf("expected %+v, got %+v", want, apps)
+	}
+}
+
+func TestNoToken(t *testing.T) {
+	c, received := newDaemon(t, respondJSON(pkg.Info{}))
+	c.Token = ""
+
+	if _, err := c.Info(context.Background()); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if received.authorization != "" {
+		t.Errorf("expected no Authorization header without a token, got %q", received.authorization)
+	}
+}
+
+// an unhealthy daemon responds with 503, which isn't an error as long as it describes why
+func TestHealthUnhealthy(t *testing.T) {
+	want := pkg.DaemonHealth{
+		Healthy: false,
+		Components: map[string]pkg.ComponentHealth{
+			"docker":   {Healthy: false, Error: "connection refused"},
+			"database": {Healthy: true},
+		},
+	}
+	c, _ := newDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(want)
+	})
+
+	health, err := c.Health(context.Background())
+	if err != nil {
+		t.Fatalf("expected an unhealthy daemon not to be an error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(health, want) {
+		t.Errorf("expected %+v, got %+v", want, health)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   Error
+	}{
+		{
+			name:   "json error",
+			status: http.StatusNotFound,
+			body:   `{"error":"app not found","code":"not_found"}`,
+			want:   Error{StatusCode: http.StatusNotFound, Code: pkg.ErrorCodeNotFound, Message: "app not found"},
+		},
+		{
+			name:   "json error without a code",
+			status: http.StatusBadRequest,
+			body:   `{"error":"invalid name"}`,
+			want:   Error{StatusCode: http.StatusBadRequest, Message: "invalid name"},
+		},
+		{
+			name:   "plain text error",
+			status: http.StatusInternalServerError,
+			body:   "failed to start container\n",
+			want:   Error{StatusCode: http.StatusInternalServerError, Message: "failed to start container"},
+		},
+		{
+			name:   "json without a message",
+			status: http.StatusBadGateway,
+			body:   `{"status":"down"}`,
+			want:   Error{StatusCode: http.StatusBadGateway, Message: `{"status":"down"}`},
+		},
+		{
+			name:   "empty body",
+			status: http.StatusUnauthorized,
+			want:   Error{StatusCode: http.StatusUnauthorized, Message: "401 Unauthorized"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := newDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				io.WriteString(w, test.body)
+			})
+
+			err := c.Start(context.Background(), "app")
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an *Error, got %T: %v", err, err)
+			}
+
+			if *apiErr != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, *apiErr)
+			}
+
+			if err.Error() != test.want.Message {
+				t.Errorf("expected the error to read %q, got %q", test.want.Message, err.Error())
+			}
+		})
+	}
+}
+
+// a deploy that the daemon rejects never turns into a stream
+func TestDeployRejected(t *testing.T) {
+	c, _ := newDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, `{"error":"a deploy of app is already in progress","code":"deploy_in_progress"}`)
+	})
+
+	_, err := c.Deploy(context.Background(), strings.NewReader("code"), 4, "multipart/form-data")
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != pkg.ErrorCodeDeployInProgress {
+		t.Fatalf("expected a deploy_in_progress error, got %v", err)
+	}
+}
+
+// writeEvent writes an event of a deploy stream the way the daemon does
+func writeEvent(w http.ResponseWriter, id string, name string, message string) {
+	data, _ := json.Marshal(pkg.DeploymentEvent{Stage: name, Message: message})
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, name, data)
+	w.(http.Flusher).Flush()
+}
+
+// a stream that drops in the middle of a deploy picks up after the last event that it returned
+func TestDeployStreamResumes(t *testing.T) {
+	var lastEventID string
+	c, _ := newDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/deploy":
+			w.WriteHeader(http.StatusMultiStatus)
+			writeEvent(w, "deploy-1", "start", "building")
+			// the connection drops here
+		case "/deploy/events":
+			lastEventID = r.Header.Get("Last-Event-ID")
+			w.WriteHeader(http.StatusMultiStatus)
+			writeEvent(w, "deploy-2", "cmd_output", "step 1/2")
+			writeEvent(w, "deploy-3", "complete", "done")
+		}
+	})
+
+	stream, err := c.Deploy(context.Background(), strings.NewReader("code"), 4, "multipart/form-data")
+	if err != nil {
+		t.Fatalf("failed to deploy: %v", err)
+	}
+	defer stream.Close()
+
+	var reconnects int
+	stream.OnReconnect = func(err error) {
+		reconnects++
+	}
+
+	var names []string
+	for {
+		name, event, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+
+		if event.Time.IsZero() {
+			t.Errorf("expected events without a time to get one")
+		}
+
+		names = append(names, name)
+	}
+
+	if want := []string{"start", "cmd_output", "complete"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected events %v, got %v", want, names)
+	}
+
+	if reconnects != 1 {
+		t.Errorf("expected the stream to reconnect once, got %d", reconnects)
+	}
+
+	if lastEventID != "deploy-1" {
+		t.Errorf("expected the stream to resume after deploy-1, got %q", lastEventID)
+	}
+}
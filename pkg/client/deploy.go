@@ -0,0 +1,236 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+const (
+	// how long a stream that dropped is retried for, the deploy keeps running on the daemon regardless
+	reconnectTimeout    = 30 * time.Second
+	maxReconnectBackoff = 5 * time.Second
+)
+
+// DeployStream is the stream of events of a deploy that the daemon accepted. When the connection drops before the
+// deploy has finished the stream reconnects to the deploy and picks up after the last event that it returned
+type DeployStream struct {
+	client  *Client
+	ctx     context.Context
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	last    string
+	// the id of the last event that was returned, daemons that predate resumable deploys don't send ids
+	lastEventID string
+	finished    bool
+	// set while nothing was read since the stream was reconnected
+	resumed bool
+
+	// OnReconnect is called with the reason whenever the stream dropped and is being reconnected
+	OnReconnect func(err error)
+}
+
+// Deploy uploads a multipart deploy request, as built by the cli, and returns the events of the deploy once the
+// daemon has accepted it. contentLength is sent when it is above 0
+func (c *Client) Deploy(ctx context.Context, body io.Reader, contentLength int64, contentType string) (*DeployStream, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/deploy", nil, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentLength > 0 {
+		req.ContentLength = contentLength
+	}
+
+	return c.openStream(ctx, req)
+}
+
+// Redeploy rebuilds an app from the code that was uploaded with its last deploy, and returns the events of the deploy
+func (c *Client) Redeploy(ctx context.Context, name string) (*DeployStream, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/redeploy/"+url.PathEscape(name), nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.openStream(ctx, req)
+}
+
+// UpdateConfig changes the environment and labels of an app and recreates its containers from the image that is
+// already built, and returns the events of the deploy
+func (c *Client) UpdateConfig(ctx context.Context, name string, update pkg.ConfigUpdate) (*DeployStream, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, appPath(name, "/config"), nil, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.openStream(ctx, req)
+}
+
+func (c *Client) openStream(ctx context.Context, req *http.Request) (*DeployStream, error) {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the daemon only responds with a stream of events once it has accepted the deploy
+	if resp.StatusCode != http.StatusMultiStatus {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+
+	return &DeployStream{
+		client:  c,
+		ctx:     ctx,
+		body:    resp.Body,
+		scanner: bufio.NewScanner(resp.Body),
+	}, nil
+}
+
+// Next returns the next event of the deploy and its name, such as "start", "cmd_output", "complete" or "error". It
+// returns io.EOF once the daemon closes the stream
+func (s *DeployStream) Next() (string, pkg.DeploymentEvent, error) {
+	for {
+		name, data, id, err := s.scan()
+		if err != nil {
+			// there is nothing to pick up after when the daemon didn't send any event ids, and a stream that ends again
+			// right after reconnecting belongs to a deploy that ended without saying so
+			if s.finished || s.lastEventID == "" || (s.resumed && err == io.EOF) {
+				return "", pkg.DeploymentEvent{}, err
+			}
+
+			if err := s.reconnect(err); err != nil {
+				return "", pkg.DeploymentEvent{}, fmt.Errorf("lost the connection to the daemon: %w", err)
+			}
+
+			s.resumed = true
+			continue
+		}
+		s.resumed = false
+
+		var event pkg.DeploymentEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return name, event, fmt.Errorf("failed to parse deployment event: %v", err)
+		}
+
+		// daemons that predate event times only have the time that the event was received
+		if event.Time.IsZero() {
+			event.Time = time.Now()
+		}
+
+		if id != "" {
+			s.lastEventID = id
+		}
+		if name == "complete" || name == "error" {
+			s.finished = true
+		}
+
+		return name, event, nil
+	}
+}
+
+// scan reads the next event from the stream and returns its name, data and id
+func (s *DeployStream) scan() (string, string, string, error) {
+	var name, id string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		s.last = line
+
+		if value, ok := strings.CutPrefix(line, "id: "); ok {
+			id = value
+			continue
+		}
+
+		if value, ok := strings.CutPrefix(line, "event: "); ok {
+			name = value
+			continue
+		}
+
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			return name, data, id, nil
+		}
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return "", "", "", err
+	}
+
+	return "", "", "", io.EOF
+}
+
+// reconnect resumes the stream after the last event that was returned, retrying until reconnectTimeout has passed
+func (s *DeployStream) reconnect(cause error) error {
+	s.body.Close()
+
+	if s.OnReconnect != nil {
+		s.OnReconnect(cause)
+	}
+
+	deadline := time.Now().Add(reconnectTimeout)
+	backoff := 250 * time.Millisecond
+	for {
+		err := s.resume()
+		if err == nil {
+			return nil
+		}
+
+		// the daemon answered, so retrying won't change its mind
+		var apiErr *Error
+		if errors.As(err, &apiErr) || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+func (s *DeployStream) resume() error {
+	req, err := s.client.newRequest(s.ctx, http.MethodGet, "/deploy/events", nil, nil, "")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Last-Event-ID", s.lastEventID)
+
+	resp, err := s.client.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		defer resp.Body.Close()
+		return readError(resp)
+	}
+
+	s.body = resp.Body
+	s.scanner = bufio.NewScanner(resp.Body)
+	return nil
+}
+
+// LastLine returns the last line that was read from the stream, when the stream ends without a "complete" or "error"
+// event it is usually the reason why
+func (s *DeployStream) LastLine() string {
+	return s.last
+}
+
+func (s *DeployStream) Close() error {
+	return s.body.Close()
+}
@@ -1,9 +1,224 @@
 package pkg
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfigFiles are the names that the project config is read from, in order of preference
+var ProjectConfigFiles = []string{"flux.json", "flux.yaml", "flux.yml"}
+
+// IsYAMLConfig reports whether a project config file is written in yaml rather than json
+func IsYAMLConfig(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// DecodeProjectConfig decodes a project config written in either yaml or json
+func DecodeProjectConfig(data []byte, isYAML bool) (ProjectConfig, error) {
+	var config ProjectConfig
+	if isYAML {
+		// an empty document isn't an error to yaml, but it isn't a config either
+		if len(bytes.TrimSpace(data)) == 0 {
+			return config, fmt.Errorf("the config is empty")
+		}
+
+		err := yaml.Unmarshal(data, &config)
+		return config, err
+	}
+
+	err := json.Unmarshal(data, &config)
+	return config, err
+}
+
+// WorkspaceConfigFiles are the names that the config of a monorepo with several apps is read from, in order of
+// preference
+var WorkspaceConfigFiles = []string{"flux.workspace.json", "flux.workspace.yaml", "flux.workspace.yml"}
+
+// WorkspaceConfig lists the apps of a monorepo, each in its own directory
+type WorkspaceConfig struct {
+	Services []WorkspaceService `json:"services" yaml:"services"`
+}
+
+// WorkspaceService is the project config of an app in a workspace, along with where its code is
+type WorkspaceService struct {
+	// the directory with the code of the app, relative to the workspace config
+	Path string `json:"path" yaml:"path"`
+	// names of the services that have to be deployed before this one
+	DependsOn     []string `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	ProjectConfig `yaml:",inline"`
+}
+
+// DecodeWorkspaceConfig decodes a workspace config written in either yaml or json
+func DecodeWorkspaceConfig(data []byte, isYAML bool) (WorkspaceConfig, error) {
+	var config WorkspaceConfig
+	if isYAML {
+		err := yaml.Unmarshal(data, &config)
+		return config, err
+	}
+
+	err := json.Unmarshal(data, &config)
+	return config, err
+}
+
+type HealthCheck struct {
+	// the path that is requested on the app, defaults to /
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// seconds between checks, defaults to 10
+	Interval int `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// consecutive failed checks before a container stops receiving traffic, defaults to 3
+	Threshold int `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+	// seconds that new containers have to stay running and healthy before they receive traffic during a deploy,
+	// defaults to 5, a negative value disables the check
+	StabilizationWindow int `json:"stabilization_window,omitempty" yaml:"stabilization_window,omitempty"`
+}
+
+const (
+	VolumeTypeVolume = "volume"
+	VolumeTypeBind   = "bind"
+)
+
+type VolumeConfig struct {
+	// the name of a docker volume, or the host path for a bind mount. A volume without a name gets a generated one
+	// and is removed together with the app
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	// the path the volume is mounted at in the container
+	Target string `json:"target" yaml:"target"`
+	// either volume or bind, defaults to volume
+	Type     string `json:"type,omitempty" yaml:"type,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty" yaml:"read_only,omitempty"`
+}
+
+// LogConfig is how much of the output of an app's containers the daemon keeps
+type LogConfig struct {
+	// megabytes that the stored logs may take up before the oldest are removed, defaults to 10
+	MaxSize int `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+	// days that stored logs are kept for, defaults to 7
+	MaxAge int `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+}
+
+// HooksConfig are shell commands that are run inside the new head container during a deploy
+type HooksConfig struct {
+	// run once the new containers are up, before they receive traffic, the deploy fails if it exits non-zero
+	PreDeploy string `json:"pre_deploy,omitempty" yaml:"pre_deploy,omitempty"`
+	// run once the new containers receive traffic, a failure is reported but doesn't fail the deploy
+	PostDeploy string `json:"post_deploy,omitempty" yaml:"post_deploy,omitempty"`
+}
+
+// ProxyConfig are the settings of the reverse proxy for a single app
+type ProxyConfig struct {
+	// milliseconds between flushes of a response to the client, -1 turns off buffering and flushes after every write.
+	// When 0 the proxy flushes event streams and responses without a length right away and buffers the rest
+	FlushInterval int `json:"flush_interval,omitempty" yaml:"flush_interval,omitempty"`
+	// seconds that connecting to a container may take, defaults to 30
+	DialTimeout int `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty"`
+	// seconds that the app gets to send the headers of a response once it has the request, 0 waits forever
+	ResponseHeaderTimeout int `json:"response_header_timeout,omitempty" yaml:"response_header_timeout,omitempty"`
+	// seconds that the proxy gets to send a whole response, overriding the daemon's proxy_write_timeout. -1 disables
+	// the limit for long lived streams
+	WriteTimeout int `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty"`
+}
+
+// when the daemon pulls the images that it uses, such as the builder
+const (
+	PullPolicyAlways       = "always"
+	PullPolicyIfNotPresent = "if-not-present"
+	PullPolicyNever        = "never"
+)
+
+const (
+	ProtocolHTTP = "http"
+	ProtocolTCP  = "tcp"
+	// http/2 without tls to the app, as grpc servers speak it
+	ProtocolGRPC = "grpc"
+)
+
+// the role of the port that the proxy sends traffic to and that is health checked
+const PortRoleHTTP = "http"
+
+type PortConfig struct {
+	Port uint16 `json:"port" yaml:"port"`
+	// what the port is used for, such as "metrics". At most one port can have the role http
+	Role string `json:"role,omitempty" yaml:"role,omitempty"`
+}
+
 type ProjectConfig struct {
-	Name        string   `json:"name,omitempty"`
-	Url         string   `json:"url,omitempty"`
-	Port        uint16   `json:"port,omitempty"`
-	EnvFile     string   `json:"env_file,omitempty"`
-	Environment []string `json:"environment,omitempty"`
+	Name        string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Url         string   `json:"url,omitempty" yaml:"url,omitempty"`
+	Port        uint16   `json:"port,omitempty" yaml:"port,omitempty"`
+	EnvFile     string   `json:"env_file,omitempty" yaml:"env_file,omitempty"`
+	Environment []string `json:"environment,omitempty" yaml:"environment,omitempty"`
+	// environment variables whose values are resolved by the daemon from a file:// or env:// reference
+	Secrets map[string]string `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	// number of containers to run the app in, traffic is balanced across them, defaults to 1
+	Replicas int `json:"replicas,omitempty" yaml:"replicas,omitempty"`
+	// minutes without any proxied requests before the app is scaled to zero, 0 disables idle scaling
+	IdleTimeout int `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	// pin each client to a single replica with a cookie, for apps that keep sessions in memory
+	Sticky      bool         `json:"sticky,omitempty" yaml:"sticky,omitempty"`
+	HealthCheck *HealthCheck `json:"health_check,omitempty" yaml:"health_check,omitempty"`
+	// when empty, the app gets a single volume mounted at WorkspaceMount
+	Volumes []VolumeConfig `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+	// where the default volume is mounted when no volumes are configured, /workspace when empty
+	WorkspaceMount string `json:"workspace_mount,omitempty" yaml:"workspace_mount,omitempty"`
+	// environment variables that are only set while the image is built
+	BuildArgs map[string]string `json:"build_args,omitempty" yaml:"build_args,omitempty"`
+	// the buildpack builder used for this app instead of the daemon's default builder
+	Builder string `json:"builder,omitempty" yaml:"builder,omitempty"`
+	// shell commands that are run in a sandbox of the project directory before the image is built, such as go
+	// generate. Nothing is run when empty
+	Prepare []string `json:"prepare,omitempty" yaml:"prepare,omitempty"`
+	// the image that the prepare commands run in, such as golang:1.23 for go generate, alpine:3 when empty
+	PrepareImage string `json:"prepare_image,omitempty" yaml:"prepare_image,omitempty"`
+	// a prebuilt image, such as myrepo/app:tag, that the app runs instead of an image built from its code. It is
+	// pulled according to image_pull_policy, and can't be combined with builder, build_args, prepare, or prepare_image
+	Image string `json:"image,omitempty" yaml:"image,omitempty"`
+	// one of the PullPolicy constants, overrides the daemon's image_pull_policy for this app
+	ImagePullPolicy string `json:"image_pull_policy,omitempty" yaml:"image_pull_policy,omitempty"`
+	// a user-defined docker network to attach the containers to, apps on the same network can reach each other by
+	// their name
+	Network string `json:"network,omitempty" yaml:"network,omitempty"`
+	// the hostname of the containers, docker uses the container id when empty
+	Hostname string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	// static entries added to /etc/hosts of the containers, in host:ip form
+	ExtraHosts []string `json:"extra_hosts,omitempty" yaml:"extra_hosts,omitempty"`
+	// either http to serve the app on its url through the proxy, or tcp to forward host_port to the app, defaults to
+	// http
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	// the port on the daemon host that is forwarded to a tcp app
+	HostPort uint16 `json:"host_port,omitempty" yaml:"host_port,omitempty"`
+	// the user that the containers run as, in docker's user[:group] form with names or ids. The user of the image, or
+	// the daemon's default, when empty
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// mount the root filesystem of the containers read-only, volumes and a tmpfs at /tmp stay writable. The daemon's
+	// default when unset
+	ReadOnlyRootfs *bool `json:"read_only_rootfs,omitempty" yaml:"read_only_rootfs,omitempty"`
+	// linux capabilities that are dropped from the containers, such as ALL or NET_RAW, on top of the daemon's
+	CapDrop []string `json:"cap_drop,omitempty" yaml:"cap_drop,omitempty"`
+	// every port that the app listens on, including port. The proxy sends traffic to port, or to the port with the
+	// http role when port isn't set
+	Ports []PortConfig `json:"ports,omitempty" yaml:"ports,omitempty"`
+	// gzip responses for clients that accept it, unless the app already compressed them
+	CompressResponses bool `json:"compress_responses,omitempty" yaml:"compress_responses,omitempty"`
+	// how the reverse proxy streams responses and how long it waits on the app, for apps that serve event streams or
+	// large downloads
+	Proxy *ProxyConfig `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	// seconds that a container gets to exit after it is sent the stop signal before it is killed, defaults to 10, or
+	// to 30 when the container is replaced by a deploy
+	StopTimeout int `json:"stop_timeout,omitempty" yaml:"stop_timeout,omitempty"`
+	// the signal that containers are stopped with, defaults to SIGTERM
+	StopSignal string `json:"stop_signal,omitempty" yaml:"stop_signal,omitempty"`
+	// store the output of the containers on the daemon so that it is kept across deploys, off when unset
+	Logs  *LogConfig   `json:"logs,omitempty" yaml:"logs,omitempty"`
+	Hooks *HooksConfig `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	// arbitrary metadata such as the team or environment, set as labels on the containers and filterable in flux list
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
 }
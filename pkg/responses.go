@@ -1,10 +1,24 @@
 package pkg
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type App struct {
 	ID               int64  `json:"id,omitempty"`
 	Name             string `json:"name,omitempty"`
 	DeploymentID     int64  `json:"deployment_id,omitempty"`
 	DeploymentStatus string `json:"deployment_status,omitempty"`
+	// the domain that the app is served on by the proxy
+	URL      string `json:"url,omitempty"`
+	Port     uint16 `json:"port,omitempty"`
+	Replicas int    `json:"replicas,omitempty"`
+	// the port on the daemon host that a tcp app is reachable on, tcp apps have no url
+	HostPort uint16            `json:"host_port,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// set while the proxy serves the maintenance page instead of the app
+	Maintenance bool `json:"maintenance,omitempty"`
 }
 
 type Compression struct {
@@ -14,8 +28,217 @@ type Compression struct {
 
 type Info struct {
 	Compression Compression `json:"compression"`
+	// empty when the daemon predates version reporting
+	Version VersionInfo `json:"version"`
+	// the largest deploy in bytes that the daemon accepts, 0 when the daemon predates the limit
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
 }
 
 type DeploymentEvent struct {
+	// the stage of the deploy, for command output this is the stage that printed it. Empty when the daemon predates
+	// it, in which case the name of the event is the stage
+	Stage   string      `json:"stage,omitempty"`
+	Message interface{} `json:"message"`
+	// when the daemon sent the event, zero when the daemon predates it
+	Time time.Time `json:"time"`
+	// only set on the complete event, the milliseconds from the start of the deploy until it completed and the size
+	// of the app image in bytes
+	DurationMs int64 `json:"duration_ms,omitempty"`
+	ImageSize  int64 `json:"image_size,omitempty"`
+}
+
+// BuildSummary is the message of the build_summary event, which buildpacks reused all of their layers from the build
+// cache and which had to build at least one of them again
+type BuildSummary struct {
+	Cached  []string `json:"cached,omitempty"`
+	Rebuilt []string `json:"rebuilt,omitempty"`
+}
+
+// ImageInfo is what the daemon found out about an image, for scaffolding the config of an app
+type ImageInfo struct {
+	Image string `json:"image"`
+	// the tcp ports that the image exposes, in ascending order
+	ExposedPorts []uint16 `json:"exposed_ports"`
+}
+
+type RenameRequest struct {
+	// the new name of the app
+	Name string `json:"name"`
+}
+
+type MaintenanceRequest struct {
+	Enabled bool `json:"enabled"`
+	// html served with the 503 while maintenance is enabled, the daemon serves a default page when this is empty
+	Page string `json:"page,omitempty"`
+}
+
+// ConfigUpdate changes the environment and labels of an app, which are applied without rebuilding it
+type ConfigUpdate struct {
+	// KEY=value pairs that are added to the environment, or replace the variable with the same name
+	SetEnv []string `json:"set_env,omitempty"`
+	// names of environment variables that are removed
+	UnsetEnv    []string          `json:"unset_env,omitempty"`
+	SetLabels   map[string]string `json:"set_labels,omitempty"`
+	UnsetLabels []string          `json:"unset_labels,omitempty"`
+}
+
+// DeployNotification is posted to the notify url of a deploy once it has finished
+type DeployNotification struct {
+	App     string      `json:"app"`
+	Success bool        `json:"success"`
 	Message interface{} `json:"message"`
 }
+
+// CrashLoopNotification is posted to the daemon's crash loop notify url when docker keeps restarting the containers
+// of an app
+type CrashLoopNotification struct {
+	App string `json:"app"`
+	// the app was restarted at least this many times within window seconds
+	Restarts int    `json:"restarts"`
+	Window   int    `json:"window"`
+	Message  string `json:"message"`
+}
+
+type ContainerStats struct {
+	ContainerID string  `json:"container_id"`
+	CPUPercent  float64 `json:"cpu_percent"`
+	MemoryUsage uint64  `json:"memory_usage"`
+	MemoryLimit uint64  `json:"memory_limit"`
+	NetworkRx   uint64  `json:"network_rx"`
+	NetworkTx   uint64  `json:"network_tx"`
+}
+
+// AppStats holds the resource usage of every container in an app, along with the totals across all of them
+type AppStats struct {
+	Name       string           `json:"name"`
+	Total      ContainerStats   `json:"total"`
+	Containers []ContainerStats `json:"containers"`
+}
+
+type ContainerInfo struct {
+	App         string `json:"app"`
+	ContainerID string `json:"container_id"`
+	// either "head" or "replica"
+	Role    string   `json:"role"`
+	Status  string   `json:"status"`
+	Image   string   `json:"image"`
+	Created string   `json:"created"`
+	Volumes []string `json:"volumes,omitempty"`
+}
+
+// AppDescription is everything that the daemon knows about an app, for troubleshooting
+type AppDescription struct {
+	App    App           `json:"app"`
+	Config ProjectConfig `json:"config"`
+	// sha256 of the source that the current image was built from
+	SourceHash string `json:"source_hash,omitempty"`
+	// whether the app was scaled to zero after going idle
+	Suspended  bool                   `json:"suspended"`
+	Containers []ContainerDescription `json:"containers"`
+	// nil when the app isn't registered with the proxy
+	Proxy *ProxyDescription `json:"proxy"`
+	// the proxies of earlier versions that are still waiting for their requests to finish, oldest first
+	Draining []DrainDescription `json:"draining,omitempty"`
+}
+
+type ContainerDescription struct {
+	ID          int64               `json:"id"`
+	ContainerID string              `json:"container_id"`
+	Head        bool                `json:"head"`
+	Volumes     []VolumeDescription `json:"volumes,omitempty"`
+	// the fields below are inspected from docker, they are missing when that failed. The environment is left out,
+	// since it holds the resolved secrets
+	Name         string            `json:"name,omitempty"`
+	Image        string            `json:"image,omitempty"`
+	Created      string            `json:"created,omitempty"`
+	RestartCount int               `json:"restart_count"`
+	State        json.RawMessage   `json:"state,omitempty"`
+	Networks     map[string]string `json:"networks,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+type VolumeDescription struct {
+	VolumeID   string `json:"volume_id"`
+	Mountpoint string `json:"mountpoint"`
+}
+
+type ProxyDescription struct {
+	Upstreams      []UpstreamDescription `json:"upstreams"`
+	ActiveRequests int64                 `json:"active_requests"`
+	LastRequest    time.Time             `json:"last_request"`
+}
+
+// Route is an entry in the routing table of the reverse proxy
+type Route struct {
+	// the host that requests are routed by, or :<host_port> for tcp apps
+	Host     string `json:"host"`
+	App      string `json:"app"`
+	Protocol string `json:"protocol"`
+	// how the proxy answers requests: running, suspended, paused, maintenance, or unavailable when the app has no
+	// containers to route to
+	State          string                `json:"state"`
+	Upstreams      []UpstreamDescription `json:"upstreams"`
+	ActiveRequests int64                 `json:"active_requests"`
+}
+
+// DrainDescription is the progress of an earlier version of an app that finishes its requests in flight before its
+// containers are stopped
+type DrainDescription struct {
+	ActiveRequests int64     `json:"active_requests"`
+	Started        time.Time `json:"started"`
+	// when the containers are stopped even if requests are still in flight
+	Deadline time.Time `json:"deadline"`
+}
+
+type UpstreamDescription struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+}
+
+type ComponentHealth struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DaemonHealth is whether the daemon is ready to serve requests, along with the state of everything it depends on
+type DaemonHealth struct {
+	Healthy bool `json:"healthy"`
+	// by component, e.g. "docker", "database", and "proxy"
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// CodeChecksumHeader is the header on the code part of a deploy request that holds the hex encoded sha256 of the
+// archive, exactly as it is uploaded
+const CodeChecksumHeader = "X-Checksum-Sha256"
+
+// the kinds of errors that the daemon responds with, so that clients can tell them apart without parsing the message
+const (
+	ErrorCodeNotFound          = "not_found"
+	ErrorCodeAlreadyRunning    = "already_running"
+	ErrorCodeAlreadyStopped    = "already_stopped"
+	ErrorCodeAlreadyPaused     = "already_paused"
+	ErrorCodeNotPaused         = "not_paused"
+	ErrorCodePaused            = "paused"
+	ErrorCodeAlreadyExists     = "already_exists"
+	ErrorCodeInvalidRequest    = "invalid_request"
+	ErrorCodeUnauthorized      = "unauthorized"
+	ErrorCodeForbidden         = "forbidden"
+	ErrorCodeInvalidConfig     = "invalid_config"
+	ErrorCodeUploadTooLarge    = "upload_too_large"
+	ErrorCodeDeployInProgress  = "deploy_in_progress"
+	ErrorCodeDockerUnavailable = "docker_unavailable"
+	ErrorCodeNoStoredCode      = "no_stored_code"
+	ErrorCodeInternal          = "internal"
+)
+
+// Error is the body of every error response from the api
+type Error struct {
+	Message string `json:"error"`
+	Code    string `json:"code"`
+}
+
+// AppProblem is an inconsistency in the state of an app that the daemon found
+type AppProblem struct {
+	App     string `json:"app"`
+	Problem string `json:"problem"`
+}
@@ -0,0 +1,48 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// set at build time with -ldflags "-X github.com/juls0730/flux/pkg.Version=..."
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+type VersionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+}
+
+func CurrentVersion() VersionInfo {
+	return VersionInfo{
+		Version: Version,
+		Commit:  Commit,
+		Date:    Date,
+	}
+}
+
+// MajorMinor returns the major and minor number of a version like v1.2.3, ok is false for development builds and
+// anything else that isn't a release version
+func MajorMinor(version string) (major, minor int, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return major, minor, true
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	AccessLogFormatJSON   = "json"
+	AccessLogFormatCommon = "common"
+)
+
+type AccessLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// either "json" for structured fields or "common" for the NCSA common log format
+	Format string `json:"format,omitempty"`
+}
+
+func (c AccessLogConfig) Validate() error {
+	switch c.Format {
+	case "", AccessLogFormatJSON, AccessLogFormatCommon:
+		return nil
+	default:
+		return fmt.Errorf("unknown access log format %q, expected %q or %q", c.Format, AccessLogFormatJSON, AccessLogFormatCommon)
+	}
+}
+
+// responseRecorder captures the status code and the number of bytes written to the client
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, the reverse proxy relies on this for flushing
+// streamed responses and hijacking upgraded connections
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func logAccess(config AccessLogConfig, appName string, r *http.Request, recorder *responseRecorder, start time.Time) {
+	duration := time.Since(start)
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if config.Format == AccessLogFormatCommon {
+		remoteHost, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remoteHost = r.RemoteAddr
+		}
+
+		logger.Infow(fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d", remoteHost, start.Format("02/Jan/2006:15:04:05 -0700"), r.Method, r.RequestURI, r.Proto, status, recorder.bytes), zap.String("app", appName), zap.String("request_id", r.Header.Get(Flux.config.RequestIDHeader)))
+		return
+	}
+
+	logger.Infow("Proxied request",
+		zap.String("app", appName),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.String("host", r.Host),
+		zap.Int("status", status),
+		zap.Int64("bytes", recorder.bytes),
+		zap.Duration("duration", duration),
+		zap.String("request_id", r.Header.Get(Flux.config.RequestIDHeader)),
+	)
+}
@@ -2,11 +2,18 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
 	"github.com/juls0730/flux/pkg"
 	"go.uber.org/zap"
 )
@@ -19,15 +26,17 @@ type App struct {
 }
 
 func CreateApp(ctx context.Context, imageName string, projectPath string, projectConfig pkg.ProjectConfig) (*App, error) {
+	log := appLogger(projectConfig.Name)
+
 	app := &App{
 		Name: projectConfig.Name,
 	}
-	logger.Debugw("Creating deployment", zap.String("name", app.Name))
+	log.Debugw("Creating deployment")
 
-	deployment, err := CreateDeployment(projectConfig.Port, projectConfig.Url, Flux.db)
+	deployment, err := CreateDeployment(projectConfig, Flux.db)
 	app.Deployment = deployment
 	if err != nil {
-		logger.Errorw("Failed to create deployment", zap.Error(err))
+		log.Errorw("Failed to create deployment", zap.Error(err))
 		return nil, err
 	}
 
@@ -36,10 +45,9 @@ func CreateApp(ctx context.Context, imageName string, projectPath string, projec
 		return nil, fmt.Errorf("failed to create container: %v", err)
 	}
 
-	if appInsertStmt == nil {
-		appInsertStmt, err = Flux.db.Prepare("INSERT INTO apps (name, deployment_id) VALUES ($1, $2) RETURNING id, name, deployment_id")
-		if err != nil {
-			return nil, fmt.Errorf("failed to prepare statement: %v", err)
+	for i := 1; i < projectConfig.Replicas; i++ {
+		if _, err := CreateContainer(ctx, imageName, projectPath, projectConfig, false, deployment); err != nil {
+			return nil, fmt.Errorf("failed to create replica: %v", err)
 		}
 	}
 
@@ -59,8 +67,10 @@ func CreateApp(ctx context.Context, imageName string, projectPath string, projec
 	return app, nil
 }
 
-func (app *App) Upgrade(ctx context.Context, projectConfig pkg.ProjectConfig, imageName string, projectPath string) error {
-	logger.Debugw("Upgrading deployment", zap.String("name", app.Name))
+func (app *App) Upgrade(ctx context.Context, projectConfig pkg.ProjectConfig, imageName string, projectPath string, eventChannel chan<- DeploymentEvent) error {
+	log := appLogger(app.name())
+
+	log.Debugw("Upgrading deployment")
 
 	// if deploy is not started, start it
 	deploymentStatus, err := app.Deployment.Status(ctx)
@@ -75,7 +85,7 @@ func (app *App) Upgrade(ctx context.Context, projectConfig pkg.ProjectConfig, im
 		}
 	}
 
-	err = app.Deployment.Upgrade(ctx, projectConfig, imageName, projectPath)
+	err = app.Deployment.Upgrade(ctx, projectConfig, imageName, projectPath, eventChannel)
 	if err != nil {
 		return fmt.Errorf("failed to upgrade deployment: %v", err)
 	}
@@ -84,31 +94,235 @@ func (app *App) Upgrade(ctx context.Context, projectConfig pkg.ProjectConfig, im
 }
 
 func (app *App) Remove(ctx context.Context) error {
-	Flux.appManager.RemoveApp(app.Name)
+	log := appLogger(app.name())
+
+	Flux.appManager.RemoveApp(app.name())
 
 	err := app.Deployment.Remove(ctx)
 	if err != nil {
-		logger.Errorw("Failed to remove deployment", zap.Error(err))
+		log.Errorw("Failed to remove deployment", zap.Error(err))
 		return err
 	}
 
 	_, err = Flux.db.Exec("DELETE FROM apps WHERE id = ?", app.ID)
 	if err != nil {
-		logger.Errorw("Failed to delete app", zap.Error(err))
+		log.Errorw("Failed to delete app", zap.Error(err))
 		return err
 	}
 
-	projectPath := filepath.Join(Flux.rootDir, "apps", app.Name)
+	projectPath := filepath.Join(Flux.rootDir, "apps", app.name())
 	err = os.RemoveAll(projectPath)
 	if err != nil {
 		return fmt.Errorf("failed to remove project directory: %v", err)
 	}
 
+	if err := Flux.logStore.Remove(app.name()); err != nil {
+		return fmt.Errorf("failed to remove stored logs: %v", err)
+	}
+
 	return nil
 }
 
+// name returns the name of the app, which changes when the app is renamed so it is guarded by the lock of the
+// deployment, like the config that holds the name as well
+func (app *App) name() string {
+	app.Deployment.stateLock.RLock()
+	defer app.Deployment.stateLock.RUnlock()
+
+	return app.Name
+}
+
+var appNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validateAppName makes sure that a name can be used for the containers and the directories that are named after the
+// app
+func validateAppName(name string) error {
+	if !appNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid app name %q, names may only contain letters, digits, _, ., and -, and must start with a letter or digit", name)
+	}
+
+	return nil
+}
+
+// Rename renames the app along with its containers and project directory, the containers keep running and keep
+// their volumes. Nothing may deploy either name while the app is renamed
+func (app *App) Rename(ctx context.Context, newName string) error {
+	log := appLogger(app.Name)
+	oldName := app.Name
+
+	// the containers are found by their name prefix when the app is upgraded, so they have to follow the app
+	var renamed []*Container
+	var renameErr error
+	for _, container := range app.Deployment.containers() {
+		containerJSON, err := Flux.dockerClient.ContainerInspect(ctx, string(container.ContainerID[:]))
+		if err != nil {
+			renameErr = err
+			break
+		}
+
+		suffix := strings.TrimPrefix(containerJSON.Name, fmt.Sprintf("/%s-", oldName))
+		if err := Flux.dockerClient.ContainerRename(ctx, string(container.ContainerID[:]), fmt.Sprintf("%s-%s", newName, suffix)); err != nil {
+			renameErr = err
+			break
+		}
+
+		renamed = append(renamed, container)
+	}
+
+	// undo whatever was renamed so far, regardless of whether the request is still around
+	undoContainers := func() {
+		for _, container := range renamed {
+			containerJSON, err := Flux.dockerClient.ContainerInspect(context.Background(), string(container.ContainerID[:]))
+			if err != nil {
+				log.Errorw("Failed to inspect container while undoing rename", zap.ByteString("container_id", container.ContainerID[:12]), zap.Error(err))
+				continue
+			}
+
+			suffix := strings.TrimPrefix(containerJSON.Name, fmt.Sprintf("/%s-", newName))
+			if err := Flux.dockerClient.ContainerRename(context.Background(), string(container.ContainerID[:]), fmt.Sprintf("%s-%s", oldName, suffix)); err != nil {
+				log.Errorw("Failed to undo container rename", zap.ByteString("container_id", container.ContainerID[:12]), zap.Error(err))
+			}
+		}
+	}
+
+	if renameErr != nil {
+		undoContainers()
+		return fmt.Errorf("failed to rename containers: %v", renameErr)
+	}
+
+	oldPath := filepath.Join(Flux.rootDir, "apps", oldName)
+	newPath := filepath.Join(Flux.rootDir, "apps", newName)
+	if err := os.Rename(oldPath, newPath); err != nil && !os.IsNotExist(err) {
+		undoContainers()
+		return fmt.Errorf("failed to rename project directory: %v", err)
+	}
+
+	projectConfig := app.Deployment.config()
+	projectConfig.Name = newName
+	configBytes, err := json.Marshal(projectConfig)
+	if err == nil {
+		err = app.saveName(newName, string(configBytes))
+	}
+	if err != nil {
+		os.Rename(newPath, oldPath)
+		undoContainers()
+		return fmt.Errorf("failed to rename app: %v", err)
+	}
+
+	// the proxy and api requests read the name and config while the app is renamed
+	app.Deployment.stateLock.Lock()
+	app.Name = newName
+	app.Deployment.Config = projectConfig
+	app.Deployment.stateLock.Unlock()
+	Flux.appManager.Store(newName, app)
+	Flux.appManager.Delete(oldName)
+
+	// the rest is named after the app as well, but the app works fine without it
+	if err := Flux.logStore.Rename(oldName, newName); err != nil {
+		log.Warnw("Failed to rename stored logs", zap.Error(err))
+	}
+
+	oldImage := fmt.Sprintf("flux_%s-image", oldName)
+	if err := Flux.dockerClient.ImageTag(ctx, oldImage, fmt.Sprintf("flux_%s-image", newName)); err != nil {
+		log.Warnw("Failed to tag image with the new name, the next deploy will rebuild it", zap.Error(err))
+	} else if _, err := Flux.dockerClient.ImageRemove(ctx, oldImage, image.RemoveOptions{}); err != nil {
+		log.Warnw("Failed to remove the old image tag", zap.Error(err))
+	}
+
+	log.Infow("Renamed app", zap.String("new_name", newName))
+
+	return nil
+}
+
+func (app *App) saveName(name string, config string) error {
+	tx, err := Flux.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE apps SET name = ? WHERE id = ?", name, app.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE deployments SET config = ? WHERE id = ?", config, app.Deployment.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Info returns what the api exposes about the app
+func (app *App) Info(ctx context.Context) (pkg.App, error) {
+	status, err := app.Deployment.Status(ctx)
+	if err != nil {
+		return pkg.App{}, err
+	}
+
+	// containers that keep crashing are mostly reported as running or pending, depending on when they are caught
+	if (status == "running" || status == "pending") && app.Deployment.crashLooping() {
+		status = "crashlooping"
+	}
+
+	info := pkg.App{
+		ID:               app.ID,
+		Name:             app.name(),
+		DeploymentID:     app.DeploymentID,
+		DeploymentStatus: status,
+		URL:              app.Deployment.url(),
+		Port:             app.Deployment.port(),
+		Replicas:         len(app.Deployment.containers()),
+		Labels:           app.Deployment.config().Labels,
+		Maintenance:      app.Deployment.inMaintenance(),
+	}
+
+	if protocol(app.Deployment.config()) == pkg.ProtocolTCP {
+		info.URL = ""
+		info.HostPort = app.Deployment.config().HostPort
+	}
+
+	return info, nil
+}
+
+// Describe returns everything that the daemon knows about the app, including the live state of its containers
+func (app *App) Describe(ctx context.Context) (pkg.AppDescription, error) {
+	info, err := app.Info(ctx)
+	if err != nil {
+		return pkg.AppDescription{}, err
+	}
+
+	description := pkg.AppDescription{
+		App:        info,
+		Config:     app.Deployment.config(),
+		SourceHash: app.Deployment.SourceHash,
+		Suspended:  app.Deployment.suspended.Load(),
+		Containers: []pkg.ContainerDescription{},
+	}
+
+	for _, container := range app.Deployment.containers() {
+		description.Containers = append(description.Containers, container.Describe(ctx))
+	}
+
+	if app.Deployment.proxy() != nil {
+		description.Proxy = app.Deployment.proxy().Describe()
+	}
+
+	app.Deployment.draining.Range(func(key, value any) bool {
+		description.Draining = append(description.Draining, key.(*DeploymentProxy).describeDrain())
+		return true
+	})
+	sort.Slice(description.Draining, func(i, j int) bool {
+		return description.Draining[i].Started.Before(description.Draining[j].Started)
+	})
+
+	return description, nil
+}
+
 type AppManager struct {
 	sync.Map
+	// the apps that could not be loaded on startup, by name, with the reason why
+	broken sync.Map
 }
 
 func (am *AppManager) GetApp(name string) *App {
@@ -136,7 +350,7 @@ func (am *AppManager) RemoveApp(name string) {
 }
 
 func (am *AppManager) AddApp(name string, app *App) {
-	if app.Deployment.Containers == nil || app.Deployment.Head == nil || len(app.Deployment.Containers) == 0 {
+	if app.Deployment.head() == nil || len(app.Deployment.containers()) == 0 {
 		panic("nil containers")
 	}
 
@@ -171,81 +385,229 @@ func (am *AppManager) Init() {
 		logger.Warnw("Failed to query apps", zap.Error(err))
 		return
 	}
-	defer rows.Close()
 
 	var apps []App
 	for rows.Next() {
 		var app App
 		if err := rows.Scan(&app.ID, &app.Name, &app.DeploymentID); err != nil {
 			logger.Warnw("Failed to scan app", zap.Error(err))
-			return
+			continue
 		}
 		apps = append(apps, app)
 	}
+	rows.Close()
 
 	for _, app := range apps {
-		deployment := &Deployment{}
-		var headContainer *Container
-		Flux.db.QueryRow("SELECT id, url, port FROM deployments WHERE id = ?", app.DeploymentID).Scan(&deployment.ID, &deployment.URL, &deployment.Port)
-		deployment.Containers = make([]*Container, 0)
+		log := appLogger(app.Name)
 
-		rows, err = Flux.db.Query("SELECT id, container_id, deployment_id, head FROM containers WHERE deployment_id = ?", app.DeploymentID)
+		// one broken app shouldn't keep every other app from coming back up
+		deployment, err := loadDeployment(app)
 		if err != nil {
-			logger.Warnw("Failed to query containers", zap.Error(err))
-			return
+			log.Errorw("Skipping app in an inconsistent state, run flux doctor for details", zap.Error(err))
+			am.broken.Store(app.Name, err.Error())
+			continue
 		}
-		defer rows.Close()
 
-		for rows.Next() {
-			var container Container
-			var containerIDString string
-			rows.Scan(&container.ID, &containerIDString, &container.DeploymentID, &container.Head)
-			container.Deployment = deployment
-			copy(container.ContainerID[:], containerIDString)
+		app.Deployment = deployment
+		am.AddApp(app.Name, &app)
 
-			if container.Head {
-				if headContainer != nil {
-					logger.Fatal("Several containers are marked as head")
-				}
+		if err := deployment.reattachNetwork(context.Background()); err != nil {
+			log.Warnw("Failed to reattach network", zap.Error(err))
+		}
 
-				headContainer = &container
-			}
+		status, err := deployment.Status(context.Background())
+		if err != nil {
+			log.Warnw("Failed to get deployment status", zap.Error(err))
+			continue
+		}
 
-			rows, err := Flux.db.Query("SELECT id, volume_id, container_id, mountpoint FROM volumes WHERE container_id = ?", container.ContainerID[:])
-			if err != nil {
-				logger.Warnw("Failed to query volumes", zap.Error(err))
-				return
-			}
-			defer rows.Close()
+		// routing the app would send requests to containers that don't exist
+		if status == "degraded" {
+			log.Warnw("Containers of the app were removed outside of flux, it won't receive traffic until it is redeployed, run flux doctor for details")
+			continue
+		}
 
-			for rows.Next() {
-				var volume Volume
-				rows.Scan(&volume.ID, &volume.VolumeID, &volume.ContainerID, &volume.Mountpoint)
-				container.Volumes = append(container.Volumes, volume)
+		// the daemon restarted while the app was paused, it is routed like a running app that answers with a 503
+		if status == "paused" {
+			deployment.paused.Store(true)
+		} else if status != "running" {
+			// an app with an idle timeout that isn't running was most likely suspended before the daemon restarted,
+			// keep it routable so that it will be woken up by the next request
+			if status == "stopped" && deployment.config().IdleTimeout > 0 {
+				deployment.suspended.Store(true)
+				Flux.proxy.AddDeployment(deployment)
 			}
 
-			deployment.Containers = append(deployment.Containers, &container)
+			continue
 		}
 
-		if headContainer == nil {
-			logger.Fatal("head container is nil!")
+		// whatever the containers printed while the daemon was down is lost
+		for _, container := range deployment.containers() {
+			Flux.logStore.Attach(container, deployment.config(), time.Now())
 		}
 
-		deployment.Head = headContainer
-		app.Deployment = deployment
-		am.AddApp(app.Name, &app)
+		proxy, _ := deployment.NewDeploymentProxy()
+		deployment.setProxy(proxy)
+		Flux.proxy.AddDeployment(deployment)
+	}
+}
 
-		status, err := deployment.Status(context.Background())
-		if err != nil {
-			logger.Warnw("Failed to get deployment status", zap.Error(err))
-			continue
+// loadDeployment loads the deployment of an app along with its containers and their volumes from the database
+func loadDeployment(app App) (*Deployment, error) {
+	deployment := &Deployment{}
+	var configString string
+	var maintenance bool
+	var maintenancePage string
+	err := Flux.db.QueryRow("SELECT id, url, port, config, source_hash, maintenance, maintenance_page FROM deployments WHERE id = ?", app.DeploymentID).Scan(&deployment.ID, &deployment.URL, &deployment.Port, &configString, &deployment.SourceHash, &maintenance, &maintenancePage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deployment %d: %v", app.DeploymentID, err)
+	}
+
+	if maintenance {
+		deployment.maintenancePage.Store(&maintenancePage)
+	}
+
+	if err := json.Unmarshal([]byte(configString), &deployment.Config); err != nil {
+		appLogger(app.Name).Warnw("Failed to parse deployment config", zap.Error(err))
+	}
+
+	// deployments created before the config was stored don't know which app they belong to
+	if deployment.Config.Name == "" {
+		deployment.Config.Name = app.Name
+	}
+	deployment.Containers = make([]*Container, 0)
+
+	rows, err := Flux.db.Query("SELECT id, container_id, deployment_id, head FROM containers WHERE deployment_id = ?", app.DeploymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query containers: %v", err)
+	}
+	defer rows.Close()
+
+	heads := 0
+	for rows.Next() {
+		container := &Container{}
+		var containerIDString string
+		if err := rows.Scan(&container.ID, &containerIDString, &container.DeploymentID, &container.Head); err != nil {
+			return nil, fmt.Errorf("failed to scan container: %v", err)
 		}
+		container.Deployment = deployment
+		copy(container.ContainerID[:], containerIDString)
 
-		if status != "running" {
+		if container.Head {
+			heads++
+			deployment.Head = container
+		}
+
+		deployment.Containers = append(deployment.Containers, container)
+	}
+
+	switch {
+	case len(deployment.Containers) == 0:
+		return nil, fmt.Errorf("deployment %d has no containers", deployment.ID)
+	case heads == 0:
+		return nil, fmt.Errorf("none of the %d containers of deployment %d is marked as head", len(deployment.Containers), deployment.ID)
+	case heads > 1:
+		return nil, fmt.Errorf("%d containers of deployment %d are marked as head", heads, deployment.ID)
+	}
+
+	for _, container := range deployment.containers() {
+		if err := container.loadVolumes(); err != nil {
+			return nil, err
+		}
+	}
+
+	return deployment, nil
+}
+
+func (c *Container) loadVolumes() error {
+	rows, err := Flux.db.Query("SELECT id, volume_id, container_id, mountpoint FROM volumes WHERE container_id = ?", c.ContainerID[:])
+	if err != nil {
+		return fmt.Errorf("failed to query volumes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var volume Volume
+		if err := rows.Scan(&volume.ID, &volume.VolumeID, &volume.ContainerID, &volume.Mountpoint); err != nil {
+			return fmt.Errorf("failed to scan volume: %v", err)
+		}
+		c.Volumes = append(c.Volumes, volume)
+	}
+
+	return nil
+}
+
+// Problems reports the apps that are in an inconsistent state, both the ones that could not be loaded at all and
+// the ones whose containers no longer match what is in the database
+func (am *AppManager) Problems(ctx context.Context) []pkg.AppProblem {
+	problems := make([]pkg.AppProblem, 0)
+	am.broken.Range(func(key, value any) bool {
+		problems = append(problems, pkg.AppProblem{
+			App:     key.(string),
+			Problem: fmt.Sprintf("not loaded: %s", value.(string)),
+		})
+		return true
+	})
+
+	known := make(map[string]bool)
+	owners := make(map[int64]string)
+	for _, app := range am.GetAllApps() {
+		owners[app.Deployment.ID] = app.name()
+		for _, container := range app.Deployment.containers() {
+			known[string(container.ContainerID[:])] = true
+
+			_, err := Flux.dockerClient.ContainerInspect(ctx, string(container.ContainerID[:]))
+			if client.IsErrNotFound(err) {
+				problems = append(problems, pkg.AppProblem{
+					App:     app.name(),
+					Problem: fmt.Sprintf("container %s no longer exists in docker, redeploy the app to recreate it", container.ContainerID[:12]),
+				})
+			} else if err != nil {
+				problems = append(problems, pkg.AppProblem{
+					App:     app.name(),
+					Problem: fmt.Sprintf("failed to inspect container %s: %v", container.ContainerID[:12], err),
+				})
+			}
+		}
+	}
+
+	orphans, err := findOrphanedContainers(ctx, known)
+	if err != nil {
+		problems = append(problems, pkg.AppProblem{
+			Problem: fmt.Sprintf("failed to look for orphaned containers: %v", err),
+		})
+	}
+
+	for _, orphan := range orphans {
+		// the name in the labels is the one the app had when the container was created, the app that owns the
+		// deployment may have been renamed since
+		appName := orphan.Labels[appLabel]
+		deploymentID, labeled := labeledDeployment(orphan.Labels)
+		owner, owned := owners[deploymentID]
+		if labeled && owned {
+			appName = owner
+		}
+
+		// the containers of a deploy that is still running aren't saved yet
+		if deploymentLock.Deploying(appName) {
 			continue
 		}
 
-		deployment.Proxy, _ = deployment.NewDeploymentProxy()
-		Flux.proxy.AddDeployment(deployment)
+		problem := fmt.Sprintf("container %s was created by flux but isn't tracked anymore, remove it with docker rm -f %s, or everything that is left of the app with flux delete --force %s", orphan.ID[:12], orphan.ID[:12], appName)
+		if labeled && owned {
+			// deleting the app would take its running containers with it
+			problem = fmt.Sprintf("container %s was created by flux but isn't tracked anymore, remove it with docker rm -f %s", orphan.ID[:12], orphan.ID[:12])
+		}
+
+		problems = append(problems, pkg.AppProblem{
+			App:     appName,
+			Problem: problem,
+		})
 	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].App < problems[j].App
+	})
+
+	return problems
 }
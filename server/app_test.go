@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// TestRenameWhileServing renames an app while its status and routes are read, run it with -race to catch the name
+// being changed without holding the lock of the deployment
+func TestRenameWhileServing(t *testing.T) {
+	newTestServer(t)
+
+	app := createTestApp(t, testProjectConfig("app"))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			Flux.proxy.Routes()
+			app.name()
+		}
+	}()
+
+	err := app.Rename(context.Background(), "renamed")
+	close(done)
+	wg.Wait()
+
+	if err != nil {
+		t.Fatalf("failed to rename app: %v", err)
+	}
+
+	if name := app.name(); name != "renamed" {
+		t.Errorf("expected the app to be named renamed, got %s", name)
+	}
+
+	if name := app.Deployment.config().Name; name != "renamed" {
+		t.Errorf("expected the config to have the new name, got %s", name)
+	}
+
+	if Flux.appManager.GetApp("renamed") != app || Flux.appManager.GetApp("app") != nil {
+		t.Errorf("expected the app to only be found under its new name")
+	}
+}
+
+// containers keep the name that the app had when they were created, an orphan is reported under the app that owns its
+// deployment now, without suggesting to delete that app
+func TestProblemsReportOrphansOfRenamedApps(t *testing.T) {
+	docker := newTestServer(t)
+
+	app := createTestApp(t, testProjectConfig("app"))
+	if err := app.Rename(context.Background(), "renamed"); err != nil {
+		t.Fatalf("failed to rename app: %v", err)
+	}
+
+	for _, c := range app.Deployment.containers() {
+		labels := docker.container(string(c.ContainerID[:])).Config.Labels
+		if labels[deploymentLabel] != strconv.FormatInt(app.Deployment.ID, 10) {
+			t.Errorf("expected the containers to be labeled with deployment %d, got %q", app.Deployment.ID, labels[deploymentLabel])
+		}
+	}
+
+	orphan := docker.addContainer("app-orphan", container.Config{Labels: ownerLabels(app.Deployment.ID, "app")}, container.HostConfig{})
+
+	var found bool
+	for _, problem := range Flux.appManager.Problems(context.Background()) {
+		if !strings.Contains(problem.Problem, orphan.ID[:12]) {
+			continue
+		}
+		found = true
+
+		if problem.App != "renamed" {
+			t.Errorf("expected the orphan to be reported under renamed, got %q", problem.App)
+		}
+
+		if strings.Contains(problem.Problem, "delete --force") {
+			t.Errorf("expected no suggestion to delete the app that still owns the deployment, got %q", problem.Problem)
+		}
+	}
+
+	if !found {
+		t.Errorf("expected the orphan to be reported")
+	}
+}
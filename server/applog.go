@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// appLogger returns a logger that tags every line with the app it is about, so that the daemon logs can be filtered
+// by app
+func appLogger(name string) *zap.SugaredLogger {
+	return logger.With(zap.String("app", name))
+}
+
+// appFilterCore drops log entries about an app when they are below the minimum level configured for that app, or
+// when only the logs of a single app should be shown. Entries that aren't about any app are always passed through.
+type appFilterCore struct {
+	zapcore.Core
+	levels map[string]zapcore.Level
+	// when set, only entries about this app are logged
+	only string
+	// the app set on this core through With, if any
+	app string
+}
+
+func newAppFilterCore(core zapcore.Core, appLevels map[string]string, only string) (zapcore.Core, error) {
+	levels := make(map[string]zapcore.Level)
+	for app, level := range appLevels {
+		parsed, err := zapcore.ParseLevel(level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level %q for app %s: %v", level, app, err)
+		}
+
+		levels[app] = parsed
+	}
+
+	return &appFilterCore{
+		Core:   core,
+		levels: levels,
+		only:   only,
+	}, nil
+}
+
+func (c *appFilterCore) enabled(app string, level zapcore.Level) bool {
+	if app == "" {
+		return true
+	}
+
+	if c.only != "" && app != c.only {
+		return false
+	}
+
+	if minLevel, ok := c.levels[app]; ok {
+		return level >= minLevel
+	}
+
+	return true
+}
+
+func appFromFields(fields []zapcore.Field) string {
+	for _, field := range fields {
+		if field.Key == "app" && field.Type == zapcore.StringType {
+			return field.String
+		}
+	}
+
+	return ""
+}
+
+func (c *appFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	app := c.app
+	if fieldApp := appFromFields(fields); fieldApp != "" {
+		app = fieldApp
+	}
+
+	return &appFilterCore{
+		Core:   c.Core.With(fields),
+		levels: c.levels,
+		only:   c.only,
+		app:    app,
+	}
+}
+
+func (c *appFilterCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabled(c.app, entry.Level) || !c.Core.Enabled(entry.Level) {
+		return checked
+	}
+
+	return checked.AddCore(entry, c)
+}
+
+func (c *appFilterCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	// the app can also be passed along with the entry itself instead of through With
+	if !c.enabled(appFromFields(fields), entry.Level) {
+		return nil
+	}
+
+	return c.Core.Write(entry, fields)
+}
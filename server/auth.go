@@ -0,0 +1,29 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// RequireAuth only lets requests through that carry the daemon's auth_token as a bearer token. Endpoints that are
+// guarded by it are disabled while no auth_token is configured
+func (s *FluxServer) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AuthToken == "" {
+			writeError(w, pkg.ErrorCodeForbidden, http.StatusForbidden, "This endpoint is disabled, set auth_token in the daemon config to enable it")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AuthToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fluxd"`)
+			writeError(w, pkg.ErrorCodeUnauthorized, http.StatusUnauthorized, "Missing or invalid auth token")
+			return
+		}
+
+		next(w, r)
+	}
+}
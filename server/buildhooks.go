@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+// BuildHooks are shell commands that the daemon runs at points of every build, such as compiling assets or scanning
+// the image. Each one runs in a sandbox with the project directory and only a few variables set, and a hook that
+// fails fails the deploy. There are none unless the daemon's config lists them
+type BuildHooks struct {
+	// the image that the hooks run in, defaultSandboxImage when empty
+	Image string `json:"image,omitempty"`
+	// run after the code was uploaded, before anything is built. Not run by a redeploy, which uploads nothing
+	PostUpload []string `json:"post_upload,omitempty"`
+	// run after the project was prepared, right before the image is built
+	PreBuild []string `json:"pre_build,omitempty"`
+	// run after the image was built, FLUX_IMAGE is the name of the image and FLUX_IMAGE_ARCHIVE the image saved as a
+	// tar archive
+	PostBuild []string `json:"post_build,omitempty"`
+}
+
+const (
+	buildHookPostUpload = "post_upload"
+	buildHookPreBuild   = "pre_build"
+	buildHookPostBuild  = "post_build"
+)
+
+// imageArchivePath is where post_build hooks find the image that was built, saved as a tar archive
+const imageArchivePath = "/image/image.tar"
+
+func (h BuildHooks) image() string {
+	if h.Image == "" {
+		return defaultSandboxImage
+	}
+
+	return h.Image
+}
+
+func (h BuildHooks) validate() error {
+	if h.Image != "" {
+		if _, err := reference.ParseNormalizedNamed(h.Image); err != nil {
+			return fmt.Errorf("invalid image %q: %v", h.Image, err)
+		}
+	}
+
+	for stage, commands := range map[string][]string{
+		buildHookPostUpload: h.PostUpload,
+		buildHookPreBuild:   h.PreBuild,
+		buildHookPostBuild:  h.PostBuild,
+	} {
+		for _, command := range commands {
+			if command == "" {
+				return fmt.Errorf("%s has an empty command", stage)
+			}
+		}
+	}
+
+	return nil
+}
+
+// buildHookEnv is the whole environment of a build hook, the hooks don't see the environment of the daemon
+func buildHookEnv(projectConfig pkg.ProjectConfig, imageName string) []string {
+	env := []string{
+		"FLUX_APP=" + projectConfig.Name,
+		"FLUX_PROJECT_PATH=" + sandboxProjectPath,
+	}
+
+	if imageName != "" {
+		env = append(env, "FLUX_IMAGE="+imageName, "FLUX_IMAGE_ARCHIVE="+imageArchivePath)
+	}
+
+	return env
+}
+
+// runBuildHooks runs the commands of a build hook one after the other in a sandbox of the project directory.
+// imageName is only set once the image has been built, the hooks get it as an archive rather than access to docker
+func (s *FluxServer) runBuildHooks(ctx context.Context, stage string, commands []string, projectPath string, projectConfig pkg.ProjectConfig, imageName string, eventChannel chan<- DeploymentEvent, log *zap.SugaredLogger) error {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	sb := sandbox{
+		image:       s.config.BuildHooks.image(),
+		projectPath: projectPath,
+		env:         buildHookEnv(projectConfig, imageName),
+	}
+	if err := s.pullSandboxImage(ctx, sb.image, projectConfig, eventChannel, log); err != nil {
+		return fmt.Errorf("%s hooks can't run: %v", stage, err)
+	}
+
+	if imageName != "" {
+		archiveDir, err := os.MkdirTemp(Flux.rootDir, "image-")
+		if err != nil {
+			return fmt.Errorf("failed to create image archive directory: %v", err)
+		}
+		defer os.RemoveAll(archiveDir)
+
+		if err := saveImage(ctx, imageName, filepath.Join(archiveDir, path.Base(imageArchivePath))); err != nil {
+			return fmt.Errorf("failed to save image for the %s hooks: %v", stage, err)
+		}
+
+		sb.mounts = append(sb.mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: archiveDir,
+			Target: path.Dir(imageArchivePath),
+		})
+	}
+
+	for _, command := range commands {
+		log.Debugw("Running build hook", zap.String("stage", stage), zap.String("command", command))
+		eventChannel <- DeploymentEvent{
+			Stage:   stage,
+			Message: fmt.Sprintf("Running %s hook", stage),
+		}
+
+		if err := sb.run(ctx, stage, command, eventChannel); err != nil {
+			return fmt.Errorf("%s hook %q failed: %v", stage, command, err)
+		}
+	}
+
+	return nil
+}
+
+// saveImage writes imageName to path as a tar archive, like docker save
+func saveImage(ctx context.Context, imageName string, path string) error {
+	archive, err := Flux.dockerClient.ImageSave(ctx, []string{imageName})
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, archive); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
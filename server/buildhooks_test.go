@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// post_build hooks get the image as an archive instead of access to docker
+func TestPostBuildHooksGetImageArchive(t *testing.T) {
+	docker := newTestServer(t)
+	Flux.config.BuildHooks.Image = "test/scanner"
+	docker.addImage(Flux.config.BuildHooks.Image)
+	docker.addImage("flux_app-image")
+
+	var created *fakeContainer
+	var archive []byte
+	docker.onCreate = func(c *fakeContainer) {
+		created = c
+		for _, m := range c.HostConfig.Mounts {
+			if m.Target == path.Dir(imageArchivePath) {
+				archive, _ = os.ReadFile(filepath.Join(m.Source, path.Base(imageArchivePath)))
+			}
+		}
+	}
+
+	events := make(chan DeploymentEvent)
+	go drainEvents(events)
+	defer close(events)
+
+	err := Flux.runBuildHooks(context.Background(), buildHookPostBuild, []string{"true"}, t.TempDir(), testProjectConfig("app"), "flux_app-image", events, logger)
+	if err != nil {
+		t.Fatalf("failed to run post_build hooks: %v", err)
+	}
+
+	if created == nil || created.Config.Image != "test/scanner" {
+		t.Fatalf("expected the hook to run in the configured image, got %+v", created)
+	}
+
+	if string(archive) != "archive of flux_app-image" {
+		t.Errorf("expected the image archive to be mounted at %s, got %q", imageArchivePath, archive)
+	}
+
+	for _, m := range created.HostConfig.Mounts {
+		if m.Target == path.Dir(imageArchivePath) && !m.ReadOnly {
+			t.Errorf("expected the image archive to be mounted read only")
+		}
+
+		if _, err := os.Stat(m.Source); m.Target != sandboxProjectPath && !os.IsNotExist(err) {
+			t.Errorf("expected the image archive to be removed once the hooks ran")
+		}
+	}
+
+	for _, variable := range []string{"FLUX_IMAGE=flux_app-image", "FLUX_IMAGE_ARCHIVE=" + imageArchivePath} {
+		if !slices.Contains(created.Config.Env, variable) {
+			t.Errorf("expected %s to be set, got %q", variable, created.Config.Env)
+		}
+	}
+}
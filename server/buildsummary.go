@@ -0,0 +1,68 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// matches the layers that the exporter of the lifecycle reports, such as "Reusing layer 'paketo-buildpacks/go-dist:go'"
+// for a layer that came from the cache and "Adding layer '...'" for one that was built again
+var exportedLayerRegex = regexp.MustCompile(`(Reusing|Adding) layer '([^']+)'`)
+
+// buildCacheTracker collects which layers of the buildpacks were reused from the output of pack build, the output of
+// stdout and stderr is observed concurrently
+type buildCacheTracker struct {
+	mu sync.Mutex
+	// buildpack ids in the order that they were first seen
+	buildpacks []string
+	rebuilt    map[string]bool
+}
+
+func newBuildCacheTracker() *buildCacheTracker {
+	return &buildCacheTracker{rebuilt: make(map[string]bool)}
+}
+
+func (t *buildCacheTracker) observe(line string) {
+	match := exportedLayerRegex.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+
+	// layers of buildpacks are named <buildpack>:<layer>, the layers of the lifecycle itself aren't interesting
+	buildpack, _, ok := strings.Cut(match[2], ":")
+	if !ok || strings.HasPrefix(buildpack, "buildpacksio/lifecycle") {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, seen := t.rebuilt[buildpack]; !seen {
+		t.buildpacks = append(t.buildpacks, buildpack)
+		t.rebuilt[buildpack] = false
+	}
+
+	if match[1] == "Adding" {
+		t.rebuilt[buildpack] = true
+	}
+}
+
+// summary returns which buildpacks were served entirely from the cache and which built a layer again, ok is false when
+// the output had no layers in it, e.g. because pack changed its output
+func (t *buildCacheTracker) summary() (summary pkg.BuildSummary, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, buildpack := range t.buildpacks {
+		if t.rebuilt[buildpack] {
+			summary.Rebuilt = append(summary.Rebuilt, buildpack)
+		} else {
+			summary.Cached = append(summary.Cached, buildpack)
+		}
+	}
+
+	return summary, len(t.buildpacks) > 0
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyCompressesResponses(t *testing.T) {
+	newTestServer(t)
+
+	body := strings.Repeat("flux ", minCompressSize)
+	var precompressed bytes.Buffer
+	gz := gzip.NewWriter(&precompressed)
+	io.WriteString(gz, body)
+	gz.Close()
+
+	upstream := http.NewServeMux()
+	upstream.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+	})
+	upstream.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "flux")
+	})
+	upstream.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, body)
+	})
+	upstream.HandleFunc("/gzipped", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(precompressed.Bytes())
+	})
+	port := newTestUpstream(t, upstream)
+
+	compressed := testProjectConfig("compressed")
+	compressed.Port = port
+	compressed.CompressResponses = true
+	createTestApp(t, compressed)
+
+	uncompressed := testProjectConfig("uncompressed")
+	uncompressed.Port = port
+	createTestApp(t, uncompressed)
+
+	tests := []struct {
+		name           string
+		url            string
+		acceptEncoding string
+		// the encoding of the response, which is decoded before it's compared to the body
+		wantEncoding string
+		// whether the proxy compressed the response, and so has to tell caches that it depends on Accept-Encoding
+		wantVary bool
+	}{
+		{"plain client", "http://compressed.example.com/text", "", "", false},
+		{"gzip client", "http://compressed.example.com/text", "gzip, deflate", "gzip", true},
+		{"gzip refused", "http://compressed.example.com/text", "gzip;q=0", "", false},
+		{"small response", "http://compressed.example.com/small", "gzip", "", false},
+		{"incompressible type", "http://compressed.example.com/image", "gzip", "", false},
+		// the app's own compression is passed through as is, rather than compressed a second time
+		{"compressed upstream", "http://compressed.example.com/gzipped", "gzip", "gzip", false},
+		{"compression disabled", "http://uncompressed.example.com/text", "gzip", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.url, nil)
+			if test.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+
+			recorder := httptest.NewRecorder()
+			Flux.proxy.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", recorder.Code)
+			}
+
+			if encoding := recorder.Header().Get("Content-Encoding"); encoding != test.wantEncoding {
+				t.Fatalf("expected a Content-Encoding of %q, got %q", test.wantEncoding, encoding)
+			}
+
+			if vary := containsFold(recorder.Header().Values("Vary"), "Accept-Encoding"); vary != test.wantVary {
+				t.Errorf("expected the response to vary on Accept-Encoding to be %t, got %v", test.wantVary, recorder.Header().Values("Vary"))
+			}
+
+			var reader io.Reader = recorder.Body
+			if test.wantEncoding == "gzip" {
+				gz, err := gzip.NewReader(recorder.Body)
+				if err != nil {
+					t.Fatalf("failed to read gzip response: %v", err)
+				}
+				reader = gz
+			}
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+
+			want := body
+			if strings.HasSuffix(test.url, "/small") {
+				want = "flux"
+			}
+			if string(got) != want {
+				t.Errorf("expected a body of %d bytes, got %d bytes", len(want), len(got))
+			}
+		})
+	}
+}
+
+func containsFold(values []string, want string) bool {
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), want) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
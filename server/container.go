@@ -2,28 +2,25 @@ package server
 
 import (
 	"context"
-	"database/sql"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
 	"github.com/joho/godotenv"
 	"github.com/juls0730/flux/pkg"
 	"go.uber.org/zap"
 )
 
-var (
-	volumeInsertStmt    *sql.Stmt
-	volumeUpdateStmt    *sql.Stmt
-	containerInsertStmt *sql.Stmt
-)
-
 type Volume struct {
 	ID          int64  `json:"id"`
 	VolumeID    string `json:"volume_id"`
@@ -40,10 +37,20 @@ type Container struct {
 	DeploymentID int64       `json:"deployment_id"`
 }
 
-func CreateDockerVolume(ctx context.Context) (vol *Volume, err error) {
+// CreateDockerVolume creates a docker volume with the given name, or a generated name if it's empty. Creating a
+// volume that already exists returns the existing volume. Volumes with a generated name belong to the deployment of
+// appName and are labeled like its containers
+func CreateDockerVolume(ctx context.Context, name string, deploymentID int64, appName string) (vol *Volume, err error) {
+	var labels map[string]string
+	if name == "" {
+		labels = ownerLabels(deploymentID, appName)
+	}
+
 	dockerVolume, err := Flux.dockerClient.VolumeCreate(ctx, volume.CreateOptions{
+		Name:       name,
 		Driver:     "local",
 		DriverOpts: map[string]string{},
+		Labels:     labels,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create volume: %v", err)
@@ -58,8 +65,42 @@ func CreateDockerVolume(ctx context.Context) (vol *Volume, err error) {
 	return vol, nil
 }
 
-func CreateDockerContainer(ctx context.Context, imageName, projectPath string, projectConfig pkg.ProjectConfig, vol *Volume) (*Container, error) {
-	containerName := fmt.Sprintf("%s-%s", projectConfig.Name, time.Now().Format("20060102-150405"))
+func validateEnvironment(variables []string) error {
+	for _, variable := range variables {
+		if key, _, ok := strings.Cut(variable, "="); !ok || key == "" {
+			return fmt.Errorf("invalid environment variable %q, expected KEY=value", variable)
+		}
+	}
+
+	return nil
+}
+
+// mergeEnvironment returns the defaults that env doesn't set a variable of the same name for, followed by env
+func mergeEnvironment(defaults []string, env []string) []string {
+	set := make(map[string]bool)
+	for _, variable := range env {
+		key, _, _ := strings.Cut(variable, "=")
+		set[key] = true
+	}
+
+	var merged []string
+	for _, variable := range defaults {
+		key, _, _ := strings.Cut(variable, "=")
+		if !set[key] {
+			merged = append(merged, variable)
+		}
+	}
+
+	return append(merged, env...)
+}
+
+func CreateDockerContainer(ctx context.Context, imageName, projectPath string, projectConfig pkg.ProjectConfig, deploymentID int64, volumes []Volume) (*Container, error) {
+	log := appLogger(projectConfig.Name)
+
+	// replicas are created within the same second, so the timestamp alone doesn't keep their names apart
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	containerName := fmt.Sprintf("%s-%s-%s", projectConfig.Name, time.Now().Format("20060102-150405"), hex.EncodeToString(suffix))
 
 	if projectConfig.EnvFile != "" {
 		envBytes, err := os.Open(filepath.Join(projectPath, projectConfig.EnvFile))
@@ -78,27 +119,45 @@ func CreateDockerContainer(ctx context.Context, imageName, projectPath string, p
 		}
 	}
 
-	logger.Debugw("Creating container", zap.String("container_id", containerName))
+	// secrets are kept out of projectConfig so that they can never be persisted with the rest of the config
+	secretEnv, err := resolveSecrets(projectConfig.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	env := mergeEnvironment(Flux.config.DefaultEnvironment, append(append([]string{}, projectConfig.Environment...), secretEnv...))
+
+	mounts, err := volumeMounts(projectConfig, volumes)
+	if err != nil {
+		return nil, err
+	}
+
+	if projectConfig.Network != "" {
+		if err := ensureNetwork(ctx, projectConfig.Network); err != nil {
+			return nil, err
+		}
+	}
+	mode, networkingConfig := networkMode(projectConfig)
+
+	log.Debugw("Creating container", zap.String("container_id", containerName))
 	resp, err := Flux.dockerClient.ContainerCreate(ctx, &container.Config{
-		Image: imageName,
-		Env:   projectConfig.Environment,
-		Volumes: map[string]struct{}{
-			vol.VolumeID: {},
-		},
+		Image:        imageName,
+		Env:          env,
+		Labels:       containerLabels(projectConfig, deploymentID),
+		ExposedPorts: exposedPorts(projectConfig),
+		User:         containerUser(projectConfig),
+		Hostname:     projectConfig.Hostname,
 	},
 		&container.HostConfig{
-			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
-			NetworkMode:   "bridge",
-			Mounts: []mount.Mount{
-				{
-					Type:     mount.TypeVolume,
-					Source:   vol.VolumeID,
-					Target:   vol.Mountpoint,
-					ReadOnly: false,
-				},
-			},
+			RestartPolicy:  container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+			NetworkMode:    mode,
+			Mounts:         mounts,
+			ReadonlyRootfs: readOnlyRootfs(projectConfig),
+			Tmpfs:          readOnlyTmpfs(projectConfig),
+			CapDrop:        capDrop(projectConfig),
+			ExtraHosts:     projectConfig.ExtraHosts,
 		},
-		nil,
+		networkingConfig,
 		nil,
 		containerName,
 	)
@@ -108,14 +167,16 @@ func CreateDockerContainer(ctx context.Context, imageName, projectPath string, p
 
 	c := &Container{
 		ContainerID: [64]byte([]byte(resp.ID)),
-		Volumes:     []Volume{*vol},
+		Volumes:     append([]Volume{}, volumes...),
 	}
 
 	return c, nil
 }
 
 func CreateContainer(ctx context.Context, imageName, projectPath string, projectConfig pkg.ProjectConfig, head bool, deployment *Deployment) (c *Container, err error) {
-	logger.Debugw("Creating container with image", zap.String("image", imageName))
+	log := appLogger(projectConfig.Name)
+
+	log.Debugw("Creating container with image", zap.String("image", imageName))
 
 	if projectConfig.EnvFile != "" {
 		envBytes, err := os.Open(filepath.Join(projectPath, projectConfig.EnvFile))
@@ -134,96 +195,97 @@ func CreateContainer(ctx context.Context, imageName, projectPath string, project
 		}
 	}
 
-	var vol *Volume
-	vol, err = CreateDockerVolume(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	vol.Mountpoint = "/workspace"
+	// replicas share the volumes of the head container, the volumes are owned by the head so that they are only ever
+	// removed once
+	if !head {
+		headContainer := deployment.head()
+		if headContainer == nil {
+			return nil, fmt.Errorf("cannot create a replica without a head container")
+		}
 
-	if volumeInsertStmt == nil {
-		volumeInsertStmt, err = Flux.db.Prepare("INSERT INTO volumes (volume_id, mountpoint, container_id) VALUES (?, ?, ?) RETURNING id, volume_id, mountpoint, container_id")
+		c, err = CreateDockerContainer(ctx, imageName, projectPath, projectConfig, deployment.ID, headContainer.Volumes)
 		if err != nil {
-			logger.Errorw("Failed to prepare statement", zap.Error(err))
 			return nil, err
 		}
-	}
+		c.Volumes = nil
 
-	c, err = CreateDockerContainer(ctx, imageName, projectPath, projectConfig, vol)
-	if err != nil {
-		return nil, err
-	}
-
-	if containerInsertStmt == nil {
-		containerInsertStmt, err = Flux.db.Prepare("INSERT INTO containers (container_id, head, deployment_id) VALUES ($1, $2, $3) RETURNING id, container_id, head, deployment_id")
-		if err != nil {
+		if err := c.save(head, deployment.ID); err != nil {
+			RemoveDockerContainer(ctx, string(c.ContainerID[:]))
 			return nil, err
 		}
+		deployment.addContainer(c)
+
+		return c, nil
 	}
 
-	var containerIDString string
-	err = containerInsertStmt.QueryRow(c.ContainerID[:], head, deployment.ID).Scan(&c.ID, &containerIDString, &c.Head, &c.DeploymentID)
+	volumes, err := ensureVolumes(ctx, projectConfig, deployment.ID, nil)
 	if err != nil {
 		return nil, err
 	}
-	copy(c.ContainerID[:], containerIDString)
 
-	err = volumeInsertStmt.QueryRow(vol.VolumeID, vol.Mountpoint, c.ContainerID[:]).Scan(&vol.ID, &vol.VolumeID, &vol.Mountpoint, &vol.ContainerID)
+	c, err = CreateDockerContainer(ctx, imageName, projectPath, projectConfig, deployment.ID, volumes)
 	if err != nil {
 		return nil, err
 	}
 
-	c.Deployment = deployment
-	if head {
-		deployment.Head = c
+	if err := c.save(head, deployment.ID); err != nil {
+		RemoveDockerContainer(ctx, string(c.ContainerID[:]))
+		return nil, err
 	}
-	deployment.Containers = append(deployment.Containers, c)
+	deployment.addContainer(c)
 
 	return c, nil
 }
 
-func (c *Container) Upgrade(ctx context.Context, imageName, projectPath string, projectConfig pkg.ProjectConfig) (*Container, error) {
-	// Create new container with new image
-	logger.Debugw("Upgrading container", zap.ByteString("container_id", c.ContainerID[:12]))
-	if c.Volumes == nil {
-		return nil, fmt.Errorf("no volumes found for container %s", c.ContainerID[:12])
+// save inserts a newly created container into the database and points all of its volumes at it, in a single
+// transaction so that a volume is never left pointing at a container that doesn't exist
+func (c *Container) save(head bool, deploymentID int64) error {
+	tx, err := Flux.db.Begin()
+	if err != nil {
+		return err
 	}
 
-	vol := &c.Volumes[0]
-
-	newContainer, err := CreateDockerContainer(ctx, imageName, projectPath, projectConfig, vol)
+	var containerIDString string
+	err = tx.Stmt(containerInsertStmt).QueryRow(c.ContainerID[:], head, deploymentID).Scan(&c.ID, &containerIDString, &c.Head, &c.DeploymentID)
 	if err != nil {
-		return nil, err
+		tx.Rollback()
+		return fmt.Errorf("failed to insert container: %v", err)
 	}
-	newContainer.Deployment = c.Deployment
+	copy(c.ContainerID[:], containerIDString)
 
-	if containerInsertStmt == nil {
-		containerInsertStmt, err = Flux.db.Prepare("INSERT INTO containers (container_id, head, deployment_id) VALUES ($1, $2, $3) RETURNING id, container_id, head, deployment_id")
-		if err != nil {
-			return nil, err
-		}
+	if err := c.saveVolumes(tx); err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	var containerIDString string
-	err = containerInsertStmt.QueryRow(newContainer.ContainerID[:], c.Head, c.Deployment.ID).Scan(&newContainer.ID, &containerIDString, &newContainer.Head, &newContainer.DeploymentID)
+	return tx.Commit()
+}
+
+func (c *Container) Upgrade(ctx context.Context, imageName, projectPath string, projectConfig pkg.ProjectConfig) (*Container, error) {
+	log := appLogger(projectConfig.Name)
+
+	// Create new container with new image
+	log.Debugw("Upgrading container", zap.ByteString("container_id", c.ContainerID[:12]))
+	// every volume of the old container is mounted on the new one, volumes that were added to the config since the
+	// last deploy are created here
+	volumes, err := ensureVolumes(ctx, projectConfig, c.Deployment.ID, c.Volumes)
 	if err != nil {
-		logger.Errorw("Failed to insert container", zap.Error(err))
 		return nil, err
 	}
-	copy(newContainer.ContainerID[:], containerIDString)
 
-	if volumeUpdateStmt == nil {
-		volumeUpdateStmt, err = Flux.db.Prepare("UPDATE volumes SET container_id = ? WHERE id = ? RETURNING id, volume_id, mountpoint, container_id")
-		if err != nil {
-			return nil, err
-		}
+	newContainer, err := CreateDockerContainer(ctx, imageName, projectPath, projectConfig, c.Deployment.ID, volumes)
+	if err != nil {
+		return nil, err
 	}
+	newContainer.Deployment = c.Deployment
 
-	vol = &newContainer.Volumes[0]
-	volumeUpdateStmt.QueryRow(newContainer.ContainerID[:], vol.ID).Scan(&vol.ID, &vol.VolumeID, &vol.Mountpoint, &vol.ContainerID)
+	if err := newContainer.save(c.Head, c.Deployment.ID); err != nil {
+		log.Errorw("Failed to save upgraded container", zap.Error(err))
+		RemoveDockerContainer(ctx, string(newContainer.ContainerID[:]))
+		return nil, err
+	}
 
-	logger.Debug("Upgraded container")
+	log.Debug("Upgraded container")
 
 	return newContainer, nil
 }
@@ -233,10 +295,20 @@ func (c *Container) Start(ctx context.Context) error {
 }
 
 func (c *Container) Stop(ctx context.Context) error {
-	return Flux.dockerClient.ContainerStop(ctx, string(c.ContainerID[:]), container.StopOptions{})
+	return Flux.dockerClient.ContainerStop(ctx, string(c.ContainerID[:]), stopOptions(c.Deployment.config()))
+}
+
+func (c *Container) Pause(ctx context.Context) error {
+	return Flux.dockerClient.ContainerPause(ctx, string(c.ContainerID[:]))
+}
+
+func (c *Container) Unpause(ctx context.Context) error {
+	return Flux.dockerClient.ContainerUnpause(ctx, string(c.ContainerID[:]))
 }
 
 func (c *Container) Remove(ctx context.Context) error {
+	log := appLogger(c.Deployment.config().Name)
+
 	err := RemoveDockerContainer(ctx, string(c.ContainerID[:]))
 
 	if err != nil {
@@ -245,7 +317,7 @@ func (c *Container) Remove(ctx context.Context) error {
 
 	tx, err := Flux.db.Begin()
 	if err != nil {
-		logger.Errorw("Failed to begin transaction", zap.Error(err))
+		log.Errorw("Failed to begin transaction", zap.Error(err))
 		return err
 	}
 
@@ -256,12 +328,15 @@ func (c *Container) Remove(ctx context.Context) error {
 	}
 
 	for _, volume := range c.Volumes {
-		if err := RemoveVolume(ctx, volume.VolumeID); err != nil {
+		if isNamedVolume(c.Deployment.config(), volume.VolumeID) {
+			log.Debugw("Keeping named volume", zap.String("volume_id", volume.VolumeID))
+		} else if err := RemoveVolume(ctx, volume.VolumeID); err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to remove volume (%s): %v", volume.VolumeID, err)
 		}
 
-		_, err = tx.Exec("DELETE FROM volumes WHERE volume_id = ?", volume.VolumeID)
+		// named volumes can be shared between apps, so only delete this container's row
+		_, err = tx.Exec("DELETE FROM volumes WHERE id = ?", volume.ID)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -269,15 +344,15 @@ func (c *Container) Remove(ctx context.Context) error {
 	}
 
 	if err := tx.Commit(); err != nil {
-		logger.Errorw("Failed to commit transaction", zap.Error(err))
+		log.Errorw("Failed to commit transaction", zap.Error(err))
 		return err
 	}
 
 	return nil
 }
 
-func (c *Container) Wait(ctx context.Context, port uint16) error {
-	return WaitForDockerContainer(ctx, string(c.ContainerID[:]), port)
+func (c *Container) Wait(ctx context.Context, port uint16, protocol string, healthPath string) error {
+	return WaitForDockerContainer(ctx, string(c.ContainerID[:]), port, protocol, healthPath)
 }
 
 func (c *Container) Status(ctx context.Context) (string, error) {
@@ -289,13 +364,168 @@ func (c *Container) Status(ctx context.Context) (string, error) {
 	return containerJSON.State.Status, nil
 }
 
-// RemoveContainer stops and removes a container, but be warned that this will not remove the container from the database
+// Info combines what flux knows about the container with its live state in docker
+func (c *Container) Info(ctx context.Context, appName string) (pkg.ContainerInfo, error) {
+	info := pkg.ContainerInfo{
+		App:         appName,
+		ContainerID: string(c.ContainerID[:12]),
+		Role:        "replica",
+	}
+
+	if c.Head {
+		info.Role = "head"
+	}
+
+	for _, volume := range c.Volumes {
+		info.Volumes = append(info.Volumes, fmt.Sprintf("%s:%s", volume.VolumeID, volume.Mountpoint))
+	}
+
+	containerJSON, err := Flux.dockerClient.ContainerInspect(ctx, string(c.ContainerID[:]))
+	if err != nil {
+		return info, err
+	}
+
+	info.Status = containerJSON.State.Status
+	info.Image = containerJSON.Config.Image
+	info.Created = containerJSON.Created
+
+	return info, nil
+}
+
+// Describe returns what flux and docker know about the container, a container that can't be inspected is still
+// described with the error
+func (c *Container) Describe(ctx context.Context) pkg.ContainerDescription {
+	description := pkg.ContainerDescription{
+		ID:          c.ID,
+		ContainerID: string(c.ContainerID[:]),
+		Head:        c.Head,
+	}
+
+	for _, volume := range c.Volumes {
+		description.Volumes = append(description.Volumes, pkg.VolumeDescription{
+			VolumeID:   volume.VolumeID,
+			Mountpoint: volume.Mountpoint,
+		})
+	}
+
+	containerJSON, err := Flux.dockerClient.ContainerInspect(ctx, string(c.ContainerID[:]))
+	if err != nil {
+		description.Error = err.Error()
+		return description
+	}
+
+	description.Name = strings.TrimPrefix(containerJSON.Name, "/")
+	description.Image = containerJSON.Config.Image
+	description.Created = containerJSON.Created
+	description.RestartCount = containerJSON.RestartCount
+
+	if state, err := json.Marshal(containerJSON.State); err == nil {
+		description.State = state
+	}
+
+	if containerJSON.NetworkSettings != nil {
+		description.Networks = make(map[string]string, len(containerJSON.NetworkSettings.Networks))
+		for name, endpoint := range containerJSON.NetworkSettings.Networks {
+			description.Networks[name] = endpoint.IPAddress
+		}
+	}
+
+	return description
+}
+
+func (c *Container) Stats(ctx context.Context) (pkg.ContainerStats, error) {
+	stats := pkg.ContainerStats{
+		ContainerID: string(c.ContainerID[:12]),
+	}
+
+	// we use a non-streaming request instead of a one-shot request here, since docker only fills in the previous
+	// cpu stats, which we need to calculate the cpu usage, when it samples the container twice
+	resp, err := Flux.dockerClient.ContainerStats(ctx, string(c.ContainerID[:]), false)
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+
+	var dockerStats container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&dockerStats); err != nil {
+		return stats, err
+	}
+
+	cpuDelta := float64(dockerStats.CPUStats.CPUUsage.TotalUsage) - float64(dockerStats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(dockerStats.CPUStats.SystemUsage) - float64(dockerStats.PreCPUStats.SystemUsage)
+	onlineCPUs := float64(dockerStats.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(dockerStats.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * onlineCPUs * 100
+	}
+
+	// page cache is counted as used memory by the kernel, but it can be reclaimed at any time, so leave it out the
+	// same way that docker stats does. cgroup v1 calls it total_inactive_file, and cgroup v2 calls it inactive_file
+	stats.MemoryUsage = dockerStats.MemoryStats.Usage
+	cache, ok := dockerStats.MemoryStats.Stats["total_inactive_file"]
+	if !ok {
+		cache = dockerStats.MemoryStats.Stats["inactive_file"]
+	}
+	if cache < stats.MemoryUsage {
+		stats.MemoryUsage -= cache
+	}
+	stats.MemoryLimit = dockerStats.MemoryStats.Limit
+
+	for _, network := range dockerStats.Networks {
+		stats.NetworkRx += network.RxBytes
+		stats.NetworkTx += network.TxBytes
+	}
+
+	return stats, nil
+}
+
+// the signals that an app can be stopped with, without the SIG prefix
+var stopSignals = map[string]bool{
+	"HUP": true, "INT": true, "QUIT": true, "ABRT": true, "KILL": true, "USR1": true, "USR2": true, "ALRM": true,
+	"TERM": true, "PWR": true, "WINCH": true,
+}
+
+func validateStopConfig(projectConfig pkg.ProjectConfig) error {
+	if projectConfig.StopTimeout < 0 {
+		return fmt.Errorf("stop_timeout must not be negative")
+	}
+
+	if projectConfig.StopSignal != "" && !stopSignals[strings.TrimPrefix(strings.ToUpper(projectConfig.StopSignal), "SIG")] {
+		return fmt.Errorf("unsupported stop_signal %q", projectConfig.StopSignal)
+	}
+
+	return nil
+}
+
+// stopOptions returns how the containers of an app are stopped, docker's defaults are used for whatever isn't set
+func stopOptions(projectConfig pkg.ProjectConfig) container.StopOptions {
+	options := container.StopOptions{
+		Signal: projectConfig.StopSignal,
+	}
+
+	if projectConfig.StopTimeout > 0 {
+		timeout := projectConfig.StopTimeout
+		options.Timeout = &timeout
+	}
+
+	return options
+}
+
+// RemoveDockerContainer stops and removes a container, a container that was already removed outside of flux is not an
+// error
 func RemoveDockerContainer(ctx context.Context, containerID string) error {
 	if err := Flux.dockerClient.ContainerStop(ctx, containerID, container.StopOptions{}); err != nil {
+		if client.IsErrNotFound(err) {
+			return nil
+		}
+
 		return fmt.Errorf("failed to stop container (%s): %v", containerID[:12], err)
 	}
 
-	if err := Flux.dockerClient.ContainerRemove(ctx, containerID, container.RemoveOptions{}); err != nil {
+	if err := Flux.dockerClient.ContainerRemove(ctx, containerID, container.RemoveOptions{}); err != nil && !client.IsErrNotFound(err) {
 		return fmt.Errorf("failed to remove container (%s): %v", containerID[:12], err)
 	}
 
@@ -303,7 +533,7 @@ func RemoveDockerContainer(ctx context.Context, containerID string) error {
 }
 
 // scuffed af "health check" for docker containers
-func WaitForDockerContainer(ctx context.Context, containerID string, containerPort uint16) error {
+func WaitForDockerContainer(ctx context.Context, containerID string, containerPort uint16, protocol string, healthPath string) error {
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
@@ -318,11 +548,8 @@ func WaitForDockerContainer(ctx context.Context, containerID string, containerPo
 				return err
 			}
 
-			if containerJSON.State.Running {
-				resp, err := http.Get(fmt.Sprintf("http://%s:%d/", containerJSON.NetworkSettings.IPAddress, containerPort))
-				if err == nil && resp.StatusCode == http.StatusOK {
-					return nil
-				}
+			if containerJSON.State.Running && checkUpstream(upstreamURL(containerIP(containerJSON), containerPort, protocol), healthPath) {
+				return nil
 			}
 
 			time.Sleep(time.Second)
@@ -330,35 +557,31 @@ func WaitForDockerContainer(ctx context.Context, containerID string, containerPo
 	}
 }
 
-func GracefullyRemoveDockerContainer(ctx context.Context, containerID string) error {
-	timeout := 30
-	err := Flux.dockerClient.ContainerStop(ctx, containerID, container.StopOptions{
-		Timeout: &timeout,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to stop container: %v", err)
-	}
+// GracefullyRemoveDockerContainer stops a container with signal, or SIGTERM when it's empty, giving it timeout to exit
+// before docker escalates to SIGKILL, and then removes it. If the container could not be stopped it is force removed,
+// so a stuck container never keeps this from returning for much longer than the timeout.
+func GracefullyRemoveDockerContainer(ctx context.Context, containerID string, timeout time.Duration, signal string) error {
+	timeoutSeconds := int(timeout.Seconds())
 
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	// ContainerStop already kills the container once the timeout passes, the extra leeway is only there so that an
+	// unresponsive docker daemon can't block us forever
+	stopCtx, cancel := context.WithTimeout(ctx, timeout+10*time.Second)
 	defer cancel()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return Flux.dockerClient.ContainerRemove(ctx, containerID, container.RemoveOptions{})
-		default:
-			containerJSON, err := Flux.dockerClient.ContainerInspect(ctx, containerID)
-			if err != nil {
-				return err
-			}
-
-			if !containerJSON.State.Running {
-				return Flux.dockerClient.ContainerRemove(ctx, containerID, container.RemoveOptions{})
-			}
+	if err := Flux.dockerClient.ContainerStop(stopCtx, containerID, container.StopOptions{
+		Signal:  signal,
+		Timeout: &timeoutSeconds,
+	}); err != nil {
+		logger.Warnw("Failed to stop container, force removing it", zap.String("container_id", containerID[:12]), zap.Error(err))
+	}
 
-			time.Sleep(time.Second)
-		}
+	if err := Flux.dockerClient.ContainerRemove(ctx, containerID, container.RemoveOptions{
+		Force: true,
+	}); err != nil {
+		return fmt.Errorf("failed to remove container (%s): %v", containerID[:12], err)
 	}
+
+	return nil
 }
 
 func RemoveVolume(ctx context.Context, volumeID string) error {
@@ -371,6 +594,27 @@ func RemoveVolume(ctx context.Context, volumeID string) error {
 	return nil
 }
 
+// findOrphanedContainers returns the containers that flux created but no longer tracks, such as containers that were
+// left behind when the database was reset. known holds the ids of every container that flux tracks
+func findOrphanedContainers(ctx context.Context, known map[string]bool) ([]types.Container, error) {
+	containers, err := Flux.dockerClient.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", managedLabel+"=true")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []types.Container
+	for _, c := range containers {
+		if !known[c.ID] {
+			orphans = append(orphans, c)
+		}
+	}
+
+	return orphans, nil
+}
+
 func findExistingDockerContainers(ctx context.Context, containerPrefix string) (map[string]bool, error) {
 	containers, err := Flux.dockerClient.ContainerList(ctx, container.ListOptions{
 		All: true,
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+func TestCreateAppWithReplicas(t *testing.T) {
+	docker := newTestServer(t)
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.Replicas = 3
+
+	app := createTestApp(t, projectConfig)
+
+	containers := app.Deployment.containers()
+	if len(containers) != projectConfig.Replicas {
+		t.Fatalf("expected %d containers, got %d", projectConfig.Replicas, len(containers))
+	}
+
+	names := make(map[string]bool)
+	for _, container := range containers {
+		c := docker.container(string(container.ContainerID[:]))
+		if c == nil {
+			t.Fatalf("container %s doesn't exist in docker", container.ContainerID[:12])
+		}
+
+		if c.Status != "running" {
+			t.Errorf("expected container %s to be running, it is %s", c.Name, c.Status)
+		}
+
+		names[c.Name] = true
+	}
+
+	if len(names) != projectConfig.Replicas {
+		t.Errorf("expected every replica to get its own name, got %v", names)
+	}
+}
+
+// a container that ignores SIGTERM is killed once the stop_timeout of its app has passed, rather than after docker's
+// default of 10 seconds
+func TestStopContainerIgnoringSigterm(t *testing.T) {
+	docker := newTestServer(t)
+	docker.onCreate = func(c *fakeContainer) {
+		c.IgnoresStop = true
+	}
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.StopTimeout = 1
+	app := createTestApp(t, projectConfig)
+
+	head := app.Deployment.head()
+	c := docker.container(string(head.ContainerID[:]))
+
+	if err := head.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop container: %v", err)
+	}
+
+	assertKilledAfterStopTimeout(t, docker.stops(c), projectConfig.StopTimeout)
+}
+
+// the containers that an upgrade replaces are stopped with the stop_timeout of the app once they have drained
+func TestUpgradeKillsPreviousContainerIgnoringSigterm(t *testing.T) {
+	docker := newTestServer(t)
+	docker.onCreate = func(c *fakeContainer) {
+		c.IgnoresStop = true
+	}
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.StopTimeout = 1
+	projectConfig.Port = newTestUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	projectConfig.HealthCheck = &pkg.HealthCheck{StabilizationWindow: -1}
+	app := createTestApp(t, projectConfig)
+
+	previous := docker.container(string(app.Deployment.head().ContainerID[:]))
+
+	events := make(chan DeploymentEvent)
+	go drainEvents(events)
+	defer close(events)
+
+	if err := app.Upgrade(context.Background(), projectConfig, "flux_app-image", filepath.Join(Flux.rootDir, "apps", "app"), events); err != nil {
+		t.Fatalf("failed to upgrade app: %v", err)
+	}
+
+	waitFor(t, "the previous container to be removed", func() bool {
+		return docker.container(previous.ID) == nil
+	})
+
+	assertKilledAfterStopTimeout(t, docker.stops(previous), projectConfig.StopTimeout)
+}
+
+func assertKilledAfterStopTimeout(t *testing.T, stops []fakeStop, stopTimeout int) {
+	t.Helper()
+
+	if len(stops) != 1 {
+		t.Fatalf("expected the container to be stopped once, got %d stops", len(stops))
+	}
+
+	stop := stops[0]
+	if stop.Signal != "" && stop.Signal != "SIGTERM" {
+		t.Errorf("expected the container to be sent SIGTERM, got %s", stop.Signal)
+	}
+
+	if stop.Timeout != stopTimeout {
+		t.Errorf("expected a stop timeout of %ds, got %ds", stopTimeout, stop.Timeout)
+	}
+
+	if !stop.Killed {
+		t.Errorf("expected the container to be killed")
+	}
+
+	if limit := time.Duration(stopTimeout)*time.Second + time.Second; stop.Took > limit {
+		t.Errorf("expected the container to be killed within %s, took %s", limit, stop.Took)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+// CrashLoopConfig decides when an app counts as crash looping, which is when docker restarted its containers after
+// they exited at least restarts times within window seconds
+type CrashLoopConfig struct {
+	Restarts int `json:"restarts,omitempty"`
+	Window   int `json:"window,omitempty"`
+	// posted a pkg.CrashLoopNotification whenever an app starts crash looping, nothing is posted when empty
+	Notify string `json:"notify,omitempty"`
+}
+
+const (
+	defaultCrashLoopRestarts = 5
+	defaultCrashLoopWindow   = 300
+	// how long to wait before subscribing to the docker events again after the subscription failed
+	dockerEventsRetryInterval = 5 * time.Second
+)
+
+func (c CrashLoopConfig) validate() error {
+	if c.Restarts < 0 || c.Window < 0 {
+		return fmt.Errorf("restarts and window can't be negative")
+	}
+
+	if c.Notify != "" {
+		return validateNotifyURL(c.Notify)
+	}
+
+	return nil
+}
+
+func (c CrashLoopConfig) window() time.Duration {
+	return time.Duration(c.Window) * time.Second
+}
+
+type containerRestart struct {
+	containerID string
+	at          time.Time
+}
+
+// recordRestart remembers that docker restarted a container of the deployment, and reports whether that made the
+// deployment start crash looping
+func (d *Deployment) recordRestart(containerID string, at time.Time) bool {
+	d.restartsLock.Lock()
+	defer d.restartsLock.Unlock()
+
+	d.restarts = append(d.restarts, containerRestart{containerID: containerID, at: at})
+
+	looping := d.crashLoopingLocked()
+	startedLooping := looping && !d.crashLoopReported
+	d.crashLoopReported = looping
+
+	return startedLooping
+}
+
+// crashLooping reports whether the containers of the deployment were restarted too often recently. It clears up on
+// its own once the containers stay up for the crash loop window, or once they are replaced by a deploy
+func (d *Deployment) crashLooping() bool {
+	d.restartsLock.Lock()
+	defer d.restartsLock.Unlock()
+
+	looping := d.crashLoopingLocked()
+	if !looping {
+		d.crashLoopReported = false
+	}
+
+	return looping
+}
+
+// crashLoopingLocked drops the restarts that are outside of the window, d.restartsLock must be held
+func (d *Deployment) crashLoopingLocked() bool {
+	config := Flux.config.CrashLoop
+	cutoff := time.Now().Add(-config.window())
+
+	current := make(map[string]bool)
+	for _, container := range d.containers() {
+		current[string(container.ContainerID[:])] = true
+	}
+
+	// restarts of containers that a deploy replaced no longer say anything about the app
+	recent := d.restarts[:0]
+	for _, restart := range d.restarts {
+		if restart.at.After(cutoff) && current[restart.containerID] {
+			recent = append(recent, restart)
+		}
+	}
+	d.restarts = recent
+
+	return len(d.restarts) >= config.Restarts
+}
+
+// WatchCrashLoops follows the docker events of the containers that flux manages, and reports apps whose containers
+// keep being restarted by their restart policy, until ctx is cancelled
+func (s *FluxServer) WatchCrashLoops(ctx context.Context) {
+	// docker only counts the restarts that it did because of the restart policy, so a container that is started by
+	// flux doesn't look like it crashed
+	restartCounts := make(map[string]int)
+
+	for {
+		messages, errs := s.dockerClient.Events(ctx, events.ListOptions{
+			Filters: filters.NewArgs(
+				filters.Arg("type", string(events.ContainerEventType)),
+				filters.Arg("event", string(events.ActionStart)),
+				filters.Arg("event", string(events.ActionDestroy)),
+				filters.Arg("label", managedLabel+"=true"),
+			),
+		})
+
+	follow:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errs:
+				logger.Warnw("Lost the docker event stream, crash loops aren't detected until it is back", zap.Error(err))
+				break follow
+			case message := <-messages:
+				if message.Action == events.ActionDestroy {
+					delete(restartCounts, message.Actor.ID)
+					continue
+				}
+
+				s.handleContainerStart(ctx, message, restartCounts)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dockerEventsRetryInterval):
+		}
+	}
+}
+
+func (s *FluxServer) handleContainerStart(ctx context.Context, message events.Message, restartCounts map[string]int) {
+	deployment := containerDeployment(message.Actor.ID)
+	if deployment == nil {
+		return
+	}
+
+	containerJSON, err := s.dockerClient.ContainerInspect(ctx, message.Actor.ID)
+	if err != nil {
+		return
+	}
+
+	previous := restartCounts[message.Actor.ID]
+	restartCounts[message.Actor.ID] = containerJSON.RestartCount
+	if containerJSON.RestartCount <= previous {
+		return
+	}
+
+	log := appLogger(deployment.config().Name)
+	log.Debugw("Container was restarted", zap.String("container_id", message.Actor.ID[:12]), zap.Int("restart_count", containerJSON.RestartCount))
+
+	if !deployment.recordRestart(message.Actor.ID, time.Unix(0, message.TimeNano)) {
+		return
+	}
+
+	config := s.config.CrashLoop
+	log.Warnw("App is crash looping", zap.Int("restarts", config.Restarts), zap.Duration("window", config.window()))
+
+	if config.Notify != "" {
+		go sendNotification(config.Notify, deployment.config().Name, pkg.CrashLoopNotification{
+			App:      deployment.config().Name,
+			Restarts: config.Restarts,
+			Window:   config.Window,
+			Message:  fmt.Sprintf("%s was restarted %d times within %s", deployment.config().Name, config.Restarts, config.window()),
+		})
+	}
+}
+
+// containerDeployment returns the deployment that a container belongs to, nil for containers of no app
+func containerDeployment(containerID string) *Deployment {
+	for _, app := range Flux.appManager.GetAllApps() {
+		for _, container := range app.Deployment.containers() {
+			if string(container.ContainerID[:]) == containerID {
+				return app.Deployment
+			}
+		}
+	}
+
+	return nil
+}
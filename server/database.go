@@ -0,0 +1,363 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "embed"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+
+	defaultBusyTimeout = 5000
+	defaultJournalMode = "WAL"
+)
+
+var (
+	//go:embed schema.sql
+	sqliteSchema string
+	//go:embed schema_postgres.sql
+	postgresSchema string
+
+	// columns that were added after a table was first created, CREATE TABLE IF NOT EXISTS will not add them to
+	// databases created by older versions of fluxd so we add them here
+	migrations = []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"deployments", "config", "TEXT NOT NULL DEFAULT '{}'"},
+		{"deployments", "source_hash", "TEXT NOT NULL DEFAULT ''"},
+		{"deployments", "maintenance", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"deployments", "maintenance_page", "TEXT NOT NULL DEFAULT ''"},
+	}
+
+	// prepared in prepareStatements when the database is opened
+	appInsertStmt        *sql.Stmt
+	deploymentInsertStmt *sql.Stmt
+	containerInsertStmt  *sql.Stmt
+	volumeInsertStmt     *sql.Stmt
+	volumeUpdateStmt     *sql.Stmt
+
+	// the statements that prepareStatements prepares, and the queries that they are prepared from
+	statements = []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&appInsertStmt, "INSERT INTO apps (name, deployment_id) VALUES (?, ?) RETURNING id, name, deployment_id"},
+		{&deploymentInsertStmt, "INSERT INTO deployments (url, port, config) VALUES (?, ?, ?) RETURNING id, url, port"},
+		{&containerInsertStmt, "INSERT INTO containers (container_id, head, deployment_id) VALUES (?, ?, ?) RETURNING id, container_id, head, deployment_id"},
+		{&volumeInsertStmt, "INSERT INTO volumes (volume_id, mountpoint, container_id) VALUES (?, ?, ?) RETURNING id, volume_id, mountpoint, container_id"},
+		{&volumeUpdateStmt, "UPDATE volumes SET container_id = ? WHERE id = ? RETURNING id, volume_id, mountpoint, container_id"},
+	}
+)
+
+type DatabaseConfig struct {
+	// either sqlite3 or postgres, defaults to sqlite3
+	Driver string `json:"driver,omitempty"`
+	// the data source name passed to the driver, for sqlite3 this defaults to fluxd.db in the root directory
+	DSN string `json:"dsn,omitempty"`
+	// sqlite3 only, how many milliseconds a write waits for the database to be unlocked before failing with
+	// "database is locked", defaults to 5000
+	BusyTimeout int `json:"busy_timeout,omitempty"`
+	// sqlite3 only, the journal mode of the database, defaults to WAL so that reads don't block on writes
+	JournalMode string `json:"journal_mode,omitempty"`
+	// the most connections that are open at once, defaults to 1 for sqlite3, which only has one writer at a time
+	// anyway, and to no limit for postgres
+	MaxOpenConns int `json:"max_open_conns,omitempty"`
+}
+
+// Database is the part of database/sql that flux uses. Queries are always written with ? placeholders, and are
+// rewritten into whatever placeholder style the driver expects.
+type Database interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+	Begin() (Tx, error)
+	Close() error
+	// either DriverSQLite or DriverPostgres
+	Driver() string
+}
+
+type Tx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	// Stmt returns a transaction specific version of a statement prepared on the database
+	Stmt(stmt *sql.Stmt) *sql.Stmt
+	Commit() error
+	Rollback() error
+}
+
+type sqlDatabase struct {
+	db     *sql.DB
+	driver string
+}
+
+type sqlTx struct {
+	tx     *sql.Tx
+	driver string
+}
+
+// OpenDatabase connects to the configured database and makes sure the schema is up to date
+func OpenDatabase(config DatabaseConfig, rootDir string) (Database, error) {
+	driver := config.Driver
+	if driver == "" {
+		driver = DriverSQLite
+	}
+
+	var schema string
+	dsn := config.DSN
+	maxOpenConns := config.MaxOpenConns
+	switch driver {
+	case DriverSQLite:
+		schema = sqliteSchema
+		if dsn == "" {
+			dsn = filepath.Join(rootDir, "fluxd.db")
+		}
+
+		var err error
+		dsn, err = sqliteDSN(dsn, config)
+		if err != nil {
+			return nil, err
+		}
+
+		if maxOpenConns == 0 {
+			maxOpenConns = 1
+		}
+	case DriverPostgres:
+		schema = postgresSchema
+		if dsn == "" {
+			return nil, fmt.Errorf("a dsn is required for the postgres driver")
+		}
+	default:
+		return nil, fmt.Errorf("unknown database driver %q, expected %q or %q", driver, DriverSQLite, DriverPostgres)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	if maxOpenConns < 0 {
+		return nil, fmt.Errorf("max_open_conns can't be negative")
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("failed to create database schema: %v", err)
+	}
+
+	database := &sqlDatabase{
+		db:     db,
+		driver: driver,
+	}
+
+	if err := migrateDatabase(database); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+
+	if err := prepareStatements(database); err != nil {
+		return nil, err
+	}
+
+	logger.Debugw("Opened database", zap.String("driver", driver), zap.Int("max_open_conns", maxOpenConns))
+
+	return database, nil
+}
+
+// sqliteDSN sets the busy timeout and journal mode of every connection through the parameters of the dsn, so that a
+// connection that the pool opens later gets them as well. Parameters that the dsn already has are left alone
+func sqliteDSN(dsn string, config DatabaseConfig) (string, error) {
+	busyTimeout := config.BusyTimeout
+	if busyTimeout == 0 {
+		busyTimeout = defaultBusyTimeout
+	}
+	if busyTimeout < 0 {
+		return "", fmt.Errorf("busy_timeout can't be negative")
+	}
+
+	journalMode := strings.ToUpper(config.JournalMode)
+	switch journalMode {
+	case "":
+		journalMode = defaultJournalMode
+	case "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+	default:
+		return "", fmt.Errorf("unknown journal_mode %q, expected DELETE, TRUNCATE, PERSIST, MEMORY, WAL, or OFF", config.JournalMode)
+	}
+
+	_, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid dsn: %v", err)
+	}
+
+	var extra []string
+	if !params.Has("_busy_timeout") && !params.Has("_timeout") {
+		extra = append(extra, "_busy_timeout="+strconv.Itoa(busyTimeout))
+	}
+	if !params.Has("_journal_mode") && !params.Has("_journal") {
+		extra = append(extra, "_journal_mode="+journalMode)
+	}
+
+	if len(extra) == 0 {
+		return dsn, nil
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	return dsn + separator + strings.Join(extra, "&"), nil
+}
+
+func migrateDatabase(db Database) error {
+	for _, migration := range migrations {
+		// selecting the column fails if it does not exist yet
+		if _, err := db.Exec(fmt.Sprintf("SELECT %s FROM %s LIMIT 0", migration.column, migration.table)); err == nil {
+			continue
+		}
+
+		logger.Infow("Migrating database", zap.String("table", migration.table), zap.String("column", migration.column))
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", migration.table, migration.column, migration.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %v", migration.column, migration.table, err)
+		}
+	}
+
+	return nil
+}
+
+// prepareStatements prepares every statement that is reused across requests up front, so that a broken query fails
+// when the daemon starts instead of in the middle of a deployment
+func prepareStatements(db Database) error {
+	for _, statement := range statements {
+		stmt, err := db.Prepare(statement.query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement %q: %v", statement.query, err)
+		}
+
+		*statement.stmt = stmt
+	}
+
+	return nil
+}
+
+// vacuumDatabase rebuilds the database so that the space of deleted rows is given back
+func vacuumDatabase(db Database) error {
+	_, err := db.Exec("VACUUM")
+	return err
+}
+
+// backupDatabase writes a consistent copy of a sqlite database to a new file in dir and returns its path, the caller
+// has to remove it
+func backupDatabase(db Database, dir string) (string, error) {
+	if db.Driver() != DriverSQLite {
+		return "", fmt.Errorf("backups are only supported for sqlite, use the tools of your database instead")
+	}
+
+	// VACUUM INTO refuses to write to files that aren't empty
+	file, err := os.CreateTemp(dir, "fluxd-backup-*.db")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	if _, err := db.Exec("VACUUM INTO ?", file.Name()); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// rebind rewrites the ? placeholders in query into the numbered $1 style placeholders that postgres expects
+func rebind(driver string, query string) string {
+	if driver != DriverPostgres {
+		return query
+	}
+
+	var builder strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
+
+func (d *sqlDatabase) Exec(query string, args ...any) (sql.Result, error) {
+	return d.db.Exec(rebind(d.driver, query), args...)
+}
+
+func (d *sqlDatabase) Query(query string, args ...any) (*sql.Rows, error) {
+	return d.db.Query(rebind(d.driver, query), args...)
+}
+
+func (d *sqlDatabase) QueryRow(query string, args ...any) *sql.Row {
+	return d.db.QueryRow(rebind(d.driver, query), args...)
+}
+
+func (d *sqlDatabase) Prepare(query string) (*sql.Stmt, error) {
+	return d.db.Prepare(rebind(d.driver, query))
+}
+
+func (d *sqlDatabase) Begin() (Tx, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlTx{
+		tx:     tx,
+		driver: d.driver,
+	}, nil
+}
+
+func (d *sqlDatabase) Close() error {
+	return d.db.Close()
+}
+
+func (d *sqlDatabase) Driver() string {
+	return d.driver
+}
+
+func (t *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.tx.Exec(rebind(t.driver, query), args...)
+}
+
+func (t *sqlTx) Stmt(stmt *sql.Stmt) *sql.Stmt {
+	return t.tx.Stmt(stmt)
+}
+
+func (t *sqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentWrites writes to the same sqlite file from many goroutines through two pools while another transaction
+// holds the write lock, every write should wait for the lock instead of failing with "database is locked"
+func TestConcurrentWrites(t *testing.T) {
+	rootDir := t.TempDir()
+	config := DatabaseConfig{
+		DSN:          filepath.Join(rootDir, "fluxd.db"),
+		MaxOpenConns: 4,
+	}
+
+	var databases []Database
+	for range 2 {
+		db, err := OpenDatabase(config, rootDir)
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		databases = append(databases, db)
+	}
+
+	// holds the write lock of the file for a while, so that the writers below have to wait for it
+	holder, err := databases[1].Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := holder.Exec("INSERT INTO deployments (url, port) VALUES (?, ?)", "holder.example.com", 8080); err != nil {
+		holder.Rollback()
+		t.Fatalf("failed to take the write lock: %v", err)
+	}
+
+	const writers = 16
+	const writes = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*writes)
+	for writer := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			db := databases[writer%len(databases)]
+			for write := range writes {
+				name := fmt.Sprintf("app-%d-%d", writer, write)
+				if err := insertTestApp(db, name); err != nil {
+					errs <- fmt.Errorf("failed to insert %s: %v", name, err)
+				}
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := holder.Commit(); err != nil {
+		t.Fatalf("failed to release the write lock: %v", err)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var count int
+	if err := databases[0].QueryRow("SELECT COUNT(*) FROM apps").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != writers*writes {
+		t.Errorf("expected %d apps, got %d", writers*writes, count)
+	}
+}
+
+// insertTestApp inserts an app and its deployment in one transaction, like creating an app does
+func insertTestApp(db Database, name string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("INSERT INTO deployments (url, port) VALUES (?, ?)", name+".example.com", 8080)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	deploymentID, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT INTO apps (name, deployment_id) VALUES (?, ?)", name, deploymentID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TestPrepareStatements prepares every statement against the schema of each driver, postgres is only tested when
+// FLUXD_TEST_POSTGRES_DSN points at a database that the test may create the schema in
+func TestPrepareStatements(t *testing.T) {
+	tests := []struct {
+		driver string
+		dsn    string
+	}{
+		{DriverSQLite, ""},
+		{DriverPostgres, os.Getenv("FLUXD_TEST_POSTGRES_DSN")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.driver, func(t *testing.T) {
+			if test.driver == DriverPostgres && test.dsn == "" {
+				t.Skip("FLUXD_TEST_POSTGRES_DSN is not set")
+			}
+
+			db, err := OpenDatabase(DatabaseConfig{Driver: test.driver, DSN: test.dsn}, t.TempDir())
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			defer db.Close()
+
+			for _, statement := range statements {
+				stmt, err := db.Prepare(statement.query)
+				if err != nil {
+					t.Errorf("failed to prepare %q: %v", statement.query, err)
+					continue
+				}
+				stmt.Close()
+			}
+		})
+	}
+}
+
+// every statement has to be rebound into numbered placeholders for postgres, even when postgres isn't around to
+// prepare them
+func TestRebindStatements(t *testing.T) {
+	for _, statement := range statements {
+		rebound := rebind(DriverPostgres, statement.query)
+		if strings.Contains(rebound, "?") {
+			t.Errorf("expected every placeholder of %q to be rebound, got %q", statement.query, rebound)
+		}
+
+		placeholders := strings.Count(statement.query, "?")
+		for n := 1; n <= placeholders; n++ {
+			if !strings.Contains(rebound, "$"+strconv.Itoa(n)) {
+				t.Errorf("expected %q to have placeholder $%d, got %q", statement.query, n, rebound)
+			}
+		}
+		if strings.Contains(rebound, "$"+strconv.Itoa(placeholders+1)) {
+			t.Errorf("expected %q to have %d placeholders, got %q", statement.query, placeholders, rebound)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver string
+		query  string
+		want   string
+	}{
+		{"sqlite is left alone", DriverSQLite, "SELECT * FROM apps WHERE name = ?", "SELECT * FROM apps WHERE name = ?"},
+		{"placeholders are numbered", DriverPostgres, "UPDATE apps SET name = ? WHERE id = ?", "UPDATE apps SET name = $1 WHERE id = $2"},
+		{"strings are left alone", DriverPostgres, "SELECT '?' FROM apps WHERE name = ?", "SELECT '?' FROM apps WHERE name = $1"},
+		{"escaped quotes", DriverPostgres, "SELECT 'it''s?' FROM apps WHERE name = ?", "SELECT 'it''s?' FROM apps WHERE name = $1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := rebind(test.driver, test.query); got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
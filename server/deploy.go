@@ -2,44 +2,99 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"database/sql"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
 	"github.com/juls0730/flux/pkg"
 	"go.uber.org/zap"
 )
 
-var (
-	appInsertStmt *sql.Stmt
-)
-
 type DeployRequest struct {
-	Config multipart.File `form:"config"`
-	Code   multipart.File `form:"code"`
+	Config     multipart.File `form:"config"`
+	Code       multipart.File `form:"code"`
+	Notify     string         `form:"notify"`
+	NoWait     bool           `form:"no_wait"`
+	ForceBuild bool           `form:"force_build"`
+	NoCache    bool           `form:"no_cache"`
 }
 
 type DeployResponse struct {
 	App App `json:"app"`
 }
 
+var ErrDeploymentSuperseded = errors.New("deploy was superseded by a newer deploy")
+
+type activeDeployment struct {
+	cancel context.CancelFunc
+	// closed once the deployment has completed
+	done chan struct{}
+	// the ticket of the deploy waiting for this one to finish, only the most recent deploy is kept waiting since
+	// any older deploy would just be replaced by it anyways
+	queued chan struct{}
+}
+
 type DeploymentLock struct {
 	mu       sync.Mutex
-	deployed map[string]context.CancelFunc
+	deployed map[string]*activeDeployment
+	// the event streams of running and recently finished deploys by their id, including queued deploys
+	streams map[string]*deployStream
 }
 
 func NewDeploymentLock() *DeploymentLock {
 	return &DeploymentLock{
-		deployed: make(map[string]context.CancelFunc),
+		deployed: make(map[string]*activeDeployment),
+		streams:  make(map[string]*deployStream),
 	}
 }
 
+// AddStream makes the events of a deploy available to clients that reconnect, until deployResumeWindow after the
+// stream has finished
+func (dt *DeploymentLock) AddStream(stream *deployStream) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	dt.streams[stream.id] = stream
+}
+
+// RemoveStream forgets the stream once deployResumeWindow has passed
+func (dt *DeploymentLock) RemoveStream(stream *deployStream) {
+	time.AfterFunc(deployResumeWindow, func() {
+		dt.mu.Lock()
+		defer dt.mu.Unlock()
+
+		delete(dt.streams, stream.id)
+	})
+}
+
+func (dt *DeploymentLock) Stream(id string) *deployStream {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	return dt.streams[id]
+}
+
 func (dt *DeploymentLock) StartDeployment(appName string, ctx context.Context) (context.Context, error) {
 	dt.mu.Lock()
 	defer dt.mu.Unlock()
@@ -49,13 +104,75 @@ func (dt *DeploymentLock) StartDeployment(appName string, ctx context.Context) (
 		return nil, fmt.Errorf("app %s is already being deployed", appName)
 	}
 
+	return dt.start(appName, ctx), nil
+}
+
+// QueueDeployment works like StartDeployment, but if the app is already being deployed it waits for that deploy to
+// complete instead of failing. onQueued is called every time the deploy has to wait. If a newer deploy is queued
+// while this one is still waiting, ErrDeploymentSuperseded is returned.
+func (dt *DeploymentLock) QueueDeployment(appName string, ctx context.Context, onQueued func()) (context.Context, error) {
+	for {
+		dt.mu.Lock()
+		active, exists := dt.deployed[appName]
+		if !exists {
+			deployCtx := dt.start(appName, ctx)
+			dt.mu.Unlock()
+			return deployCtx, nil
+		}
+
+		if active.queued != nil {
+			close(active.queued)
+		}
+		ticket := make(chan struct{})
+		active.queued = ticket
+		dt.mu.Unlock()
+
+		onQueued()
+
+		select {
+		case <-active.done:
+			dt.mu.Lock()
+			superseded := active.queued != ticket
+			dt.mu.Unlock()
+
+			if superseded {
+				return nil, ErrDeploymentSuperseded
+			}
+		case <-ticket:
+			return nil, ErrDeploymentSuperseded
+		case <-ctx.Done():
+			dt.mu.Lock()
+			if active.queued == ticket {
+				active.queued = nil
+			}
+			dt.mu.Unlock()
+
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// start marks the app as being deployed, dt.mu must be held
+func (dt *DeploymentLock) start(appName string, ctx context.Context) context.Context {
 	// Create a context that can be cancelled
 	ctx, cancel := context.WithCancel(ctx)
 
 	// Store the cancel function
-	dt.deployed[appName] = cancel
+	dt.deployed[appName] = &activeDeployment{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	return ctx
+}
+
+// Deploying reports whether the app is being deployed right now
+func (dt *DeploymentLock) Deploying(appName string) bool {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
 
-	return ctx, nil
+	_, exists := dt.deployed[appName]
+	return exists
 }
 
 func (dt *DeploymentLock) CompleteDeployment(appName string) {
@@ -63,9 +180,11 @@ func (dt *DeploymentLock) CompleteDeployment(appName string) {
 	defer dt.mu.Unlock()
 
 	// Remove the app from deployed tracking
-	if cancel, exists := dt.deployed[appName]; exists {
+	if active, exists := dt.deployed[appName]; exists {
 		// Cancel the context
-		cancel()
+		active.cancel()
+		// Wake up the queued deploy, if any
+		close(active.done)
 		// Remove from map
 		delete(dt.deployed, appName)
 	}
@@ -74,9 +193,14 @@ func (dt *DeploymentLock) CompleteDeployment(appName string) {
 var deploymentLock = NewDeploymentLock()
 
 type DeploymentEvent struct {
-	Stage      string      `json:"stage"`
-	Message    interface{} `json:"message"`
-	StatusCode int         `json:"status,omitempty"`
+	Stage string `json:"stage"`
+	// the stage that a cmd_output line was printed in
+	OutputStage string      `json:"-"`
+	Message     interface{} `json:"message"`
+	StatusCode  int         `json:"status,omitempty"`
+	// only set on the complete event
+	Duration  time.Duration `json:"-"`
+	ImageSize int64         `json:"-"`
 }
 
 func (s *FluxServer) DeployHandler(w http.ResponseWriter, r *http.Request) {
@@ -88,107 +212,117 @@ func (s *FluxServer) DeployHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	err := r.ParseMultipartForm(10 << 30) // 10 GiB
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUploadSize)
+	// anything past the first 32 MiB of the upload is buffered on disk instead of in memory
+	err := r.ParseMultipartForm(32 << 20)
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			logger.Warnw("Rejected deploy that is too large", zap.Int64("max_upload_size", maxBytesErr.Limit))
+			writeError(w, pkg.ErrorCodeUploadTooLarge, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload is larger than the maximum upload size of %d bytes", maxBytesErr.Limit))
+			return
+		}
+
 		logger.Errorw("Failed to parse multipart form", zap.Error(err))
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	var deployRequest DeployRequest
-	deployRequest.Config, _, err = r.FormFile("config")
+	var configHeader *multipart.FileHeader
+	deployRequest.Config, configHeader, err = r.FormFile("config")
 	if err != nil {
-		http.Error(w, "No flux.json found", http.StatusBadRequest)
+		writeError(w, pkg.ErrorCodeInvalidConfig, http.StatusBadRequest, "No flux.json or flux.yaml found")
 		return
 	}
 	defer deployRequest.Config.Close()
 
-	var projectConfig pkg.ProjectConfig
-	if err := json.NewDecoder(deployRequest.Config).Decode(&projectConfig); err != nil {
+	configBytes, err := io.ReadAll(deployRequest.Config)
+	if err != nil {
+		internalError(w, fmt.Errorf("failed to read config: %v", err))
+		return
+	}
+
+	// older clients only ever send flux.json, without a content type
+	isYAML := strings.Contains(configHeader.Header.Get("Content-Type"), "yaml") || pkg.IsYAMLConfig(configHeader.Filename)
+	projectConfig, err := pkg.DecodeProjectConfig(configBytes, isYAML)
+	if err != nil {
 		logger.Errorw("Failed to decode config", zap.Error(err))
 
-		http.Error(w, "Invalid flux.json", http.StatusBadRequest)
+		writeError(w, pkg.ErrorCodeInvalidConfig, http.StatusBadRequest, fmt.Sprintf("Invalid %s: %v", configHeader.Filename, err))
 		return
 	}
 
-	ctx, err := deploymentLock.StartDeployment(projectConfig.Name, r.Context())
-	if err != nil {
-		// This will happen if the app is already being deployed
-		http.Error(w, err.Error(), http.StatusConflict)
+	log := appLogger(projectConfig.Name)
+
+	// the old containers can't be stopped gracefully while they are frozen
+	if app := Flux.appManager.GetApp(projectConfig.Name); app != nil && app.Deployment.paused.Load() {
+		writeError(w, pkg.ErrorCodePaused, http.StatusConflict, "App is paused, unpause it before deploying")
 		return
 	}
 
-	go func() {
-		<-ctx.Done()
-		deploymentLock.CompleteDeployment(projectConfig.Name)
-	}()
+	deployRequest.Notify = r.FormValue("notify")
+	if deployRequest.Notify != "" {
+		if err := validateNotifyURL(deployRequest.Notify); err != nil {
+			writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		writeError(w, pkg.ErrorCodeInternal, http.StatusInternalServerError, "Streaming unsupported!")
 		return
 	}
 
-	w.WriteHeader(http.StatusMultiStatus)
-
-	eventChannel := make(chan DeploymentEvent, 10)
-	defer close(eventChannel)
-
-	var wg sync.WaitGroup
-	defer wg.Wait()
-
-	wg.Add(1)
-	go func(w http.ResponseWriter, flusher http.Flusher) {
-		defer wg.Done()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case event, ok := <-eventChannel:
-				if !ok {
-					return
-				}
-
-				ev := pkg.DeploymentEvent{
-					Message: event.Message,
-				}
-
-				eventJSON, err := json.Marshal(ev)
-				if err != nil {
-					// Write error directly to ResponseWriter
-					jsonErr := json.NewEncoder(w).Encode(err)
-					if jsonErr != nil {
-						fmt.Fprint(w, "data: {\"message\": \"Error encoding error\"}\n\n")
-						return
-					}
-
-					fmt.Fprintf(w, "data: %s\n\n", err.Error())
-					if flusher != nil {
-						flusher.Flush()
-					}
-					return
-				}
+	// the deploy runs to completion even if the client goes away, so that a build is never aborted halfway by a
+	// dropped connection, and a client can reconnect to it or not follow it at all
+	deployCtx := context.WithoutCancel(r.Context())
+
+	var ctx context.Context
+	deployRequest.NoWait = r.FormValue("no_wait") == "true"
+	deployRequest.ForceBuild = r.FormValue("force_build") == "true"
+	// a clean build is still a build, even if the source is unchanged
+	deployRequest.NoCache = r.FormValue("no_cache") == "true"
+	if deployRequest.NoWait {
+		ctx, err = deploymentLock.StartDeployment(projectConfig.Name, deployCtx)
+		if err != nil {
+			// This will happen if the app is already being deployed
+			writeError(w, pkg.ErrorCodeDeployInProgress, http.StatusConflict, err.Error())
+			return
+		}
+	}
 
-				fmt.Fprintf(w, "event: %s\n", event.Stage)
-				fmt.Fprintf(w, "data: %s\n\n", eventJSON)
-				if flusher != nil {
-					flusher.Flush()
-				}
+	eventChannel, closeStream := streamDeploy(w, r, flusher, projectConfig.Name, deployRequest.Notify)
+	defer closeStream()
 
-				if event.Stage == "error" || event.Stage == "complete" {
-					return
-				}
+	if !deployRequest.NoWait {
+		ctx, err = deploymentLock.QueueDeployment(projectConfig.Name, deployCtx, func() {
+			eventChannel <- DeploymentEvent{
+				Stage:   "queued",
+				Message: "Waiting for in-progress deploy to finish",
+			}
+		})
+		if err != nil {
+			eventChannel <- DeploymentEvent{
+				Stage:      "error",
+				Message:    err.Error(),
+				StatusCode: http.StatusConflict,
 			}
+			return
 		}
-	}(w, flusher)
+	}
+
+	defer deploymentLock.CompleteDeployment(projectConfig.Name)
 
+	started := time.Now()
 	eventChannel <- DeploymentEvent{
 		Stage:   "start",
 		Message: "Uploading code",
 	}
 
-	deployRequest.Code, _, err = r.FormFile("code")
+	var codeHeader *multipart.FileHeader
+	deployRequest.Code, codeHeader, err = r.FormFile("code")
 	if err != nil {
 		eventChannel <- DeploymentEvent{
 			Stage:      "error",
@@ -199,286 +333,1207 @@ func (s *FluxServer) DeployHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer deployRequest.Code.Close()
 
-	if projectConfig.Name == "" || projectConfig.Url == "" || projectConfig.Port == 0 {
+	// the client says how it encoded the archive, clients that don't were built against this daemon's compression
+	// settings
+	compressed := s.config.Compression.Enabled
+	switch encoding := codeHeader.Header.Get("Content-Encoding"); encoding {
+	case "":
+	case "gzip":
+		compressed = true
+	case "identity":
+		compressed = false
+	default:
 		eventChannel <- DeploymentEvent{
 			Stage:      "error",
-			Message:    "Invalid flux.json, a name, url, and port must be specified",
+			Message:    fmt.Sprintf("Unsupported code archive encoding %q", encoding),
 			StatusCode: http.StatusBadRequest,
 		}
 		return
 	}
 
-	logger.Infow("Deploying project", zap.String("name", projectConfig.Name), zap.String("url", projectConfig.Url))
-
-	projectPath, err := s.UploadAppCode(deployRequest.Code, projectConfig)
-	if err != nil {
-		logger.Infow("Failed to upload code", zap.Error(err))
-		eventChannel <- DeploymentEvent{
-			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to upload code: %s", err),
-			StatusCode: http.StatusInternalServerError,
-		}
-		return
-	}
-
-	// Streams the each line of the pipe into the eventChannel, this closes the pipe when the function exits
-	var pipeGroup sync.WaitGroup
-
-	streamPipe := func(pipe io.ReadCloser) {
-		pipeGroup.Add(1)
-		defer pipeGroup.Done()
-
-		scanner := bufio.NewScanner(pipe)
-		for scanner.Scan() {
-			line := scanner.Text()
+	// clients that predate checksums don't send one
+	if checksum := codeHeader.Header.Get(pkg.CodeChecksumHeader); checksum != "" {
+		if err := verifyChecksum(deployRequest.Code, checksum); err != nil {
+			log.Warnw("Rejected corrupted code archive", zap.Error(err))
 			eventChannel <- DeploymentEvent{
-				Stage:   "cmd_output",
-				Message: line,
+				Stage:      "error",
+				Message:    fmt.Sprintf("The code archive was corrupted during the upload, deploy again: %s", err),
+				StatusCode: http.StatusBadRequest,
 			}
+			return
 		}
+	}
 
-		if err := scanner.Err(); err != nil {
-			eventChannel <- DeploymentEvent{
-				Stage:   "error",
-				Message: fmt.Sprintf("Failed to read pipe: %s", err),
-			}
-			logger.Errorw("Error reading pipe", zap.Error(err))
+	if err := validateProjectConfig(projectConfig); err != nil {
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    fmt.Sprintf("Invalid flux.json, %s", err),
+			StatusCode: http.StatusBadRequest,
 		}
+		return
 	}
 
-	logger.Debugw("Preparing project", zap.String("name", projectConfig.Name))
-	eventChannel <- DeploymentEvent{
-		Stage:   "preparing",
-		Message: "Preparing project",
+	// the ports were validated above
+	projectConfig.Port, _ = proxyPort(projectConfig)
+	if projectConfig.Replicas == 0 {
+		projectConfig.Replicas = 1
 	}
 
-	prepareCmd := exec.Command("go", "generate")
-	prepareCmd.Dir = projectPath
-	cmdOut, err := prepareCmd.StdoutPipe()
-	if err != nil {
-		logger.Errorw("Failed to get stdout pipe", zap.Error(err))
+	// resolve the secrets once up front so that a bad reference fails the deploy before we spend time building
+	if _, err := resolveSecrets(projectConfig.Secrets); err != nil {
 		eventChannel <- DeploymentEvent{
 			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to get stdout pipe: %s", err),
-			StatusCode: http.StatusInternalServerError,
+			Message:    err.Error(),
+			StatusCode: http.StatusBadRequest,
 		}
-
 		return
 	}
-	cmdErr, err := prepareCmd.StderrPipe()
+
+	log.Infow("Deploying project", zap.String("url", projectConfig.Url))
+
+	sourceHash := sha256.New()
+	projectPath, err := s.UploadAppCode(io.TeeReader(deployRequest.Code, sourceHash), compressed, projectConfig)
+	if err == nil {
+		// the tar reader can stop before the end of the archive, the rest still has to end up in the hash
+		_, err = io.Copy(sourceHash, deployRequest.Code)
+	}
 	if err != nil {
-		logger.Errorw("Failed to get stderr pipe", zap.Error(err))
+		log.Infow("Failed to upload code", zap.Error(err))
 		eventChannel <- DeploymentEvent{
 			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to get stderr pipe: %s", err),
+			Message:    fmt.Sprintf("Failed to upload code: %s", err),
 			StatusCode: http.StatusInternalServerError,
 		}
 		return
 	}
-
-	err = prepareCmd.Start()
-	if err != nil {
-		logger.Errorw("Failed to prepare project", zap.Error(err))
+	if err := s.runBuildHooks(ctx, buildHookPostUpload, s.config.BuildHooks.PostUpload, projectPath, projectConfig, "", eventChannel, log); err != nil {
+		log.Errorw("Build hook failed", zap.Error(err))
 		eventChannel <- DeploymentEvent{
 			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to prepare project: %s", err),
+			Message:    err.Error(),
 			StatusCode: http.StatusInternalServerError,
 		}
-
 		return
 	}
 
-	go streamPipe(cmdOut)
-	go streamPipe(cmdErr)
+	// the image depends on the build args just as much as on the source
+	for _, arg := range buildArgs(projectConfig.BuildArgs) {
+		sourceHash.Write([]byte(arg + "\x00"))
+	}
+	if projectConfig.Builder != "" {
+		sourceHash.Write([]byte("builder=" + projectConfig.Builder + "\x00"))
+	}
+	// so does what the prepare commands generate
+	for _, command := range projectConfig.Prepare {
+		sourceHash.Write([]byte("prepare=" + command + "\x00"))
+	}
+	if projectConfig.PrepareImage != "" {
+		sourceHash.Write([]byte("prepare_image=" + projectConfig.PrepareImage + "\x00"))
+	}
+	// and an app that runs a prebuilt image depends on nothing else
+	if projectConfig.Image != "" {
+		sourceHash.Write([]byte("image=" + projectConfig.Image + "\x00"))
+	}
+	sourceHashString := hex.EncodeToString(sourceHash.Sum(nil))
 
-	pipeGroup.Wait()
+	s.deployProject(ctx, projectPath, projectConfig, sourceHashString, deployRequest.ForceBuild || deployRequest.NoCache, deployRequest.NoCache, started, eventChannel, log)
+}
 
-	err = prepareCmd.Wait()
-	if err != nil {
-		logger.Errorw("Failed to prepare project", zap.Error(err))
-		eventChannel <- DeploymentEvent{
-			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to prepare project: %s", err),
-			StatusCode: http.StatusInternalServerError,
-		}
+// RedeployHandler rebuilds an app from the code that was uploaded with its last deploy and upgrades it, so that
+// changes to the daemon, such as a new builder or registries, can be picked up without uploading the code again
+func (s *FluxServer) RedeployHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
 
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
 		return
 	}
 
-	eventChannel <- DeploymentEvent{
-		Stage:   "building",
-		Message: "Building project image",
+	// the old containers can't be stopped gracefully while they are frozen
+	if app.Deployment.paused.Load() {
+		writeError(w, pkg.ErrorCodePaused, http.StatusConflict, "App is paused, unpause it before deploying")
+		return
 	}
 
-	logger.Debugw("Building image for project", zap.String("name", projectConfig.Name))
-	imageName := fmt.Sprintf("flux_%s-image", projectConfig.Name)
-	buildCmd := exec.Command("pack", "build", imageName, "--builder", s.config.Builder)
-	buildCmd.Dir = projectPath
-	cmdOut, err = buildCmd.StdoutPipe()
-	if err != nil {
-		logger.Errorw("Failed to get stdout pipe", zap.Error(err))
-		eventChannel <- DeploymentEvent{
-			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to get stdout pipe: %s", err),
-			StatusCode: http.StatusInternalServerError,
-		}
-
+	projectPath := filepath.Join(s.rootDir, "apps", app.name())
+	if info, err := os.Stat(projectPath); err != nil || !info.IsDir() {
+		writeError(w, pkg.ErrorCodeNoStoredCode, http.StatusNotFound, "No stored code found for the app, deploy it instead")
 		return
 	}
-	cmdErr, err = buildCmd.StderrPipe()
-	if err != nil {
-		logger.Errorw("Failed to get stderr pipe", zap.Error(err))
-		eventChannel <- DeploymentEvent{
-			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to get stderr pipe: %s", err),
-			StatusCode: http.StatusInternalServerError,
-		}
 
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, pkg.ErrorCodeInternal, http.StatusInternalServerError, "Streaming unsupported!")
 		return
 	}
 
-	err = buildCmd.Start()
-	if err != nil {
-		logger.Errorw("Failed to build image", zap.Error(err))
-		eventChannel <- DeploymentEvent{
-			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to build image: %s", err),
-			StatusCode: http.StatusInternalServerError,
-		}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
 
-		return
-	}
+	projectConfig := app.Deployment.config()
+	log := appLogger(projectConfig.Name)
 
-	go streamPipe(cmdOut)
-	go streamPipe(cmdErr)
+	deployCtx := context.WithoutCancel(r.Context())
 
-	pipeGroup.Wait()
+	eventChannel, closeStream := streamDeploy(w, r, flusher, projectConfig.Name, "")
+	defer closeStream()
 
-	err = buildCmd.Wait()
+	ctx, err := deploymentLock.QueueDeployment(projectConfig.Name, deployCtx, func() {
+		eventChannel <- DeploymentEvent{
+			Stage:   "queued",
+			Message: "Waiting for in-progress deploy to finish",
+		}
+	})
 	if err != nil {
-		logger.Errorw("Failed to build image", zap.Error(err))
 		eventChannel <- DeploymentEvent{
 			Stage:      "error",
-			Message:    fmt.Sprintf("Failed to build image: %s", err),
-			StatusCode: http.StatusInternalServerError,
+			Message:    err.Error(),
+			StatusCode: http.StatusConflict,
 		}
-
 		return
 	}
 
-	app := Flux.appManager.GetApp(projectConfig.Name)
+	defer deploymentLock.CompleteDeployment(projectConfig.Name)
 
+	started := time.Now()
 	eventChannel <- DeploymentEvent{
-		Stage:   "creating",
-		Message: "Creating deployment",
-	}
-
-	if app == nil {
-		app, err = CreateApp(ctx, imageName, projectPath, projectConfig)
-		if err != nil {
-			logger.Errorw("Failed to create app", zap.Error(err))
-			eventChannel <- DeploymentEvent{
-				Stage:      "error",
-				Message:    fmt.Sprintf("Failed to create app: %s", err),
-				StatusCode: http.StatusInternalServerError,
-			}
-
-			return
-		}
-	} else {
-		err = app.Upgrade(ctx, projectConfig, imageName, projectPath)
-		if err != nil {
-			logger.Errorw("Failed to upgrade app", zap.Error(err))
-			eventChannel <- DeploymentEvent{
-				Stage:      "error",
-				Message:    fmt.Sprintf("Failed to upgrade app: %s", err),
-				StatusCode: http.StatusInternalServerError,
-			}
-
-			return
-		}
+		Stage:   "start",
+		Message: "Rebuilding stored code",
 	}
 
-	eventChannel <- DeploymentEvent{
-		Stage:   "complete",
-		Message: app,
-	}
+	log.Infow("Redeploying project", zap.String("url", projectConfig.Url))
 
-	logger.Infow("App deployed successfully", zap.String("name", app.Name))
+	// the stored code is what the source hash was computed from, so it stays the same, but the build always runs
+	s.deployProject(ctx, projectPath, projectConfig, app.Deployment.SourceHash, true, false, started, eventChannel, log)
 }
 
-func (s *FluxServer) StartDeployHandler(w http.ResponseWriter, r *http.Request) {
+// UpdateConfigHandler applies changes to the environment and labels of an app by recreating its containers from the
+// image that is already built, with the same health checks and swap as a deploy
+func (s *FluxServer) UpdateConfigHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
-	app := Flux.appManager.GetApp(name)
-	if app == nil {
-		http.Error(w, "App not found", http.StatusNotFound)
+	var update pkg.ConfigUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "Invalid config update")
 		return
 	}
 
-	status, err := app.Deployment.Status(r.Context())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := validateConfigUpdate(update); err != nil {
+		writeError(w, pkg.ErrorCodeInvalidConfig, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if status == "running" {
-		http.Error(w, "App is already running", http.StatusBadRequest)
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
 		return
 	}
 
-	err = app.Deployment.Start(r.Context())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// the old containers can't be stopped gracefully while they are frozen
+	if app.Deployment.paused.Load() {
+		writeError(w, pkg.ErrorCodePaused, http.StatusConflict, "App is paused, unpause it before changing its config")
 		return
 	}
 
-	if app.Deployment.Proxy == nil {
-		app.Deployment.Proxy, _ = app.Deployment.NewDeploymentProxy()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, pkg.ErrorCodeInternal, http.StatusInternalServerError, "Streaming unsupported!")
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
 
-func (s *FluxServer) StopDeployHandler(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
+	log := appLogger(name)
 
-	app := Flux.appManager.GetApp(name)
-	if app == nil {
-		http.Error(w, "App not found", http.StatusNotFound)
-		return
-	}
+	deployCtx := context.WithoutCancel(r.Context())
 
-	status, err := app.Deployment.Status(r.Context())
+	eventChannel, closeStream := streamDeploy(w, r, flusher, name, "")
+	defer closeStream()
+
+	ctx, err := deploymentLock.QueueDeployment(name, deployCtx, func() {
+		eventChannel <- DeploymentEvent{
+			Stage:   "queued",
+			Message: "Waiting for in-progress deploy to finish",
+		}
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    err.Error(),
+			StatusCode: http.StatusConflict,
+		}
 		return
 	}
 
-	if status == "stopped" {
-		http.Error(w, "App is already stopped", http.StatusBadRequest)
+	defer deploymentLock.CompleteDeployment(name)
+
+	// the deploy that held the lock may have changed or removed the app
+	app = Flux.appManager.GetApp(name)
+	if app == nil {
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    "App not found",
+			StatusCode: http.StatusNotFound,
+		}
 		return
 	}
 
-	err = app.Deployment.Stop(r.Context())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	projectConfig := applyConfigUpdate(app.Deployment.config(), update)
+	if err := validateLabels(projectConfig.Labels); err != nil {
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    fmt.Sprintf("Invalid config update, %s", err),
+			StatusCode: http.StatusBadRequest,
+		}
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	started := time.Now()
+	eventChannel <- DeploymentEvent{
+		Stage:   "start",
+		Message: "Applying config changes",
+	}
+
+	log.Infow("Updating config", zap.Strings("set_env", envNames(update.SetEnv)), zap.Strings("unset_env", update.UnsetEnv))
+
+	// with the source hash unchanged the current image is reused, it is only rebuilt from the stored code if it was
+	// removed
+	projectPath := filepath.Join(s.rootDir, "apps", name)
+	s.deployProject(ctx, projectPath, projectConfig, app.Deployment.SourceHash, false, false, started, eventChannel, log)
+}
+
+func validateConfigUpdate(update pkg.ConfigUpdate) error {
+	if err := validateEnvironment(update.SetEnv); err != nil {
+		return err
+	}
+
+	for _, key := range update.UnsetEnv {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+
+	return validateLabels(update.SetLabels)
+}
+
+// applyConfigUpdate returns a copy of the config with the update applied, the config itself is left untouched since
+// it is shared with the running deployment
+func applyConfigUpdate(projectConfig pkg.ProjectConfig, update pkg.ConfigUpdate) pkg.ProjectConfig {
+	removed := make(map[string]bool)
+	for _, key := range update.UnsetEnv {
+		removed[key] = true
+	}
+	for _, variable := range update.SetEnv {
+		key, _, _ := strings.Cut(variable, "=")
+		removed[key] = true
+	}
+
+	var environment []string
+	for _, variable := range projectConfig.Environment {
+		key, _, _ := strings.Cut(variable, "=")
+		if !removed[key] {
+			environment = append(environment, variable)
+		}
+	}
+	projectConfig.Environment = append(environment, update.SetEnv...)
+
+	labels := make(map[string]string)
+	for key, value := range projectConfig.Labels {
+		labels[key] = value
+	}
+	for key, value := range update.SetLabels {
+		labels[key] = value
+	}
+	for _, key := range update.UnsetLabels {
+		delete(labels, key)
+	}
+
+	projectConfig.Labels = nil
+	if len(labels) > 0 {
+		projectConfig.Labels = labels
+	}
+
+	return projectConfig
+}
+
+// envNames returns the names of KEY=value pairs, so that the values, which may be secret, aren't logged
+func envNames(variables []string) []string {
+	names := make([]string, 0, len(variables))
+	for _, variable := range variables {
+		name, _, _ := strings.Cut(variable, "=")
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// deployProject builds the project at projectPath, unless the source and image are unchanged since the last build, and
+// creates or upgrades the app with it. noCache builds without the cache of earlier builds
+func (s *FluxServer) deployProject(ctx context.Context, projectPath string, projectConfig pkg.ProjectConfig, sourceHash string, forceBuild, noCache bool, started time.Time, eventChannel chan<- DeploymentEvent, log *zap.SugaredLogger) {
+	imageName := fmt.Sprintf("flux_%s-image", projectConfig.Name)
+	app := Flux.appManager.GetApp(projectConfig.Name)
+
+	// the tag of a prebuilt image may have moved since it was last pulled
+	repull := projectConfig.Image != "" && s.pullPolicy(projectConfig) == pkg.PullPolicyAlways
+
+	if app != nil && !forceBuild && !repull && app.Deployment.SourceHash == sourceHash && imageExists(ctx, imageName) {
+		// the stored config has the port that was detected from the image filled in
+		compareConfig := projectConfig
+		if compareConfig.Port == 0 {
+			compareConfig.Port = app.Deployment.config().Port
+		}
+
+		message := "Source unchanged, recreating containers"
+		if !reflect.DeepEqual(app.Deployment.config(), compareConfig) {
+			message = "Source unchanged, config changed, recreating containers"
+		}
+
+		log.Debugw("Skipping build", zap.String("source_hash", sourceHash))
+		eventChannel <- DeploymentEvent{
+			Stage:   "build_skipped",
+			Message: message,
+		}
+	} else if projectConfig.Image != "" {
+		if err := s.usePrebuiltImage(ctx, imageName, projectConfig, eventChannel, log); err != nil {
+			return
+		}
+	} else {
+		releaseBuildSlot, err := s.acquireBuildSlot(ctx, func() {
+			eventChannel <- DeploymentEvent{
+				Stage:   "queued",
+				Message: "Queued, waiting for build slot",
+			}
+		})
+		if err != nil {
+			eventChannel <- DeploymentEvent{
+				Stage:      "error",
+				Message:    fmt.Sprintf("Stopped waiting for a build slot: %s", err),
+				StatusCode: http.StatusServiceUnavailable,
+			}
+			return
+		}
+
+		err = s.buildProject(ctx, projectPath, imageName, projectConfig, noCache, eventChannel, log)
+		releaseBuildSlot()
+		if err != nil {
+			return
+		}
+
+		// the image now matches this source, even if creating the containers fails below
+		if app != nil {
+			if err := app.Deployment.SetSourceHash(sourceHash); err != nil {
+				log.Warnw("Failed to save source hash", zap.Error(err))
+			}
+		}
+	}
+
+	if projectConfig.Port == 0 {
+		port, err := exposedPort(ctx, imageName)
+		if err != nil {
+			eventChannel <- DeploymentEvent{
+				Stage:      "error",
+				Message:    fmt.Sprintf("Invalid flux.json, %s", err),
+				StatusCode: http.StatusBadRequest,
+			}
+			return
+		}
+
+		log.Debugw("Detected port from image", zap.Uint16("port", port))
+		projectConfig.Port = port
+	}
+
+	eventChannel <- DeploymentEvent{
+		Stage:   "creating",
+		Message: "Creating deployment",
+	}
+
+	var err error
+	if app == nil {
+		app, err = CreateApp(ctx, imageName, projectPath, projectConfig)
+		if err != nil {
+			log.Errorw("Failed to create app", zap.Error(err))
+			eventChannel <- DeploymentEvent{
+				Stage:      "error",
+				Message:    fmt.Sprintf("Failed to create app: %s", err),
+				StatusCode: errorStatus(err),
+			}
+
+			return
+		}
+	} else {
+		err = app.Upgrade(ctx, projectConfig, imageName, projectPath, eventChannel)
+		if err != nil {
+			log.Errorw("Failed to upgrade app", zap.Error(err))
+			eventChannel <- DeploymentEvent{
+				Stage:      "error",
+				Message:    fmt.Sprintf("Failed to upgrade app: %s", err),
+				StatusCode: errorStatus(err),
+			}
+
+			return
+		}
+	}
+
+	if app.Deployment.SourceHash != sourceHash {
+		if err := app.Deployment.SetSourceHash(sourceHash); err != nil {
+			log.Warnw("Failed to save source hash", zap.Error(err))
+		}
+	}
+
+	// the size is only for the user to notice a bloated image, so the deploy still succeeds without it
+	var imageSize int64
+	if imageInspect, _, err := Flux.dockerClient.ImageInspectWithRaw(ctx, imageName); err == nil {
+		imageSize = imageInspect.Size
+	} else {
+		log.Warnw("Failed to inspect app image", zap.Error(err))
+	}
+
+	eventChannel <- DeploymentEvent{
+		Stage:     "complete",
+		Message:   app,
+		Duration:  time.Since(started),
+		ImageSize: imageSize,
+	}
+
+	log.Infow("App deployed successfully")
+}
+
+// streamDeploy sends the events of a deploy to the client as they come in, and keeps them around for a client that
+// reconnects. notify is posted the result of the deploy when it is set. The returned function closes the event channel
+// and waits until every event has been sent
+func streamDeploy(w http.ResponseWriter, r *http.Request, flusher http.Flusher, appName, notify string) (chan DeploymentEvent, func()) {
+	stream := newDeployStream()
+	deploymentLock.AddStream(stream)
+
+	w.WriteHeader(http.StatusMultiStatus)
+
+	eventChannel := make(chan DeploymentEvent, 10)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer stream.finish()
+
+		// events that are sent after the deploy has failed or completed are dropped, but the channel is still drained
+		// so that nothing blocks on it
+		finished := false
+		for event := range eventChannel {
+			if finished {
+				continue
+			}
+
+			ev := pkg.DeploymentEvent{
+				Stage:      event.Stage,
+				Message:    event.Message,
+				Time:       time.Now(),
+				DurationMs: event.Duration.Milliseconds(),
+				ImageSize:  event.ImageSize,
+			}
+			if event.OutputStage != "" {
+				ev.Stage = event.OutputStage
+			}
+
+			eventJSON, err := json.Marshal(ev)
+			if err != nil {
+				eventJSON, _ = json.Marshal(pkg.DeploymentEvent{
+					Stage:   "error",
+					Message: fmt.Sprintf("Failed to encode event: %s", err),
+					Time:    time.Now(),
+				})
+				event.Stage = "error"
+			}
+
+			stream.publish(event.Stage, eventJSON)
+
+			if event.Stage == "error" || event.Stage == "complete" {
+				if notify != "" {
+					go sendDeployNotification(notify, pkg.DeployNotification{
+						App:     appName,
+						Success: event.Stage == "complete",
+						Message: event.Message,
+					})
+				}
+
+				finished = true
+				stream.finish()
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		stream.serve(r.Context(), w, flusher, 0)
+	}()
+
+	return eventChannel, func() {
+		close(eventChannel)
+		wg.Wait()
+		deploymentLock.RemoveStream(stream)
+	}
+}
+
+// DeployEventsHandler lets a client whose deploy stream dropped pick it back up, the Last-Event-ID header holds the id
+// of the last event that it received. Every event after it is replayed before the stream continues
+func (s *FluxServer) DeployEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, seq, err := parseEventID(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stream := deploymentLock.Stream(id)
+	if stream == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "Deploy not found, it finished too long ago or the daemon restarted")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, pkg.ErrorCodeInternal, http.StatusInternalServerError, "Streaming unsupported!")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusMultiStatus)
+
+	stream.serve(r.Context(), w, flusher, seq)
+}
+
+// acquireBuildSlot waits until fewer than max_concurrent_builds builds are running, onQueued is called if it has to
+// wait. The returned function frees the slot again
+func (s *FluxServer) acquireBuildSlot(ctx context.Context, onQueued func()) (func(), error) {
+	if s.buildSlots == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-s.buildSlots }
+
+	select {
+	case s.buildSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	onQueued()
+
+	select {
+	case s.buildSlots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// buildProject prepares the project and builds its image with pack, streaming the output of both into eventChannel.
+// Failures are reported on eventChannel before being returned
+func (s *FluxServer) buildProject(ctx context.Context, projectPath, imageName string, projectConfig pkg.ProjectConfig, noCache bool, eventChannel chan<- DeploymentEvent, log *zap.SugaredLogger) error {
+	// Streams the each line of the pipe into the eventChannel, this closes the pipe when the function exits
+	var pipeGroup sync.WaitGroup
+
+	cacheTracker := newBuildCacheTracker()
+	streamPipe := func(pipe io.ReadCloser, stage string) {
+		defer pipeGroup.Done()
+
+		scanner := bufio.NewScanner(pipe)
+		for scanner.Scan() {
+			line := scanner.Text()
+			cacheTracker.observe(line)
+			eventChannel <- DeploymentEvent{
+				Stage:       "cmd_output",
+				OutputStage: stage,
+				Message:     line,
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			eventChannel <- DeploymentEvent{
+				Stage:   "error",
+				Message: fmt.Sprintf("Failed to read pipe: %s", err),
+			}
+			log.Errorw("Error reading pipe", zap.Error(err))
+		}
+	}
+
+	// fail before preparing anything if the image can't be built anyways
+	builder, err := s.preflightBuild(ctx, projectConfig, eventChannel, log)
+	if err != nil {
+		return err
+	}
+
+	if len(projectConfig.Prepare) > 0 {
+		log.Debugw("Preparing project")
+		eventChannel <- DeploymentEvent{
+			Stage:   "preparing",
+			Message: "Preparing project",
+		}
+
+		// prepare commands get the same environment as the build hooks rather than the daemon's, along with the build
+		// args since they are part of the build
+		sb := sandbox{
+			image:       prepareImage(projectConfig),
+			projectPath: projectPath,
+			env:         append(buildHookEnv(projectConfig, ""), buildArgs(projectConfig.BuildArgs)...),
+		}
+		if err := s.pullSandboxImage(ctx, sb.image, projectConfig, eventChannel, log); err != nil {
+			log.Errorw("Prepare image is unavailable", zap.Error(err))
+			eventChannel <- DeploymentEvent{
+				Stage:      "error",
+				Message:    fmt.Sprintf("Failed to prepare project: %s", err),
+				StatusCode: errorStatus(err),
+			}
+
+			return err
+		}
+
+		for _, command := range projectConfig.Prepare {
+			if err := sb.run(ctx, "preparing", command, eventChannel); err != nil {
+				log.Errorw("Failed to prepare project", zap.String("command", command), zap.Error(err))
+				eventChannel <- DeploymentEvent{
+					Stage:      "error",
+					Message:    fmt.Sprintf("Failed to prepare project, %q failed: %s", command, err),
+					StatusCode: http.StatusInternalServerError,
+				}
+
+				return err
+			}
+		}
+	}
+
+	if err := s.runBuildHooks(ctx, buildHookPreBuild, s.config.BuildHooks.PreBuild, projectPath, projectConfig, "", eventChannel, log); err != nil {
+		log.Errorw("Build hook failed", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		}
+
+		return err
+	}
+
+	eventChannel <- DeploymentEvent{
+		Stage:   "building",
+		Message: "Building project image",
+	}
+
+	log.Debugw("Building image for project")
+	packArgs := []string{"build", imageName, "--builder", builder}
+	if pullPolicy := s.pullPolicy(projectConfig); pullPolicy != "" {
+		// pack pulls the run image and buildpacks itself
+		packArgs = append(packArgs, "--pull-policy", pullPolicy)
+	}
+	if noCache {
+		log.Infow("Building without cache")
+		packArgs = append(packArgs, "--clear-cache")
+	}
+	for _, arg := range buildArgs(projectConfig.BuildArgs) {
+		packArgs = append(packArgs, "--env", arg)
+	}
+
+	buildCmd := exec.Command(s.packPath(), packArgs...)
+	buildCmd.Dir = projectPath
+	buildCmd.Env = s.dockerEnv()
+	cmdOut, err := buildCmd.StdoutPipe()
+	if err != nil {
+		log.Errorw("Failed to get stdout pipe", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    fmt.Sprintf("Failed to get stdout pipe: %s", err),
+			StatusCode: http.StatusInternalServerError,
+		}
+
+		return err
+	}
+	cmdErr, err := buildCmd.StderrPipe()
+	if err != nil {
+		log.Errorw("Failed to get stderr pipe", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    fmt.Sprintf("Failed to get stderr pipe: %s", err),
+			StatusCode: http.StatusInternalServerError,
+		}
+
+		return err
+	}
+
+	err = buildCmd.Start()
+	if err != nil {
+		log.Errorw("Failed to build image", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    fmt.Sprintf("Failed to build image: %s", err),
+			StatusCode: errorStatus(err),
+		}
+
+		return err
+	}
+
+	// added before the goroutines start, otherwise Wait can return before they do and their output is sent after the
+	// event channel was closed
+	pipeGroup.Add(2)
+	go streamPipe(cmdOut, "building")
+	go streamPipe(cmdErr, "building")
+
+	pipeGroup.Wait()
+
+	err = buildCmd.Wait()
+	if err != nil {
+		log.Errorw("Failed to build image", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    fmt.Sprintf("Failed to build image: %s", err),
+			StatusCode: errorStatus(err),
+		}
+
+		return err
+	}
+
+	if summary, ok := cacheTracker.summary(); ok {
+		log.Debugw("Build cache usage", zap.Strings("cached", summary.Cached), zap.Strings("rebuilt", summary.Rebuilt))
+		eventChannel <- DeploymentEvent{
+			Stage:   "build_summary",
+			Message: summary,
+		}
+	}
+
+	if err := s.runBuildHooks(ctx, buildHookPostBuild, s.config.BuildHooks.PostBuild, projectPath, projectConfig, imageName, eventChannel, log); err != nil {
+		log.Errorw("Build hook failed", zap.Error(err))
+		// the image was rejected, so the next deploy of the same source has to build it again instead of reusing it
+		if app := Flux.appManager.GetApp(projectConfig.Name); app != nil {
+			if err := app.Deployment.SetSourceHash(""); err != nil {
+				log.Warnw("Failed to reset source hash", zap.Error(err))
+			}
+		}
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// projectConfigValidators check everything about a flux.json that can be checked before anything is built, in the
+// order that their problems are reported in
+var projectConfigValidators = []func(pkg.ProjectConfig) error{
+	func(projectConfig pkg.ProjectConfig) error {
+		if projectConfig.Name == "" {
+			return fmt.Errorf("a name must be specified")
+		}
+
+		return nil
+	},
+	validateProtocol,
+	validateProxyConfig,
+	func(projectConfig pkg.ProjectConfig) error {
+		_, err := proxyPort(projectConfig)
+		return err
+	},
+	func(projectConfig pkg.ProjectConfig) error {
+		if projectConfig.Replicas < 0 {
+			return fmt.Errorf("replicas must be at least 1")
+		}
+
+		return nil
+	},
+	validatePrepare,
+	func(projectConfig pkg.ProjectConfig) error { return validateBuildArgs(projectConfig.BuildArgs) },
+	func(projectConfig pkg.ProjectConfig) error { return validateNetwork(projectConfig.Network) },
+	validateHosts,
+	validateVolumes,
+	validateStopConfig,
+	func(projectConfig pkg.ProjectConfig) error { return validateLogConfig(projectConfig.Logs) },
+	func(projectConfig pkg.ProjectConfig) error { return validatePullPolicy(projectConfig.ImagePullPolicy) },
+	validateContainerSecurity,
+	func(projectConfig pkg.ProjectConfig) error { return validateLabels(projectConfig.Labels) },
+	validateImage,
+}
+
+// validateProjectConfig returns the first problem with a flux.json, a replica count of 0 is valid and means 1
+func validateProjectConfig(projectConfig pkg.ProjectConfig) error {
+	for _, validate := range projectConfigValidators {
+		if err := validate(projectConfig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validatePrepare(projectConfig pkg.ProjectConfig) error {
+	for _, command := range projectConfig.Prepare {
+		if strings.TrimSpace(command) == "" {
+			return fmt.Errorf("prepare has an empty command")
+		}
+	}
+
+	if projectConfig.PrepareImage != "" {
+		if _, err := reference.ParseNormalizedNamed(projectConfig.PrepareImage); err != nil {
+			return fmt.Errorf("invalid prepare_image %q: %v", projectConfig.PrepareImage, err)
+		}
+	}
+
+	return nil
+}
+
+// prepareImage returns the image that the prepare commands of an app run in
+func prepareImage(projectConfig pkg.ProjectConfig) string {
+	if projectConfig.PrepareImage == "" {
+		return defaultSandboxImage
+	}
+
+	return projectConfig.PrepareImage
+}
+
+// preflightBuild makes sure that pack is installed and that the builder of the app is available, pulling the builder
+// when it is missing, e.g. because it was removed or the daemon's builder was changed since it started. It returns the
+// builder
+func (s *FluxServer) preflightBuild(ctx context.Context, projectConfig pkg.ProjectConfig, eventChannel chan<- DeploymentEvent, log *zap.SugaredLogger) (string, error) {
+	if _, err := s.checkPack(); err != nil {
+		log.Errorw("Pack is unavailable", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		}
+
+		return "", err
+	}
+
+	builder := s.config.Builder
+	if projectConfig.Builder != "" {
+		builder = projectConfig.Builder
+	}
+
+	pull, err := needsPull(ctx, builder, s.pullPolicy(projectConfig))
+	if err != nil {
+		log.Errorw("Builder image is unavailable", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		}
+
+		return "", err
+	}
+
+	if pull {
+		log.Infow("Pulling builder image", zap.String("image", builder))
+		eventChannel <- DeploymentEvent{
+			Stage:   "pulling_builder",
+			Message: fmt.Sprintf("Pulling builder image %s, this may take a while", builder),
+		}
+
+		if err := pullImage(ctx, builder); err != nil {
+			log.Errorw("Failed to pull builder image", zap.Error(err))
+			eventChannel <- DeploymentEvent{
+				Stage:      "error",
+				Message:    fmt.Sprintf("Failed to pull builder image %s: %s", builder, err),
+				StatusCode: errorStatus(err),
+			}
+
+			return "", err
+		}
+	}
+
+	return builder, nil
+}
+
+// reservedBuildArgPrefix is used by the buildpack lifecycle for the variables that it sets up itself
+const reservedBuildArgPrefix = "CNB_"
+
+func validateBuildArgs(args map[string]string) error {
+	for key := range args {
+		if key == "" || strings.ContainsAny(key, "= ") {
+			return fmt.Errorf("invalid build arg name %q", key)
+		}
+
+		if strings.HasPrefix(key, reservedBuildArgPrefix) {
+			return fmt.Errorf("build arg %s is reserved, %s* variables are set by the build itself", key, reservedBuildArgPrefix)
+		}
+	}
+
+	return nil
+}
+
+// buildArgs returns the build args as KEY=VALUE pairs, sorted so that they are always in the same order
+func buildArgs(args map[string]string) []string {
+	pairs := make([]string, 0, len(args))
+	for key, value := range args {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return pairs
+}
+
+func pullImage(ctx context.Context, imageName string) error {
+	events, err := Flux.dockerClient.ImagePull(ctx, imageName, image.PullOptions{
+		RegistryAuth: Flux.encodedRegistryAuth(imageName),
+	})
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	// the pull is only done once the progress stream has been read to the end
+	_, err = io.Copy(io.Discard, events)
+	return err
+}
+
+// imageExists reports whether the image is still available locally
+func imageExists(ctx context.Context, imageName string) bool {
+	_, _, err := Flux.dockerClient.ImageInspectWithRaw(ctx, imageName)
+	return err == nil
+}
+
+// exposedTCPPorts returns the tcp ports that the image exposes in ascending order
+func exposedTCPPorts(ctx context.Context, imageName string) ([]int, error) {
+	imageInspect, _, err := Flux.dockerClient.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %v", err)
+	}
+
+	var ports []int
+	if imageInspect.Config != nil {
+		for port := range imageInspect.Config.ExposedPorts {
+			if port.Proto() == "tcp" {
+				ports = append(ports, port.Int())
+			}
+		}
+	}
+	sort.Ints(ports)
+
+	return ports, nil
+}
+
+// exposedPort returns the tcp port that the image exposes, for apps that don't configure a port themselves
+func exposedPort(ctx context.Context, imageName string) (uint16, error) {
+	ports, err := exposedTCPPorts(ctx, imageName)
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(ports) {
+	case 0:
+		return 0, fmt.Errorf("no port is set and the image doesn't expose one, set the port that the app listens on")
+	case 1:
+		return uint16(ports[0]), nil
+	default:
+		return 0, fmt.Errorf("no port is set and the image exposes several ports %v, set the port that the app listens on", ports)
+	}
+}
+
+func (s *FluxServer) StartDeployHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	status, err := app.Deployment.Status(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if status == "running" {
+		writeError(w, pkg.ErrorCodeAlreadyRunning, http.StatusBadRequest, "App is already running")
+		return
+	}
+
+	if status == "paused" {
+		writeError(w, pkg.ErrorCodePaused, http.StatusBadRequest, "App is paused, unpause it instead")
+		return
+	}
+
+	if status == "degraded" {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "Containers of the app were removed outside of flux, redeploy it to recreate them")
+		return
+	}
+
+	err = app.Deployment.Start(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if app.Deployment.proxy() == nil {
+		proxy, _ := app.Deployment.NewDeploymentProxy()
+		app.Deployment.setProxy(proxy)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *FluxServer) StopDeployHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	status, err := app.Deployment.Status(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if status == "stopped" {
+		writeError(w, pkg.ErrorCodeAlreadyStopped, http.StatusBadRequest, "App is already stopped")
+		return
+	}
+
+	err = app.Deployment.Stop(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *FluxServer) PauseDeployHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	status, err := app.Deployment.Status(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if status == "paused" {
+		writeError(w, pkg.ErrorCodeAlreadyPaused, http.StatusBadRequest, "App is already paused")
+		return
+	}
+
+	if status != "running" {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, fmt.Sprintf("Only running apps can be paused, the app is %s", status))
+		return
+	}
+
+	err = app.Deployment.Pause(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *FluxServer) UnpauseDeployHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	status, err := app.Deployment.Status(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if status != "paused" {
+		writeError(w, pkg.ErrorCodeNotPaused, http.StatusBadRequest, "App is not paused")
+		return
+	}
+
+	err = app.Deployment.Unpause(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *FluxServer) MaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var maintenanceRequest pkg.MaintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&maintenanceRequest); err != nil {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "Invalid maintenance request")
+		return
+	}
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	// there is no way to show a page to a raw tcp connection
+	if protocol(app.Deployment.config()) == pkg.ProtocolTCP {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "Maintenance mode is not supported for tcp apps")
+		return
+	}
+
+	if err := app.Deployment.SetMaintenance(maintenanceRequest.Enabled, maintenanceRequest.Page); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	appLogger(name).Infow("Set maintenance mode", zap.Bool("enabled", maintenanceRequest.Enabled))
+
+	w.WriteHeader(http.StatusOK)
+}
 
 func (s *FluxServer) DeleteDeployHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
-	logger.Debugw("Deleting deployment", zap.String("name", name))
+	log := appLogger(name)
+
+	log.Debugw("Deleting deployment")
+
+	// ?force=true removes whatever is left of an app that can't be deleted normally, even if the daemon doesn't know it
+	if r.URL.Query().Get("force") == "true" {
+		if _, err := deploymentLock.StartDeployment(name, context.Background()); err != nil {
+			writeError(w, pkg.ErrorCodeDeployInProgress, http.StatusConflict, err.Error())
+			return
+		}
+		defer deploymentLock.CompleteDeployment(name)
+
+		if err := Flux.appManager.ForceDeleteApp(r.Context(), name); err != nil {
+			log.Errorw("Failed to force delete app", zap.Error(err))
+			internalError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if Flux.appManager.GetApp(name) == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
 
 	err := Flux.appManager.DeleteApp(name)
 
 	if err != nil {
-		logger.Errorw("Failed to delete app", zap.Error(err))
-		http.Error(w, err.Error(), http.StatusNotFound)
+		log.Errorw("Failed to delete app", zap.Error(err))
+		internalError(w, err)
 		return
 	}
 
@@ -487,10 +1542,10 @@ func (s *FluxServer) DeleteDeployHandler(w http.ResponseWriter, r *http.Request)
 
 func (s *FluxServer) DeleteAllDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
 	for _, app := range Flux.appManager.GetAllApps() {
-		err := Flux.appManager.DeleteApp(app.Name)
+		err := Flux.appManager.DeleteApp(app.name())
 		if err != nil {
-			logger.Errorw("Failed to remove app", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			appLogger(app.name()).Errorw("Failed to remove app", zap.Error(err))
+			internalError(w, err)
 			return
 		}
 	}
@@ -499,21 +1554,27 @@ func (s *FluxServer) DeleteAllDeploymentsHandler(w http.ResponseWriter, r *http.
 }
 
 func (s *FluxServer) ListAppsHandler(w http.ResponseWriter, r *http.Request) {
+	// ?label=key or ?label=key=value, every selector has to match
+	selectors, err := parseLabelSelectors(r.URL.Query()["label"])
+	if err != nil {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// for each app, get the deployment status
 	var apps []pkg.App
 	for _, app := range Flux.appManager.GetAllApps() {
-		var extApp pkg.App
-		deploymentStatus, err := app.Deployment.Status(r.Context())
+		if !matchLabels(app.Deployment.config().Labels, selectors) {
+			continue
+		}
+
+		extApp, err := app.Info(r.Context())
 		if err != nil {
-			logger.Errorw("Failed to get deployment status", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			appLogger(app.name()).Errorw("Failed to get deployment status", zap.Error(err))
+			internalError(w, err)
 			return
 		}
 
-		extApp.ID = app.ID
-		extApp.Name = app.Name
-		extApp.DeploymentID = app.DeploymentID
-		extApp.DeploymentStatus = deploymentStatus
 		apps = append(apps, extApp)
 	}
 
@@ -521,9 +1582,423 @@ func (s *FluxServer) ListAppsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(apps)
 }
 
+func (s *FluxServer) GetAppHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	extApp, err := app.Info(r.Context())
+	if err != nil {
+		appLogger(app.name()).Errorw("Failed to get deployment status", zap.Error(err))
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(extApp)
+}
+
+// DescribeAppHandler responds with everything that the daemon knows about an app, for troubleshooting
+func (s *FluxServer) DescribeAppHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	description, err := app.Describe(r.Context())
+	if err != nil {
+		appLogger(app.name()).Errorw("Failed to describe app", zap.Error(err))
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(description)
+}
+
+// RenameAppHandler renames an app in place, so that it keeps its containers and volumes
+func (s *FluxServer) RenameAppHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var renameRequest pkg.RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&renameRequest); err != nil {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "Invalid rename request")
+		return
+	}
+
+	if err := validateAppName(renameRequest.Name); err != nil {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// a deploy of either name would race with the rename
+	if _, err := deploymentLock.StartDeployment(name, context.Background()); err != nil {
+		writeError(w, pkg.ErrorCodeDeployInProgress, http.StatusConflict, err.Error())
+		return
+	}
+	defer deploymentLock.CompleteDeployment(name)
+
+	if renameRequest.Name != name {
+		if _, err := deploymentLock.StartDeployment(renameRequest.Name, context.Background()); err != nil {
+			writeError(w, pkg.ErrorCodeDeployInProgress, http.StatusConflict, err.Error())
+			return
+		}
+		defer deploymentLock.CompleteDeployment(renameRequest.Name)
+	}
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	if Flux.appManager.GetApp(renameRequest.Name) != nil {
+		writeError(w, pkg.ErrorCodeAlreadyExists, http.StatusConflict, fmt.Sprintf("An app named %s already exists", renameRequest.Name))
+		return
+	}
+
+	if err := app.Rename(r.Context(), renameRequest.Name); err != nil {
+		appLogger(name).Errorw("Failed to rename app", zap.Error(err))
+		internalError(w, err)
+		return
+	}
+
+	extApp, err := app.Info(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(extApp)
+}
+
+func (s *FluxServer) AppStatsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	total, containerStats, err := app.Deployment.Stats(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pkg.AppStats{
+		Name:       app.name(),
+		Total:      total,
+		Containers: containerStats,
+	})
+}
+
+// AppLogsHandler returns the logs of an app as plain text. Apps with logs enabled get everything that was stored
+// across deploys, other apps only get what docker still has of their current containers
+func (s *FluxServer) AppLogsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	app := Flux.appManager.GetApp(name)
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return
+	}
+
+	var opts logOptions
+	if tailParam := r.URL.Query().Get("tail"); tailParam != "" {
+		var err error
+		opts.tail, err = strconv.Atoi(tailParam)
+		if err != nil || opts.tail < 0 {
+			writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "tail must be a positive number")
+			return
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		time *time.Time
+	}{{"since", &opts.since}, {"until", &opts.until}} {
+		value := r.URL.Query().Get(param.name)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 timestamp", param.name))
+			return
+		}
+		*param.time = t
+	}
+
+	if !opts.since.IsZero() && !opts.until.IsZero() && opts.until.Before(opts.since) {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "until must not be before since")
+		return
+	}
+
+	// the logs are buffered so that a failure can still be reported with a proper status
+	var logs bytes.Buffer
+	var err error
+	if app.Deployment.config().Logs != nil {
+		err = Flux.logStore.WriteTo(&logs, app.name(), opts)
+	} else {
+		err = containerLogs(r.Context(), &logs, app.Deployment, opts)
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	logs.WriteTo(w)
+}
+
+// CopyFromAppHandler responds with a tar archive of a path in the head container of an app, as docker cp produces it
+func (s *FluxServer) CopyFromAppHandler(w http.ResponseWriter, r *http.Request) {
+	app, containerPath, ok := appFilePath(w, r)
+	if !ok {
+		return
+	}
+
+	reader, _, err := Flux.dockerClient.CopyFromContainer(r.Context(), string(app.Deployment.head().ContainerID[:]), containerPath)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, fmt.Sprintf("%s does not exist in the container", containerPath))
+			return
+		}
+
+		internalError(w, err)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	if _, err := io.Copy(w, reader); err != nil {
+		appLogger(app.name()).Warnw("Failed to send files", zap.String("path", containerPath), zap.Error(err))
+	}
+}
+
+// CopyToAppHandler extracts a tar archive with a single root into the head container of an app. Like docker cp, a path
+// that is an existing directory receives the root as is, any other path is what the root is renamed to
+func (s *FluxServer) CopyToAppHandler(w http.ResponseWriter, r *http.Request) {
+	app, containerPath, ok := appFilePath(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUploadSize)
+	containerID := string(app.Deployment.head().ContainerID[:])
+
+	stat, err := Flux.dockerClient.ContainerStatPath(r.Context(), containerID, containerPath)
+	if err != nil && !errdefs.IsNotFound(err) {
+		internalError(w, err)
+		return
+	}
+
+	destination := containerPath
+	var content io.Reader = r.Body
+	if err != nil || !stat.Mode.IsDir() {
+		destination = path.Dir(containerPath)
+		renamed := renameArchiveRoot(r.Body, path.Base(containerPath))
+		defer renamed.Close()
+		content = renamed
+	}
+
+	if err := Flux.dockerClient.CopyToContainer(r.Context(), containerID, destination, content, container.CopyToContainerOptions{}); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, pkg.ErrorCodeUploadTooLarge, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload too large, the limit is %d bytes", maxBytesErr.Limit))
+			return
+		}
+
+		if errdefs.IsNotFound(err) {
+			writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, fmt.Sprintf("%s does not exist in the container", destination))
+			return
+		}
+
+		internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// appFilePath looks up the app and the container path of a file request, and responds with an error if either is
+// missing
+func appFilePath(w http.ResponseWriter, r *http.Request) (*App, string, bool) {
+	app := Flux.appManager.GetApp(r.PathValue("name"))
+	if app == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App not found")
+		return nil, "", false
+	}
+
+	containerPath := r.URL.Query().Get("path")
+	if !path.IsAbs(containerPath) {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "path must be an absolute path in the container")
+		return nil, "", false
+	}
+
+	if app.Deployment.head() == nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, "App has no containers")
+		return nil, "", false
+	}
+
+	return app, path.Clean(containerPath), true
+}
+
+func (s *FluxServer) ListContainersHandler(w http.ResponseWriter, r *http.Request) {
+	containers := []pkg.ContainerInfo{}
+	for _, app := range Flux.appManager.GetAllApps() {
+		for _, container := range app.Deployment.containers() {
+			info, err := container.Info(r.Context(), app.name())
+			if err != nil {
+				// a container that is missing from docker is still worth showing, so only log the error
+				logger.Warnw("Failed to inspect container", zap.String("app", app.name()), zap.Error(err))
+				info.Status = "unknown"
+			}
+
+			containers = append(containers, info)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(containers)
+}
+
+// ProxyRoutesHandler returns the routing table of the reverse proxy, to find out why a host isn't routed where it
+// should be
+func (s *FluxServer) ProxyRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.proxy.Routes())
+}
+
+// InspectImageHandler reports the ports that an image exposes, the image is pulled first if it isn't available on the
+// daemon yet
+func (s *FluxServer) InspectImageHandler(w http.ResponseWriter, r *http.Request) {
+	imageName := r.URL.Query().Get("image")
+	if imageName == "" {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "image is required")
+		return
+	}
+
+	pull, err := needsPull(r.Context(), imageName, s.config.ImagePullPolicy)
+	if err != nil {
+		writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if pull {
+		logger.Infow("Pulling image to inspect it", zap.String("image", imageName))
+		if err := pullImage(r.Context(), imageName); err != nil {
+			writeError(w, pkg.ErrorCodeNotFound, http.StatusNotFound, fmt.Sprintf("Failed to pull image %s: %s", imageName, err))
+			return
+		}
+	}
+
+	ports, err := exposedTCPPorts(r.Context(), imageName)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	info := pkg.ImageInfo{
+		Image:        imageName,
+		ExposedPorts: make([]uint16, 0, len(ports)),
+	}
+	for _, port := range ports {
+		info.ExposedPorts = append(info.ExposedPorts, uint16(port))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *FluxServer) DoctorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Flux.appManager.Problems(r.Context()))
+}
+
 func (s *FluxServer) DaemonInfoHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(pkg.Info{
-		Compression: s.config.Compression,
+		Compression:   s.config.Compression,
+		MaxUploadSize: s.config.MaxUploadSize,
+		Version:       pkg.CurrentVersion(),
 	})
 }
+
+// DaemonConfigHandler responds with the config that the daemon is running with, after defaults were applied, with
+// secrets redacted
+func (s *FluxServer) DaemonConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.config.redacted())
+}
+
+// VacuumDatabaseHandler compacts the database, which gives back the space that deleted apps and containers took up
+func (s *FluxServer) VacuumDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Infow("Vacuuming database")
+
+	if err := vacuumDatabase(Flux.db); err != nil {
+		internalError(w, fmt.Errorf("failed to vacuum database: %v", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// BackupDatabaseHandler responds with a consistent copy of the sqlite database, taken while the daemon keeps running
+func (s *FluxServer) BackupDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	if Flux.db.Driver() != DriverSQLite {
+		writeError(w, pkg.ErrorCodeInvalidRequest, http.StatusBadRequest, "Backups are only supported for sqlite, use pg_dump to back up a postgres database")
+		return
+	}
+
+	backupPath, err := backupDatabase(Flux.db, Flux.rootDir)
+	if err != nil {
+		internalError(w, fmt.Errorf("failed to back up database: %v", err))
+		return
+	}
+	defer os.Remove(backupPath)
+
+	backup, err := os.Open(backupPath)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer backup.Close()
+
+	stat, err := backup.Stat()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="fluxd-%s.db"`, time.Now().UTC().Format("20060102-150405")))
+	if _, err := io.Copy(w, backup); err != nil {
+		logger.Warnw("Failed to send database backup", zap.Error(err))
+	}
+}
+
+// HealthHandler is the readiness probe, unlike /heartbeat it fails with a 503 when docker, the database, or the proxy
+// is unavailable
+func (s *FluxServer) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	health := s.Health(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
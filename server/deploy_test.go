@@ -0,0 +1,370 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// fakePack writes a pack binary that answers version, and whose builds only finish once release is called
+func fakePack(t *testing.T) (packPath string, started func() bool, release func()) {
+	t.Helper()
+
+	dir := t.TempDir()
+	startedPath := filepath.Join(dir, "started")
+	releasePath := filepath.Join(dir, "release")
+
+	packPath = filepath.Join(dir, "pack")
+	script := fmt.Sprintf(`#!/bin/sh
+case "$1" in
+version)
+	echo 0.0.0-test
+	;;
+build)
+	echo "building $2"
+	touch %q
+	while [ ! -e %q ]; do sleep 0.05; done
+	echo "built $2"
+	;;
+esac
+`, startedPath, releasePath)
+	if err := os.WriteFile(packPath, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	started = func() bool {
+		_, err := os.Stat(startedPath)
+		return err == nil
+	}
+	release = func() {
+		if err := os.WriteFile(releasePath, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return packPath, started, release
+}
+
+// deployRequest builds the multipart body of a deploy the way the cli does, with the code archive encoded as encoding,
+// either gzip or identity
+func deployRequest(t *testing.T, projectConfig pkg.ProjectConfig, encoding string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	config, err := writer.CreateFormFile("config", "flux.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewEncoder(config).Encode(projectConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="code"; filename="code.tar.gz"`)
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Encoding", encoding)
+	code, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := tarArchive(t, "main.go")
+	if encoding == "identity" {
+		archive = plainTarArchive(t, "main.go")
+	}
+	if _, err := io.Copy(code, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &body, writer.FormDataContentType()
+}
+
+// a client that goes away in the middle of a build doesn't take the deploy down with it, the app is still created and
+// the deploy lock is released so that the next deploy isn't stuck behind it
+func TestDeploySurvivesClientDisconnect(t *testing.T) {
+	docker := newTestServer(t)
+
+	packPath, buildStarted, releaseBuild := fakePack(t)
+	Flux.config.PackPath = packPath
+	Flux.config.Builder = "test/builder"
+	Flux.config.MaxUploadSize = 1 << 20
+	docker.addImage(Flux.config.Builder)
+	// what the build would have produced
+	docker.addImage("flux_app-image")
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.Port = newTestUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	projectConfig.HealthCheck = &pkg.HealthCheck{StabilizationWindow: -1}
+
+	requestContexts := make(chan context.Context, 1)
+	handlerDone := make(chan struct{})
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handlerDone)
+
+		requestContexts <- r.Context()
+		Flux.DeployHandler(w, r)
+	}))
+	t.Cleanup(daemon.Close)
+
+	body, contentType := deployRequest(t, projectConfig, "gzip")
+	ctx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, daemon.URL+"/deploy", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := daemon.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to deploy: %v", err)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("expected the deploy to be accepted, got %d", resp.StatusCode)
+	}
+
+	waitFor(t, "the build to start", buildStarted)
+
+	disconnect()
+	resp.Body.Close()
+	// the daemon has to notice that the client is gone before the build finishes
+	<-(<-requestContexts).Done()
+
+	releaseBuild()
+	select {
+	case <-handlerDone:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the deploy to finish")
+	}
+
+	app := Flux.appManager.GetApp(projectConfig.Name)
+	if app == nil {
+		t.Fatal("expected the app to be created after the client disconnected")
+	}
+
+	if containers := app.Deployment.containers(); len(containers) != 1 {
+		t.Errorf("expected the app to have 1 container, got %d", len(containers))
+	}
+
+	if deploymentLock.Deploying(projectConfig.Name) {
+		t.Errorf("expected the deploy lock of %s to be released", projectConfig.Name)
+	}
+}
+
+// the code archive is read the way the client says that it encoded it, not the way the daemon would have
+func TestDeployCodeEncoding(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression bool
+		encoding    string
+	}{
+		{"plain tar to a daemon with compression", true, "identity"},
+		{"gzip tar to a daemon without compression", false, "gzip"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docker := newTestServer(t)
+
+			packPath, _, releaseBuild := fakePack(t)
+			releaseBuild()
+			Flux.config.PackPath = packPath
+			Flux.config.Builder = "test/builder"
+			Flux.config.MaxUploadSize = 1 << 20
+			Flux.config.Compression.Enabled = test.compression
+			docker.addImage(Flux.config.Builder)
+			docker.addImage("flux_app-image")
+
+			projectConfig := testProjectConfig("app")
+			projectConfig.Port = newTestUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "ok")
+			}))
+			projectConfig.HealthCheck = &pkg.HealthCheck{StabilizationWindow: -1}
+
+			body, contentType := deployRequest(t, projectConfig, test.encoding)
+			req := httptest.NewRequest(http.MethodPost, "/deploy", body)
+			req.Header.Set("Content-Type", contentType)
+			recorder := httptest.NewRecorder()
+			Flux.DeployHandler(recorder, req)
+
+			if !strings.Contains(recorder.Body.String(), "event: complete") {
+				t.Fatalf("expected the deploy to complete, got %s", recorder.Body.String())
+			}
+
+			content, err := os.ReadFile(filepath.Join(Flux.rootDir, "apps", projectConfig.Name, "main.go"))
+			if err != nil || string(content) != "main.go" {
+				t.Errorf("expected the code to be extracted, got %q: %v", content, err)
+			}
+		})
+	}
+}
+
+// prepare commands run in a sandbox with the environment of the build hooks and the build args, not with the daemon's
+// environment
+func TestPrepareEnvironment(t *testing.T) {
+	docker := newTestServer(t)
+	t.Setenv("FLUXD_TEST_SECRET", "hunter2")
+
+	packPath, _, releaseBuild := fakePack(t)
+	releaseBuild()
+	Flux.config.PackPath = packPath
+	Flux.config.Builder = "test/builder"
+	docker.addImage(Flux.config.Builder)
+	docker.addImage(defaultSandboxImage)
+
+	projectPath := t.TempDir()
+	projectConfig := testProjectConfig("app")
+	projectConfig.BuildArgs = map[string]string{"GOPRIVATE": "example.com/*"}
+	projectConfig.Prepare = []string{"env > prepare.env"}
+
+	events := make(chan DeploymentEvent)
+	go drainEvents(events)
+	defer close(events)
+
+	if err := Flux.buildProject(context.Background(), projectPath, "flux_app-image", projectConfig, false, events, logger); err != nil {
+		t.Fatalf("failed to build project: %v", err)
+	}
+
+	env, err := os.ReadFile(filepath.Join(projectPath, "prepare.env"))
+	if err != nil {
+		t.Fatalf("expected the prepare command to run: %v", err)
+	}
+
+	vars := make(map[string]string)
+	for _, line := range strings.Split(string(env), "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			vars[key] = value
+		}
+	}
+
+	want := map[string]string{
+		"FLUX_APP":          "app",
+		"FLUX_PROJECT_PATH": sandboxProjectPath,
+		"GOPRIVATE":         "example.com/*",
+	}
+	for key, value := range want {
+		if vars[key] != value {
+			t.Errorf("expected %s to be %q, got %q", key, value, vars[key])
+		}
+	}
+
+	if _, ok := vars["FLUXD_TEST_SECRET"]; ok {
+		t.Errorf("expected the environment of the daemon not to reach prepare commands")
+	}
+}
+
+// an app with a prebuilt image runs that image instead of building its code
+func TestDeployPrebuiltImage(t *testing.T) {
+	docker := newTestServer(t)
+
+	packPath, buildStarted, _ := fakePack(t)
+	Flux.config.PackPath = packPath
+	Flux.config.MaxUploadSize = 1 << 20
+	docker.addRemoteImage("nginx:1.27", "80/tcp")
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.Image = "nginx:1.27"
+	projectConfig.Port = newTestUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	projectConfig.HealthCheck = &pkg.HealthCheck{StabilizationWindow: -1}
+
+	body, contentType := deployRequest(t, projectConfig, "gzip")
+	req := httptest.NewRequest(http.MethodPost, "/deploy", body)
+	req.Header.Set("Content-Type", contentType)
+	recorder := httptest.NewRecorder()
+	Flux.DeployHandler(recorder, req)
+
+	if !strings.Contains(recorder.Body.String(), "event: complete") {
+		t.Fatalf("expected the deploy to complete, got %s", recorder.Body.String())
+	}
+
+	if buildStarted() {
+		t.Errorf("expected an app with a prebuilt image not to be built")
+	}
+
+	app := Flux.appManager.GetApp(projectConfig.Name)
+	if app == nil {
+		t.Fatal("expected the app to be created")
+	}
+
+	head := app.Deployment.head()
+	if image := docker.container(string(head.ContainerID[:])).Config.Image; image != "flux_app-image" {
+		t.Errorf("expected the container to run the prebuilt image tagged as flux_app-image, got %q", image)
+	}
+}
+
+func TestValidateProjectConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(projectConfig *pkg.ProjectConfig)
+		// a part of the error, empty when the config is valid
+		wantErr string
+	}{
+		{"valid", func(c *pkg.ProjectConfig) {}, ""},
+		{"replicas default to 1", func(c *pkg.ProjectConfig) { c.Replicas = 0 }, ""},
+		{"no name", func(c *pkg.ProjectConfig) { c.Name = "" }, "a name must be specified"},
+		{"no url", func(c *pkg.ProjectConfig) { c.Url = "" }, "a url must be specified"},
+		{"unknown protocol", func(c *pkg.ProjectConfig) { c.Protocol = "ftp" }, `unknown protocol "ftp"`},
+		{"proxy", func(c *pkg.ProjectConfig) { c.Proxy = &pkg.ProxyConfig{DialTimeout: -1} }, "proxy.dial_timeout"},
+		{"ports", func(c *pkg.ProjectConfig) { c.Ports = []pkg.PortConfig{{Port: 9090}, {Port: 9090}} }, "more than once"},
+		{"negative replicas", func(c *pkg.ProjectConfig) { c.Replicas = -1 }, "replicas must be at least 1"},
+		{"prepare", func(c *pkg.ProjectConfig) { c.Prepare = []string{" "} }, "empty command"},
+		{"prepare image", func(c *pkg.ProjectConfig) { c.PrepareImage = "Golang" }, "invalid prepare_image"},
+		{"build args", func(c *pkg.ProjectConfig) { c.BuildArgs = map[string]string{"CNB_USER_ID": "0"} }, "is reserved"},
+		{"network", func(c *pkg.ProjectConfig) { c.Network = "host" }, "reserved by docker"},
+		{"hosts", func(c *pkg.ProjectConfig) { c.ExtraHosts = []string{"db"} }, "expected host:ip"},
+		{"volumes", func(c *pkg.ProjectConfig) { c.Volumes = []pkg.VolumeConfig{{Target: "data"}} }, "data"},
+		{"stop timeout", func(c *pkg.ProjectConfig) { c.StopTimeout = -1 }, "stop_timeout"},
+		{"logs", func(c *pkg.ProjectConfig) { c.Logs = &pkg.LogConfig{MaxSize: -1} }, "max_size"},
+		{"pull policy", func(c *pkg.ProjectConfig) { c.ImagePullPolicy = "sometimes" }, "image_pull_policy"},
+		{"security", func(c *pkg.ProjectConfig) { c.CapDrop = []string{"not a capability"} }, "cap_drop"},
+		{"labels", func(c *pkg.ProjectConfig) { c.Labels = map[string]string{"a=b": ""} }, "must not contain"},
+		{"image", func(c *pkg.ProjectConfig) { c.Image = "nginx:1.27" }, ""},
+		{"invalid image", func(c *pkg.ProjectConfig) { c.Image = "Nginx" }, "invalid image"},
+		{"image and builder", func(c *pkg.ProjectConfig) { c.Image, c.Builder = "nginx", "paketobuildpacks/builder" }, "can't be combined"},
+		{"image and prepare", func(c *pkg.ProjectConfig) { c.Image, c.Prepare = "nginx", []string{"make"} }, "can't be combined"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			projectConfig := testProjectConfig("app")
+			projectConfig.Replicas = 1
+			test.modify(&projectConfig)
+
+			err := validateProjectConfig(projectConfig)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("expected the config to be valid, got %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
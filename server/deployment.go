@@ -2,118 +2,299 @@ package server
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/docker/docker/client"
 	"github.com/juls0730/flux/pkg"
 	"go.uber.org/zap"
 )
 
-var (
-	deploymentInsertStmt *sql.Stmt
-)
-
 type Deployment struct {
-	ID         int64            `json:"id"`
-	Head       *Container       `json:"head,omitempty"`
-	Containers []*Container     `json:"containers,omitempty"`
-	Proxy      *DeploymentProxy `json:"-"`
-	URL        string           `json:"url"`
-	Port       uint16           `json:"port"`
+	ID int64 `json:"id"`
+	// Head and Containers are read concurrently by api requests and the proxy, so once the deployment is shared they
+	// must only be read through head and containers, and replaced through setContainers or addContainer
+	Head       *Container   `json:"head,omitempty"`
+	Containers []*Container `json:"containers,omitempty"`
+	// Proxy, URL, Port, and Config change with every upgrade while the proxy and api requests read them, so once the
+	// deployment is shared they must only be read through proxy, url, port, and config, and replaced through
+	// setProxy and setConfig
+	Proxy  *DeploymentProxy  `json:"-"`
+	URL    string            `json:"url"`
+	Port   uint16            `json:"port"`
+	Config pkg.ProjectConfig `json:"-"`
+	// sha256 of the source archive that the app image was last built from
+	SourceHash string `json:"-"`
+
+	// set when the containers were stopped because the deployment went idle, the deployment stays registered with
+	// the proxy so the next request can wake it back up
+	suspended atomic.Bool
+	wakeLock  sync.Mutex
+	// set while the containers are frozen with docker pause, the proxy answers with a 503 in the meantime
+	paused atomic.Bool
+	// the page that the proxy answers with while the deployment is in maintenance, nil otherwise
+	maintenancePage atomic.Pointer[string]
+	// guards Head and Containers, the slice is copied on every change instead of being modified in place
+	containersLock sync.RWMutex
+	// guards Proxy, URL, Port, and Config
+	stateLock sync.RWMutex
+	// the proxies of earlier versions that are waiting for their requests to finish, as a set of *DeploymentProxy
+	draining sync.Map
+	// when docker restarted the containers after they exited, within the crash loop window
+	restarts     []containerRestart
+	restartsLock sync.Mutex
+	// whether the current crash loop was already reported, so that it is only reported once
+	crashLoopReported bool
+}
+
+// containers returns the containers of the deployment. The slice is never modified after it is returned, so it can be
+// used without holding any lock
+func (d *Deployment) containers() []*Container {
+	d.containersLock.RLock()
+	defer d.containersLock.RUnlock()
+
+	return d.Containers
+}
+
+func (d *Deployment) head() *Container {
+	d.containersLock.RLock()
+	defer d.containersLock.RUnlock()
+
+	return d.Head
+}
+
+// setContainers replaces the containers of the deployment, containers must not be modified afterwards
+func (d *Deployment) setContainers(head *Container, containers []*Container) {
+	d.containersLock.Lock()
+	defer d.containersLock.Unlock()
+
+	d.Head = head
+	d.Containers = containers
+}
+
+func (d *Deployment) proxy() *DeploymentProxy {
+	d.stateLock.RLock()
+	defer d.stateLock.RUnlock()
+
+	return d.Proxy
+}
+
+func (d *Deployment) setProxy(proxy *DeploymentProxy) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+
+	d.Proxy = proxy
+}
+
+// config returns the config that the deployment was last deployed with. It is replaced as a whole by setConfig, so
+// it must not be modified
+func (d *Deployment) config() pkg.ProjectConfig {
+	d.stateLock.RLock()
+	defer d.stateLock.RUnlock()
+
+	return d.Config
+}
+
+func (d *Deployment) url() string {
+	d.stateLock.RLock()
+	defer d.stateLock.RUnlock()
+
+	return d.URL
+}
+
+func (d *Deployment) port() uint16 {
+	d.stateLock.RLock()
+	defer d.stateLock.RUnlock()
+
+	return d.Port
+}
+
+// setConfig replaces the config of the deployment along with the url and port that follow from it
+func (d *Deployment) setConfig(projectConfig pkg.ProjectConfig) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+
+	d.Config = projectConfig
+	d.URL = deploymentURL(projectConfig)
+	d.Port = projectConfig.Port
+}
+
+// deploymentURL returns the url that the deployment is stored under, tcp apps aren't served on a url so they are stored
+// under their host port, which is just as unique
+func deploymentURL(projectConfig pkg.ProjectConfig) string {
+	if protocol(projectConfig) == pkg.ProtocolTCP {
+		return fmt.Sprintf("tcp:%d", projectConfig.HostPort)
+	}
+
+	return projectConfig.Url
 }
 
 // Creates a deployment and containers in the database
-func CreateDeployment(port uint16, appUrl string, db *sql.DB) (*Deployment, error) {
+func CreateDeployment(projectConfig pkg.ProjectConfig, db Database) (*Deployment, error) {
+	log := appLogger(projectConfig.Name)
+
 	var deployment Deployment
 	var err error
 
-	if deploymentInsertStmt == nil {
-		deploymentInsertStmt, err = db.Prepare("INSERT INTO deployments (url, port) VALUES ($1, $2) RETURNING id, url, port")
-		if err != nil {
-			logger.Errorw("Failed to prepare statement", zap.Error(err))
-			return nil, err
-		}
+	configBytes, err := json.Marshal(projectConfig)
+	if err != nil {
+		log.Errorw("Failed to marshal project config", zap.Error(err))
+		return nil, err
 	}
 
-	err = deploymentInsertStmt.QueryRow(appUrl, port).Scan(&deployment.ID, &deployment.URL, &deployment.Port)
+	err = deploymentInsertStmt.QueryRow(deploymentURL(projectConfig), projectConfig.Port, string(configBytes)).Scan(&deployment.ID, &deployment.URL, &deployment.Port)
 	if err != nil {
-		logger.Errorw("Failed to insert deployment", zap.Error(err))
+		log.Errorw("Failed to insert deployment", zap.Error(err))
 		return nil, err
 	}
 
+	deployment.Config = projectConfig
+
 	return &deployment, nil
 }
 
-func (deployment *Deployment) Upgrade(ctx context.Context, projectConfig pkg.ProjectConfig, imageName string, projectPath string) error {
+// Upgrade replaces the containers of the deployment with ones running imageName, the output of the deploy hooks is
+// streamed into eventChannel
+func (deployment *Deployment) Upgrade(ctx context.Context, projectConfig pkg.ProjectConfig, imageName string, projectPath string, eventChannel chan<- DeploymentEvent) error {
+	log := appLogger(projectConfig.Name)
+
 	existingContainers, err := findExistingDockerContainers(ctx, projectConfig.Name)
 	if err != nil {
 		return fmt.Errorf("failed to find existing containers: %v", err)
 	}
 
-	container, err := deployment.Head.Upgrade(ctx, imageName, projectPath, projectConfig)
+	previousHead := deployment.head()
+	previousContainers := deployment.containers()
+
+	container, err := previousHead.Upgrade(ctx, imageName, projectPath, projectConfig)
 	if err != nil {
-		logger.Errorw("Failed to upgrade container", zap.Error(err))
+		log.Errorw("Failed to upgrade container", zap.Error(err))
 		return err
 	}
 
-	// copy(container.ContainerID[:], containerIDString)
-	deployment.Head = container
-	deployment.Containers = append(deployment.Containers, container)
+	// the upgraded container takes over as the head
+	deployment.addContainer(container)
+	newContainers := []*Container{container}
 
-	logger.Debugw("Starting container", zap.ByteString("container_id", container.ContainerID[:12]))
-	err = container.Start(ctx)
-	if err != nil {
-		logger.Errorw("Failed to start container", zap.Error(err))
-		return err
+	// traffic only moves to the new containers at the very end, so until then the previous version is still serving
+	rollBack := func() {
+		deployment.abortUpgrade(previousHead, previousContainers, newContainers)
+		eventChannel <- DeploymentEvent{
+			Stage:   "rolled_back",
+			Message: "Rolled back, the previous version is still serving traffic",
+		}
 	}
 
-	if err := container.Wait(ctx, projectConfig.Port); err != nil {
-		logger.Errorw("Failed to wait for container", zap.Error(err))
-		return err
+	for i := 1; i < projectConfig.Replicas; i++ {
+		replica, err := CreateContainer(ctx, imageName, projectPath, projectConfig, false, deployment)
+		if err != nil {
+			log.Errorw("Failed to create replica", zap.Error(err))
+			rollBack()
+			return err
+		}
+
+		newContainers = append(newContainers, replica)
 	}
 
-	if _, err := Flux.db.Exec("UPDATE deployments SET url = ?, port = ? WHERE id = ?", projectConfig.Url, projectConfig.Port, deployment.ID); err != nil {
-		logger.Errorw("Failed to update deployment", zap.Error(err))
-		return err
+	started := time.Now()
+	for _, container := range newContainers {
+		log.Debugw("Starting container", zap.ByteString("container_id", container.ContainerID[:12]))
+		err = container.Start(ctx)
+		if err != nil {
+			log.Errorw("Failed to start container", zap.Error(err))
+			rollBack()
+			return err
+		}
+
+		Flux.logStore.Attach(container, projectConfig, started)
+	}
+
+	for _, container := range newContainers {
+		if err := container.Wait(ctx, projectConfig.Port, protocol(projectConfig), healthCheckConfig(projectConfig.HealthCheck).Path); err != nil {
+			log.Errorw("Failed to wait for container", zap.Error(err))
+			rollBack()
+			return fmt.Errorf("new version never became ready, keeping the previous version: %v", err)
+		}
+	}
+
+	// the old containers keep serving traffic until the new ones have proven that they don't crash right away
+	if err := waitForStability(ctx, newContainers, projectConfig); err != nil {
+		log.Errorw("New containers are not stable", zap.Error(err))
+		rollBack()
+		return fmt.Errorf("new version did not stay healthy, keeping the previous version: %v", err)
 	}
 
-	// Create a new proxy that points to the new head, and replace the old one, but ensure that the old one is gracefully shutdown
-	oldProxy := deployment.Proxy
-	deployment.Proxy, err = deployment.NewDeploymentProxy()
+	// hooks run once, in the head, so that something like a migration isn't run by every replica
+	if projectConfig.Hooks != nil && projectConfig.Hooks.PreDeploy != "" {
+		if err := runHook(ctx, container, "pre_deploy", projectConfig.Hooks.PreDeploy, eventChannel); err != nil {
+			log.Errorw("Pre-deploy hook failed", zap.Error(err))
+			rollBack()
+			return fmt.Errorf("%v, keeping the previous version", err)
+		}
+	}
+
+	configBytes, err := json.Marshal(projectConfig)
 	if err != nil {
-		logger.Errorw("Failed to create deployment proxy", zap.Error(err))
+		log.Errorw("Failed to marshal project config", zap.Error(err))
 		return err
 	}
 
-	tx, err := Flux.db.Begin()
-	if err != nil {
-		logger.Errorw("Failed to begin transaction", zap.Error(err))
+	if _, err := Flux.db.Exec("UPDATE deployments SET url = ?, port = ?, config = ? WHERE id = ?", deploymentURL(projectConfig), projectConfig.Port, string(configBytes), deployment.ID); err != nil {
+		log.Errorw("Failed to update deployment", zap.Error(err))
 		return err
 	}
+	previousURL := deployment.url()
+	deployment.setConfig(projectConfig)
 
 	var containers []*Container
 	var oldContainers []*Container
-	for _, container := range deployment.Containers {
+	for _, container := range deployment.containers() {
 		if existingContainers[string(container.ContainerID[:])] {
-			logger.Debugw("Deleting container from db", zap.ByteString("container_id", container.ContainerID[:12]))
-
-			_, err = tx.Exec("DELETE FROM containers WHERE id = ?", container.ID)
 			oldContainers = append(oldContainers, container)
-
-			if err != nil {
-				logger.Errorw("Failed to delete container", zap.Error(err))
-				tx.Rollback()
-				return err
-			}
-
 			continue
 		}
 
 		containers = append(containers, container)
 	}
 
+	// Create a new proxy that points to the new containers, and replace the old one, but ensure that the old one is gracefully shutdown
+	deployment.setContainers(deployment.head(), containers)
+	oldProxy := deployment.proxy()
+	newProxy, err := deployment.NewDeploymentProxy()
+	deployment.setProxy(newProxy)
+	if err != nil {
+		log.Errorw("Failed to create deployment proxy", zap.Error(err))
+		return err
+	}
+	// the url, the host port, or the protocol may have changed
+	Flux.proxy.AddDeployment(deployment)
+	if previousURL != deployment.url() {
+		Flux.proxy.deployments.CompareAndDelete(previousURL, deployment)
+	}
+
+	tx, err := Flux.db.Begin()
+	if err != nil {
+		log.Errorw("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+
+	for _, container := range oldContainers {
+		log.Debugw("Deleting container from db", zap.ByteString("container_id", container.ContainerID[:12]))
+
+		_, err = tx.Exec("DELETE FROM containers WHERE id = ?", container.ID)
+		if err != nil {
+			log.Errorw("Failed to delete container", zap.Error(err))
+			tx.Rollback()
+			return err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
-		logger.Errorw("Failed to commit transaction", zap.Error(err))
+		log.Errorw("Failed to commit transaction", zap.Error(err))
 		return err
 	}
 
@@ -123,20 +304,45 @@ func (deployment *Deployment) Upgrade(ctx context.Context, projectConfig pkg.Pro
 		for _, container := range oldContainers {
 			err := RemoveDockerContainer(context.Background(), string(container.ContainerID[:]))
 			if err != nil {
-				logger.Errorw("Failed to remove container", zap.Error(err))
+				log.Errorw("Failed to remove container", zap.Error(err))
+			}
+		}
+	}
+
+	// the new version is already serving traffic, so there is nothing left to roll back to
+	if projectConfig.Hooks != nil && projectConfig.Hooks.PostDeploy != "" {
+		if err := runHook(ctx, container, "post_deploy", projectConfig.Hooks.PostDeploy, eventChannel); err != nil {
+			log.Warnw("Post-deploy hook failed", zap.Error(err))
+			eventChannel <- DeploymentEvent{
+				Stage:   "post_deploy",
+				Message: fmt.Sprintf("Warning: %v, the new version is still deployed", err),
 			}
 		}
 	}
 
-	deployment.Containers = containers
 	return nil
 }
 
 func (d *Deployment) Remove(ctx context.Context) error {
-	for _, container := range d.Containers {
+	log := appLogger(d.config().Name)
+
+	// replicas are removed before the head, since the head owns the volume that the replicas have mounted
+	containers := make([]*Container, 0, len(d.containers()))
+	for _, container := range d.containers() {
+		if !container.Head {
+			containers = append(containers, container)
+		}
+	}
+	for _, container := range d.containers() {
+		if container.Head {
+			containers = append(containers, container)
+		}
+	}
+
+	for _, container := range containers {
 		err := container.Remove(ctx)
 		if err != nil {
-			logger.Errorf("Failed to remove container (%s): %v\n", container.ContainerID[:12], err)
+			log.Errorf("Failed to remove container (%s): %v\n", container.ContainerID[:12], err)
 			return err
 		}
 	}
@@ -145,7 +351,7 @@ func (d *Deployment) Remove(ctx context.Context) error {
 
 	_, err := Flux.db.Exec("DELETE FROM deployments WHERE id = ?", d.ID)
 	if err != nil {
-		logger.Errorw("Failed to delete deployment", zap.Error(err))
+		log.Errorw("Failed to delete deployment", zap.Error(err))
 		return err
 	}
 
@@ -153,51 +359,294 @@ func (d *Deployment) Remove(ctx context.Context) error {
 }
 
 func (d *Deployment) Start(ctx context.Context) error {
-	for _, container := range d.Containers {
+	log := appLogger(d.config().Name)
+
+	started := time.Now()
+	for _, container := range d.containers() {
 		err := container.Start(ctx)
 		if err != nil {
-			logger.Errorf("Failed to start container (%s): %v\n", container.ContainerID[:12], err)
+			log.Errorf("Failed to start container (%s): %v\n", container.ContainerID[:12], err)
 			return err
 		}
+
+		Flux.logStore.Attach(container, d.config(), started)
 	}
 
-	if d.Proxy == nil {
-		d.Proxy, _ = d.NewDeploymentProxy()
+	// containers can get a new IP address when they are restarted, so a suspended deployment needs a fresh proxy
+	if d.proxy() == nil || d.suspended.Load() {
+		proxy, _ := d.NewDeploymentProxy()
+		d.setProxy(proxy)
 		Flux.proxy.AddDeployment(d)
 	}
+	d.suspended.Store(false)
 
 	return nil
 }
 
 func (d *Deployment) Stop(ctx context.Context) error {
-	for _, container := range d.Containers {
+	log := appLogger(d.config().Name)
+
+	// a frozen process can't handle the stop signal, so it gets to shut down gracefully instead of being killed
+	if d.paused.Load() {
+		if err := d.Unpause(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, container := range d.containers() {
 		err := container.Stop(ctx)
 		if err != nil {
-			logger.Errorf("Failed to start container (%s): %v\n", container.ContainerID[:12], err)
+			log.Errorf("Failed to start container (%s): %v\n", container.ContainerID[:12], err)
 			return err
 		}
 	}
 
 	Flux.proxy.RemoveDeployment(d)
-	d.Proxy = nil
+	d.setProxy(nil)
+	d.suspended.Store(false)
+
+	return nil
+}
+
+// Pause freezes the containers of the deployment, they keep their memory but get no cpu time until they are unpaused
+func (d *Deployment) Pause(ctx context.Context) error {
+	log := appLogger(d.config().Name)
+
+	// mark the deployment as paused first so that the proxy stops sending requests to containers that are freezing
+	d.paused.Store(true)
+
+	for _, container := range d.containers() {
+		err := container.Pause(ctx)
+		if err != nil {
+			log.Errorf("Failed to pause container (%s): %v\n", container.ContainerID[:12], err)
+			return err
+		}
+	}
 
 	return nil
 }
 
+func (d *Deployment) Unpause(ctx context.Context) error {
+	log := appLogger(d.config().Name)
+
+	for _, container := range d.containers() {
+		err := container.Unpause(ctx)
+		if err != nil {
+			log.Errorf("Failed to unpause container (%s): %v\n", container.ContainerID[:12], err)
+			return err
+		}
+	}
+
+	d.paused.Store(false)
+
+	return nil
+}
+
+// Suspend stops the containers of an idle deployment, but keeps it routable so that the next request wakes it up
+func (d *Deployment) Suspend(ctx context.Context) error {
+	log := appLogger(d.config().Name)
+
+	d.wakeLock.Lock()
+	defer d.wakeLock.Unlock()
+
+	if d.suspended.Load() {
+		return nil
+	}
+
+	// mark the deployment as suspended before stopping anything so that requests that arrive in the meantime wait
+	// for the deployment to be woken up instead of being sent to a container that is shutting down
+	d.suspended.Store(true)
+
+	log.Infow("Suspending idle deployment", zap.String("url", d.url()))
+	for _, container := range d.containers() {
+		err := container.Stop(ctx)
+		if err != nil {
+			log.Errorf("Failed to stop container (%s): %v\n", container.ContainerID[:12], err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Wake starts a suspended deployment and blocks until its containers are ready to receive traffic
+func (d *Deployment) Wake(ctx context.Context) error {
+	log := appLogger(d.config().Name)
+
+	d.wakeLock.Lock()
+	defer d.wakeLock.Unlock()
+
+	// another request may have already woken the deployment while we were waiting for the lock
+	if !d.suspended.Load() {
+		return nil
+	}
+
+	log.Infow("Waking idle deployment", zap.String("url", d.url()))
+	started := time.Now()
+	for _, container := range d.containers() {
+		err := container.Start(ctx)
+		if err != nil {
+			log.Errorf("Failed to start container (%s): %v\n", container.ContainerID[:12], err)
+			return err
+		}
+
+		Flux.logStore.Attach(container, d.config(), started)
+	}
+
+	// the new proxy starts out sending traffic to every container, so all of them have to pass a health check first.
+	// They were started together, so waiting on them one after another takes about as long as the slowest one
+	for _, container := range d.containers() {
+		if err := container.Wait(ctx, d.port(), protocol(d.config()), healthCheckConfig(d.config().HealthCheck).Path); err != nil {
+			return err
+		}
+	}
+
+	proxy, err := d.NewDeploymentProxy()
+	if err != nil {
+		return err
+	}
+
+	d.setProxy(proxy)
+	Flux.proxy.AddDeployment(d)
+	d.suspended.Store(false)
+
+	return nil
+}
+
+// abortUpgrade removes the containers created by an upgrade that failed before it switched traffic over, and puts
+// the deployment back the way it was before the upgrade
+func (deployment *Deployment) abortUpgrade(previousHead *Container, previousContainers []*Container, newContainers []*Container) {
+	log := appLogger(deployment.config().Name)
+	// the upgrade may have failed because its context was cancelled, cleaning up has to happen regardless
+	ctx := context.Background()
+
+	deployment.setContainers(previousHead, previousContainers)
+
+	tx, err := Flux.db.Begin()
+	if err != nil {
+		log.Errorw("Failed to begin transaction", zap.Error(err))
+		return
+	}
+
+	// the upgrade pointed the volumes at the new head, so point them back at the previous one
+	if err := previousHead.saveVolumes(tx); err != nil {
+		log.Errorw("Failed to restore volumes", zap.Error(err))
+		tx.Rollback()
+		return
+	}
+
+	for _, container := range newContainers {
+		// only the volumes that were created for this upgrade are still pointing at the new containers
+		if _, err := tx.Exec("DELETE FROM volumes WHERE container_id = ?", container.ContainerID[:]); err != nil {
+			log.Errorw("Failed to delete volumes", zap.Error(err))
+			tx.Rollback()
+			return
+		}
+
+		if _, err := tx.Exec("DELETE FROM containers WHERE id = ?", container.ID); err != nil {
+			log.Errorw("Failed to delete container", zap.Error(err))
+			tx.Rollback()
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Errorw("Failed to commit transaction", zap.Error(err))
+		return
+	}
+
+	for _, container := range newContainers {
+		if err := RemoveDockerContainer(ctx, string(container.ContainerID[:])); err != nil {
+			log.Errorw("Failed to remove container", zap.Error(err))
+		}
+
+		for _, volume := range container.Volumes {
+			if findVolume(previousHead.Volumes, volume.Mountpoint) != nil || isNamedVolume(deployment.config(), volume.VolumeID) {
+				continue
+			}
+
+			if err := RemoveVolume(ctx, volume.VolumeID); err != nil {
+				log.Errorw("Failed to remove volume", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (deployment *Deployment) addContainer(c *Container) {
+	c.Deployment = deployment
+
+	deployment.containersLock.Lock()
+	defer deployment.containersLock.Unlock()
+
+	if c.Head {
+		deployment.Head = c
+	}
+	// clipping forces append to copy, so that slices that were handed out by containers stay untouched
+	deployment.Containers = append(slices.Clip(deployment.Containers), c)
+}
+
+// SetSourceHash records the hash of the source archive that the app image was built from
+func (d *Deployment) SetSourceHash(hash string) error {
+	if _, err := Flux.db.Exec("UPDATE deployments SET source_hash = ? WHERE id = ?", hash, d.ID); err != nil {
+		return err
+	}
+
+	d.SourceHash = hash
+	return nil
+}
+
+// SetMaintenance puts the deployment in or out of maintenance, the containers are left alone. An empty page means the
+// default maintenance page
+func (d *Deployment) SetMaintenance(enabled bool, page string) error {
+	if !enabled {
+		page = ""
+	}
+
+	if _, err := Flux.db.Exec("UPDATE deployments SET maintenance = ?, maintenance_page = ? WHERE id = ?", enabled, page, d.ID); err != nil {
+		return err
+	}
+
+	if enabled {
+		d.maintenancePage.Store(&page)
+	} else {
+		d.maintenancePage.Store(nil)
+	}
+
+	return nil
+}
+
+func (d *Deployment) inMaintenance() bool {
+	return d.maintenancePage.Load() != nil
+}
+
 func (d *Deployment) Status(ctx context.Context) (string, error) {
 	var status string
 	if d == nil {
 		return "", fmt.Errorf("deployment is nil")
 	}
 
-	if d.Containers == nil {
+	log := appLogger(d.config().Name)
+
+	if d.suspended.Load() {
+		return "idle", nil
+	}
+
+	if d.paused.Load() {
+		return "paused", nil
+	}
+
+	if d.containers() == nil {
 		return "", fmt.Errorf("containers are nil")
 	}
 
-	for _, container := range d.Containers {
+	for _, container := range d.containers() {
 		containerStatus, err := container.Status(ctx)
+		if client.IsErrNotFound(err) {
+			// the container was removed outside of flux, a deploy recreates it
+			return "degraded", nil
+		}
 		if err != nil {
-			logger.Errorw("Failed to get container status", zap.Error(err))
+			log.Errorw("Failed to get container status", zap.Error(err))
 			return "", err
 		}
 
@@ -214,7 +663,35 @@ func (d *Deployment) Status(ctx context.Context) (string, error) {
 		return "running", nil
 	case "exited":
 		return "stopped", nil
+	case "paused":
+		return "paused", nil
 	default:
 		return "pending", nil
 	}
 }
+
+// Stats collects the resource usage of every container in the deployment
+func (d *Deployment) Stats(ctx context.Context) (pkg.ContainerStats, []pkg.ContainerStats, error) {
+	log := appLogger(d.config().Name)
+
+	var total pkg.ContainerStats
+	var containerStats []pkg.ContainerStats
+
+	for _, container := range d.containers() {
+		stats, err := container.Stats(ctx)
+		if err != nil {
+			log.Errorw("Failed to get container stats", zap.Error(err))
+			return total, nil, err
+		}
+
+		total.CPUPercent += stats.CPUPercent
+		total.MemoryUsage += stats.MemoryUsage
+		total.MemoryLimit += stats.MemoryLimit
+		total.NetworkRx += stats.NetworkRx
+		total.NetworkTx += stats.NetworkTx
+
+		containerStats = append(containerStats, stats)
+	}
+
+	return total, containerStats, nil
+}
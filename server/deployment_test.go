@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// drainEvents discards the events of an upgrade until the channel is closed
+func drainEvents(events chan DeploymentEvent) {
+	for range events {
+	}
+}
+
+// TestUpgradeWhileServing upgrades an app while its status is requested and it receives traffic, run it with -race
+// to catch state of the deployment that is replaced without holding its lock
+func TestUpgradeWhileServing(t *testing.T) {
+	docker := newTestServer(t)
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.Port = newTestUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	projectConfig.HealthCheck = &pkg.HealthCheck{StabilizationWindow: -1}
+	app := createTestApp(t, projectConfig)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(3)
+	// only reads memory, since every request to docker or the app orders it before or after the upgrade as far as the
+	// race detector is concerned
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			Flux.proxy.Routes()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			if _, err := app.Info(context.Background()); err != nil {
+				t.Errorf("failed to get app info: %v", err)
+				return
+			}
+			app.Describe(context.Background())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			recorder := httptest.NewRecorder()
+			Flux.proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://"+projectConfig.Url+"/", nil))
+			if recorder.Code != http.StatusOK {
+				t.Errorf("expected the app to keep serving during the upgrade, got %d", recorder.Code)
+				return
+			}
+		}
+	}()
+
+	upgradedConfig := projectConfig
+	upgradedConfig.Labels = map[string]string{"version": "2"}
+
+	events := make(chan DeploymentEvent)
+	go drainEvents(events)
+	err := app.Upgrade(context.Background(), upgradedConfig, "flux_app-image", filepath.Join(Flux.rootDir, "apps", "app"), events)
+	close(events)
+	close(done)
+	wg.Wait()
+
+	if err != nil {
+		t.Fatalf("failed to upgrade app: %v", err)
+	}
+
+	if labels := app.Deployment.config().Labels; labels["version"] != "2" {
+		t.Errorf("expected the upgraded config to be in use, got labels %v", labels)
+	}
+
+	// the previous container is removed once its requests have drained
+	waitFor(t, "the previous container to be removed", func() bool {
+		return len(docker.containerIDs()) == 1
+	})
+}
+
+func TestUpgradeRollsBackWhenNewContainersNeverBecomeReady(t *testing.T) {
+	docker := newTestServer(t)
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.Replicas = 2
+	projectConfig.Port = newTestUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "previous")
+	}))
+	projectConfig.HealthCheck = &pkg.HealthCheck{StabilizationWindow: -1}
+	app := createTestApp(t, projectConfig)
+
+	previousHead := app.Deployment.head()
+	previousContainers := docker.containerIDs()
+
+	// the new version crashes as soon as it starts
+	docker.onStart = func(c *fakeContainer) {
+		c.Status = "exited"
+		c.ExitCode = 1
+	}
+
+	events := make(chan DeploymentEvent)
+	go drainEvents(events)
+	defer close(events)
+
+	// waiting for the new containers gives up once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := app.Upgrade(ctx, projectConfig, "flux_app-image", filepath.Join(Flux.rootDir, "apps", "app"), events); err == nil {
+		t.Fatal("expected the upgrade to fail")
+	}
+
+	if head := app.Deployment.head(); head != previousHead {
+		t.Errorf("expected the previous head to be restored")
+	}
+
+	if containers := app.Deployment.containers(); len(containers) != 2 {
+		t.Errorf("expected the deployment to be back to its 2 previous containers, got %d", len(containers))
+	}
+
+	containerIDs := docker.containerIDs()
+	slices.Sort(containerIDs)
+	slices.Sort(previousContainers)
+	if !slices.Equal(containerIDs, previousContainers) {
+		t.Errorf("expected the new containers to be removed, docker has %d containers", len(containerIDs))
+	}
+
+	if got := countRows(t, "containers", "1 = 1"); got != 2 {
+		t.Errorf("expected 2 containers in the database, got %d", got)
+	}
+
+	if got := countRows(t, "volumes", "container_id = ?", previousHead.ContainerID[:]); got != 1 {
+		t.Errorf("expected the volume to point at the previous head again, %d volumes do", got)
+	}
+
+	recorder := httptest.NewRecorder()
+	Flux.proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://"+projectConfig.Url+"/", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "previous" {
+		t.Errorf("expected the previous version to keep serving, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestUpgradeReattachesVolumes(t *testing.T) {
+	docker := newTestServer(t)
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.Port = newTestUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	projectConfig.HealthCheck = &pkg.HealthCheck{StabilizationWindow: -1}
+	projectConfig.Volumes = []pkg.VolumeConfig{
+		{Target: "/data"},
+		{Target: "/cache"},
+	}
+	app := createTestApp(t, projectConfig)
+
+	previousHead := app.Deployment.head()
+	volumes := make(map[string]string)
+	for _, target := range []string{"/data", "/cache"} {
+		volume := findVolume(previousHead.Volumes, target)
+		if volume == nil {
+			t.Fatalf("expected the app to have a volume at %s", target)
+		}
+
+		volumes[target] = volume.VolumeID
+	}
+
+	events := make(chan DeploymentEvent)
+	go drainEvents(events)
+	defer close(events)
+
+	if err := app.Upgrade(context.Background(), projectConfig, "flux_app-image", filepath.Join(Flux.rootDir, "apps", "app"), events); err != nil {
+		t.Fatalf("failed to upgrade app: %v", err)
+	}
+
+	waitFor(t, "the previous container to be removed", func() bool {
+		return docker.container(string(previousHead.ContainerID[:])) == nil
+	})
+
+	head := app.Deployment.head()
+	if head == previousHead {
+		t.Fatal("expected the upgrade to replace the head")
+	}
+
+	mounts := make(map[string]string)
+	for _, m := range docker.container(string(head.ContainerID[:])).HostConfig.Mounts {
+		mounts[m.Target] = m.Source
+	}
+
+	for target, volumeID := range volumes {
+		if volume := findVolume(head.Volumes, target); volume == nil || volume.VolumeID != volumeID {
+			t.Errorf("expected the new head to keep volume %s at %s, got %+v", volumeID, target, volume)
+		}
+
+		if mounts[target] != volumeID {
+			t.Errorf("expected volume %s to be mounted at %s in the new head, got %q", volumeID, target, mounts[target])
+		}
+
+		if !docker.hasVolume(volumeID) {
+			t.Errorf("expected volume %s to outlive the previous head", volumeID)
+		}
+	}
+
+	if got := countRows(t, "volumes", "container_id = ?", head.ContainerID[:]); got != 2 {
+		t.Errorf("expected both volumes to belong to the new head in the database, %d do", got)
+	}
+
+	if got := countRows(t, "volumes", "1 = 1"); got != 2 {
+		t.Errorf("expected the volumes to be moved rather than copied, got %d rows", got)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long the events of a finished deploy are kept around, so that a client whose connection dropped can reconnect
+// and pick up where it left off. Deploys that are still running keep their events until they finish
+const deployResumeWindow = time.Minute
+
+type streamEvent struct {
+	name string
+	data []byte
+}
+
+// deployStream records the events of a deploy so that they can be replayed to a client that reconnects with the
+// Last-Event-ID of the last event it received. Event ids are the id of the deploy followed by the sequence number of
+// the event
+type deployStream struct {
+	id string
+
+	mu     sync.Mutex
+	events []streamEvent
+	done   bool
+	// closed and replaced whenever an event is published or the stream finishes
+	changed chan struct{}
+}
+
+func newDeployStream() *deployStream {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return &deployStream{
+		id:      hex.EncodeToString(id),
+		changed: make(chan struct{}),
+	}
+}
+
+func (s *deployStream) publish(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return
+	}
+
+	s.events = append(s.events, streamEvent{name: name, data: data})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// finish marks the stream as complete, clients are sent every remaining event and then the stream is closed
+func (s *deployStream) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return
+	}
+
+	s.done = true
+	close(s.changed)
+}
+
+// serve writes every event after the event with the sequence number after to w, until the stream finishes or the
+// client goes away. The deploy carries on either way
+func (s *deployStream) serve(ctx context.Context, w http.ResponseWriter, flusher http.Flusher, after int) {
+	next := after
+	for {
+		s.mu.Lock()
+		events := s.events[min(next, len(s.events)):]
+		done := s.done
+		changed := s.changed
+		s.mu.Unlock()
+
+		for _, event := range events {
+			next++
+			fmt.Fprintf(w, "id: %s-%d\n", s.id, next)
+			fmt.Fprintf(w, "event: %s\n", event.name)
+			fmt.Fprintf(w, "data: %s\n\n", event.data)
+		}
+		if len(events) > 0 {
+			flusher.Flush()
+		}
+
+		if done {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// parseEventID splits a Last-Event-ID into the id of the deploy and the sequence number of the event
+func parseEventID(eventID string) (string, int, error) {
+	id, seq, ok := strings.Cut(eventID, "-")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid event id %q", eventID)
+	}
+
+	n, err := strconv.Atoi(seq)
+	if err != nil || n < 0 {
+		return "", 0, fmt.Errorf("invalid event id %q", eventID)
+	}
+
+	return id, n, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+// how long to wait for docker to respond to a ping
+const dockerPingTimeout = 5 * time.Second
+
+// the files that docker_cert_path has to contain, named like docker names them in DOCKER_CERT_PATH
+var dockerCertFiles = []string{"ca.pem", "cert.pem", "key.pem"}
+
+func (c FluxServerConfig) validateDocker() error {
+	if c.DockerHost != "" {
+		if _, err := client.ParseHostURL(c.DockerHost); err != nil {
+			return fmt.Errorf("invalid docker_host %q: %v", c.DockerHost, err)
+		}
+	}
+
+	if c.DockerCertPath == "" {
+		return nil
+	}
+
+	for _, name := range dockerCertFiles {
+		if _, err := os.Stat(filepath.Join(c.DockerCertPath, name)); err != nil {
+			return fmt.Errorf("docker_cert_path must contain %s: %v", strings.Join(dockerCertFiles, ", "), err)
+		}
+	}
+
+	return nil
+}
+
+// newDockerClient connects to the docker daemon in the config, or the one from the environment when the config doesn't
+// set one, and makes sure that it is actually reachable, since creating the client itself never fails on a docker
+// daemon that isn't running
+func newDockerClient(config FluxServerConfig) (*client.Client, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if config.DockerHost != "" {
+		opts = append(opts, client.WithHost(config.DockerHost))
+	}
+
+	if config.DockerCertPath != "" {
+		opts = append(opts, client.WithTLSClientConfig(
+			filepath.Join(config.DockerCertPath, "ca.pem"),
+			filepath.Join(config.DockerCertPath, "cert.pem"),
+			filepath.Join(config.DockerCertPath, "key.pem"),
+		))
+	}
+
+	dockerClient, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPingTimeout)
+	defer cancel()
+
+	if _, err := dockerClient.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("docker is unavailable at %s, make sure that docker is running and that docker_host or DOCKER_HOST is set if it doesn't use the default socket: %v", dockerClient.DaemonHost(), err)
+	}
+
+	return dockerClient, nil
+}
+
+// dockerEnv returns the environment for commands that talk to docker themselves, such as pack, so that they use the
+// same docker daemon as fluxd
+func (s *FluxServer) dockerEnv() []string {
+	env := os.Environ()
+	if s.config.DockerHost != "" {
+		env = append(env, "DOCKER_HOST="+s.config.DockerHost)
+	}
+
+	if s.config.DockerCertPath != "" {
+		env = append(env, "DOCKER_CERT_PATH="+s.config.DockerCertPath, "DOCKER_TLS_VERIFY=1")
+	}
+
+	// pack pulls images itself, so it needs the registry credentials too
+	if dir, err := s.writePackDockerConfig(); err != nil {
+		logger.Warnw("Failed to write registry credentials for pack", zap.Error(err))
+	} else if dir != "" {
+		env = append(env, "DOCKER_CONFIG="+dir)
+	}
+
+	return env
+}
+
+func dockerAvailable() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPingTimeout)
+	defer cancel()
+
+	_, err := Flux.dockerClient.Ping(ctx)
+	return err == nil
+}
+
+// errorStatus returns the status code for a request that failed because of err. Nothing works without docker, so
+// docker going away is reported as a 503 rather than as a bug in fluxd
+func errorStatus(err error) int {
+	if client.IsErrConnectionFailed(err) || !dockerAvailable() {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+func internalError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		writeError(w, pkg.ErrorCodeDockerUnavailable, status, fmt.Sprintf("Docker is unavailable: %s", err))
+		return
+	}
+
+	writeError(w, pkg.ErrorCodeInternal, status, err.Error())
+}
+
+// writeError responds with a json error, code is one of the pkg.ErrorCode constants
+func writeError(w http.ResponseWriter, code string, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(pkg.Error{
+		Message: msg,
+		Code:    code,
+	})
+}
@@ -0,0 +1,753 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+// fakeDocker is an in memory stand-in for the parts of the docker engine api that flux uses, so that the container
+// lifecycle can be tested without a docker daemon
+type fakeDocker struct {
+	mu         sync.Mutex
+	mux        *http.ServeMux
+	containers map[string]*fakeContainer
+	volumes    map[string]*volume.Volume
+	// local images by name:tag
+	images map[string]*types.ImageInspect
+	// images that can be pulled, by name:tag
+	registry map[string]*types.ImageInspect
+	// the address that containers get once they are started, 127.0.0.1 by default
+	containerIP string
+	// called with every container that is created, before it is stored
+	onCreate func(c *fakeContainer)
+	// called with every container that is started, after it is marked as running
+	onStart func(c *fakeContainer)
+}
+
+type fakeContainer struct {
+	ID         string
+	Name       string
+	Config     container.Config
+	HostConfig container.HostConfig
+	Status     string
+	ExitCode   int
+	IP         string
+	// set for a process that doesn't exit on any stop signal other than KILL, docker kills it once the stop timeout
+	// has passed
+	IgnoresStop bool
+	Stops       []fakeStop
+	// the output of a container that runs a command, multiplexed like the logs of docker
+	output bytes.Buffer
+	// closed once the command of the container exited, nil for containers without a command
+	exited chan struct{}
+	kill   context.CancelFunc
+}
+
+// fakeStop is a stop request that a container received
+type fakeStop struct {
+	Signal  string
+	Timeout int
+	// whether docker had to kill the container after the timeout
+	Killed bool
+	Took   time.Duration
+}
+
+func newFakeDocker() *fakeDocker {
+	d := &fakeDocker{
+		mux:         http.NewServeMux(),
+		containers:  make(map[string]*fakeContainer),
+		volumes:     make(map[string]*volume.Volume),
+		images:      make(map[string]*types.ImageInspect),
+		registry:    make(map[string]*types.ImageInspect),
+		containerIP: "127.0.0.1",
+	}
+
+	d.mux.HandleFunc("GET /_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", api.DefaultVersion)
+		w.Write([]byte("OK"))
+	})
+	d.mux.HandleFunc("POST /containers/create", d.createContainer)
+	d.mux.HandleFunc("GET /containers/json", d.listContainers)
+	d.mux.HandleFunc("GET /containers/{id}/json", d.inspectContainer)
+	d.mux.HandleFunc("POST /containers/{id}/start", d.startContainer)
+	d.mux.HandleFunc("POST /containers/{id}/stop", d.stopContainer)
+	d.mux.HandleFunc("POST /containers/{id}/rename", d.renameContainer)
+	d.mux.HandleFunc("GET /containers/{id}/logs", d.containerLogs)
+	d.mux.HandleFunc("POST /containers/{id}/wait", d.waitContainer)
+	d.mux.HandleFunc("DELETE /containers/{id}", d.removeContainer)
+	d.mux.HandleFunc("POST /volumes/create", d.createVolume)
+	d.mux.HandleFunc("GET /volumes", d.listVolumes)
+	d.mux.HandleFunc("DELETE /volumes/{name}", d.removeVolume)
+	d.mux.HandleFunc("POST /images/create", d.pullImage)
+	d.mux.HandleFunc("GET /images/get", d.saveImage)
+	// image names contain slashes, so they can't be matched by a wildcard followed by more of the path
+	d.mux.HandleFunc("GET /images/", d.inspectImage)
+	d.mux.HandleFunc("POST /images/", d.tagImage)
+
+	return d
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the client puts the api version in front of every path
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v"+api.DefaultVersion)
+	d.mux.ServeHTTP(w, r)
+}
+
+func dockerError(w http.ResponseWriter, status int, format string, args ...any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf(format, args...)})
+}
+
+func writeDockerJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomID() string {
+	id := make([]byte, 32)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// addContainer adds a running container that flux didn't create, such as one that a test deployment starts out with
+func (d *fakeDocker) addContainer(name string, config container.Config, hostConfig container.HostConfig) *fakeContainer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := &fakeContainer{
+		ID:         randomID(),
+		Name:       name,
+		Config:     config,
+		HostConfig: hostConfig,
+		Status:     "running",
+		IP:         d.containerIP,
+	}
+	d.containers[c.ID] = c
+
+	return c
+}
+
+// addVolume adds a volume with a generated name, like docker creates for a volume without a name
+func (d *fakeDocker) addVolume(labels map[string]string) *volume.Volume {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := randomID()
+	v := &volume.Volume{Name: name, Driver: "local", Mountpoint: "/var/lib/docker/volumes/" + name + "/_data", Labels: labels}
+	d.volumes[name] = v
+
+	return v
+}
+
+func (d *fakeDocker) container(id string) *fakeContainer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.find(id)
+}
+
+// find looks a container up by its id or name, d.mu must be held
+func (d *fakeDocker) find(idOrName string) *fakeContainer {
+	if c, ok := d.containers[idOrName]; ok {
+		return c
+	}
+
+	for _, c := range d.containers {
+		if c.Name == strings.TrimPrefix(idOrName, "/") {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (d *fakeDocker) hasVolume(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.volumes[name]
+	return ok
+}
+
+// stops returns the stop requests that c received, c may already be removed
+func (d *fakeDocker) stops(c *fakeContainer) []fakeStop {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(c.Stops)
+}
+
+// containerIDs returns the ids of every container, in no particular order
+func (d *fakeDocker) containerIDs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var ids []string
+	for id := range d.containers {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func (d *fakeDocker) createContainer(w http.ResponseWriter, r *http.Request) {
+	var config container.CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		dockerError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+
+	c := &fakeContainer{
+		ID:     randomID(),
+		Name:   r.URL.Query().Get("name"),
+		Config: *config.Config,
+		Status: "created",
+	}
+	if config.HostConfig != nil {
+		c.HostConfig = *config.HostConfig
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if c.Name != "" && d.find(c.Name) != nil {
+		dockerError(w, http.StatusConflict, `Conflict. The container name "/%s" is already in use`, c.Name)
+		return
+	}
+
+	// volumes that are mounted by name are created on the fly, like docker does
+	for _, m := range c.HostConfig.Mounts {
+		if m.Type == mount.TypeVolume && d.volumes[m.Source] == nil {
+			d.volumes[m.Source] = &volume.Volume{Name: m.Source, Driver: "local"}
+		}
+	}
+
+	if d.onCreate != nil {
+		d.onCreate(c)
+	}
+	d.containers[c.ID] = c
+
+	writeDockerJSON(w, http.StatusCreated, container.CreateResponse{ID: c.ID})
+}
+
+func (d *fakeDocker) listContainers(w http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		dockerError(w, http.StatusBadRequest, "invalid filters: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	containers := []types.Container{}
+	for _, c := range d.containers {
+		if !matchesLabelFilters(c.Config.Labels, args) {
+			continue
+		}
+
+		containers = append(containers, types.Container{
+			ID:     c.ID,
+			Names:  []string{"/" + c.Name},
+			Image:  c.Config.Image,
+			Labels: c.Config.Labels,
+			State:  c.Status,
+		})
+	}
+
+	writeDockerJSON(w, http.StatusOK, containers)
+}
+
+func matchesLabelFilters(labels map[string]string, args filters.Args) bool {
+	for _, filter := range args.Get("label") {
+		key, value, hasValue := strings.Cut(filter, "=")
+		actual, ok := labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *fakeDocker) inspectContainer(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := d.find(r.PathValue("id"))
+	if c == nil {
+		dockerError(w, http.StatusNotFound, "No such container: %s", r.PathValue("id"))
+		return
+	}
+
+	var mounts []types.MountPoint
+	for _, m := range c.HostConfig.Mounts {
+		mounts = append(mounts, types.MountPoint{Type: m.Type, Name: m.Source, Source: m.Source, Destination: m.Target})
+	}
+
+	config := c.Config
+	hostConfig := c.HostConfig
+	networkSettings := &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{}}
+	if c.Status == "running" {
+		networkSettings.Networks["bridge"] = &network.EndpointSettings{IPAddress: c.IP}
+	}
+
+	writeDockerJSON(w, http.StatusOK, types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         c.ID,
+			Name:       "/" + c.Name,
+			Created:    time.Now().Format(time.RFC3339Nano),
+			Image:      c.Config.Image,
+			HostConfig: &hostConfig,
+			State: &types.ContainerState{
+				Status:   c.Status,
+				Running:  c.Status == "running",
+				Paused:   c.Status == "paused",
+				ExitCode: c.ExitCode,
+			},
+		},
+		Mounts:          mounts,
+		Config:          &config,
+		NetworkSettings: networkSettings,
+	})
+}
+
+func (d *fakeDocker) startContainer(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := d.find(r.PathValue("id"))
+	if c == nil {
+		dockerError(w, http.StatusNotFound, "No such container: %s", r.PathValue("id"))
+		return
+	}
+
+	if c.Status == "running" {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	c.Status = "running"
+	c.IP = d.containerIP
+	if d.onStart != nil {
+		d.onStart(c)
+	}
+
+	if len(c.Config.Cmd) > 0 && c.Status == "running" {
+		d.runCommand(c)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runCommand runs the command of a container on the host, in the directory that is bind mounted at its working
+// directory and with only the environment of the container. d.mu has to be held
+func (d *fakeDocker) runCommand(c *fakeContainer) {
+	var dir string
+	for _, m := range c.HostConfig.Mounts {
+		if m.Type == mount.TypeBind && m.Target == c.Config.WorkingDir {
+			dir = m.Source
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.kill = cancel
+	c.exited = make(chan struct{})
+
+	cmd := exec.CommandContext(ctx, c.Config.Cmd[0], c.Config.Cmd[1:]...)
+	cmd.Dir = dir
+	// docker sets a PATH for images that don't
+	cmd.Env = append([]string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}, c.Config.Env...)
+	cmd.Stdout = lockedWriter{&d.mu, stdcopy.NewStdWriter(&c.output, stdcopy.Stdout)}
+	cmd.Stderr = lockedWriter{&d.mu, stdcopy.NewStdWriter(&c.output, stdcopy.Stderr)}
+
+	go func() {
+		defer close(c.exited)
+
+		err := cmd.Run()
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		c.Status = "exited"
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			c.ExitCode = exitErr.ExitCode()
+		} else if err != nil {
+			c.ExitCode = 127
+			fmt.Fprintln(stdcopy.NewStdWriter(&c.output, stdcopy.Stderr), err)
+		}
+	}()
+}
+
+// lockedWriter writes to w while holding mu
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+// exited returns the channel that is closed once the command of a container exited, nil when it doesn't run one
+func (d *fakeDocker) exited(id string) (*fakeContainer, <-chan struct{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := d.find(id)
+	if c == nil {
+		return nil, nil, false
+	}
+
+	return c, c.exited, true
+}
+
+// containerLogs returns the whole output of a container once its command exited, like following its logs
+func (d *fakeDocker) containerLogs(w http.ResponseWriter, r *http.Request) {
+	c, exited, ok := d.exited(r.PathValue("id"))
+	if !ok {
+		dockerError(w, http.StatusNotFound, "No such container: %s", r.PathValue("id"))
+		return
+	}
+
+	if exited != nil {
+		select {
+		case <-exited:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	d.mu.Lock()
+	output := bytes.Clone(c.output.Bytes())
+	d.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+func (d *fakeDocker) waitContainer(w http.ResponseWriter, r *http.Request) {
+	c, exited, ok := d.exited(r.PathValue("id"))
+	if !ok {
+		dockerError(w, http.StatusNotFound, "No such container: %s", r.PathValue("id"))
+		return
+	}
+
+	if exited != nil {
+		select {
+		case <-exited:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	writeDockerJSON(w, http.StatusOK, container.WaitResponse{StatusCode: int64(c.ExitCode)})
+}
+
+// stopContainer sends the stop signal and, like docker, kills a container that is still running once the timeout has
+// passed. The timeout defaults to 10 seconds
+func (d *fakeDocker) stopContainer(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	c := d.find(r.PathValue("id"))
+	if c == nil {
+		d.mu.Unlock()
+		dockerError(w, http.StatusNotFound, "No such container: %s", r.PathValue("id"))
+		return
+	}
+
+	if c.Status != "running" {
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	stop := fakeStop{
+		Signal:  r.URL.Query().Get("signal"),
+		Timeout: 10,
+	}
+	if t := r.URL.Query().Get("t"); t != "" {
+		stop.Timeout, _ = strconv.Atoi(t)
+	}
+	ignoresStop := c.IgnoresStop && strings.TrimPrefix(strings.ToUpper(stop.Signal), "SIG") != "KILL"
+	d.mu.Unlock()
+
+	started := time.Now()
+	if ignoresStop {
+		select {
+		case <-time.After(time.Duration(stop.Timeout) * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stop.Killed = ignoresStop
+	stop.Took = time.Since(started)
+	c.Stops = append(c.Stops, stop)
+	c.Status = "exited"
+	if stop.Killed {
+		c.ExitCode = 137
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *fakeDocker) renameContainer(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := d.find(r.PathValue("id"))
+	if c == nil {
+		dockerError(w, http.StatusNotFound, "No such container: %s", r.PathValue("id"))
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if other := d.find(name); other != nil && other != c {
+		dockerError(w, http.StatusConflict, `Conflict. The container name "/%s" is already in use`, name)
+		return
+	}
+
+	c.Name = name
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *fakeDocker) removeContainer(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := d.find(r.PathValue("id"))
+	if c == nil {
+		dockerError(w, http.StatusNotFound, "No such container: %s", r.PathValue("id"))
+		return
+	}
+
+	if c.Status == "running" && r.URL.Query().Get("force") != "1" {
+		dockerError(w, http.StatusConflict, "cannot remove container %s: container is running, stop the container before removing or force remove", c.ID[:12])
+		return
+	}
+
+	if c.kill != nil {
+		c.kill()
+	}
+	delete(d.containers, c.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *fakeDocker) createVolume(w http.ResponseWriter, r *http.Request) {
+	var options volume.CreateOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		dockerError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if v, ok := d.volumes[options.Name]; ok {
+		writeDockerJSON(w, http.StatusCreated, v)
+		return
+	}
+
+	name := options.Name
+	if name == "" {
+		name = randomID()
+	}
+
+	v := &volume.Volume{Name: name, Driver: "local", Mountpoint: "/var/lib/docker/volumes/" + name + "/_data", Labels: options.Labels}
+	d.volumes[name] = v
+
+	writeDockerJSON(w, http.StatusCreated, v)
+}
+
+func (d *fakeDocker) listVolumes(w http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		dockerError(w, http.StatusBadRequest, "invalid filters: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	response := volume.ListResponse{Volumes: []*volume.Volume{}}
+	for _, v := range d.volumes {
+		if matchesLabelFilters(v.Labels, args) {
+			response.Volumes = append(response.Volumes, v)
+		}
+	}
+
+	writeDockerJSON(w, http.StatusOK, response)
+}
+
+// removeVolume refuses to remove a volume that is still mounted by a container, even when forced, like docker does
+func (d *fakeDocker) removeVolume(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := r.PathValue("name")
+	if _, ok := d.volumes[name]; !ok {
+		dockerError(w, http.StatusNotFound, "get %s: no such volume", name)
+		return
+	}
+
+	for _, c := range d.containers {
+		for _, m := range c.HostConfig.Mounts {
+			if m.Source == name {
+				dockerError(w, http.StatusConflict, "remove %s: volume is in use - [%s]", name, c.ID)
+				return
+			}
+		}
+	}
+
+	delete(d.volumes, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// imageTag adds the latest tag to an image name without a tag
+func imageTag(name string) string {
+	if strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		return name
+	}
+
+	return name + ":latest"
+}
+
+// addImage adds a local image that exposes ports
+func (d *fakeDocker) addImage(name string, ports ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.images[imageTag(name)] = fakeImage(name, ports)
+}
+
+// addRemoteImage adds an image that isn't available locally, but can be pulled
+func (d *fakeDocker) addRemoteImage(name string, ports ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.registry[imageTag(name)] = fakeImage(name, ports)
+}
+
+func fakeImage(name string, ports []string) *types.ImageInspect {
+	exposedPorts := make(nat.PortSet)
+	for _, port := range ports {
+		exposedPorts[nat.Port(port)] = struct{}{}
+	}
+
+	return &types.ImageInspect{
+		ID:       "sha256:" + randomID(),
+		RepoTags: []string{imageTag(name)},
+		Config:   &container.Config{ExposedPorts: exposedPorts},
+	}
+}
+
+func (d *fakeDocker) pullImage(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	image, ok := d.registry[name]
+	if !ok {
+		dockerError(w, http.StatusNotFound, "pull access denied for %s, repository does not exist", name)
+		return
+	}
+	d.images[name] = image
+
+	writeDockerJSON(w, http.StatusOK, map[string]string{"status": "Downloaded newer image for " + name})
+}
+
+func (d *fakeDocker) inspectImage(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/json")
+	if !ok {
+		dockerError(w, http.StatusNotFound, "page not found")
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	image, ok := d.images[imageTag(name)]
+	if !ok {
+		dockerError(w, http.StatusNotFound, "No such image: %s", name)
+		return
+	}
+
+	writeDockerJSON(w, http.StatusOK, image)
+}
+
+func (d *fakeDocker) tagImage(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/tag")
+	if !ok {
+		dockerError(w, http.StatusNotFound, "page not found")
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	image, ok := d.images[imageTag(name)]
+	if !ok {
+		dockerError(w, http.StatusNotFound, "No such image: %s", name)
+		return
+	}
+
+	target := r.URL.Query().Get("repo") + ":" + r.URL.Query().Get("tag")
+	tagged := *image
+	tagged.RepoTags = append(slices.Clone(image.RepoTags), target)
+	d.images[target] = &tagged
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// saveImage writes a stand-in for the archive of an image, which only names the image
+func (d *fakeDocker) saveImage(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("names")
+
+	d.mu.Lock()
+	_, ok := d.images[imageTag(name)]
+	d.mu.Unlock()
+	if !ok {
+		dockerError(w, http.StatusNotFound, "No such image: %s", name)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	io.WriteString(w, "archive of "+name)
+}
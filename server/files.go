@@ -0,0 +1,90 @@
+package server
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// verifyChecksum makes sure that the sha256 of an uploaded file matches the hex encoded checksum that the client sent,
+// and rewinds the file so that it can be read again
+func verifyChecksum(file io.ReadSeeker, checksum string) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, checksum) {
+		return fmt.Errorf("expected sha256 %s, received %s", checksum, actual)
+	}
+
+	return nil
+}
+
+// renameArchiveRoot rewrites a tar archive with a single root, such as the ones that docker cp produces, so that its
+// root is called newRoot. The returned reader has to be closed
+func renameArchiveRoot(r io.Reader, newRoot string) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	rename := func(name string) (string, string) {
+		root, rest, nested := strings.Cut(strings.TrimPrefix(name, "./"), "/")
+		if !nested {
+			return root, newRoot
+		}
+
+		return root, newRoot + "/" + rest
+	}
+
+	go func() {
+		tarReader := tar.NewReader(r)
+		tarWriter := tar.NewWriter(pipeWriter)
+
+		var archiveRoot string
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+
+			root, name := rename(header.Name)
+			if archiveRoot == "" {
+				archiveRoot = root
+			}
+
+			if root != archiveRoot {
+				pipeWriter.CloseWithError(fmt.Errorf("the archive has more than one root, %s and %s", archiveRoot, root))
+				return
+			}
+
+			header.Name = name
+			if header.Typeflag == tar.TypeLink {
+				_, header.Linkname = rename(header.Linkname)
+			}
+
+			if err := tarWriter.WriteHeader(header); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+
+			if _, err := io.Copy(tarWriter, tarReader); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+
+		pipeWriter.CloseWithError(tarWriter.Close())
+	}()
+
+	return pipeReader
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+// docker names the volumes that aren't given a name with 64 hex characters
+var generatedVolumeRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ForceDeleteApp removes everything that flux has of an app, even when its database rows are missing or don't match
+// docker anymore. Its containers are found by their labels as well as in the database, every step is attempted and
+// the errors of all of them are returned together. Named volumes are kept, like when an app is deleted. The name in
+// the labels is the one an app had when the container was created, so whatever another app owns is never removed,
+// the app may have been renamed since
+func (am *AppManager) ForceDeleteApp(ctx context.Context, name string) error {
+	log := appLogger(name)
+
+	var errs []error
+	var projectConfig pkg.ProjectConfig
+	if app := am.GetApp(name); app != nil {
+		projectConfig = app.Deployment.config()
+		Flux.proxy.RemoveDeployment(app.Deployment)
+	}
+	am.Delete(name)
+	am.broken.Delete(name)
+
+	deploymentID, containerIDs, volumeIDs, config, err := trackedResources(name)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to read the app from the database: %v", err))
+	}
+	if config.Name != "" {
+		projectConfig = config
+	}
+
+	for _, filter := range ownerFilters(name, deploymentID) {
+		labeled, err := Flux.dockerClient.ContainerList(ctx, container.ListOptions{All: true, Filters: filter})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list containers: %v", err))
+		}
+		for _, c := range labeled {
+			owned, err := ownedElsewhere(c.Labels, containerOwnerQuery, []byte(c.ID), deploymentID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to look up the owner of container (%s): %v", c.ID[:12], err))
+				continue
+			}
+			if owned {
+				log.Infow("Keeping container of another app", zap.String("container_id", c.ID[:12]))
+				continue
+			}
+
+			containerIDs = append(containerIDs, c.ID)
+		}
+	}
+
+	removedContainers := make(map[string]bool)
+	for _, containerID := range containerIDs {
+		if removedContainers[containerID] {
+			continue
+		}
+		removedContainers[containerID] = true
+
+		containerJSON, err := Flux.dockerClient.ContainerInspect(ctx, containerID)
+		if client.IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to inspect container (%s): %v", containerID[:12], err))
+			continue
+		}
+
+		for _, m := range containerJSON.Mounts {
+			if m.Type == mount.TypeVolume {
+				volumeIDs = append(volumeIDs, m.Name)
+			}
+		}
+
+		log.Infow("Force removing container", zap.String("container_id", containerID[:12]))
+		if err := Flux.dockerClient.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to remove container (%s): %v", containerID[:12], err))
+		}
+	}
+
+	volumeLabels := make(map[string]map[string]string)
+	for _, filter := range ownerFilters(name, deploymentID) {
+		volumes, err := Flux.dockerClient.VolumeList(ctx, volume.ListOptions{Filters: filter})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list volumes: %v", err))
+		}
+		for _, v := range volumes.Volumes {
+			volumeIDs = append(volumeIDs, v.Name)
+			volumeLabels[v.Name] = v.Labels
+		}
+	}
+
+	removedVolumes := make(map[string]bool)
+	for _, volumeID := range volumeIDs {
+		if removedVolumes[volumeID] || isNamedVolume(projectConfig, volumeID) || !generatedVolumeRegex.MatchString(volumeID) {
+			continue
+		}
+		removedVolumes[volumeID] = true
+
+		// a volume that was mounted in one of the containers can still be shared with another app's
+		owned, err := ownedElsewhere(volumeLabels[volumeID], volumeOwnerQuery, volumeID, deploymentID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to look up the owner of volume (%s): %v", volumeID, err))
+			continue
+		}
+		if owned {
+			log.Infow("Keeping volume of another app", zap.String("volume_id", volumeID))
+			continue
+		}
+
+		log.Infow("Force removing volume", zap.String("volume_id", volumeID))
+		if err := Flux.dockerClient.VolumeRemove(ctx, volumeID, true); err != nil && !client.IsErrNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to remove volume (%s): %v", volumeID, err))
+		}
+	}
+
+	if err := purgeApp(name, deploymentID); err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove the app from the database: %v", err))
+	}
+
+	if err := os.RemoveAll(filepath.Join(Flux.rootDir, "apps", name)); err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove project directory: %v", err))
+	}
+
+	if err := Flux.logStore.Remove(name); err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove stored logs: %v", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ownerFilters returns the label filters that find the containers and volumes of an app, by its name and, when the
+// database still has it, by its deployment
+func ownerFilters(name string, deploymentID int64) []filters.Args {
+	byName := filters.NewArgs(filters.Arg("label", managedLabel+"=true"), filters.Arg("label", appLabel+"="+name))
+	if deploymentID == 0 {
+		return []filters.Args{byName}
+	}
+
+	return []filters.Args{
+		byName,
+		filters.NewArgs(filters.Arg("label", managedLabel+"=true"), filters.Arg("label", fmt.Sprintf("%s=%d", deploymentLabel, deploymentID))),
+	}
+}
+
+const (
+	// the deployment that the database tracks a container for
+	containerOwnerQuery = "SELECT deployment_id FROM containers WHERE container_id = ?"
+	// the deployment of the container that the database tracks a volume for
+	volumeOwnerQuery = "SELECT containers.deployment_id FROM volumes JOIN containers ON containers.container_id = volumes.container_id WHERE volumes.volume_id = ?"
+)
+
+// ownedElsewhere reports whether a container or volume belongs to another deployment than deploymentID, either
+// because the database tracks it for one, or because its labels name a deployment that still has an app
+func ownedElsewhere(labels map[string]string, ownerQuery string, id any, deploymentID int64) (bool, error) {
+	var trackedBy int64
+	err := Flux.db.QueryRow(ownerQuery, id).Scan(&trackedBy)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if err == nil && trackedBy != deploymentID {
+		return true, nil
+	}
+
+	labeledBy, ok := labeledDeployment(labels)
+	if !ok || labeledBy == deploymentID {
+		return false, nil
+	}
+
+	var appID int64
+	err = Flux.db.QueryRow("SELECT id FROM apps WHERE deployment_id = ?", labeledBy).Scan(&appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// trackedResources returns what the database has of an app, whatever of it is still there
+func trackedResources(name string) (deploymentID int64, containerIDs []string, volumeIDs []string, projectConfig pkg.ProjectConfig, err error) {
+	var nullableDeploymentID sql.NullInt64
+	err = Flux.db.QueryRow("SELECT deployment_id FROM apps WHERE name = ?", name).Scan(&nullableDeploymentID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !nullableDeploymentID.Valid) {
+		return 0, nil, nil, projectConfig, nil
+	}
+	if err != nil {
+		return 0, nil, nil, projectConfig, err
+	}
+	deploymentID = nullableDeploymentID.Int64
+
+	var configJSON string
+	if err := Flux.db.QueryRow("SELECT config FROM deployments WHERE id = ?", deploymentID).Scan(&configJSON); err == nil {
+		// a config that doesn't decode only means that named volumes are told apart by their name alone
+		json.Unmarshal([]byte(configJSON), &projectConfig)
+	}
+
+	rows, err := Flux.db.Query("SELECT container_id FROM containers WHERE deployment_id = ?", deploymentID)
+	if err != nil {
+		return deploymentID, nil, nil, projectConfig, err
+	}
+	for rows.Next() {
+		var containerID string
+		if err := rows.Scan(&containerID); err != nil {
+			rows.Close()
+			return deploymentID, containerIDs, nil, projectConfig, err
+		}
+		containerIDs = append(containerIDs, containerID)
+	}
+	rows.Close()
+
+	rows, err = Flux.db.Query("SELECT volume_id FROM volumes WHERE container_id IN (SELECT container_id FROM containers WHERE deployment_id = ?)", deploymentID)
+	if err != nil {
+		return deploymentID, containerIDs, nil, projectConfig, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var volumeID string
+		if err := rows.Scan(&volumeID); err != nil {
+			return deploymentID, containerIDs, volumeIDs, projectConfig, err
+		}
+		volumeIDs = append(volumeIDs, volumeID)
+	}
+
+	return deploymentID, containerIDs, volumeIDs, projectConfig, rows.Err()
+}
+
+// purgeApp deletes every database row of an app in a single transaction
+func purgeApp(name string, deploymentID int64) error {
+	tx, err := Flux.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	statements := []struct {
+		query string
+		arg   any
+	}{
+		{"DELETE FROM volumes WHERE container_id IN (SELECT container_id FROM containers WHERE deployment_id = ?)", deploymentID},
+		{"DELETE FROM containers WHERE deployment_id = ?", deploymentID},
+		{"DELETE FROM apps WHERE name = ?", name},
+		{"DELETE FROM deployments WHERE id = ?", deploymentID},
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.arg); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
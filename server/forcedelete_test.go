@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/juls0730/flux/pkg"
+)
+
+func TestForceDeleteAppWithVolumes(t *testing.T) {
+	docker := newTestServer(t)
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.Volumes = []pkg.VolumeConfig{
+		{Target: "/data"},
+		{Source: "shared", Target: "/shared"},
+	}
+
+	app := createTestApp(t, projectConfig)
+	projectPath := filepath.Join(Flux.rootDir, "apps", projectConfig.Name)
+
+	head := app.Deployment.head()
+	generated := findVolume(head.Volumes, "/data").VolumeID
+	if got := countRows(t, "volumes", "container_id = ?", head.ContainerID[:]); got != 2 {
+		t.Fatalf("expected the head to own 2 volumes, got %d", got)
+	}
+
+	if err := Flux.appManager.ForceDeleteApp(context.Background(), projectConfig.Name); err != nil {
+		t.Fatalf("failed to force delete app: %v", err)
+	}
+
+	for _, table := range []string{"apps", "deployments", "containers", "volumes"} {
+		if got := countRows(t, table, "1 = 1"); got != 0 {
+			t.Errorf("expected no rows in %s, got %d", table, got)
+		}
+	}
+
+	if ids := docker.containerIDs(); len(ids) != 0 {
+		t.Errorf("expected every container to be removed, %d are left", len(ids))
+	}
+
+	if docker.hasVolume(generated) {
+		t.Errorf("expected the generated volume %s to be removed", generated)
+	}
+
+	if !docker.hasVolume("shared") {
+		t.Errorf("expected the named volume to be kept")
+	}
+
+	if _, err := os.Stat(projectPath); !os.IsNotExist(err) {
+		t.Errorf("expected the project directory to be removed, got %v", err)
+	}
+}
+
+// containers and volumes keep the name that the app had when they were created, force deleting that name after the
+// app was renamed must not take the renamed app's resources with it
+func TestForceDeleteAppAfterRename(t *testing.T) {
+	docker := newTestServer(t)
+
+	projectConfig := testProjectConfig("app")
+	projectConfig.Replicas = 2
+	projectConfig.Volumes = []pkg.VolumeConfig{{Target: "/data"}}
+
+	app := createTestApp(t, projectConfig)
+	if err := app.Rename(context.Background(), "renamed"); err != nil {
+		t.Fatalf("failed to rename app: %v", err)
+	}
+
+	containerIDs := docker.containerIDs()
+	generated := findVolume(app.Deployment.head().Volumes, "/data").VolumeID
+
+	// a leftover of an earlier app with the old name, which nothing owns anymore
+	leftover := docker.addContainer("app-leftover", container.Config{Labels: ownerLabels(app.Deployment.ID+1, "app")}, container.HostConfig{})
+
+	if err := Flux.appManager.ForceDeleteApp(context.Background(), "app"); err != nil {
+		t.Fatalf("failed to force delete app: %v", err)
+	}
+
+	if docker.container(leftover.ID) != nil {
+		t.Errorf("expected the leftover of the old name to be removed")
+	}
+
+	for _, containerID := range containerIDs {
+		if docker.container(containerID) == nil {
+			t.Errorf("expected container %s of the renamed app to be kept", containerID[:12])
+		}
+	}
+
+	if !docker.hasVolume(generated) {
+		t.Errorf("expected the volume %s of the renamed app to be kept", generated)
+	}
+
+	for table, want := range map[string]int{"apps": 1, "deployments": 1, "containers": 2, "volumes": 1} {
+		if got := countRows(t, table, "1 = 1"); got != want {
+			t.Errorf("expected %d rows in %s, got %d", want, table, got)
+		}
+	}
+
+	if Flux.appManager.GetApp("renamed") != app {
+		t.Errorf("expected the renamed app to be kept")
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+var healthCheckClient = &http.Client{
+	Timeout: 5 * time.Second,
+}
+
+// healthCheckConfig fills in the defaults for everything the app did not configure
+func healthCheckConfig(configured *pkg.HealthCheck) pkg.HealthCheck {
+	var healthCheck pkg.HealthCheck
+	if configured != nil {
+		healthCheck = *configured
+	}
+
+	if healthCheck.Path == "" {
+		healthCheck.Path = "/"
+	}
+
+	if healthCheck.Interval <= 0 {
+		healthCheck.Interval = 10
+	}
+
+	if healthCheck.Threshold <= 0 {
+		healthCheck.Threshold = 3
+	}
+
+	if healthCheck.StabilizationWindow == 0 {
+		healthCheck.StabilizationWindow = 5
+	}
+
+	return healthCheck
+}
+
+// protocol returns the protocol that the app is served with
+func protocol(projectConfig pkg.ProjectConfig) string {
+	if projectConfig.Protocol == "" {
+		return pkg.ProtocolHTTP
+	}
+
+	return projectConfig.Protocol
+}
+
+// upstreamURL returns the address of a container, its scheme is the protocol that the app speaks
+func upstreamURL(ip string, port uint16, protocol string) *url.URL {
+	return &url.URL{Scheme: protocol, Host: net.JoinHostPort(ip, strconv.Itoa(int(port)))}
+}
+
+// checkHealth periodically checks every upstream of the proxy, taking upstreams out of the rotation once they fail
+// enough checks in a row and putting them back once they pass again. It runs until the proxy is replaced or removed
+func (dp *DeploymentProxy) checkHealth() {
+	healthCheck := healthCheckConfig(dp.deployment.config().HealthCheck)
+	log := appLogger(dp.deployment.config().Name)
+
+	failures := make([]int, len(dp.upstreams))
+	ticker := time.NewTicker(time.Duration(healthCheck.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if dp.deployment.proxy() != dp {
+			return
+		}
+
+		// the containers are stopped or frozen on purpose while the deployment is idle or paused
+		if dp.deployment.suspended.Load() || dp.deployment.paused.Load() {
+			continue
+		}
+
+		for i, upstream := range dp.upstreams {
+			if checkUpstream(upstream, healthCheck.Path) {
+				failures[i] = 0
+				if !dp.healthy[i].Swap(true) {
+					log.Infow("Container is healthy again", zap.String("upstream", upstream.Host))
+				}
+
+				continue
+			}
+
+			failures[i]++
+			if failures[i] >= healthCheck.Threshold && dp.healthy[i].Swap(false) {
+				log.Warnw("Container failed its health checks, no longer routing traffic to it", zap.String("upstream", upstream.Host), zap.Int("failures", failures[i]))
+			}
+		}
+	}
+}
+
+// checkUpstream checks that the app is up, tcp and grpc apps only have to accept connections since a plain http
+// request means nothing to them
+func checkUpstream(upstream *url.URL, path string) bool {
+	if upstream.Scheme == pkg.ProtocolTCP || upstream.Scheme == pkg.ProtocolGRPC {
+		conn, err := net.DialTimeout("tcp", upstream.Host, healthCheckClient.Timeout)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+
+		return true
+	}
+
+	resp, err := healthCheckClient.Get(upstream.String() + path)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode < http.StatusBadRequest
+}
+
+// waitForStability makes sure that containers which just became ready stay running and healthy for the stabilization
+// window, so that an app which crashes right after starting up never receives traffic
+func waitForStability(ctx context.Context, containers []*Container, projectConfig pkg.ProjectConfig) error {
+	healthCheck := healthCheckConfig(projectConfig.HealthCheck)
+	if healthCheck.StabilizationWindow < 0 {
+		return nil
+	}
+
+	// docker restarts crashed containers, so a crash has to be detected through the restart count as well
+	restartCounts := make(map[*Container]int)
+	for _, container := range containers {
+		containerJSON, err := Flux.dockerClient.ContainerInspect(ctx, string(container.ContainerID[:]))
+		if err != nil {
+			return err
+		}
+
+		restartCounts[container] = containerJSON.RestartCount
+	}
+
+	window := time.Duration(healthCheck.StabilizationWindow) * time.Second
+	deadline := time.Now().Add(window)
+	for {
+		for _, container := range containers {
+			containerJSON, err := Flux.dockerClient.ContainerInspect(ctx, string(container.ContainerID[:]))
+			if err != nil {
+				return err
+			}
+
+			if !containerJSON.State.Running {
+				return fmt.Errorf("container %s exited with code %d within %s of becoming ready", container.ContainerID[:12], containerJSON.State.ExitCode, window)
+			}
+
+			if containerJSON.RestartCount != restartCounts[container] {
+				return fmt.Errorf("container %s crashed and was restarted within %s of becoming ready", container.ContainerID[:12], window)
+			}
+
+			upstream := upstreamURL(containerIP(containerJSON), projectConfig.Port, protocol(projectConfig))
+			if !checkUpstream(upstream, healthCheck.Path) {
+				return fmt.Errorf("container %s failed a health check within %s of becoming ready", container.ContainerID[:12], window)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
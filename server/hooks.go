@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"go.uber.org/zap"
+)
+
+// Exec runs command with sh inside the container and writes its output to output, it fails if the command exits
+// non-zero
+func (c *Container) Exec(ctx context.Context, command string, output io.Writer) error {
+	containerID := string(c.ContainerID[:])
+
+	execResp, err := Flux.dockerClient.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          []string{"sh", "-c", command},
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create exec: %v", err)
+	}
+
+	attachResp, err := Flux.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to attach to exec: %v", err)
+	}
+	defer attachResp.Close()
+
+	if _, err := stdcopy.StdCopy(output, output, attachResp.Reader); err != nil {
+		return fmt.Errorf("failed to read exec output: %v", err)
+	}
+
+	inspect, err := Flux.dockerClient.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect exec: %v", err)
+	}
+
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("exited with code %d", inspect.ExitCode)
+	}
+
+	return nil
+}
+
+// runHook runs a deploy hook in c, streaming its output into eventChannel as the output of stage
+func runHook(ctx context.Context, c *Container, stage string, command string, eventChannel chan<- DeploymentEvent) error {
+	log := appLogger(c.Deployment.config().Name)
+	log.Debugw("Running deploy hook", zap.String("stage", stage), zap.ByteString("container_id", c.ContainerID[:12]))
+
+	eventChannel <- DeploymentEvent{
+		Stage:   stage,
+		Message: fmt.Sprintf("Running %s hook", stage),
+	}
+
+	output, wait := streamOutput(stage, eventChannel)
+	err := c.Exec(ctx, command, output)
+	wait()
+
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v", stage, err)
+	}
+
+	return nil
+}
+
+// streamOutput returns a writer that sends every line written to it into eventChannel as the output of stage. wait has
+// to be called once nothing writes to it anymore, it returns after the last line was sent
+func streamOutput(stage string, eventChannel chan<- DeploymentEvent) (output io.Writer, wait func()) {
+	pipeReader, pipeWriter := io.Pipe()
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+
+		scanner := bufio.NewScanner(pipeReader)
+		for scanner.Scan() {
+			eventChannel <- DeploymentEvent{
+				Stage:       "cmd_output",
+				OutputStage: stage,
+				Message:     scanner.Text(),
+			}
+		}
+
+		// drain whatever is left so the writer is never blocked on a line that was too long
+		io.Copy(io.Discard, pipeReader)
+	}()
+
+	return pipeWriter, func() {
+		pipeWriter.Close()
+		<-streamDone
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/distribution/reference"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+func validatePullPolicy(policy string) error {
+	switch policy {
+	case "", pkg.PullPolicyAlways, pkg.PullPolicyIfNotPresent, pkg.PullPolicyNever:
+		return nil
+	default:
+		return fmt.Errorf("image_pull_policy must be %s, %s, or %s, got %q", pkg.PullPolicyAlways, pkg.PullPolicyIfNotPresent, pkg.PullPolicyNever, policy)
+	}
+}
+
+// pullPolicy returns the pull policy of an app, apps without one use the daemon's
+func (s *FluxServer) pullPolicy(projectConfig pkg.ProjectConfig) string {
+	if projectConfig.ImagePullPolicy != "" {
+		return projectConfig.ImagePullPolicy
+	}
+
+	return s.config.ImagePullPolicy
+}
+
+// needsPull reports whether an image has to be pulled before it is used under policy. An empty policy pulls images
+// that are missing, it fails if the image is missing and the policy forbids pulling
+func needsPull(ctx context.Context, imageName string, policy string) (bool, error) {
+	switch policy {
+	case pkg.PullPolicyAlways:
+		return true, nil
+	case pkg.PullPolicyNever:
+		if !imageExists(ctx, imageName) {
+			return false, fmt.Errorf("image %s is not available locally and image_pull_policy is %s", imageName, pkg.PullPolicyNever)
+		}
+
+		return false, nil
+	default:
+		return !imageExists(ctx, imageName), nil
+	}
+}
+
+// validateImage checks the prebuilt image of an app, the settings that only matter to a build would silently be
+// ignored next to it
+func validateImage(projectConfig pkg.ProjectConfig) error {
+	if projectConfig.Image == "" {
+		return nil
+	}
+
+	if _, err := reference.ParseNormalizedNamed(projectConfig.Image); err != nil {
+		return fmt.Errorf("invalid image %q: %v", projectConfig.Image, err)
+	}
+
+	if projectConfig.Builder != "" || len(projectConfig.BuildArgs) > 0 || len(projectConfig.Prepare) > 0 || projectConfig.PrepareImage != "" {
+		return fmt.Errorf("image can't be combined with builder, build_args, prepare, or prepare_image, a prebuilt image isn't built")
+	}
+
+	return nil
+}
+
+// usePrebuiltImage pulls the prebuilt image of an app if its pull policy asks for it, and tags it as imageName so that
+// the rest of the deploy treats it like an image that was built from the app's code
+func (s *FluxServer) usePrebuiltImage(ctx context.Context, imageName string, projectConfig pkg.ProjectConfig, eventChannel chan<- DeploymentEvent, log *zap.SugaredLogger) error {
+	pull, err := needsPull(ctx, projectConfig.Image, s.pullPolicy(projectConfig))
+	if err != nil {
+		log.Errorw("Image is unavailable", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    err.Error(),
+			StatusCode: http.StatusBadRequest,
+		}
+
+		return err
+	}
+
+	if pull {
+		log.Infow("Pulling image", zap.String("image", projectConfig.Image))
+		eventChannel <- DeploymentEvent{
+			Stage:   "pulling_image",
+			Message: fmt.Sprintf("Pulling image %s", projectConfig.Image),
+		}
+
+		if err := pullImage(ctx, projectConfig.Image); err != nil {
+			log.Errorw("Failed to pull image", zap.Error(err))
+			eventChannel <- DeploymentEvent{
+				Stage:      "error",
+				Message:    fmt.Sprintf("Failed to pull image %s: %s", projectConfig.Image, err),
+				StatusCode: errorStatus(err),
+			}
+
+			return err
+		}
+	}
+
+	if err := Flux.dockerClient.ImageTag(ctx, projectConfig.Image, imageName); err != nil {
+		log.Errorw("Failed to tag image", zap.Error(err))
+		eventChannel <- DeploymentEvent{
+			Stage:      "error",
+			Message:    fmt.Sprintf("Failed to tag image %s: %s", projectConfig.Image, err),
+			StatusCode: errorStatus(err),
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// docker keeps these label namespaces for itself, and flux. is used for the labels that flux sets itself
+var reservedLabelPrefixes = []string{"com.docker.", "io.docker.", "org.dockerproject.", "flux."}
+
+// labels that flux sets on every container and generated volume that it creates, so that they can be found in docker
+const (
+	managedLabel = "flux.managed"
+	// the ID of the deployment that owns the container or volume. Unlike the name it never changes for as long as the
+	// app exists, so it is what tells the resources of one app apart from another's
+	deploymentLabel = "flux.deployment"
+	// the name of the app when the container or volume was created, it is only informational since it isn't updated
+	// when the app is renamed
+	appLabel = "flux.app"
+)
+
+// ownerLabels returns the labels that mark a container or volume as owned by the deployment of an app
+func ownerLabels(deploymentID int64, appName string) map[string]string {
+	return map[string]string{
+		managedLabel:    "true",
+		deploymentLabel: strconv.FormatInt(deploymentID, 10),
+		appLabel:        appName,
+	}
+}
+
+// labeledDeployment returns the deployment that owns a container or volume by its labels, and false when it has none
+func labeledDeployment(labels map[string]string) (int64, bool) {
+	deploymentID, err := strconv.ParseInt(labels[deploymentLabel], 10, 64)
+	return deploymentID, err == nil
+}
+
+// containerLabels returns the labels of the app along with the labels that mark its containers as owned by flux
+func containerLabels(projectConfig pkg.ProjectConfig, deploymentID int64) map[string]string {
+	labels := maps.Clone(projectConfig.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	maps.Copy(labels, ownerLabels(deploymentID, projectConfig.Name))
+
+	return labels
+}
+
+func validateLabels(labels map[string]string) error {
+	for key := range labels {
+		if key == "" {
+			return fmt.Errorf("label keys must not be empty")
+		}
+
+		// labels are filtered on with key=value
+		if strings.ContainsAny(key, "=,") {
+			return fmt.Errorf("label key %q must not contain = or ,", key)
+		}
+
+		for _, prefix := range reservedLabelPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return fmt.Errorf("label key %q uses the reserved %s namespace", key, strings.TrimSuffix(prefix, "."))
+			}
+		}
+	}
+
+	return nil
+}
+
+// labelSelector matches apps that have a label, and when hasValue is set only if the label has that value
+type labelSelector struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// parseLabelSelectors parses selectors in the form of key or key=value
+func parseLabelSelectors(selectors []string) ([]labelSelector, error) {
+	parsed := make([]labelSelector, 0, len(selectors))
+	for _, selector := range selectors {
+		key, value, hasValue := strings.Cut(selector, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid label selector %q, expected key or key=value", selector)
+		}
+
+		parsed = append(parsed, labelSelector{key: key, value: value, hasValue: hasValue})
+	}
+
+	return parsed, nil
+}
+
+// matchLabels reports whether labels satisfy every selector
+func matchLabels(labels map[string]string, selectors []labelSelector) bool {
+	for _, selector := range selectors {
+		value, ok := labels[selector.key]
+		if !ok || (selector.hasValue && value != selector.value) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,482 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+const currentLogFile = "current.log"
+
+// logConfig fills in the defaults of a log config, 10 megabytes for 7 days
+func logConfig(cfg *pkg.LogConfig) pkg.LogConfig {
+	config := pkg.LogConfig{
+		MaxSize: 10,
+		MaxAge:  7,
+	}
+
+	if cfg == nil {
+		return config
+	}
+
+	if cfg.MaxSize > 0 {
+		config.MaxSize = cfg.MaxSize
+	}
+
+	if cfg.MaxAge > 0 {
+		config.MaxAge = cfg.MaxAge
+	}
+
+	return config
+}
+
+func validateLogConfig(cfg *pkg.LogConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	if cfg.MaxSize < 0 {
+		return fmt.Errorf("logs max_size must not be negative")
+	}
+
+	if cfg.MaxAge < 0 {
+		return fmt.Errorf("logs max_age must not be negative")
+	}
+
+	return nil
+}
+
+// appLogStore is a rotating file store for the output of the containers of a single app. Output is appended to
+// current.log, which is rotated once it takes up a quarter of the max size, and rotated files are removed once they
+// are too old or the store has grown past its max size
+type appLogStore struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	file    *os.File
+	size    int64
+}
+
+func (s *appLogStore) configure(cfg *pkg.LogConfig) {
+	config := logConfig(cfg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxSize = int64(config.MaxSize) << 20
+	s.maxAge = time.Duration(config.MaxAge) * 24 * time.Hour
+}
+
+func (s *appLogStore) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if s.size > 0 && s.size+int64(len(p)) > s.maxSize/4 {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *appLogStore) open() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.dir, currentLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = stat.Size()
+	return nil
+}
+
+func (s *appLogStore) rotate() error {
+	s.file.Close()
+	s.file = nil
+
+	rotated := filepath.Join(s.dir, fmt.Sprintf("%d.log", time.Now().UnixNano()))
+	if err := os.Rename(filepath.Join(s.dir, currentLogFile), rotated); err != nil {
+		return err
+	}
+
+	s.prune()
+
+	return s.open()
+}
+
+// prune removes the rotated files that are older than the max age, and then the oldest ones until the store fits in
+// its max size again
+func (s *appLogStore) prune() {
+	files, err := s.rotatedFiles()
+	if err != nil {
+		logger.Warnw("Failed to list stored logs", zap.String("dir", s.dir), zap.Error(err))
+		return
+	}
+
+	var total int64
+	var kept []os.FileInfo
+	for _, file := range files {
+		if time.Since(file.ModTime()) > s.maxAge {
+			os.Remove(filepath.Join(s.dir, file.Name()))
+			continue
+		}
+
+		total += file.Size()
+		kept = append(kept, file)
+	}
+
+	for len(kept) > 0 && total+s.size > s.maxSize {
+		os.Remove(filepath.Join(s.dir, kept[0].Name()))
+		total -= kept[0].Size()
+		kept = kept[1:]
+	}
+}
+
+// rotatedFiles returns the rotated log files, oldest first
+func (s *appLogStore) rotatedFiles() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == currentLogFile || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, info)
+	}
+
+	// rotated files are named after the time they were rotated at
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	return files, nil
+}
+
+// LogStore persists the output of the containers of apps that have logs enabled, so that it outlives the containers
+type LogStore struct {
+	// app name -> *appLogStore
+	stores sync.Map
+	// the ids of the containers whose output is currently being written to a store
+	attached sync.Map
+}
+
+func (ls *LogStore) dir(appName string) string {
+	return filepath.Join(Flux.rootDir, "logs", appName)
+}
+
+func (ls *LogStore) store(projectConfig pkg.ProjectConfig) *appLogStore {
+	value, _ := ls.stores.LoadOrStore(projectConfig.Name, &appLogStore{dir: ls.dir(projectConfig.Name)})
+	store := value.(*appLogStore)
+	// the limits can change with every deploy
+	store.configure(projectConfig.Logs)
+
+	return store
+}
+
+// Attach writes the output that a container produces from since onwards to the log store of its app in the
+// background, until the container stops. Nothing is stored for apps without logs enabled
+func (ls *LogStore) Attach(c *Container, projectConfig pkg.ProjectConfig, since time.Time) {
+	if projectConfig.Logs == nil {
+		return
+	}
+
+	containerID := string(c.ContainerID[:])
+	if _, attached := ls.attached.LoadOrStore(containerID, struct{}{}); attached {
+		return
+	}
+
+	store := ls.store(projectConfig)
+	log := appLogger(projectConfig.Name)
+
+	go func() {
+		defer ls.attached.Delete(containerID)
+
+		reader, err := Flux.dockerClient.ContainerLogs(context.Background(), containerID, container.LogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     true,
+			Timestamps: true,
+			Since:      strconv.FormatInt(since.Unix(), 10),
+		})
+		if err != nil {
+			log.Warnw("Failed to attach to container output", zap.ByteString("container_id", c.ContainerID[:12]), zap.Error(err))
+			return
+		}
+		defer reader.Close()
+
+		if _, err := stdcopy.StdCopy(store, store, reader); err != nil {
+			log.Warnw("Failed to store container output", zap.ByteString("container_id", c.ContainerID[:12]), zap.Error(err))
+		}
+	}()
+}
+
+// WriteTo writes the stored logs of an app that match opts to w, oldest first
+func (ls *LogStore) WriteTo(w io.Writer, appName string, opts logOptions) error {
+	dir := ls.dir(appName)
+	files, err := (&appLogStore{dir: dir}).rotatedFiles()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var readers []io.Reader
+	for _, file := range append(files, nil) {
+		name := currentLogFile
+		if file != nil {
+			name = file.Name()
+		}
+
+		f, err := os.Open(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		readers = append(readers, f)
+	}
+
+	if opts.since.IsZero() && opts.until.IsZero() {
+		return writeTail(w, io.MultiReader(readers...), opts.tail)
+	}
+
+	lines, err := readLogLines(io.MultiReader(readers...))
+	if err != nil {
+		return err
+	}
+
+	return writeLogLines(w, lines, opts)
+}
+
+// Remove closes the log store of an app and removes everything that was stored for it
+func (ls *LogStore) Remove(appName string) error {
+	if value, ok := ls.stores.LoadAndDelete(appName); ok {
+		store := value.(*appLogStore)
+		store.mu.Lock()
+		if store.file != nil {
+			store.file.Close()
+			store.file = nil
+		}
+		store.mu.Unlock()
+	}
+
+	return os.RemoveAll(ls.dir(appName))
+}
+
+// Rename moves the stored logs of an app over to its new name, containers that are still attached keep writing to
+// the same store
+func (ls *LogStore) Rename(oldName string, newName string) error {
+	renameDir := func() error {
+		err := os.Rename(ls.dir(oldName), ls.dir(newName))
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	value, ok := ls.stores.LoadAndDelete(oldName)
+	if !ok {
+		return renameDir()
+	}
+
+	store := value.(*appLogStore)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.file != nil {
+		store.file.Close()
+		store.file = nil
+	}
+
+	err := renameDir()
+	if err == nil {
+		store.dir = ls.dir(newName)
+	}
+	ls.stores.Store(newName, store)
+
+	return err
+}
+
+// logOptions selects the lines of an app's logs that are returned
+type logOptions struct {
+	// only the last tail lines when above 0
+	tail int
+	// only lines from within since and until, a zero time leaves that end open
+	since time.Time
+	until time.Time
+}
+
+// logLine is a line of container output along with the timestamp that docker put in front of it
+type logLine struct {
+	time time.Time
+	text string
+}
+
+// readLogLines splits the output of containers into lines. Lines without a timestamp get the time of the line before
+// them, so that they stay in place when lines are sorted
+func readLogLines(r io.Reader) ([]logLine, error) {
+	var lines []logLine
+	var last time.Time
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		timestamp, _, _ := strings.Cut(text, " ")
+		if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			last = t
+		}
+
+		lines = append(lines, logLine{time: last, text: text})
+	}
+
+	return lines, scanner.Err()
+}
+
+// writeLogLines writes the lines that are within the time window of opts to w, and only the last opts.tail of them
+// when it is above 0
+func writeLogLines(w io.Writer, lines []logLine, opts logOptions) error {
+	var matching []logLine
+	for _, line := range lines {
+		if !opts.since.IsZero() && line.time.Before(opts.since) {
+			continue
+		}
+
+		if !opts.until.IsZero() && line.time.After(opts.until) {
+			continue
+		}
+
+		matching = append(matching, line)
+	}
+
+	if opts.tail > 0 && len(matching) > opts.tail {
+		matching = matching[len(matching)-opts.tail:]
+	}
+
+	for _, line := range matching {
+		if _, err := fmt.Fprintln(w, line.text); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// containerLogs writes the output that docker still has of the current containers of a deployment to w, this is all
+// there is for apps without logs enabled. The output of replicas is merged in the order it was written in
+func containerLogs(ctx context.Context, w io.Writer, deployment *Deployment, opts logOptions) error {
+	logsOptions := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+	}
+	if !opts.since.IsZero() {
+		logsOptions.Since = fmt.Sprintf("%d.%09d", opts.since.Unix(), opts.since.Nanosecond())
+	}
+	if !opts.until.IsZero() {
+		logsOptions.Until = fmt.Sprintf("%d.%09d", opts.until.Unix(), opts.until.Nanosecond())
+	}
+
+	var lines []logLine
+	for _, c := range deployment.containers() {
+		reader, err := Flux.dockerClient.ContainerLogs(ctx, string(c.ContainerID[:]), logsOptions)
+		if err != nil {
+			return fmt.Errorf("failed to get logs of container %s: %v", c.ContainerID[:12], err)
+		}
+
+		var buf bytes.Buffer
+		_, err = stdcopy.StdCopy(&buf, &buf, reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read logs of container %s: %v", c.ContainerID[:12], err)
+		}
+
+		containerLines, err := readLogLines(&buf)
+		if err != nil {
+			return fmt.Errorf("failed to read logs of container %s: %v", c.ContainerID[:12], err)
+		}
+		lines = append(lines, containerLines...)
+	}
+
+	// the lines of each container are in order already, a stable sort keeps lines with the same time that way
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].time.Before(lines[j].time)
+	})
+
+	// tail has to apply to the merged lines, not to each container on its own
+	return writeLogLines(w, lines, opts)
+}
+
+// writeTail copies r to w, or only its last tail lines when tail is above 0
+func writeTail(w io.Writer, r io.Reader, tail int) error {
+	if tail <= 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	lines := make([]string, 0, tail)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(lines) == tail {
+			lines = lines[1:]
+		}
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
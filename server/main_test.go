@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/client"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger = zap.NewNop().Sugar()
+
+	os.Exit(m.Run())
+}
+
+// newTestServer points Flux at a fresh sqlite database in a temporary root directory and at a fake docker engine,
+// which is returned so that tests can set up and inspect the containers and volumes that flux sees
+func newTestServer(t *testing.T) *fakeDocker {
+	t.Helper()
+
+	docker := newFakeDocker()
+	engine := httptest.NewServer(docker)
+	t.Cleanup(engine.Close)
+
+	dockerClient, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+engine.Listener.Addr().(*net.TCPAddr).String()),
+		client.WithHTTPClient(engine.Client()),
+		client.WithVersion(api.DefaultVersion),
+	)
+	if err != nil {
+		t.Fatalf("failed to create docker client: %v", err)
+	}
+	t.Cleanup(func() { dockerClient.Close() })
+
+	rootDir := t.TempDir()
+	db, err := OpenDatabase(DatabaseConfig{}, rootDir)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	previous := Flux
+	Flux = &FluxServer{
+		config: FluxServerConfig{
+			RequestIDHeader: defaultRequestIDHeader,
+		},
+		db:           db,
+		proxy:        &Proxy{},
+		rootDir:      rootDir,
+		appManager:   new(AppManager),
+		logStore:     new(LogStore),
+		dockerClient: dockerClient,
+		Logger:       logger,
+	}
+	t.Cleanup(func() { Flux = previous })
+
+	return docker
+}
+
+// testProjectConfig is the config of a small app that is served on name.example.com
+func testProjectConfig(name string) pkg.ProjectConfig {
+	return pkg.ProjectConfig{
+		Name: name,
+		Url:  name + ".example.com",
+		Port: 8080,
+	}
+}
+
+// countRows returns how many rows of table match where
+func countRows(t *testing.T, table string, where string, args ...any) int {
+	t.Helper()
+
+	var count int
+	if err := Flux.db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+where, args...).Scan(&count); err != nil {
+		t.Fatalf("failed to count %s: %v", table, err)
+	}
+
+	return count
+}
+
+// createTestApp creates and starts the app with the flux_<name>-image image
+func createTestApp(t *testing.T, projectConfig pkg.ProjectConfig) *App {
+	t.Helper()
+
+	projectPath := filepath.Join(Flux.rootDir, "apps", projectConfig.Name)
+	if err := os.MkdirAll(projectPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := CreateApp(context.Background(), fmt.Sprintf("flux_%s-image", projectConfig.Name), projectPath, projectConfig)
+	if err != nil {
+		t.Fatalf("failed to create app: %v", err)
+	}
+
+	return app
+}
+
+// newTestUpstream serves handler on 127.0.0.1, where the fake docker engine puts every container, and returns the
+// port that it listens on so that it can be used as the port of an app
+func newTestUpstream(t *testing.T, handler http.Handler) uint16 {
+	t.Helper()
+
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	return uint16(upstream.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+// waitFor polls condition until it holds, failing the test if it doesn't within a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+var networkNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+var hostnameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+// the longest hostname that linux allows
+const maxHostnameLength = 64
+
+func validateNetwork(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	switch name {
+	case "bridge", "host", "none":
+		return fmt.Errorf("network %q is reserved by docker, pick another name", name)
+	}
+
+	if !networkNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid network name %q", name)
+	}
+
+	return nil
+}
+
+// validateHosts checks the hostname and extra_hosts of an app, extra hosts are in docker's host:ip form, where ip can
+// also be host-gateway for the address of the daemon host
+func validateHosts(projectConfig pkg.ProjectConfig) error {
+	if projectConfig.Hostname != "" {
+		if len(projectConfig.Hostname) > maxHostnameLength || !hostnameRegex.MatchString(projectConfig.Hostname) {
+			return fmt.Errorf("invalid hostname %q", projectConfig.Hostname)
+		}
+	}
+
+	for _, extraHost := range projectConfig.ExtraHosts {
+		host, ip, ok := strings.Cut(extraHost, ":")
+		if !ok {
+			return fmt.Errorf("invalid extra host %q, expected host:ip", extraHost)
+		}
+
+		if !hostnameRegex.MatchString(host) {
+			return fmt.Errorf("invalid host %q in extra host %q", host, extraHost)
+		}
+
+		if ip != "host-gateway" && net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid ip %q in extra host %q", ip, extraHost)
+		}
+	}
+
+	return nil
+}
+
+// ensureNetwork creates a user-defined bridge network with the given name if it doesn't exist yet. Containers on a
+// user-defined network can reach each other by name, unlike on docker's default bridge
+func ensureNetwork(ctx context.Context, name string) error {
+	_, err := Flux.dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err == nil {
+		return nil
+	}
+
+	if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect network (%s): %v", name, err)
+	}
+
+	logger.Debugw("Creating network", zap.String("network", name))
+	_, err = Flux.dockerClient.NetworkCreate(ctx, name, network.CreateOptions{
+		Driver: "bridge",
+		Labels: map[string]string{"managed-by": "flux"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create network (%s): %v", name, err)
+	}
+
+	return nil
+}
+
+// networkMode returns the network that the app's containers are attached to, along with the endpoint that makes
+// them resolvable by the app's name on it
+func networkMode(projectConfig pkg.ProjectConfig) (container.NetworkMode, *network.NetworkingConfig) {
+	if projectConfig.Network == "" {
+		return "bridge", nil
+	}
+
+	return container.NetworkMode(projectConfig.Network), &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			projectConfig.Network: {Aliases: []string{projectConfig.Name}},
+		},
+	}
+}
+
+// containerIP returns the address that the proxy can reach the container at, containers on a user-defined network
+// don't have an address on the default bridge
+func containerIP(containerJSON types.ContainerJSON) string {
+	if containerJSON.NetworkSettings == nil {
+		return ""
+	}
+
+	if containerJSON.NetworkSettings.IPAddress != "" {
+		return containerJSON.NetworkSettings.IPAddress
+	}
+
+	for _, endpoint := range containerJSON.NetworkSettings.Networks {
+		if endpoint.IPAddress != "" {
+			return endpoint.IPAddress
+		}
+	}
+
+	return ""
+}
+
+// reattachNetwork makes sure that the network of the deployment still exists and that all of its containers are
+// attached to it, since the network can be removed while the daemon isn't running
+func (d *Deployment) reattachNetwork(ctx context.Context) error {
+	if d.config().Network == "" {
+		return nil
+	}
+
+	if err := ensureNetwork(ctx, d.config().Network); err != nil {
+		return err
+	}
+
+	_, networkingConfig := networkMode(d.config())
+	for _, container := range d.containers() {
+		containerJSON, err := Flux.dockerClient.ContainerInspect(ctx, string(container.ContainerID[:]))
+		if err != nil {
+			return err
+		}
+
+		if _, ok := containerJSON.NetworkSettings.Networks[d.config().Network]; ok {
+			continue
+		}
+
+		err = Flux.dockerClient.NetworkConnect(ctx, d.config().Network, string(container.ContainerID[:]), networkingConfig.EndpointsConfig[d.config().Network])
+		if err != nil {
+			return fmt.Errorf("failed to attach container (%s) to network (%s): %v", container.ContainerID[:12], d.config().Network, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+var notificationClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+func validateNotifyURL(notifyURL string) error {
+	parsed, err := url.Parse(notifyURL)
+	if err != nil {
+		return fmt.Errorf("invalid notify url: %v", err)
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid notify url %q, expected an http or https url", notifyURL)
+	}
+
+	return nil
+}
+
+// sendDeployNotification posts the result of a deployment to notifyURL, failures are only logged since a
+// notification should never fail a deploy
+func sendDeployNotification(notifyURL string, notification pkg.DeployNotification) {
+	sendNotification(notifyURL, notification.App, notification)
+}
+
+// sendNotification posts notification about app to notifyURL as json, failures are only logged
+func sendNotification(notifyURL string, app string, notification any) {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		logger.Errorw("Failed to marshal notification", zap.Error(err))
+		return
+	}
+
+	resp, err := notificationClient.Post(notifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Warnw("Failed to send notification", zap.String("app", app), zap.String("url", notifyURL), zap.Error(err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logger.Warnw("Notification was rejected", zap.String("app", app), zap.String("url", notifyURL), zap.Int("status", resp.StatusCode))
+	}
+}
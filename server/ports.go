@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/juls0730/flux/pkg"
+)
+
+// proxyPort checks the ports of the app and returns the port that the proxy sends traffic to, which is port when it is
+// set and otherwise the port with the http role. It is 0 when neither is set, in which case the port is detected from
+// the image
+func proxyPort(projectConfig pkg.ProjectConfig) (uint16, error) {
+	seen := make(map[uint16]bool)
+	var httpPort uint16
+	for _, port := range projectConfig.Ports {
+		if port.Port == 0 {
+			return 0, fmt.Errorf("every entry in ports needs a port")
+		}
+
+		if seen[port.Port] {
+			return 0, fmt.Errorf("port %d is listed in ports more than once", port.Port)
+		}
+		seen[port.Port] = true
+
+		if port.Role != pkg.PortRoleHTTP {
+			continue
+		}
+
+		if httpPort != 0 {
+			return 0, fmt.Errorf("only one port can have the %s role", pkg.PortRoleHTTP)
+		}
+		httpPort = port.Port
+	}
+
+	if projectConfig.Port != 0 && httpPort != 0 && projectConfig.Port != httpPort {
+		return 0, fmt.Errorf("port is %d but the port with the %s role is %d, set only one of them", projectConfig.Port, pkg.PortRoleHTTP, httpPort)
+	}
+
+	if projectConfig.Port != 0 {
+		return projectConfig.Port, nil
+	}
+
+	return httpPort, nil
+}
+
+// exposedPorts returns every port of the app, so that docker knows what the containers listen on
+func exposedPorts(projectConfig pkg.ProjectConfig) nat.PortSet {
+	ports := make(nat.PortSet)
+	if projectConfig.Port != 0 {
+		ports[nat.Port(strconv.Itoa(int(projectConfig.Port))+"/tcp")] = struct{}{}
+	}
+
+	for _, port := range projectConfig.Ports {
+		ports[nat.Port(strconv.Itoa(int(port.Port))+"/tcp")] = struct{}{}
+	}
+
+	return ports
+}
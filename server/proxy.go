@@ -2,42 +2,196 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/juls0730/flux/pkg"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
 )
 
+const (
+	// how often deployments are checked for having gone idle
+	idleCheckInterval = 30 * time.Second
+	// how long a request to an idle deployment is held while the deployment starts back up
+	coldStartTimeout = 30 * time.Second
+	// how long clients of the proxy get to send the headers of a request
+	proxyHeaderTimeout = 10 * time.Second
+	// how long the proxy keeps an idle keep-alive connection open
+	proxyIdleTimeout = 2 * time.Minute
+	// the cookie that pins a client to a replica of a sticky app, its value identifies the container of the replica
+	affinityCookieName = "flux_affinity"
+)
+
+// served while a deployment is in maintenance and no page of its own was given
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html>
+<head><title>Down for maintenance</title></head>
+<body>
+<h1>Down for maintenance</h1>
+<p>This site is undergoing planned maintenance, please check back soon.</p>
+</body>
+</html>
+`
+
+type upstreamContextKey struct{}
+
 type Proxy struct {
 	deployments sync.Map
+	// the listeners that forward the host ports of tcp apps, by deployment id
+	tcpListeners sync.Map
 }
 
 func (p *Proxy) RemoveDeployment(deployment *Deployment) {
-	p.deployments.Delete(deployment.URL)
+	p.deployments.Delete(deployment.url())
+	p.closeTCP(deployment)
 }
 
 func (p *Proxy) AddDeployment(deployment *Deployment) {
-	logger.Debugw("Adding deployment", zap.String("url", deployment.URL))
-	p.deployments.Store(deployment.URL, deployment)
+	logger.Debugw("Adding deployment", zap.String("url", deployment.url()))
+
+	if protocol(deployment.config()) == pkg.ProtocolTCP {
+		if err := p.listenTCP(deployment); err != nil {
+			appLogger(deployment.config().Name).Errorw("Failed to forward host port", zap.Uint16("host_port", deployment.config().HostPort), zap.Error(err))
+		}
+
+		return
+	}
+
+	p.closeTCP(deployment)
+	p.deployments.Store(deployment.url(), deployment)
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	host := r.Host
 
-	deployment, ok := p.deployments.Load(host)
+	// the app receives the id with the request, since the director passes on the headers of the request
+	ensureRequestID(w, r, Flux.config.RequestIDHeader)
+
+	var appName string
+	if Flux.config.AccessLog.Enabled {
+		recorder := &responseRecorder{ResponseWriter: w}
+		w = recorder
+
+		start := time.Now()
+		defer func() {
+			logAccess(Flux.config.AccessLog, appName, r, recorder, start)
+		}()
+	}
+
+	value, ok := p.deployments.Load(host)
 	if !ok {
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
 	}
+	deployment := value.(*Deployment)
+	appName = deployment.config().Name
+
+	if maxBodySize := Flux.config.ProxyMaxBodySize; maxBodySize > 0 {
+		if r.ContentLength > maxBodySize {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// bodies without a content length are cut off once they get too large
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	}
+
+	if page := deployment.maintenancePage.Load(); page != nil {
+		serveMaintenancePage(w, *page)
+		return
+	}
+
+	if deployment.paused.Load() {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if deployment.suspended.Load() {
+		// hold the request until the deployment is back up, this intentionally doesn't use the request context so
+		// that a client giving up doesn't abort the cold start for every other waiting request
+		ctx, cancel := context.WithTimeout(context.Background(), coldStartTimeout)
+		err := deployment.Wake(ctx)
+		cancel()
+
+		if err != nil {
+			logger.Errorw("Failed to wake idle deployment", zap.String("url", deployment.url()), zap.Error(err))
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	dp := deployment.proxy()
+	if dp == nil {
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	atomic.AddInt64(&dp.activeRequests, 1)
+	defer atomic.AddInt64(&dp.activeRequests, -1)
+	atomic.StoreInt64(&dp.lastRequest, time.Now().UnixNano())
 
-	atomic.AddInt64(&deployment.(*Deployment).Proxy.activeRequests, 1)
+	dp.ServeHTTP(w, r)
+}
+
+func serveMaintenancePage(w http.ResponseWriter, page string) {
+	if page == "" {
+		page = defaultMaintenancePage
+	}
 
-	deployment.(*Deployment).Proxy.proxy.ServeHTTP(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the page must not outlive the maintenance in any cache
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(w, page)
+}
+
+// SuspendIdleDeployments periodically scales deployments with an idle timeout down to zero once they have not
+// received any requests for that long
+func (p *Proxy) SuspendIdleDeployments(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var deployments []*Deployment
+		p.deployments.Range(func(key, value any) bool {
+			deployments = append(deployments, value.(*Deployment))
+			return true
+		})
+		p.tcpListeners.Range(func(key, value any) bool {
+			deployments = append(deployments, value.(*tcpListener).deployment)
+			return true
+		})
+
+		for _, deployment := range deployments {
+			if deployment.config().IdleTimeout <= 0 || deployment.suspended.Load() || deployment.paused.Load() || deployment.inMaintenance() || deployment.proxy() == nil {
+				continue
+			}
+
+			if !deployment.proxy().Idle(time.Duration(deployment.config().IdleTimeout) * time.Minute) {
+				continue
+			}
+
+			if err := deployment.Suspend(context.Background()); err != nil {
+				logger.Errorw("Failed to suspend idle deployment", zap.String("url", deployment.url()), zap.Error(err))
+			}
+		}
+	}
 }
 
 type DeploymentProxy struct {
@@ -45,71 +199,398 @@ type DeploymentProxy struct {
 	proxy          *httputil.ReverseProxy
 	gracePeriod    time.Duration
 	activeRequests int64
+	// unix nano timestamp of the last request that was proxied
+	lastRequest int64
+	// the containers that traffic is balanced across, in round-robin order
+	upstreams []*url.URL
+	// the affinity cookie values of the upstreams at the same index
+	affinityKeys []string
+	// whether the upstream at the same index is passing its health checks, unhealthy upstreams get no traffic
+	healthy []atomic.Bool
+	next    uint64
+	// unix nano timestamp of when the proxy started draining after it was replaced, 0 until then
+	drainStarted atomic.Int64
 }
 
+// how often a draining proxy checks whether its requests have finished
+const drainPollInterval = 100 * time.Millisecond
+
 func (deployment *Deployment) NewDeploymentProxy() (*DeploymentProxy, error) {
 	if deployment == nil {
 		return nil, fmt.Errorf("deployment is nil")
 	}
 
-	containerJSON, err := Flux.dockerClient.ContainerInspect(context.Background(), string(deployment.Head.ContainerID[:]))
-	if err != nil {
-		return nil, err
+	var upstreams []*url.URL
+	var affinityKeys []string
+	for _, container := range deployment.containers() {
+		containerJSON, err := Flux.dockerClient.ContainerInspect(context.Background(), string(container.ContainerID[:]))
+		if err != nil {
+			return nil, err
+		}
+
+		if containerIP(containerJSON) == "" {
+			return nil, fmt.Errorf("no IP address found for container %s", container.ContainerID[:12])
+		}
+
+		upstreams = append(upstreams, upstreamURL(containerIP(containerJSON), deployment.port(), protocol(deployment.config())))
+		affinityKeys = append(affinityKeys, affinityKey(container))
 	}
 
-	if containerJSON.NetworkSettings.IPAddress == "" {
-		return nil, fmt.Errorf("no IP address found for container %s", deployment.Head.ContainerID[:12])
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("deployment has no containers")
 	}
 
-	containerUrl, err := url.Parse(fmt.Sprintf("http://%s:%d", containerJSON.NetworkSettings.IPAddress, deployment.Port))
-	if err != nil {
-		return nil, err
+	dp := &DeploymentProxy{
+		deployment:     deployment,
+		gracePeriod:    time.Second * 30,
+		activeRequests: 0,
+		lastRequest:    time.Now().UnixNano(),
+		upstreams:      upstreams,
+		affinityKeys:   affinityKeys,
+		healthy:        make([]atomic.Bool, len(upstreams)),
 	}
 
-	proxy := &httputil.ReverseProxy{
+	for i := range dp.healthy {
+		dp.healthy[i].Store(true)
+	}
+
+	dp.proxy = &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
-			req.URL = containerUrl
+			// the app is reached at the address of its container, so tell it where the request was actually sent.
+			// X-Forwarded-For is appended to by the ReverseProxy itself after the director runs
+			req.Header.Set("X-Forwarded-Host", req.Host)
+			req.Header.Set("X-Forwarded-Proto", forwardedProto(req))
+
+			containerUrl := req.Context().Value(upstreamContextKey{}).(*url.URL)
+			req.URL.Scheme = containerUrl.Scheme
+			if req.URL.Scheme == pkg.ProtocolGRPC {
+				// the http/2 transport takes care of speaking grpc's cleartext http/2
+				req.URL.Scheme = "http"
+			}
+			req.URL.Host = containerUrl.Host
 			req.Host = containerUrl.Host
+
+			if dp.sticky() {
+				stripAffinityCookie(req)
+			}
 		},
 		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			IdleConnTimeout:     90 * time.Second,
-			MaxIdleConnsPerHost: 100,
+			DialContext:           (&net.Dialer{Timeout: dialTimeout(deployment.config())}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   100,
+			ResponseHeaderTimeout: time.Duration(proxyConfig(deployment.config()).ResponseHeaderTimeout) * time.Second,
 		},
-		ModifyResponse: func(resp *http.Response) error {
-			atomic.AddInt64(&deployment.Proxy.activeRequests, -1)
+		FlushInterval: time.Duration(proxyConfig(deployment.config()).FlushInterval) * time.Millisecond,
+		ModifyResponse: func(res *http.Response) error {
+			// the client already gets the request id from the proxy, an app that echoes it would send it twice
+			res.Header.Del(Flux.config.RequestIDHeader)
 			return nil
 		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxyError(w, r, deployment, err)
+		},
 	}
 
-	return &DeploymentProxy{
-		deployment:     deployment,
-		proxy:          proxy,
-		gracePeriod:    time.Second * 30,
-		activeRequests: 0,
-	}, nil
+	if protocol(deployment.config()) == pkg.ProtocolGRPC {
+		dp.proxy.Transport = h2cTransport()
+		// every message of a stream has to reach the client right away
+		dp.proxy.FlushInterval = -1
+	}
+
+	go dp.checkHealth()
+
+	return dp, nil
+}
+
+// default for how long connecting to a container may take, like go's default transport
+const defaultProxyDialTimeout = 30 * time.Second
+
+// proxyConfig returns the proxy settings of an app, the zero value when it has none
+func proxyConfig(projectConfig pkg.ProjectConfig) pkg.ProxyConfig {
+	if projectConfig.Proxy == nil {
+		return pkg.ProxyConfig{}
+	}
+
+	return *projectConfig.Proxy
+}
+
+func dialTimeout(projectConfig pkg.ProjectConfig) time.Duration {
+	if timeout := proxyConfig(projectConfig).DialTimeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+
+	return defaultProxyDialTimeout
+}
+
+func validateProxyConfig(projectConfig pkg.ProjectConfig) error {
+	config := proxyConfig(projectConfig)
+	if config.FlushInterval < -1 {
+		return fmt.Errorf("proxy.flush_interval must be -1 or more, got %d", config.FlushInterval)
+	}
+
+	if config.DialTimeout < 0 {
+		return fmt.Errorf("proxy.dial_timeout must not be negative, got %d", config.DialTimeout)
+	}
+
+	if config.ResponseHeaderTimeout < 0 {
+		return fmt.Errorf("proxy.response_header_timeout must not be negative, got %d", config.ResponseHeaderTimeout)
+	}
+
+	if config.WriteTimeout < -1 {
+		return fmt.Errorf("proxy.write_timeout must be -1 or more, got %d", config.WriteTimeout)
+	}
+
+	return nil
+}
+
+// h2cTransport speaks http/2 without tls to the app, which is what grpc servers expect
+func h2cTransport() http.RoundTripper {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func (dp *DeploymentProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the deadline is set on the connection that the response is written to, so it has to be set before any wrapping
+	switch writeTimeout := proxyConfig(dp.deployment.config()).WriteTimeout; {
+	case writeTimeout > 0:
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Duration(writeTimeout) * time.Second))
+	case writeTimeout < 0:
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+
+	// grpc compresses messages itself, and a gzipped grpc response is invalid
+	compress := dp.deployment.config().CompressResponses && protocol(dp.deployment.config()) != pkg.ProtocolGRPC
+	if compress && r.Method != http.MethodHead && acceptsGzip(r) {
+		gzipWriter := &gzipResponseWriter{ResponseWriter: w}
+		defer gzipWriter.Close()
+		w = gzipWriter
+	}
+
+	index := -1
+	if dp.sticky() {
+		index = dp.affinityUpstream(r)
+	}
+
+	if index == -1 {
+		index = dp.nextUpstreamIndex()
+		if index == -1 {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if dp.sticky() {
+			setAffinityCookie(w, r, dp.affinityKeys[index])
+		}
+	}
+
+	dp.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), upstreamContextKey{}, dp.upstreams[index])))
+}
+
+// describeDrain returns the progress of a proxy that is draining
+func (dp *DeploymentProxy) describeDrain() pkg.DrainDescription {
+	started := time.Unix(0, dp.drainStarted.Load())
+
+	return pkg.DrainDescription{
+		ActiveRequests: atomic.LoadInt64(&dp.activeRequests),
+		Started:        started,
+		Deadline:       started.Add(dp.gracePeriod),
+	}
+}
+
+func (dp *DeploymentProxy) Describe() *pkg.ProxyDescription {
+	description := &pkg.ProxyDescription{
+		ActiveRequests: atomic.LoadInt64(&dp.activeRequests),
+		LastRequest:    time.Unix(0, atomic.LoadInt64(&dp.lastRequest)),
+	}
+
+	for i, upstream := range dp.upstreams {
+		description.Upstreams = append(description.Upstreams, pkg.UpstreamDescription{
+			URL:     upstream.String(),
+			Healthy: dp.healthy[i].Load(),
+		})
+	}
+
+	return description
+}
+
+func (dp *DeploymentProxy) sticky() bool {
+	return dp.deployment.config().Sticky && len(dp.upstreams) > 1
+}
+
+// nextUpstreamIndex returns the index of the next healthy upstream in round-robin order, or -1 if none are healthy
+func (dp *DeploymentProxy) nextUpstreamIndex() int {
+	for range dp.upstreams {
+		next := atomic.AddUint64(&dp.next, 1)
+		index := int((next - 1) % uint64(len(dp.upstreams)))
+		if dp.healthy[index].Load() {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// affinityKey identifies the container of a replica in the affinity cookie. The cookie points at the container rather
+// than at the position of the replica, so that a client whose container was replaced by a deploy is re-pinned instead
+// of silently ending up on a container that doesn't have its session. The container id is hashed to keep it private
+func affinityKey(c *Container) string {
+	sum := sha256.Sum256(c.ContainerID[:])
+	return hex.EncodeToString(sum[:8])
 }
 
+// affinityUpstream returns the upstream that the affinity cookie of the request points to, or -1 if the client has to
+// be assigned a new one because it has no cookie yet or the upstream no longer exists or is unhealthy
+func (dp *DeploymentProxy) affinityUpstream(r *http.Request) int {
+	cookie, err := r.Cookie(affinityCookieName)
+	if err != nil {
+		return -1
+	}
+
+	index := slices.Index(dp.affinityKeys, cookie.Value)
+	if index == -1 || !dp.healthy[index].Load() {
+		return -1
+	}
+
+	return index
+}
+
+func setAffinityCookie(w http.ResponseWriter, r *http.Request, key string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     affinityCookieName,
+		Value:    key,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   forwardedProto(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// forwardedProto returns the scheme that the client used, flux can sit behind a proxy that terminates tls
+func forwardedProto(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+
+	return "http"
+}
+
+// proxyError responds to a request that could not be proxied to the app. An app that can't be connected to is most
+// likely (re)starting, so that is a 503 that can be retried, anything else that went wrong is a 502
+func proxyError(w http.ResponseWriter, r *http.Request, deployment *Deployment, err error) {
+	log := appLogger(deployment.config().Name)
+
+	// the client went away, there is nobody to respond to
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		log.Debugw("Client closed the request", zap.String("url", deployment.url()), zap.String("path", r.URL.Path))
+		return
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		log.Warnw("Failed to connect to container", zap.String("url", deployment.url()), zap.Error(err))
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	// the app didn't answer within proxy.response_header_timeout
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		log.Warnw("Timed out waiting for container", zap.String("url", deployment.url()), zap.Error(err))
+		http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+		return
+	}
+
+	log.Warnw("Failed to proxy request to container", zap.String("url", deployment.url()), zap.Error(err))
+	http.Error(w, "Bad gateway", http.StatusBadGateway)
+}
+
+// stripAffinityCookie removes the affinity cookie from a request before it is passed on to the app
+func stripAffinityCookie(r *http.Request) {
+	var cookies []string
+	for _, cookie := range r.Cookies() {
+		if cookie.Name != affinityCookieName {
+			cookies = append(cookies, cookie.String())
+		}
+	}
+
+	if len(cookies) == 0 {
+		r.Header.Del("Cookie")
+	} else {
+		r.Header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+}
+
+// Idle reports whether the proxy has had no requests in flight and none for at least the given duration
+func (dp *DeploymentProxy) Idle(timeout time.Duration) bool {
+	if atomic.LoadInt64(&dp.activeRequests) != 0 {
+		return false
+	}
+
+	return time.Since(time.Unix(0, atomic.LoadInt64(&dp.lastRequest))) >= timeout
+}
+
+// GracefulShutdown waits for the requests that are still in flight to finish, for up to the grace period, and then
+// removes the old containers
 func (dp *DeploymentProxy) GracefulShutdown(oldContainers []*Container) {
+	log := appLogger(dp.deployment.config().Name)
+
+	started := time.Now()
+	dp.drainStarted.Store(started.UnixNano())
+	dp.deployment.draining.Store(dp, struct{}{})
+
+	log.Infow("Draining old containers", zap.Int64("active_requests", atomic.LoadInt64(&dp.activeRequests)), zap.Duration("grace_period", dp.gracePeriod))
+
 	ctx, cancel := context.WithTimeout(context.Background(), dp.gracePeriod)
 	defer cancel()
 
-	done := false
-	for !done {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	// a request can still pick up this proxy right as it is replaced, so the count is only checked after the first tick
+	timedOut := false
+drain:
+	for {
 		select {
 		case <-ctx.Done():
-			done = true
-		default:
+			timedOut = true
+			break drain
+		case <-ticker.C:
 			if atomic.LoadInt64(&dp.activeRequests) == 0 {
-				done = true
+				break drain
 			}
-
-			time.Sleep(time.Second)
 		}
 	}
 
+	dp.deployment.draining.Delete(dp)
+
+	if timedOut {
+		log.Warnw("Drain timed out, stopping old containers with requests in flight", zap.Int64("active_requests", atomic.LoadInt64(&dp.activeRequests)), zap.Duration("duration", time.Since(started)), zap.Duration("grace_period", dp.gracePeriod))
+	} else {
+		log.Infow("Drain completed", zap.Duration("duration", time.Since(started)), zap.Duration("grace_period", dp.gracePeriod))
+	}
+
+	// the old containers get the grace period to exit, unless the app says how long it needs
+	timeout := dp.gracePeriod
+	if stopTimeout := dp.deployment.config().StopTimeout; stopTimeout > 0 {
+		timeout = time.Duration(stopTimeout) * time.Second
+	}
+
 	for _, container := range oldContainers {
-		err := RemoveDockerContainer(context.Background(), string(container.ContainerID[:]))
+		err := GracefullyRemoveDockerContainer(context.Background(), string(container.ContainerID[:]), timeout, dp.deployment.config().StopSignal)
 		if err != nil {
 			logger.Errorw("Failed to remove container", zap.Error(err))
 		}
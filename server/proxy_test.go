@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// writeGRPCMessage writes an uncompressed length prefixed grpc message
+func writeGRPCMessage(w io.Writer, message []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+	if _, err := w.Write(append(header, message...)); err != nil {
+		return err
+	}
+
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
+
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// grpcEcho is a bidirectional streaming grpc method that answers every message as soon as it arrives
+func grpcEcho(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+		http.Error(w, "expected a grpc request over http/2", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	for {
+		message, err := readGRPCMessage(r.Body)
+		if err != nil {
+			break
+		}
+
+		if err := writeGRPCMessage(w, append([]byte("echo: "), message...)); err != nil {
+			return
+		}
+	}
+
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// TestProxyStreamsGRPC streams messages both ways through the proxy to a cleartext http/2 app, every reply has to
+// make it back before the client sends its next message
+func TestProxyStreamsGRPC(t *testing.T) {
+	newTestServer(t)
+
+	projectConfig := testProjectConfig("grpc")
+	projectConfig.Protocol = pkg.ProtocolGRPC
+	projectConfig.Port = newTestUpstream(t, h2c.NewHandler(http.HandlerFunc(grpcEcho), &http2.Server{}))
+	createTestApp(t, projectConfig)
+
+	proxy := httptest.NewUnstartedServer(nil)
+	proxy.Config = Flux.proxyServer()
+	proxy.Start()
+	t.Cleanup(proxy.Close)
+
+	// grpc clients speak http/2 to the proxy without tls
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	body, stream := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, proxy.URL+"/echo.Echo/Stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = projectConfig.Url
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	// the response headers only arrive once the request is under way
+	responses := make(chan *http.Response, 1)
+	errs := make(chan error, 1)
+	go func() {
+		resp, err := client.Do(req)
+		if err != nil {
+			errs <- err
+			return
+		}
+		responses <- resp
+	}()
+
+	if err := writeGRPCMessage(stream, []byte("message 0")); err != nil {
+		t.Fatalf("failed to send the first message: %v", err)
+	}
+
+	var resp *http.Response
+	select {
+	case resp = <-responses:
+	case err := <-errs:
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Fatalf("expected a 200 over http/2, got %d over %s", resp.StatusCode, resp.Proto)
+	}
+
+	for i := range 3 {
+		if i > 0 {
+			if err := writeGRPCMessage(stream, []byte(fmt.Sprintf("message %d", i))); err != nil {
+				t.Fatalf("failed to send message %d: %v", i, err)
+			}
+		}
+
+		reply, err := readGRPCMessage(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to receive the reply to message %d: %v", i, err)
+		}
+
+		if want := fmt.Sprintf("echo: message %d", i); string(reply) != want {
+			t.Errorf("expected %q, got %q", want, reply)
+		}
+	}
+
+	stream.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatalf("failed to finish the stream: %v", err)
+	}
+
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("expected the Grpc-Status trailer to make it through the proxy, got %q", status)
+	}
+}
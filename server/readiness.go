@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// how long each dependency gets to respond to a readiness check
+const readinessTimeout = 3 * time.Second
+
+// Health checks everything that the daemon needs in order to deploy and serve apps
+func (s *FluxServer) Health(ctx context.Context) pkg.DaemonHealth {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"docker":   s.checkDocker,
+		"database": s.checkDatabase,
+		"proxy":    s.checkProxy,
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+
+	// the checks run at the same time, so an unreachable dependency only costs the timeout once
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		go func() {
+			results <- result{name, check(ctx)}
+		}()
+	}
+
+	health := pkg.DaemonHealth{
+		Healthy:    true,
+		Components: make(map[string]pkg.ComponentHealth, len(checks)),
+	}
+	for range checks {
+		result := <-results
+
+		component := pkg.ComponentHealth{Healthy: result.err == nil}
+		if result.err != nil {
+			health.Healthy = false
+			component.Error = result.err.Error()
+		}
+
+		health.Components[result.name] = component
+	}
+
+	return health
+}
+
+func (s *FluxServer) checkDocker(ctx context.Context) error {
+	_, err := s.dockerClient.Ping(ctx)
+	return err
+}
+
+func (s *FluxServer) checkDatabase(ctx context.Context) error {
+	// the database interface has no contexts, so a database that doesn't respond is given up on instead
+	done := make(chan error, 1)
+	go func() {
+		var one int
+		done <- s.db.QueryRow("SELECT 1").Scan(&one)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("database did not respond: %v", ctx.Err())
+	}
+}
+
+func (s *FluxServer) checkProxy(ctx context.Context) error {
+	if s.proxyListener == nil {
+		return fmt.Errorf("proxy is not listening")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.proxyListener.Addr().String())
+	if err != nil {
+		return fmt.Errorf("proxy is not accepting connections: %v", err)
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+	"go.uber.org/zap"
+)
+
+// RegistryAuth are the credentials for a private registry that images are pulled from
+type RegistryAuth struct {
+	// the host of the registry, such as ghcr.io or registry.example.com:5000, docker.io for docker hub
+	Server   string `json:"server"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// a bearer token that is sent to the registry as is, used instead of a username and password
+	Token string `json:"token,omitempty"`
+}
+
+const (
+	dockerHubRegistry = "docker.io"
+	// the key that docker login stores the credentials of docker hub under
+	dockerHubConfigKey = "https://index.docker.io/v1/"
+)
+
+func (c FluxServerConfig) validateRegistries() error {
+	for _, auth := range c.Registries {
+		if auth.Server == "" {
+			return fmt.Errorf("every entry in registries needs a server")
+		}
+
+		if auth.Token == "" && (auth.Username == "" || auth.Password == "") {
+			return fmt.Errorf("registry %s needs either a token or a username and password", auth.Server)
+		}
+	}
+
+	return nil
+}
+
+// normalizeRegistry strips the scheme and path that the keys of a docker config.json can have, and maps the other
+// names of docker hub to docker.io
+func normalizeRegistry(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server, _, _ = strings.Cut(server, "/")
+
+	switch server {
+	case "index.docker.io", "registry-1.docker.io":
+		return dockerHubRegistry
+	default:
+		return server
+	}
+}
+
+// imageRegistry returns the registry that an image is pulled from
+func imageRegistry(imageName string) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return dockerHubRegistry
+	}
+
+	return reference.Domain(named)
+}
+
+// dockerConfigDir is where the docker cli keeps its config.json
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".docker")
+}
+
+// readDockerConfig reads the config.json of the docker cli, a missing file is an empty config. Fields other than the
+// auths are kept as is, so that credential helpers keep working when it is written back out for pack
+func readDockerConfig() (map[string]json.RawMessage, error) {
+	config := make(map[string]json.RawMessage)
+
+	dir := dockerConfigDir()
+	if dir == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse docker config: %v", err)
+	}
+
+	return config, nil
+}
+
+type dockerConfigAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+func dockerConfigAuths(config map[string]json.RawMessage) map[string]dockerConfigAuth {
+	auths := make(map[string]dockerConfigAuth)
+	if raw, ok := config["auths"]; ok {
+		json.Unmarshal(raw, &auths)
+	}
+
+	return auths
+}
+
+// registryAuth returns the credentials for the registry of an image, either from the daemon config or, for registries
+// that it has no credentials for, from the config.json of the docker cli. Credential helpers are only used by pack
+func (s *FluxServer) registryAuth(imageName string) (registry.AuthConfig, bool) {
+	server := imageRegistry(imageName)
+
+	for _, auth := range s.config.Registries {
+		if normalizeRegistry(auth.Server) != server {
+			continue
+		}
+
+		return registry.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			RegistryToken: auth.Token,
+			ServerAddress: auth.Server,
+		}, true
+	}
+
+	config, err := readDockerConfig()
+	if err != nil {
+		logger.Warnw("Failed to read docker config", zap.Error(err))
+		return registry.AuthConfig{}, false
+	}
+
+	for key, auth := range dockerConfigAuths(config) {
+		if normalizeRegistry(key) != server {
+			continue
+		}
+
+		authConfig := registry.AuthConfig{
+			IdentityToken: auth.IdentityToken,
+			RegistryToken: auth.RegistryToken,
+			ServerAddress: key,
+		}
+
+		if decoded, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil {
+			authConfig.Username, authConfig.Password, _ = strings.Cut(string(decoded), ":")
+		}
+
+		return authConfig, true
+	}
+
+	return registry.AuthConfig{}, false
+}
+
+// encodedRegistryAuth returns the credentials for the registry of an image as docker expects them in an image pull,
+// empty when there are none
+func (s *FluxServer) encodedRegistryAuth(imageName string) string {
+	authConfig, ok := s.registryAuth(imageName)
+	if !ok {
+		return ""
+	}
+
+	encoded, err := registry.EncodeAuthConfig(authConfig)
+	if err != nil {
+		logger.Warnw("Failed to encode registry credentials", zap.String("image", imageName), zap.Error(err))
+		return ""
+	}
+
+	return encoded
+}
+
+// writePackDockerConfig writes a docker config.json with the registries of the daemon config added on top of the
+// config of the docker cli, since pack pulls the images that it needs itself. It returns the directory that
+// DOCKER_CONFIG has to point pack at, or an empty string when the daemon config has no registries
+func (s *FluxServer) writePackDockerConfig() (string, error) {
+	if len(s.config.Registries) == 0 {
+		return "", nil
+	}
+
+	config, err := readDockerConfig()
+	if err != nil {
+		return "", err
+	}
+
+	auths := dockerConfigAuths(config)
+	for _, auth := range s.config.Registries {
+		key := auth.Server
+		if normalizeRegistry(key) == dockerHubRegistry {
+			key = dockerHubConfigKey
+		}
+
+		entry := dockerConfigAuth{RegistryToken: auth.Token}
+		if auth.Username != "" {
+			entry.Auth = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		}
+		auths[key] = entry
+	}
+
+	config["auths"], err = json.Marshal(auths)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(s.rootDir, "docker")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0600); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
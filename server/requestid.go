@@ -0,0 +1,35 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// the header that carries the id of a request through the proxy when request_id_header isn't set
+const defaultRequestIDHeader = "X-Request-ID"
+
+func validateRequestIDHeader(name string) error {
+	if name != "" && !httpguts.ValidHeaderFieldName(name) {
+		return fmt.Errorf("invalid request_id_header %q", name)
+	}
+
+	return nil
+}
+
+// ensureRequestID makes sure that the request carries an id in the request id header, generating one when the client
+// didn't send one, and echoes it back to the client. Trace context headers such as traceparent are passed on as is
+func ensureRequestID(w http.ResponseWriter, r *http.Request, header string) {
+	requestID := r.Header.Get(header)
+	if requestID == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		requestID = hex.EncodeToString(id)
+		r.Header.Set(header, requestID)
+	}
+
+	w.Header().Set(header, requestID)
+}
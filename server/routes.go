@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// Routes returns the routing table of the proxy, the http hosts and the host ports of tcp apps, sorted by host
+func (p *Proxy) Routes() []pkg.Route {
+	routes := []pkg.Route{}
+	p.deployments.Range(func(key, value any) bool {
+		routes = append(routes, route(key.(string), value.(*Deployment)))
+		return true
+	})
+
+	p.tcpListeners.Range(func(key, value any) bool {
+		listener := value.(*tcpListener)
+		routes = append(routes, route(fmt.Sprintf(":%d", listener.port), listener.deployment))
+		return true
+	})
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Host < routes[j].Host
+	})
+
+	return routes
+}
+
+func route(host string, deployment *Deployment) pkg.Route {
+	route := pkg.Route{
+		Host:      host,
+		App:       deployment.config().Name,
+		Protocol:  protocol(deployment.config()),
+		Upstreams: []pkg.UpstreamDescription{},
+	}
+
+	dp := deployment.proxy()
+	if dp != nil {
+		description := dp.Describe()
+		route.Upstreams = description.Upstreams
+		route.ActiveRequests = description.ActiveRequests
+	}
+
+	// in the order that the proxy checks them in
+	switch {
+	case deployment.maintenancePage.Load() != nil:
+		route.State = "maintenance"
+	case deployment.paused.Load():
+		route.State = "paused"
+	case deployment.suspended.Load():
+		route.State = "suspended"
+	case dp == nil:
+		route.State = "unavailable"
+	default:
+		route.State = "running"
+	}
+
+	return route
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+// defaultSandboxImage is what build hooks and prepare commands run in when no image is configured for them
+const defaultSandboxImage = "alpine:3"
+
+// sandboxProjectPath is where the project directory is mounted in a sandbox, and its working directory
+const sandboxProjectPath = "/workspace"
+
+// sandbox is a throwaway container that build hooks and prepare commands run in. Only the project directory is
+// mounted into it, it runs as the user that fluxd runs as with every capability dropped, and it has no access to
+// docker
+type sandbox struct {
+	image       string
+	projectPath string
+	env         []string
+	// mounted read-only next to the project directory, such as the image archive of post_build hooks
+	mounts []mount.Mount
+}
+
+// run runs command with sh in a new container of the sandbox, streaming its output into eventChannel as the output of
+// stage. The container is removed once the command exits, or when ctx is done
+func (sb sandbox) run(ctx context.Context, stage string, command string, eventChannel chan<- DeploymentEvent) error {
+	mounts := []mount.Mount{{
+		Type:   mount.TypeBind,
+		Source: sb.projectPath,
+		Target: sandboxProjectPath,
+	}}
+	for _, m := range sb.mounts {
+		m.ReadOnly = true
+		mounts = append(mounts, m)
+	}
+
+	resp, err := Flux.dockerClient.ContainerCreate(ctx, &container.Config{
+		Image:      sb.image,
+		Cmd:        []string{"sh", "-c", command},
+		WorkingDir: sandboxProjectPath,
+		Env:        sb.env,
+		// whatever the command writes to the project directory stays readable to fluxd
+		User: fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+	}, &container.HostConfig{
+		Mounts:      mounts,
+		CapDrop:     []string{"ALL"},
+		SecurityOpt: []string{"no-new-privileges"},
+	}, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create sandbox: %v", err)
+	}
+	// the request may be gone already, the command is killed if it is still running
+	defer Flux.dockerClient.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+
+	if err := Flux.dockerClient.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start sandbox: %v", err)
+	}
+
+	logs, err := Flux.dockerClient.ContainerLogs(ctx, resp.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read sandbox output: %v", err)
+	}
+	defer logs.Close()
+
+	output, wait := streamOutput(stage, eventChannel)
+	_, err = stdcopy.StdCopy(output, output, logs)
+	wait()
+	if err != nil {
+		return fmt.Errorf("failed to read sandbox output: %v", err)
+	}
+
+	statusCh, errCh := Flux.dockerClient.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to wait for sandbox: %v", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("exited with code %d", status.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// pullSandboxImage makes sure that the image of a sandbox is available under the pull policy of the app
+func (s *FluxServer) pullSandboxImage(ctx context.Context, imageName string, projectConfig pkg.ProjectConfig, eventChannel chan<- DeploymentEvent, log *zap.SugaredLogger) error {
+	pull, err := needsPull(ctx, imageName, s.pullPolicy(projectConfig))
+	if err != nil || !pull {
+		return err
+	}
+
+	log.Infow("Pulling sandbox image", zap.String("image", imageName))
+	eventChannel <- DeploymentEvent{
+		Stage:   "pulling_image",
+		Message: fmt.Sprintf("Pulling sandbox image %s", imageName),
+	}
+
+	if err := pullImage(ctx, imageName); err != nil {
+		return fmt.Errorf("failed to pull sandbox image %s: %w", imageName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestSandboxRun(t *testing.T) {
+	docker := newTestServer(t)
+	docker.addImage(defaultSandboxImage)
+
+	var created *fakeContainer
+	docker.onCreate = func(c *fakeContainer) {
+		created = c
+	}
+
+	events := make(chan DeploymentEvent, 10)
+	projectPath := t.TempDir()
+	sb := sandbox{
+		image:       defaultSandboxImage,
+		projectPath: projectPath,
+		env:         []string{"FLUX_APP=app"},
+	}
+
+	err := sb.run(context.Background(), buildHookPreBuild, "echo out; echo err >&2; echo $FLUX_APP; exit 3", events)
+	close(events)
+
+	if err == nil || !strings.Contains(err.Error(), "exited with code 3") {
+		t.Errorf("expected the command to exit with 3, got %v", err)
+	}
+
+	var lines []string
+	for event := range events {
+		if event.Stage != "cmd_output" || event.OutputStage != buildHookPreBuild {
+			t.Errorf("expected the output of %s, got an event of %s/%s", buildHookPreBuild, event.Stage, event.OutputStage)
+		}
+
+		lines = append(lines, event.Message.(string))
+	}
+
+	// every line is sent before run returns, from stdout and stderr alike
+	if len(lines) != 3 || !slices.Contains(lines, "out") || !slices.Contains(lines, "err") || !slices.Contains(lines, "app") {
+		t.Errorf("expected the output of the command, got %q", lines)
+	}
+
+	if created == nil {
+		t.Fatal("expected the command to run in a container")
+	}
+
+	// the project directory is all that the sandbox can reach, docker included
+	want := []mount.Mount{{Type: mount.TypeBind, Source: projectPath, Target: sandboxProjectPath}}
+	if !slices.Equal(created.HostConfig.Mounts, want) {
+		t.Errorf("expected only the project directory to be mounted, got %+v", created.HostConfig.Mounts)
+	}
+
+	if !slices.Equal(created.Config.Env, sb.env) {
+		t.Errorf("expected only the environment of the sandbox, got %q", created.Config.Env)
+	}
+
+	if created.Config.WorkingDir != sandboxProjectPath {
+		t.Errorf("expected the command to run in %s, got %q", sandboxProjectPath, created.Config.WorkingDir)
+	}
+
+	if !slices.Equal(created.HostConfig.CapDrop, []string{"ALL"}) || !slices.Contains(created.HostConfig.SecurityOpt, "no-new-privileges") {
+		t.Errorf("expected every capability to be dropped, got %q and %q", created.HostConfig.CapDrop, created.HostConfig.SecurityOpt)
+	}
+
+	if created.HostConfig.Privileged || created.HostConfig.NetworkMode.IsHost() || created.HostConfig.PidMode.IsHost() {
+		t.Errorf("expected the sandbox not to share the host's privileges, network, or processes")
+	}
+
+	if ids := docker.containerIDs(); len(ids) != 0 {
+		t.Errorf("expected the sandbox to be removed, %d containers are left", len(ids))
+	}
+}
@@ -1,7 +1,11 @@
 CREATE TABLE IF NOT EXISTS deployments (
     id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE,
     url TEXT NOT NULL UNIQUE,
-    port INTEGER NOT NULL
+    port INTEGER NOT NULL,
+    config TEXT NOT NULL DEFAULT '{}',
+    source_hash TEXT NOT NULL DEFAULT '',
+    maintenance BOOLEAN NOT NULL DEFAULT FALSE,
+    maintenance_page TEXT NOT NULL DEFAULT ''
 );
 
 CREATE TABLE IF NOT EXISTS apps (
@@ -25,4 +29,4 @@ CREATE TABLE IF NOT EXISTS volumes (
     mountpoint TEXT NOT NULL,
     container_id INTEGER NOT NULL,
     FOREIGN KEY(container_id) REFERENCES containers(id)
-);
\ No newline at end of file
+);
@@ -0,0 +1,32 @@
+-- container ids are stored as raw bytes, the same way that they end up in sqlite
+CREATE TABLE IF NOT EXISTS deployments (
+    id BIGSERIAL PRIMARY KEY,
+    url TEXT NOT NULL UNIQUE,
+    port INTEGER NOT NULL,
+    config TEXT NOT NULL DEFAULT '{}',
+    source_hash TEXT NOT NULL DEFAULT '',
+    maintenance BOOLEAN NOT NULL DEFAULT FALSE,
+    maintenance_page TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS apps (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    deployment_id BIGINT,
+    FOREIGN KEY(deployment_id) REFERENCES deployments(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS containers (
+    id BIGSERIAL PRIMARY KEY,
+    container_id BYTEA NOT NULL,
+    head BOOLEAN NOT NULL,
+    deployment_id BIGINT NOT NULL,
+    FOREIGN KEY(deployment_id) REFERENCES deployments(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS volumes (
+    id BIGSERIAL PRIMARY KEY,
+    volume_id TEXT NOT NULL,
+    mountpoint TEXT NOT NULL,
+    container_id BYTEA NOT NULL
+);
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// resolveSecret resolves a secret reference to its value. Supported references are file:///path/to/secret, which
+// reads the file on the daemon host, and env://NAME, which reads an environment variable of the daemon. The value
+// must never end up in logs or responses, so errors only ever mention the reference.
+func resolveSecret(reference string) (string, error) {
+	ref, err := url.Parse(reference)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret reference %q", reference)
+	}
+
+	switch ref.Scheme {
+	case "file":
+		if ref.Path == "" {
+			return "", fmt.Errorf("invalid secret reference %q, expected file:///path/to/secret", reference)
+		}
+
+		value, err := os.ReadFile(ref.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %v", ref.Path, err)
+		}
+
+		return strings.TrimRight(string(value), "\r\n"), nil
+	case "env":
+		if ref.Host == "" {
+			return "", fmt.Errorf("invalid secret reference %q, expected env://NAME", reference)
+		}
+
+		value, ok := os.LookupEnv(ref.Host)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref.Host)
+		}
+
+		return value, nil
+	default:
+		return "", fmt.Errorf("unsupported secret reference %q, expected file:// or env://", reference)
+	}
+}
+
+// resolveSecrets resolves every secret in the project config into a NAME=value environment variable
+func resolveSecrets(secrets map[string]string) ([]string, error) {
+	var env []string
+	for name, reference := range secrets {
+		value, err := resolveSecret(reference)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve secret %s: %v", name, err)
+		}
+
+		env = append(env, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	return env, nil
+}
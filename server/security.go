@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/juls0730/flux/pkg"
+)
+
+// ContainerDefaults hardens the containers of every app. Apps can override the user and read_only_rootfs, and drop
+// more capabilities, but can't get back the ones that are dropped here
+type ContainerDefaults struct {
+	// the user that containers run as when the app doesn't set one, the image's user when empty
+	User           string   `json:"user,omitempty"`
+	ReadOnlyRootfs bool     `json:"read_only_rootfs,omitempty"`
+	CapDrop        []string `json:"cap_drop,omitempty"`
+}
+
+var (
+	// either a name or a numeric id, as accepted by docker for the user and the group
+	userPattern       = regexp.MustCompile(`^([a-z_][a-z0-9_.-]*\$?|[0-9]+)$`)
+	capabilityPattern = regexp.MustCompile(`^[A-Z_]+$`)
+)
+
+// validateUser checks that user is in docker's user[:group] form, where both are a name or a numeric id
+func validateUser(user string) error {
+	if user == "" {
+		return nil
+	}
+
+	name, group, hasGroup := strings.Cut(user, ":")
+	if !userPattern.MatchString(name) {
+		return fmt.Errorf("user %q must be a user name or id, optionally followed by :group", user)
+	}
+
+	if hasGroup && !userPattern.MatchString(group) {
+		return fmt.Errorf("user %q must have a group name or id after the :", user)
+	}
+
+	return nil
+}
+
+func validateCapabilities(capabilities []string) error {
+	for _, capability := range capabilities {
+		if !capabilityPattern.MatchString(strings.ToUpper(capability)) {
+			return fmt.Errorf("cap_drop has an invalid capability %q, use names such as ALL or NET_RAW", capability)
+		}
+	}
+
+	return nil
+}
+
+func (d ContainerDefaults) validate() error {
+	if err := validateUser(d.User); err != nil {
+		return err
+	}
+
+	return validateCapabilities(d.CapDrop)
+}
+
+func validateContainerSecurity(projectConfig pkg.ProjectConfig) error {
+	if err := validateUser(projectConfig.User); err != nil {
+		return err
+	}
+
+	return validateCapabilities(projectConfig.CapDrop)
+}
+
+// containerUser returns the user that the containers of an app run as, empty for the user of the image
+func containerUser(projectConfig pkg.ProjectConfig) string {
+	if projectConfig.User != "" {
+		return projectConfig.User
+	}
+
+	return Flux.config.ContainerDefaults.User
+}
+
+func readOnlyRootfs(projectConfig pkg.ProjectConfig) bool {
+	if projectConfig.ReadOnlyRootfs != nil {
+		return *projectConfig.ReadOnlyRootfs
+	}
+
+	return Flux.config.ContainerDefaults.ReadOnlyRootfs
+}
+
+// capDrop returns the capabilities that are dropped from the containers of an app, those of the daemon and the app
+func capDrop(projectConfig pkg.ProjectConfig) []string {
+	var capabilities []string
+	for _, capability := range append(slices.Clone(Flux.config.ContainerDefaults.CapDrop), projectConfig.CapDrop...) {
+		capability = strings.ToUpper(capability)
+		if !slices.Contains(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+
+	return capabilities
+}
+
+// readOnlyTmpfs returns the tmpfs mounts that a container with a read-only root filesystem gets, so that apps still
+// have somewhere to write temporary files. /tmp is left alone when a volume is mounted there
+func readOnlyTmpfs(projectConfig pkg.ProjectConfig) map[string]string {
+	if !readOnlyRootfs(projectConfig) {
+		return nil
+	}
+
+	for _, volume := range projectConfig.Volumes {
+		if volume.Target == "/tmp" {
+			return nil
+		}
+	}
+
+	return map[string]string{"/tmp": ""}
+}
@@ -4,34 +4,45 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"context"
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
-	_ "embed"
-
-	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/juls0730/flux/pkg"
-	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var (
-	//go:embed schema.sql
-	schemaBytes   []byte
 	DefaultConfig = FluxServerConfig{
-		Builder: "paketobuildpacks/builder-jammy-tiny",
+		Builder:   "paketobuildpacks/builder-jammy-tiny",
+		PackPath:  "pack",
+		APIPort:   5647,
+		ProxyPort: 7465,
+		// 1 GiB
+		MaxUploadSize: 1 << 30,
 		Compression: pkg.Compression{
 			Enabled: false,
 			Level:   0,
 		},
+		AccessLog: AccessLogConfig{
+			Enabled: false,
+			Format:  AccessLogFormatJSON,
+		},
 	}
 	Flux   *FluxServer
 	logger *zap.SugaredLogger
@@ -39,25 +50,72 @@ var (
 
 type FluxServerConfig struct {
 	Builder     string          `json:"builder"`
+	PackPath    string          `json:"pack_path,omitempty"`
 	Compression pkg.Compression `json:"compression"`
+	AccessLog   AccessLogConfig `json:"access_log"`
+	Database    DatabaseConfig  `json:"database"`
+	// the address that the api and proxy listen on, all interfaces when empty
+	ListenAddr string `json:"listen_addr,omitempty"`
+	APIPort    int    `json:"api_port,omitempty"`
+	ProxyPort  int    `json:"proxy_port,omitempty"`
+	// minimum log level per app, e.g. {"my-app": "error"} to quiet down a noisy app
+	AppLogLevels map[string]string `json:"app_log_levels,omitempty"`
+	// the largest deploy request in bytes that is accepted
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
+	// the most builds that run at the same time, further deploys wait for a slot, 0 is unlimited
+	MaxConcurrentBuilds int `json:"max_concurrent_builds,omitempty"`
+	// the bearer token that guards sensitive endpoints such as copying files in and out of containers, those
+	// endpoints are disabled when it is empty
+	AuthToken string `json:"auth_token,omitempty"`
+	// seconds that a client of the reverse proxy gets to send its whole request, 0 disables the limit. Headers always
+	// have to arrive within proxyHeaderTimeout
+	ProxyReadTimeout int `json:"proxy_read_timeout,omitempty"`
+	// seconds that the reverse proxy gets to send a whole response, from the end of the request headers, 0 disables
+	// the limit
+	ProxyWriteTimeout int `json:"proxy_write_timeout,omitempty"`
+	// the largest request body in bytes that the reverse proxy passes on to an app, 0 disables the limit
+	ProxyMaxBodySize int64 `json:"proxy_max_body_size,omitempty"`
+	// the header that identifies a request in the access log, the app, and the response, it is generated by the
+	// proxy when the client doesn't send one. X-Request-ID when empty
+	RequestIDHeader string `json:"request_id_header,omitempty"`
+	// one of the pkg.PullPolicy constants. When empty the builder is pulled on startup and pack pulls what it needs
+	// on every build, like before there was a pull policy
+	ImagePullPolicy string `json:"image_pull_policy,omitempty"`
+	// the docker daemon that apps are built and run on, such as tcp://10.0.0.5:2376, DOCKER_HOST or the default
+	// socket when empty
+	DockerHost string `json:"docker_host,omitempty"`
+	// a directory with the ca.pem, cert.pem, and key.pem to connect to a docker daemon that requires TLS
+	DockerCertPath string `json:"docker_cert_path,omitempty"`
+	// the user, read-only root filesystem, and dropped capabilities of every app's containers
+	ContainerDefaults ContainerDefaults `json:"container_defaults"`
+	// credentials for private registries that the builder and other images are pulled from, registries that aren't
+	// listed fall back to the config.json of the docker cli
+	Registries []RegistryAuth `json:"registries,omitempty"`
+	// commands that are run in a sandbox at points of every build, none by default
+	BuildHooks BuildHooks `json:"build_hooks"`
+	// when an app whose containers keep being restarted is reported as crash looping
+	CrashLoop CrashLoopConfig `json:"crash_loop"`
+	// KEY=value pairs set in the containers of every app, the environment of an app overrides them by key
+	DefaultEnvironment []string `json:"default_environment,omitempty"`
 }
 
 type FluxServer struct {
 	config       FluxServerConfig
-	db           *sql.DB
+	db           Database
 	proxy        *Proxy
 	rootDir      string
 	appManager   *AppManager
+	logStore     *LogStore
 	dockerClient *client.Client
-	Logger       *zap.SugaredLogger
+	apiListener  net.Listener
+	// the listener that the proxy serves on, reported by the readiness check
+	proxyListener net.Listener
+	// holds a value for every build that is running, nil when builds are unlimited
+	buildSlots chan struct{}
+	Logger     *zap.SugaredLogger
 }
 
 func NewFluxServer() *FluxServer {
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		logger.Fatalw("Failed to create docker client", zap.Error(err))
-	}
-
 	rootDir := os.Getenv("FLUXD_ROOT_DIR")
 	if rootDir == "" {
 		rootDir = "/var/fluxd"
@@ -67,23 +125,35 @@ func NewFluxServer() *FluxServer {
 		logger.Fatalw("Failed to create fluxd directory", zap.Error(err))
 	}
 
-	db, err := sql.Open("sqlite3", filepath.Join(rootDir, "fluxd.db"))
-	if err != nil {
-		logger.Fatalw("Failed to open database", zap.Error(err))
+	return &FluxServer{
+		proxy:      &Proxy{},
+		appManager: new(AppManager),
+		logStore:   new(LogStore),
+		rootDir:    rootDir,
 	}
+}
+
+func (s *FluxServer) packPath() string {
+	if s.config.PackPath == "" {
+		return "pack"
+	}
+
+	return s.config.PackPath
+}
 
-	_, err = db.Exec(string(schemaBytes))
+// checkPack makes sure that the pack binary can be run, and returns its version
+func (s *FluxServer) checkPack() (string, error) {
+	packPath, err := exec.LookPath(s.packPath())
 	if err != nil {
-		logger.Fatalw("Failed to create database schema", zap.Error(err))
+		return "", fmt.Errorf("pack binary %q was not found, install pack (https://buildpacks.io/docs/for-platform-operators/how-to/integrate-ci/pack/) or set pack_path in %s", s.packPath(), filepath.Join(s.rootDir, "config.json"))
 	}
 
-	return &FluxServer{
-		db:           db,
-		proxy:        &Proxy{},
-		appManager:   new(AppManager),
-		rootDir:      rootDir,
-		dockerClient: dockerClient,
+	version, err := exec.Command(packPath, "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s version: %v", packPath, err)
 	}
+
+	return strings.TrimSpace(string(version)), nil
 }
 
 func (s *FluxServer) Stop() {
@@ -112,6 +182,8 @@ func NewServer() *FluxServer {
 		logger.Fatalw("Failed to create logger", zap.Error(err))
 	}
 
+	logger.Infow("Starting fluxd", zap.String("version", pkg.Version), zap.String("commit", pkg.Commit), zap.String("date", pkg.Date))
+
 	Flux = NewFluxServer()
 	Flux.Logger = logger
 
@@ -144,18 +216,147 @@ func NewServer() *FluxServer {
 		logger.Fatalw("Failed to parse config file", zap.Error(err))
 	}
 
+	filteredLogger := lameLogger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		filterCore, err := newAppFilterCore(core, serverConfig.AppLogLevels, os.Getenv("FLUXD_LOG_APP"))
+		if err != nil {
+			logger.Fatalw("Invalid app log levels", zap.Error(err))
+		}
+
+		return filterCore
+	}))
+	logger = filteredLogger.Sugar()
+	Flux.Logger = logger
+
+	if err := serverConfig.AccessLog.Validate(); err != nil {
+		logger.Fatalw("Invalid access log config", zap.Error(err))
+	}
+
+	if serverConfig.APIPort == 0 {
+		serverConfig.APIPort = DefaultConfig.APIPort
+	}
+
+	if serverConfig.ProxyPort == 0 {
+		serverConfig.ProxyPort = DefaultConfig.ProxyPort
+	}
+
+	if serverConfig.MaxUploadSize <= 0 {
+		serverConfig.MaxUploadSize = DefaultConfig.MaxUploadSize
+	}
+
+	if err := serverConfig.CrashLoop.validate(); err != nil {
+		logger.Fatalw("Invalid crash_loop", zap.Error(err))
+	}
+
+	if serverConfig.CrashLoop.Restarts == 0 {
+		serverConfig.CrashLoop.Restarts = defaultCrashLoopRestarts
+	}
+
+	if serverConfig.CrashLoop.Window == 0 {
+		serverConfig.CrashLoop.Window = defaultCrashLoopWindow
+	}
+
+	if err := serverConfig.validateProxyLimits(); err != nil {
+		logger.Fatalw("Invalid proxy limits", zap.Error(err))
+	}
+
+	if err := validateRequestIDHeader(serverConfig.RequestIDHeader); err != nil {
+		logger.Fatalw("Invalid request id header", zap.Error(err))
+	}
+
+	if serverConfig.RequestIDHeader == "" {
+		serverConfig.RequestIDHeader = defaultRequestIDHeader
+	}
+
+	if err := validatePullPolicy(serverConfig.ImagePullPolicy); err != nil {
+		logger.Fatalw("Invalid image pull policy", zap.Error(err))
+	}
+
+	if err := serverConfig.ContainerDefaults.validate(); err != nil {
+		logger.Fatalw("Invalid container_defaults", zap.Error(err))
+	}
+
+	if err := serverConfig.validateRegistries(); err != nil {
+		logger.Fatalw("Invalid registries", zap.Error(err))
+	}
+
+	if err := serverConfig.BuildHooks.validate(); err != nil {
+		logger.Fatalw("Invalid build_hooks", zap.Error(err))
+	}
+
+	if err := validateEnvironment(serverConfig.DefaultEnvironment); err != nil {
+		logger.Fatalw("Invalid default_environment", zap.Error(err))
+	}
+
+	if err := serverConfig.validateDocker(); err != nil {
+		logger.Fatalw("Invalid docker config", zap.Error(err))
+	}
+
+	Flux.dockerClient, err = newDockerClient(serverConfig)
+	if err != nil {
+		logger.Fatalw("Failed to connect to docker", zap.Error(err))
+	}
+	logger.Infow("Connected to docker", zap.String("host", Flux.dockerClient.DaemonHost()))
+
+	if serverConfig.MaxConcurrentBuilds < 0 {
+		logger.Fatalw("Invalid max_concurrent_builds, it must not be negative", zap.Int("max_concurrent_builds", serverConfig.MaxConcurrentBuilds))
+	}
+
+	// FLUXD_PROXY_PORT predates proxy_port, so it still takes precedence
+	if proxyPort := os.Getenv("FLUXD_PROXY_PORT"); proxyPort != "" {
+		serverConfig.ProxyPort, err = strconv.Atoi(proxyPort)
+		if err != nil {
+			logger.Fatalw("Invalid FLUXD_PROXY_PORT", zap.String("port", proxyPort))
+		}
+	}
+
+	if err := serverConfig.validateListen(); err != nil {
+		logger.Fatalw("Invalid listen config", zap.Error(err))
+	}
+
+	// listen before doing anything slow, so that a port that is already in use fails startup right away
+	Flux.apiListener, err = net.Listen("tcp", serverConfig.apiAddr())
+	if err != nil {
+		logger.Fatalw("Failed to listen for the api", zap.String("address", serverConfig.apiAddr()), zap.Error(err))
+	}
+
+	Flux.proxyListener, err = net.Listen("tcp", serverConfig.proxyAddr())
+	if err != nil {
+		logger.Fatalw("Failed to listen for the proxy", zap.String("address", serverConfig.proxyAddr()), zap.Error(err))
+	}
+
 	Flux.config = serverConfig
+	if serverConfig.MaxConcurrentBuilds > 0 {
+		Flux.buildSlots = make(chan struct{}, serverConfig.MaxConcurrentBuilds)
+	}
 
-	logger.Infof("Pulling builder image %s this may take a while...", serverConfig.Builder)
-	events, err := Flux.dockerClient.ImagePull(context.Background(), fmt.Sprintf("%s:latest", serverConfig.Builder), image.PullOptions{})
+	Flux.db, err = OpenDatabase(serverConfig.Database, Flux.rootDir)
 	if err != nil {
-		logger.Fatalw("Failed to pull builder image", zap.Error(err))
+		logger.Fatalw("Failed to open database", zap.Error(err))
+	}
+
+	if packVersion, err := Flux.checkPack(); err != nil {
+		logger.Errorw("Pack is unavailable, deploys will fail until it is installed", zap.Error(err))
+	} else {
+		logger.Infow("Found pack", zap.String("version", packVersion))
+	}
+
+	builderImage := fmt.Sprintf("%s:latest", serverConfig.Builder)
+	pull := true
+	if serverConfig.ImagePullPolicy != "" {
+		pull, err = needsPull(context.Background(), builderImage, serverConfig.ImagePullPolicy)
+		if err != nil {
+			logger.Errorw("Builder image is unavailable, deploys will fail until it is available", zap.Error(err))
+		}
 	}
 
-	// blocking wait for the iamge to be pulled
-	io.Copy(io.Discard, events)
+	if pull {
+		logger.Infof("Pulling builder image %s this may take a while...", serverConfig.Builder)
+		if err := pullImage(context.Background(), builderImage); err != nil {
+			logger.Fatalw("Failed to pull builder image", zap.Error(err))
+		}
 
-	logger.Infow("Successfully pulled builder image", zap.String("image", serverConfig.Builder))
+		logger.Infow("Successfully pulled builder image", zap.String("image", serverConfig.Builder))
+	}
 
 	if err := os.MkdirAll(filepath.Join(Flux.rootDir, "apps"), 0755); err != nil {
 		logger.Fatalw("Failed to create apps directory", zap.Error(err))
@@ -163,14 +364,12 @@ func NewServer() *FluxServer {
 
 	Flux.appManager.Init()
 
-	port := os.Getenv("FLUXD_PROXY_PORT")
-	if port == "" {
-		port = "7465"
-	}
+	go Flux.proxy.SuspendIdleDeployments(idleCheckInterval)
+	go Flux.WatchCrashLoops(context.Background())
 
 	go func() {
-		logger.Infof("Proxy server starting on http://127.0.0.1:%s", port)
-		if err := http.ListenAndServe(fmt.Sprintf(":%s", port), Flux.proxy); err != nil && err != http.ErrServerClosed {
+		logger.Infof("Proxy server starting on http://%s", Flux.proxyListener.Addr())
+		if err := Flux.proxyServer().Serve(Flux.proxyListener); err != nil && err != http.ErrServerClosed {
 			logger.Fatalw("Proxy server error", zap.Error(err))
 		}
 	}()
@@ -178,7 +377,126 @@ func NewServer() *FluxServer {
 	return Flux
 }
 
-func (s *FluxServer) UploadAppCode(code io.Reader, projectConfig pkg.ProjectConfig) (string, error) {
+const redacted = "<redacted>"
+
+// matches the password in a key=value dsn, such as the ones postgres accepts
+var dsnPasswordRegexp = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+
+// redacted returns the config without the auth token and database password, so that it can be shown to clients
+func (c FluxServerConfig) redacted() FluxServerConfig {
+	if c.AuthToken != "" {
+		c.AuthToken = redacted
+	}
+
+	// the slice is shared with the config that the daemon runs with
+	c.Registries = slices.Clone(c.Registries)
+	for i := range c.Registries {
+		if c.Registries[i].Password != "" {
+			c.Registries[i].Password = redacted
+		}
+
+		if c.Registries[i].Token != "" {
+			c.Registries[i].Token = redacted
+		}
+	}
+
+	if dsn, err := url.Parse(c.Database.DSN); err == nil && dsn.User != nil {
+		c.Database.DSN = dsn.Redacted()
+	} else {
+		c.Database.DSN = dsnPasswordRegexp.ReplaceAllString(c.Database.DSN, "${1}"+redacted)
+	}
+
+	return c
+}
+
+func (c FluxServerConfig) validateProxyLimits() error {
+	if c.ProxyReadTimeout < 0 {
+		return fmt.Errorf("proxy_read_timeout must not be negative, got %d", c.ProxyReadTimeout)
+	}
+
+	if c.ProxyWriteTimeout < 0 {
+		return fmt.Errorf("proxy_write_timeout must not be negative, got %d", c.ProxyWriteTimeout)
+	}
+
+	if c.ProxyMaxBodySize < 0 {
+		return fmt.Errorf("proxy_max_body_size must not be negative, got %d", c.ProxyMaxBodySize)
+	}
+
+	return nil
+}
+
+// proxyServer is the server for the public facing reverse proxy, its timeouts keep slow clients from holding on to
+// connections forever
+func (s *FluxServer) proxyServer() *http.Server {
+	headerTimeout := proxyHeaderTimeout
+	readTimeout := time.Duration(s.config.ProxyReadTimeout) * time.Second
+	if readTimeout > 0 && readTimeout < headerTimeout {
+		headerTimeout = readTimeout
+	}
+
+	return &http.Server{
+		// grpc clients talk http/2 without tls, http/1.1 clients are served as before
+		Handler:           h2c.NewHandler(s.proxy, &http2.Server{IdleTimeout: proxyIdleTimeout}),
+		ReadHeaderTimeout: headerTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      time.Duration(s.config.ProxyWriteTimeout) * time.Second,
+		IdleTimeout:       proxyIdleTimeout,
+	}
+}
+
+func (c FluxServerConfig) validateListen() error {
+	if c.APIPort < 1 || c.APIPort > 65535 {
+		return fmt.Errorf("api_port must be between 1 and 65535, got %d", c.APIPort)
+	}
+
+	if c.ProxyPort < 1 || c.ProxyPort > 65535 {
+		return fmt.Errorf("proxy_port must be between 1 and 65535, got %d", c.ProxyPort)
+	}
+
+	if c.APIPort == c.ProxyPort {
+		return fmt.Errorf("api_port and proxy_port must be different, both are %d", c.APIPort)
+	}
+
+	if strings.ContainsAny(c.ListenAddr, ":/ ") && net.ParseIP(c.ListenAddr) == nil {
+		return fmt.Errorf("listen_addr must be a hostname or ip address without a port, got %q", c.ListenAddr)
+	}
+
+	return nil
+}
+
+func (c FluxServerConfig) apiAddr() string {
+	return net.JoinHostPort(c.ListenAddr, strconv.Itoa(c.APIPort))
+}
+
+func (c FluxServerConfig) proxyAddr() string {
+	return net.JoinHostPort(c.ListenAddr, strconv.Itoa(c.ProxyPort))
+}
+
+// Serve serves the api on the listener opened by NewServer
+func (s *FluxServer) Serve(handler http.Handler) error {
+	logger.Infof("Fluxd started on http://%s", s.apiListener.Addr())
+	return http.Serve(s.apiListener, handler)
+}
+
+// extractFile writes the current file of an archive to path, the file is closed before returning so that extracting
+// a large archive doesn't hold on to a file descriptor for every file in it
+func extractFile(path string, reader io.Reader) error {
+	outFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(outFile, reader); err != nil {
+		outFile.Close()
+		return fmt.Errorf("failed to copy file during extraction: %v", err)
+	}
+
+	return outFile.Close()
+}
+
+// UploadAppCode extracts the tar archive of the app's code, which is gzipped if compressed is set, into the app's
+// directory
+func (s *FluxServer) UploadAppCode(code io.Reader, compressed bool, projectConfig pkg.ProjectConfig) (string, error) {
 	var err error
 	projectPath := filepath.Join(s.rootDir, "apps", projectConfig.Name)
 	if err = os.MkdirAll(projectPath, 0755); err != nil {
@@ -193,7 +511,7 @@ func (s *FluxServer) UploadAppCode(code io.Reader, projectConfig pkg.ProjectConf
 		}
 	}()
 
-	if s.config.Compression.Enabled {
+	if compressed {
 		gzReader, err = gzip.NewReader(code)
 		if err != nil {
 			logger.Infow("Failed to create gzip reader", zap.Error(err))
@@ -219,6 +537,12 @@ func (s *FluxServer) UploadAppCode(code io.Reader, projectConfig pkg.ProjectConf
 			return "", err
 		}
 
+		// an entry like ../../etc/passwd would otherwise be written outside of the project directory
+		if !filepath.IsLocal(header.Name) {
+			logger.Warnw("Rejecting archive with an unsafe path", zap.String("project", projectConfig.Name), zap.String("path", header.Name))
+			return "", fmt.Errorf("invalid path in code archive: %q", header.Name)
+		}
+
 		// Construct full path
 		path := filepath.Join(projectPath, header.Name)
 
@@ -235,17 +559,10 @@ func (s *FluxServer) UploadAppCode(code io.Reader, projectConfig pkg.ProjectConf
 				return "", err
 			}
 
-			outFile, err := os.Create(path)
-			if err != nil {
+			if err = extractFile(path, tarReader); err != nil {
 				logger.Debugw("Failed to extract file", zap.Error(err))
 				return "", err
 			}
-			defer outFile.Close()
-
-			if _, err = io.Copy(outFile, tarReader); err != nil {
-				logger.Debugw("Failed to copy file during extraction", zap.Error(err))
-				return "", err
-			}
 		}
 	}
 
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarArchive writes a gzipped tar archive with a regular file for every name, whose content is the name itself
+func tarArchive(t *testing.T, names ...string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	if _, err := plainTarArchive(t, names...).WriteTo(gzWriter); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+// plainTarArchive writes the archive of tarArchive without compressing it
+func plainTarArchive(t *testing.T, names ...string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, name := range names {
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(name)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tarWriter.Write([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestUploadAppCode(t *testing.T) {
+	newTestServer(t)
+
+	projectPath, err := Flux.UploadAppCode(tarArchive(t, "main.go", "static/index.html"), true, testProjectConfig("app"))
+	if err != nil {
+		t.Fatalf("failed to upload code: %v", err)
+	}
+
+	for _, name := range []string{"main.go", "static/index.html"} {
+		content, err := os.ReadFile(filepath.Join(projectPath, name))
+		if err != nil {
+			t.Errorf("expected %s to be extracted: %v", name, err)
+			continue
+		}
+
+		if string(content) != name {
+			t.Errorf("expected %s to contain %q, got %q", name, name, content)
+		}
+	}
+}
+
+func TestUploadAppCodeRejectsUnsafePaths(t *testing.T) {
+	outside := t.TempDir()
+
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{"parent directory", "../escaped.txt"},
+		{"nested parent directory", "static/../../../escaped.txt"},
+		{"absolute path", filepath.Join(outside, "escaped.txt")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newTestServer(t)
+
+			// the entry comes after a harmless one, so that an archive isn't trusted because its first entry is fine
+			_, err := Flux.UploadAppCode(tarArchive(t, "main.go", test.entry), true, testProjectConfig("app"))
+			if err == nil {
+				t.Fatalf("expected %q to be rejected", test.entry)
+			}
+
+			escaped := []string{
+				filepath.Join(Flux.rootDir, "apps", "escaped.txt"),
+				filepath.Join(Flux.rootDir, "escaped.txt"),
+				filepath.Join(filepath.Dir(Flux.rootDir), "escaped.txt"),
+				filepath.Join(outside, "escaped.txt"),
+			}
+			for _, path := range escaped {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("expected nothing to be written to %s", path)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/juls0730/flux/pkg"
+	"go.uber.org/zap"
+)
+
+// how long to wait for a container of a tcp app to accept a forwarded connection
+const tcpDialTimeout = 5 * time.Second
+
+// tcpListener forwards the connections to a host port to the containers of a tcp app
+type tcpListener struct {
+	deployment *Deployment
+	port       uint16
+	listener   net.Listener
+}
+
+// validateProtocol makes sure that an http app has a url to be served on, and that a tcp app has a host port that it
+// can be forwarded on
+func validateProtocol(projectConfig pkg.ProjectConfig) error {
+	switch protocol(projectConfig) {
+	case pkg.ProtocolHTTP, pkg.ProtocolGRPC:
+		if projectConfig.Url == "" {
+			return fmt.Errorf("a url must be specified")
+		}
+
+		return nil
+	case pkg.ProtocolTCP:
+	default:
+		return fmt.Errorf("unknown protocol %q, expected http, grpc, or tcp", projectConfig.Protocol)
+	}
+
+	hostPort := projectConfig.HostPort
+	if hostPort == 0 {
+		return fmt.Errorf("tcp apps need a host_port to be forwarded on")
+	}
+
+	if int(hostPort) == Flux.config.APIPort || int(hostPort) == Flux.config.ProxyPort {
+		return fmt.Errorf("host_port %d is used by fluxd itself", hostPort)
+	}
+
+	for _, app := range Flux.appManager.GetAllApps() {
+		if protocol(app.Deployment.config()) != pkg.ProtocolTCP || app.Deployment.config().HostPort != hostPort {
+			continue
+		}
+
+		if app.name() != projectConfig.Name {
+			return fmt.Errorf("host_port %d is already used by %s", hostPort, app.name())
+		}
+
+		// the app is already forwarding this port
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", hostPortAddr(hostPort))
+	if err != nil {
+		return fmt.Errorf("host_port %d can't be used: %v", hostPort, err)
+	}
+	listener.Close()
+
+	return nil
+}
+
+// listenTCP starts forwarding the host port of a tcp app, if it isn't forwarded already
+func (p *Proxy) listenTCP(deployment *Deployment) error {
+	if value, ok := p.tcpListeners.Load(deployment.ID); ok {
+		if value.(*tcpListener).port == deployment.config().HostPort {
+			return nil
+		}
+
+		p.closeTCP(deployment)
+	}
+
+	listener, err := net.Listen("tcp", hostPortAddr(deployment.config().HostPort))
+	if err != nil {
+		return err
+	}
+
+	tl := &tcpListener{
+		deployment: deployment,
+		port:       deployment.config().HostPort,
+		listener:   listener,
+	}
+	p.tcpListeners.Store(deployment.ID, tl)
+
+	appLogger(deployment.config().Name).Infow("Forwarding host port", zap.String("address", listener.Addr().String()))
+	go tl.serve()
+
+	return nil
+}
+
+func (p *Proxy) closeTCP(deployment *Deployment) {
+	value, ok := p.tcpListeners.LoadAndDelete(deployment.ID)
+	if !ok {
+		return
+	}
+
+	value.(*tcpListener).listener.Close()
+}
+
+// hostPortAddr returns the address that a tcp app's host port is listened on, the same interface as the proxy
+func hostPortAddr(port uint16) string {
+	return net.JoinHostPort(Flux.config.ListenAddr, strconv.Itoa(int(port)))
+}
+
+func (tl *tcpListener) serve() {
+	for {
+		conn, err := tl.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			appLogger(tl.deployment.config().Name).Warnw("Failed to accept connection", zap.Error(err))
+			continue
+		}
+
+		go tl.forward(conn)
+	}
+}
+
+// forward copies data both ways between a client and one of the app's containers until either side closes
+func (tl *tcpListener) forward(conn net.Conn) {
+	defer conn.Close()
+
+	deployment := tl.deployment
+	log := appLogger(deployment.config().Name)
+
+	// the same as the 503 for http requests
+	if deployment.paused.Load() {
+		return
+	}
+
+	if deployment.suspended.Load() {
+		// the same as for http requests, the connection is held until the deployment is back up
+		ctx, cancel := context.WithTimeout(context.Background(), coldStartTimeout)
+		err := deployment.Wake(ctx)
+		cancel()
+
+		if err != nil {
+			log.Errorw("Failed to wake idle deployment", zap.String("url", deployment.url()), zap.Error(err))
+			return
+		}
+	}
+
+	dp := deployment.proxy()
+	if dp == nil {
+		return
+	}
+
+	atomic.AddInt64(&dp.activeRequests, 1)
+	defer atomic.AddInt64(&dp.activeRequests, -1)
+	atomic.StoreInt64(&dp.lastRequest, time.Now().UnixNano())
+
+	index := dp.nextUpstreamIndex()
+	if index == -1 {
+		log.Warnw("No healthy container to forward connection to")
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", dp.upstreams[index].Host, tcpDialTimeout)
+	if err != nil {
+		log.Warnw("Failed to connect to container", zap.String("upstream", dp.upstreams[index].Host), zap.Error(err))
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, conn)
+		// let the app know that the client is done sending, while it may still be responding
+		if tcpConn, ok := upstream.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+		close(done)
+	}()
+
+	// once the app is done responding nothing that the client still sends can be answered
+	io.Copy(conn, upstream)
+	conn.Close()
+	<-done
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/juls0730/flux/pkg"
+)
+
+const defaultVolumeMountpoint = "/workspace"
+
+// volumeConfigs returns the volumes configured for the app, or the default workspace volume if none are
+func volumeConfigs(projectConfig pkg.ProjectConfig) []pkg.VolumeConfig {
+	if len(projectConfig.Volumes) == 0 {
+		mountpoint := projectConfig.WorkspaceMount
+		if mountpoint == "" {
+			mountpoint = defaultVolumeMountpoint
+		}
+
+		return []pkg.VolumeConfig{{Type: pkg.VolumeTypeVolume, Target: mountpoint}}
+	}
+
+	return projectConfig.Volumes
+}
+
+func validateVolumes(projectConfig pkg.ProjectConfig) error {
+	if projectConfig.WorkspaceMount != "" {
+		if !path.IsAbs(projectConfig.WorkspaceMount) {
+			return fmt.Errorf("workspace_mount %q must be an absolute path", projectConfig.WorkspaceMount)
+		}
+
+		// the workspace volume is only created when there are no volumes, so it would silently be ignored
+		if len(projectConfig.Volumes) > 0 {
+			return fmt.Errorf("workspace_mount can't be combined with volumes, add the workspace to volumes instead")
+		}
+	}
+
+	targets := make(map[string]bool)
+	for _, volume := range projectConfig.Volumes {
+		if !path.IsAbs(volume.Target) {
+			return fmt.Errorf("volume target %q must be an absolute path", volume.Target)
+		}
+
+		if targets[path.Clean(volume.Target)] {
+			return fmt.Errorf("volume target %q is used more than once", volume.Target)
+		}
+		targets[path.Clean(volume.Target)] = true
+
+		switch volume.Type {
+		case "", pkg.VolumeTypeVolume:
+		case pkg.VolumeTypeBind:
+			if !filepath.IsAbs(volume.Source) {
+				return fmt.Errorf("bind mount source %q must be an absolute path", volume.Source)
+			}
+
+			if _, err := os.Stat(volume.Source); err != nil {
+				return fmt.Errorf("bind mount source %q does not exist on the daemon host", volume.Source)
+			}
+		default:
+			return fmt.Errorf("unknown volume type %q for %s, expected volume or bind", volume.Type, volume.Target)
+		}
+	}
+
+	return nil
+}
+
+// ensureVolumes returns the existing volumes along with a newly created docker volume for every configured volume
+// mount that none of the existing volumes are mounted at. The new volumes are not saved to the database yet, so their
+// ID is 0
+func ensureVolumes(ctx context.Context, projectConfig pkg.ProjectConfig, deploymentID int64, existing []Volume) ([]Volume, error) {
+	volumes := append([]Volume{}, existing...)
+
+	for _, volumeConfig := range volumeConfigs(projectConfig) {
+		if volumeConfig.Type == pkg.VolumeTypeBind || findVolume(volumes, volumeConfig.Target) != nil {
+			continue
+		}
+
+		vol, err := CreateDockerVolume(ctx, volumeConfig.Source, deploymentID, projectConfig.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		vol.Mountpoint = volumeConfig.Target
+		volumes = append(volumes, *vol)
+	}
+
+	return volumes, nil
+}
+
+func findVolume(volumes []Volume, mountpoint string) *Volume {
+	for i := range volumes {
+		if path.Clean(volumes[i].Mountpoint) == path.Clean(mountpoint) {
+			return &volumes[i]
+		}
+	}
+
+	return nil
+}
+
+// volumeMounts returns the mounts for every volume configured for the app. Volumes that are no longer configured are
+// kept around so their data isn't lost, but they are not mounted
+func volumeMounts(projectConfig pkg.ProjectConfig, volumes []Volume) ([]mount.Mount, error) {
+	var mounts []mount.Mount
+	for _, volumeConfig := range volumeConfigs(projectConfig) {
+		if volumeConfig.Type == pkg.VolumeTypeBind {
+			mounts = append(mounts, mount.Mount{
+				Type:     mount.TypeBind,
+				Source:   volumeConfig.Source,
+				Target:   volumeConfig.Target,
+				ReadOnly: volumeConfig.ReadOnly,
+			})
+			continue
+		}
+
+		vol := findVolume(volumes, volumeConfig.Target)
+		if vol == nil {
+			return nil, fmt.Errorf("no volume found for %s", volumeConfig.Target)
+		}
+
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   vol.VolumeID,
+			Target:   vol.Mountpoint,
+			ReadOnly: volumeConfig.ReadOnly,
+		})
+	}
+
+	return mounts, nil
+}
+
+// isNamedVolume reports whether the volume was given a name in the app config. Named volumes can be shared with
+// things outside of flux, so they are left alone when the app is removed
+func isNamedVolume(projectConfig pkg.ProjectConfig, volumeID string) bool {
+	for _, volumeConfig := range projectConfig.Volumes {
+		if volumeConfig.Type != pkg.VolumeTypeBind && volumeConfig.Source == volumeID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// saveVolumes points every volume of the container at it in the database, inserting the volumes that are new
+func (c *Container) saveVolumes(tx Tx) error {
+	for i := range c.Volumes {
+		vol := &c.Volumes[i]
+
+		var err error
+		if vol.ID == 0 {
+			err = tx.Stmt(volumeInsertStmt).QueryRow(vol.VolumeID, vol.Mountpoint, c.ContainerID[:]).Scan(&vol.ID, &vol.VolumeID, &vol.Mountpoint, &vol.ContainerID)
+		} else {
+			err = tx.Stmt(volumeUpdateStmt).QueryRow(c.ContainerID[:], vol.ID).Scan(&vol.ID, &vol.VolumeID, &vol.Mountpoint, &vol.ContainerID)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to save volume (%s): %v", vol.VolumeID, err)
+		}
+	}
+
+	return nil
+}